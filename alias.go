@@ -0,0 +1,74 @@
+package compression
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// Deflate is an alias of Flate
+const Deflate = Flate
+
+// algorithmAlias is an alternative name accepted by ParseAlgorithm
+type algorithmAlias struct {
+	algorithm  Algorithm
+	deprecated bool
+}
+
+// algorithmAliases maps lower-case alternative names to algorithms.
+// Renamed algorithms keep their old name here, marked deprecated.
+var algorithmAliases = map[string]algorithmAlias{
+	"bz2":         {algorithm: Bzip2},
+	"deflate":     {algorithm: Flate},
+	"gz":          {algorithm: Gzip},
+	"identity":    {algorithm: None},
+	"passthrough": {algorithm: None},
+	"rawsnappy":   {algorithm: SnappyBlock},
+	"zst":         {algorithm: Zstd},
+}
+
+// retiredAlgorithms maps retired Algorithm values to their replacement.
+// New resolves them and reports the deprecation.
+var retiredAlgorithms = map[Algorithm]Algorithm{}
+
+var deprecationHook atomic.Pointer[func(name, replacement string)]
+
+// SetDeprecationHook sets a function called when a deprecated algorithm
+// name is parsed or a retired Algorithm value is passed to New, so that
+// callers can find and update outdated configurations. A nil fn removes
+// the hook.
+func SetDeprecationHook(fn func(name, replacement string)) {
+	if fn == nil {
+		deprecationHook.Store(nil)
+		return
+	}
+	deprecationHook.Store(&fn)
+}
+
+func deprecated(name string, replacement Algorithm) {
+	if fn := deprecationHook.Load(); fn != nil {
+		(*fn)(name, replacement.String())
+	}
+}
+
+// resolveAlias returns the algorithm aliased by name
+func resolveAlias(name string) (Algorithm, bool) {
+	alias, ok := algorithmAliases[strings.ToLower(name)]
+	if !ok {
+		return 0, false
+	}
+	if alias.deprecated {
+		deprecated(name, alias.algorithm)
+	}
+	return alias.algorithm, true
+}
+
+// resolveRetired returns the replacement of a retired algorithm, or a if
+// it is not retired
+func resolveRetired(a Algorithm) Algorithm {
+	replacement, ok := retiredAlgorithms[a]
+	if !ok {
+		return a
+	}
+	deprecated(a.String(), replacement)
+	return replacement
+}
@@ -0,0 +1,44 @@
+package compression
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestParseAlgorithm_Aliases(t *testing.T) {
+	for name, want := range map[string]Algorithm{"deflate": Flate, "GZ": Gzip, "zst": Zstd} {
+		if a, err := ParseAlgorithm(name); err != nil || a != want {
+			t.Errorf("ParseAlgorithm(%q) = %v, %v", name, a, err)
+		}
+	}
+	if Deflate != Flate {
+		t.Error("Deflate is not an alias of Flate")
+	}
+}
+
+func TestSetDeprecationHook(t *testing.T) {
+	aliases, retired := maps.Clone(algorithmAliases), maps.Clone(retiredAlgorithms)
+	t.Cleanup(func() {
+		algorithmAliases, retiredAlgorithms = aliases, retired
+		SetDeprecationHook(nil)
+	})
+	algorithmAliases["old-s2"] = algorithmAlias{algorithm: S2, deprecated: true}
+	retiredAlgorithms[Algorithm(99)] = Snappy
+
+	var calls []string
+	SetDeprecationHook(func(name, replacement string) {
+		calls = append(calls, name+"->"+replacement)
+	})
+
+	if a, err := ParseAlgorithm("old-s2"); err != nil || a != S2 {
+		t.Fatalf("ParseAlgorithm = %v, %v", a, err)
+	}
+	if m := New(Algorithm(99)); m.algorithm != Snappy {
+		t.Errorf("retired algorithm resolved to %v", m.algorithm)
+	}
+	ParseAlgorithm("deflate")
+
+	if len(calls) != 2 || calls[0] != "old-s2->s2" || calls[1] != "Algorithm(99)->snappy" {
+		t.Errorf("deprecation calls = %v", calls)
+	}
+}
@@ -0,0 +1,97 @@
+package compression
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// WithAllocTracking measures the heap allocations of every sampleEvery-th
+// Write and Read call and exposes them through Stats, so canaries catch
+// allocation regressions in the codec path. Like testing.AllocsPerRun, a
+// measurement reads the runtime memory statistics, which stops the world
+// briefly, so sampleEvery should be large in production. Allocations are
+// counted process-wide and include those of concurrent goroutines; the
+// numbers are most precise with little concurrent activity. A sampleEvery
+// of 0 or less disables tracking.
+func WithAllocTracking(sampleEvery int) Option {
+	return func(m *Middleware) {
+		m.allocSample = max(sampleEvery, 0)
+	}
+}
+
+// AllocStats is a snapshot of the allocations of sampled calls
+type AllocStats struct {
+	// Calls counts the sampled calls
+	Calls int64
+	// Objects and Bytes are the heap allocations of the sampled calls
+	Objects int64
+	Bytes   int64
+}
+
+// ObjectsPerCall returns the average number of allocations per sampled call
+func (a AllocStats) ObjectsPerCall() float64 {
+	if a.Calls == 0 {
+		return 0
+	}
+	return float64(a.Objects) / float64(a.Calls)
+}
+
+// BytesPerCall returns the average number of bytes allocated per sampled
+// call
+func (a AllocStats) BytesPerCall() float64 {
+	if a.Calls == 0 {
+		return 0
+	}
+	return float64(a.Bytes) / float64(a.Calls)
+}
+
+// allocCounter accumulates the allocations of sampled calls
+type allocCounter struct {
+	calls   counter
+	objects counter
+	bytes   counter
+	// seen counts all calls to pick the samples
+	seen atomic.Int64
+}
+
+// allocProbe measures the allocations of a sampled call
+type allocProbe struct {
+	c       *allocCounter
+	objects uint64
+	bytes   uint64
+}
+
+// start returns a probe if the call is sampled, nil otherwise
+func (a *allocCounter) start(every int) *allocProbe {
+	if every <= 0 || a.seen.Add(1)%int64(every) != 0 {
+		return nil
+	}
+	p := &allocProbe{c: a}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	p.objects, p.bytes = ms.Mallocs, ms.TotalAlloc
+	return p
+}
+
+// stop records the allocations since start. The probe itself is allocated
+// before the first reading and not counted.
+func (p *allocProbe) stop() {
+	if p == nil {
+		return
+	}
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	p.c.calls.Add(1)
+	p.c.objects.Add(int64(ms.Mallocs - p.objects))
+	p.c.bytes.Add(int64(ms.TotalAlloc - p.bytes))
+}
+
+func (a *allocCounter) linkTag(tag *allocCounter) {
+	a.calls.tag = &tag.calls
+	a.objects.tag = &tag.objects
+	a.bytes.tag = &tag.bytes
+}
+
+func (a *allocCounter) snapshot() AllocStats {
+	return AllocStats{Calls: a.calls.Load(), Objects: a.objects.Load(), Bytes: a.bytes.Load()}
+}
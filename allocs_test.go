@@ -0,0 +1,62 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestWithAllocTracking(t *testing.T) {
+	m := New(S2, WithAllocTracking(2))
+	data := bytes.Repeat([]byte("allocations "), 100)
+
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	for range 10 {
+		w.Write(data)
+	}
+	w.(io.Closer).Close()
+	r := m.Reader(&buf)
+	p := make([]byte, 100)
+	for range 4 {
+		r.Read(p)
+	}
+
+	s := m.Stats()
+	if s.WriteAllocs.Calls != 5 || s.ReadAllocs.Calls != 2 {
+		t.Fatalf("Expected 5 sampled writes and 2 sampled reads, got %d and %d", s.WriteAllocs.Calls, s.ReadAllocs.Calls)
+	}
+	t.Logf("write: %.1f allocs, %.0f bytes per call; read: %.1f allocs, %.0f bytes per call",
+		s.WriteAllocs.ObjectsPerCall(), s.WriteAllocs.BytesPerCall(), s.ReadAllocs.ObjectsPerCall(), s.ReadAllocs.BytesPerCall())
+	// Buffered S2 writes do not allocate once the writer is set up
+	if s.WriteAllocs.ObjectsPerCall() > 1 {
+		t.Fatalf("Expected at most one allocation per write, got %.1f", s.WriteAllocs.ObjectsPerCall())
+	}
+}
+
+func TestWithAllocTracking_Disabled(t *testing.T) {
+	for name, m := range map[string]*Middleware{
+		"unset":    New(S2),
+		"zero":     New(S2, WithAllocTracking(0)),
+		"negative": New(S2, WithAllocTracking(-1)),
+	} {
+		w := m.Writer(io.Discard)
+		w.Write([]byte("untracked"))
+		w.(io.Closer).Close()
+		if s := m.Stats(); s.WriteAllocs.Calls != 0 {
+			t.Errorf("%s: expected no samples, got %d", name, s.WriteAllocs.Calls)
+		}
+	}
+}
+
+var allocSink []byte
+
+func TestAllocCounter(t *testing.T) {
+	var c allocCounter
+	p := c.start(1)
+	allocSink = make([]byte, 1<<20)
+	p.stop()
+	if s := c.snapshot(); s.Calls != 1 || s.Objects < 1 || s.Bytes < 1<<20 {
+		t.Fatalf("Expected the 1 MiB allocation to be counted, got %+v", s)
+	}
+}
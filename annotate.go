@@ -0,0 +1,110 @@
+package compression
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// WithRatioAnnotations makes framed writers precede every frame with an
+// annotation holding its uncompressed size, compressed size and compression
+// ratio, so relays can route or tier frames by reading the headers with
+// ScanFrameAnnotations instead of decompressing them. Readers skip
+// annotations without the option. The option implies framing with
+// DefaultFrameSize unless WithFrameSize is set; streams using it cannot be
+// read by versions of this package before it.
+func WithRatioAnnotations() Option {
+	return func(m *Middleware) {
+		m.annotate = true
+		if m.frameSize == 0 {
+			m.frameSize = DefaultFrameSize
+		}
+	}
+}
+
+// FrameAnnotation describes a frame written with WithRatioAnnotations
+type FrameAnnotation struct {
+	// Size is the uncompressed size of the frame
+	Size int64
+	// CompressedSize is the size of the frame payload in the container
+	CompressedSize int64
+	// Ratio is Size divided by CompressedSize in hundredths, 0 for frames
+	// without payload such as runs of zeros
+	Ratio uint64
+}
+
+// annotationRecord returns the annotation of rec
+func annotationRecord(rec frameRecord) frameRecord {
+	size, compressed := uint64(rec.size), uint64(len(rec.payload))
+	var ratio uint64
+	if compressed > 0 {
+		ratio = size * 100 / compressed
+	}
+	payload := binary.AppendUvarint(nil, size)
+	payload = binary.AppendUvarint(payload, compressed)
+	payload = binary.AppendUvarint(payload, ratio)
+	return frameRecord{typ: frameAnnotation, payload: payload}
+}
+
+// parseAnnotation parses the payload of an annotation frame
+func parseAnnotation(payload []byte) (FrameAnnotation, error) {
+	var v [3]uint64
+	for i := range v {
+		n := 0
+		if v[i], n = binary.Uvarint(payload); n <= 0 {
+			return FrameAnnotation{}, fmt.Errorf("%w: bad frame annotation", ErrCorruptStream)
+		}
+		payload = payload[n:]
+	}
+	if len(payload) != 0 || v[0] > MaxFrameSize || v[1] > 2*MaxFrameSize {
+		return FrameAnnotation{}, fmt.Errorf("%w: bad frame annotation", ErrCorruptStream)
+	}
+	return FrameAnnotation{Size: int64(v[0]), CompressedSize: int64(v[1]), Ratio: v[2]}, nil
+}
+
+// ScanFrameAnnotations calls fn with the location and annotation of every
+// annotated frame of the container read from r, reading only frame headers
+// and annotations. Frames without an annotation are skipped. It stops at the
+// first error returned by fn.
+func ScanFrameAnnotations(r io.Reader, fn func(FrameInfo, FrameAnnotation) error) error {
+	var consumed counter
+	cr := containerReader{r: bufio.NewReader(&countingReader{r: r, n: &consumed})}
+	var fi FrameInfo
+	var pending *FrameAnnotation
+	for {
+		fi.CompressedOffset = consumed.Load() - int64(cr.r.Buffered())
+		rec, size, err := cr.nextHeader()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if rec.typ == frameAnnotation {
+			if rec.payload, err = cr.readPayload(size); err != nil {
+				return err
+			}
+			a, err := parseAnnotation(rec.payload)
+			if err != nil {
+				return err
+			}
+			pending = &a
+			continue
+		}
+		if _, err := cr.r.Discard(size); err != nil {
+			return fmt.Errorf("%w: truncated frame", io.ErrUnexpectedEOF)
+		}
+		if rec.size == 0 {
+			continue
+		}
+		fi.Size = int64(rec.size)
+		if pending != nil {
+			if err := fn(fi, *pending); err != nil {
+				return err
+			}
+			pending = nil
+		}
+		fi.UncompressedOffset += fi.Size
+	}
+}
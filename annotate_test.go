@@ -0,0 +1,136 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// scanAnnotations returns the annotated frames of container
+func scanAnnotations(t *testing.T, container []byte) ([]FrameInfo, []FrameAnnotation) {
+	t.Helper()
+	var infos []FrameInfo
+	var annotations []FrameAnnotation
+	err := ScanFrameAnnotations(bytes.NewReader(container), func(fi FrameInfo, a FrameAnnotation) error {
+		infos = append(infos, fi)
+		annotations = append(annotations, a)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to scan annotations: %v", err)
+	}
+	return infos, annotations
+}
+
+func TestWithRatioAnnotations(t *testing.T) {
+	requireZstd(t)
+	// A compressible frame, an incompressible one and a run of zeros
+	data := append(bytes.Repeat([]byte("hot hot hot "), 400)[:4096], snapshots(t, "a", 4096)...)
+	data = append(data, make([]byte, 4096)...)
+	m := New(Zstd, WithFrameSize(4096), WithRatioAnnotations(), WithSparseDetection())
+	container := writeContainer(t, m, data)
+
+	// Readers skip annotations without the option
+	got, err := io.ReadAll(New(Zstd, WithFrameSize(4096)).Reader(bytes.NewReader(container)))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	var out bytes.Buffer
+	if _, err := io.Copy(&out, New(Zstd, WithFrameSize(4096)).Reader(bytes.NewReader(container))); err != nil || !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("Failed to copy: %v", err)
+	}
+
+	infos, annotations := scanAnnotations(t, container)
+	index, err := ReadContainerIndex(bytes.NewReader(container), int64(len(container)))
+	if err != nil {
+		t.Fatalf("Failed to read the index: %v", err)
+	}
+	if len(annotations) != 3 {
+		t.Fatalf("Expected 3 annotated frames, got %d", len(annotations))
+	}
+	for i, a := range annotations {
+		if infos[i] != index[i] || a.Size != 4096 {
+			t.Fatalf("Frame %d: got %+v at %+v, want %+v", i, a, infos[i], index[i])
+		}
+	}
+	if hot := annotations[0]; hot.Ratio < 1000 || hot.Ratio != uint64(hot.Size*100/hot.CompressedSize) {
+		t.Fatalf("Expected a high ratio for the compressible frame, got %+v", hot)
+	}
+	if cold := annotations[1]; cold.Ratio > 100 || cold.CompressedSize < 4096 {
+		t.Fatalf("Expected a ratio of at most 1 for the incompressible frame, got %+v", cold)
+	}
+	if zeros := annotations[2]; zeros.CompressedSize != 0 || zeros.Ratio != 0 {
+		t.Fatalf("Expected no payload for the run of zeros, got %+v", zeros)
+	}
+}
+
+func TestWithRatioAnnotations_Hooks(t *testing.T) {
+	pad := func(_ uint64, _ byte, p []byte) ([]byte, error) { return append(bytes.Clone(p), 0, 0, 0, 0), nil }
+	unpad := func(_ uint64, _ byte, p []byte) ([]byte, error) { return p[:len(p)-4], nil }
+	data := bytes.Repeat([]byte("wrapped "), 1000)
+	plain := writeContainer(t, New(S2, WithFrameSize(2000), WithRatioAnnotations()), data)
+	wrapped := writeContainer(t, New(S2, WithFrameSize(2000), WithRatioAnnotations(), WithFramePayloadHooks(pad, unpad)), data)
+
+	_, before := scanAnnotations(t, plain)
+	_, after := scanAnnotations(t, wrapped)
+	for i := range after {
+		// Annotations hold the size stored, after the hooks
+		if after[i].CompressedSize != before[i].CompressedSize+4 {
+			t.Fatalf("Frame %d: expected the wrapped size, got %+v without hooks, %+v with", i, before[i], after[i])
+		}
+	}
+	got, err := io.ReadAll(New(S2, WithFrameSize(2000), WithFramePayloadHooks(pad, unpad)).Reader(bytes.NewReader(wrapped)))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to read: %v", err)
+	}
+}
+
+func TestWithRatioAnnotations_ExtractFrames(t *testing.T) {
+	requireZstd(t)
+	data := snapshots(t, "abab", 1000)
+	m := New(Zstd, WithFrameSize(1000), WithRatioAnnotations(), WithFrameDedup(4))
+	container := writeContainer(t, m, data)
+
+	var out bytes.Buffer
+	if err := ExtractFrames(bytes.NewReader(container), &out, FrameRange(1, 2)); err != nil {
+		t.Fatalf("Failed to extract frames: %v", err)
+	}
+	_, annotations := scanAnnotations(t, out.Bytes())
+	// The reference to frame 0 is extracted as the frame itself
+	if len(annotations) != 2 || annotations[1].CompressedSize < 1000 {
+		t.Fatalf("Expected annotations of the extracted frames, got %+v", annotations)
+	}
+	got, err := io.ReadAll(New(Zstd, WithFrameSize(1000)).Reader(&out))
+	if err != nil || !bytes.Equal(got, data[1000:3000]) {
+		t.Fatalf("Failed to read the extracted frames: %v", err)
+	}
+}
+
+func TestScanFrameAnnotations_Errors(t *testing.T) {
+	container := writeContainer(t, New(S2, WithFrameSize(1000), WithRatioAnnotations()), bytes.Repeat([]byte("x"), 3000))
+	errStop := errors.New("stop")
+	calls := 0
+	err := ScanFrameAnnotations(bytes.NewReader(container), func(FrameInfo, FrameAnnotation) error {
+		calls++
+		return errStop
+	})
+	if !errors.Is(err, errStop) || calls != 1 {
+		t.Fatalf("Expected the scan to stop at the callback error, got %v after %d calls", err, calls)
+	}
+
+	// The annotation of the first frame, with a trailing byte
+	i := bytes.IndexByte(container, frameAnnotation)
+	end := i + 3 + int(container[i+2])
+	bad := append(bytes.Clone(container[:i+2]), container[i+2]+1)
+	bad = append(append(append(bad, container[i+3:end]...), 0), container[end:]...)
+	if err := ScanFrameAnnotations(bytes.NewReader(bad), func(FrameInfo, FrameAnnotation) error { return nil }); !errors.Is(err, ErrCorruptStream) {
+		t.Fatalf("Expected a corrupt stream error, got %v", err)
+	}
+
+	// No annotations without the option
+	_, annotations := scanAnnotations(t, writeContainer(t, New(S2, WithFrameSize(1000)), bytes.Repeat([]byte("x"), 3000)))
+	if len(annotations) != 0 {
+		t.Fatalf("Expected no annotations, got %d", len(annotations))
+	}
+}
@@ -0,0 +1,305 @@
+package compression
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+// Archive layout, following the container layout
+//
+//	header:  magic "HBCA" | version (1 byte)
+//	entries: the compressed streams of the entries, back to back
+//	index:   uvarint entry count | per entry: uvarint name length | name |
+//	         algorithm (1 byte) | uvarint offset | uvarint compressed size |
+//	         uvarint uncompressed size | uvarint metadata count |
+//	         per metadata pair, sorted by key: uvarint key length | key |
+//	         uvarint value length | value
+//	trailer: index offset (uint64 LE) | entry count (uint32 LE) |
+//	         flags (uint32 LE) | magic "HBAT"
+const (
+	archiveMagic        = "HBCA"
+	archiveVersion      = 1
+	archiveTrailerMagic = "HBAT"
+	archiveHeaderSize   = len(archiveMagic) + 1
+)
+
+// ErrArchiveClosed is returned when adding entries to a closed ArchiveWriter
+var ErrArchiveClosed = errors.New("compression: archive closed")
+
+// ArchiveEntry describes an entry of an archive
+type ArchiveEntry struct {
+	Name string
+	// Meta holds the metadata passed to AddEntry
+	Meta      map[string]string
+	Algorithm Algorithm
+	// Offset is the offset of the compressed entry in the archive
+	Offset         int64
+	CompressedSize int64
+	// Size is the uncompressed size of the entry
+	Size int64
+}
+
+// ArchiveWriter bundles many compressed entries with an index into a single
+// file, e.g. the buffers spilled by a job. It is lighter than tar: entries
+// are compressed streams of the middleware, located through the index at the
+// end of the archive, so each can be read without reading the others.
+type ArchiveWriter struct {
+	m       *Middleware
+	out     *offsetWriter
+	entries []ArchiveEntry
+	names   map[string]bool
+	closed  bool
+	// err fails the archive once an entry could not be written
+	err error
+}
+
+// NewArchiveWriter returns an archive writer writing to w. Entries are
+// compressed with the middleware, which must be configured like the one
+// reading the archive. Close writes the index.
+func (m *Middleware) NewArchiveWriter(w io.Writer) *ArchiveWriter {
+	return &ArchiveWriter{m: m, out: &offsetWriter{w: w}, names: make(map[string]bool)}
+}
+
+// offsetWriter counts the bytes written to w
+type offsetWriter struct {
+	w      io.Writer
+	offset int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.Write(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+// AddEntry compresses the data read from r into the archive as an entry
+// with the given name and metadata. Names must be unique. If an entry
+// cannot be written, the archive is incomplete and all further calls fail.
+func (a *ArchiveWriter) AddEntry(name string, meta map[string]string, r io.Reader) error {
+	if a.closed {
+		return ErrArchiveClosed
+	}
+	if a.err != nil {
+		return a.err
+	}
+	if a.names[name] {
+		return fmt.Errorf("compression: duplicate archive entry %q", name)
+	}
+	if err := a.writeHeader(); err != nil {
+		a.err = err
+		return err
+	}
+	e := ArchiveEntry{Name: name, Meta: meta, Algorithm: a.m.algorithm, Offset: a.out.offset}
+	w, err := a.m.WriterE(a.out)
+	if err != nil {
+		return err
+	}
+	e.Size, err = io.Copy(w, r)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		a.err = fmt.Errorf("compression: archive entry %q: %w", name, err)
+		return a.err
+	}
+	e.CompressedSize = a.out.offset - e.Offset
+	a.entries = append(a.entries, e)
+	a.names[name] = true
+	return nil
+}
+
+// Close writes the index and trailer. It does not close the underlying
+// writer.
+func (a *ArchiveWriter) Close() error {
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	if a.err != nil {
+		return a.err
+	}
+	if err := a.writeHeader(); err != nil {
+		return err
+	}
+	indexOffset := a.out.offset
+	index := binary.AppendUvarint(nil, uint64(len(a.entries)))
+	for _, e := range a.entries {
+		index = appendArchiveString(index, e.Name)
+		index = append(index, byte(e.Algorithm))
+		index = binary.AppendUvarint(index, uint64(e.Offset))
+		index = binary.AppendUvarint(index, uint64(e.CompressedSize))
+		index = binary.AppendUvarint(index, uint64(e.Size))
+		keys := make([]string, 0, len(e.Meta))
+		for k := range e.Meta {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		index = binary.AppendUvarint(index, uint64(len(keys)))
+		for _, k := range keys {
+			index = appendArchiveString(index, k)
+			index = appendArchiveString(index, e.Meta[k])
+		}
+	}
+	trailer := binary.LittleEndian.AppendUint64(nil, uint64(indexOffset))
+	trailer = binary.LittleEndian.AppendUint32(trailer, uint32(len(a.entries)))
+	trailer = binary.LittleEndian.AppendUint32(trailer, 0)
+	trailer = append(trailer, archiveTrailerMagic...)
+	_, err := a.out.Write(append(index, trailer...))
+	return err
+}
+
+// writeHeader writes the archive header before the first entry
+func (a *ArchiveWriter) writeHeader() error {
+	if a.out.offset > 0 {
+		return nil
+	}
+	_, err := a.out.Write(append([]byte(archiveMagic), archiveVersion))
+	return err
+}
+
+func appendArchiveString(p []byte, s string) []byte {
+	p = binary.AppendUvarint(p, uint64(len(s)))
+	return append(p, s...)
+}
+
+// ArchiveReader reads entries of an archive written by ArchiveWriter
+type ArchiveReader struct {
+	m       *Middleware
+	r       io.ReaderAt
+	entries []ArchiveEntry
+	byName  map[string]int
+}
+
+// OpenArchive reads the index of the archive of the given size from r.
+// Entries are decompressed with the middleware.
+func (m *Middleware) OpenArchive(r io.ReaderAt, size int64) (*ArchiveReader, error) {
+	if size < int64(archiveHeaderSize+1+TrailerSize) {
+		return nil, fmt.Errorf("%w: archive too small", ErrCorruptStream)
+	}
+	header := make([]byte, archiveHeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if string(header[:len(archiveMagic)]) != archiveMagic {
+		return nil, fmt.Errorf("%w: bad archive magic", ErrCorruptStream)
+	}
+	if version := header[len(archiveMagic)]; version != archiveVersion {
+		return nil, fmt.Errorf("%w: archive version %d", ErrUnsupportedVersion, version)
+	}
+
+	trailer := make([]byte, TrailerSize)
+	if _, err := r.ReadAt(trailer, size-TrailerSize); err != nil {
+		return nil, err
+	}
+	if string(trailer[16:]) != archiveTrailerMagic {
+		return nil, fmt.Errorf("%w: bad archive trailer", ErrCorruptStream)
+	}
+	indexOffset := int64(binary.LittleEndian.Uint64(trailer))
+	if indexOffset < int64(archiveHeaderSize) || indexOffset > size-TrailerSize {
+		return nil, fmt.Errorf("%w: bad archive index offset", ErrCorruptStream)
+	}
+	index := make([]byte, size-TrailerSize-indexOffset)
+	if _, err := r.ReadAt(index, indexOffset); err != nil {
+		return nil, err
+	}
+
+	ar := &ArchiveReader{m: m, r: r, byName: make(map[string]int)}
+	d := archiveDecoder{p: index}
+	count := d.uvarint()
+	if count != uint64(binary.LittleEndian.Uint32(trailer[8:])) {
+		return nil, fmt.Errorf("%w: bad archive index", ErrCorruptStream)
+	}
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		e := ArchiveEntry{Name: d.string()}
+		e.Algorithm = Algorithm(d.byte())
+		e.Offset = int64(d.uvarint())
+		e.CompressedSize = int64(d.uvarint())
+		e.Size = int64(d.uvarint())
+		if n := d.uvarint(); n > 0 && d.err == nil {
+			e.Meta = make(map[string]string)
+			for j := uint64(0); j < n && d.err == nil; j++ {
+				k := d.string()
+				e.Meta[k] = d.string()
+			}
+		}
+		if e.Offset < int64(archiveHeaderSize) || e.CompressedSize < 0 || e.Offset > indexOffset || e.CompressedSize > indexOffset-e.Offset {
+			d.err = errors.New("entry outside of the archive")
+		}
+		ar.byName[e.Name] = len(ar.entries)
+		ar.entries = append(ar.entries, e)
+	}
+	if d.err != nil || len(d.p) != 0 {
+		return nil, fmt.Errorf("%w: bad archive index: %v", ErrCorruptStream, d.err)
+	}
+	return ar, nil
+}
+
+// Entries returns the entries in the order they were added
+func (a *ArchiveReader) Entries() []ArchiveEntry {
+	return a.entries
+}
+
+// Open returns a reader decompressing the entry with the given name. It
+// returns an error wrapping fs.ErrNotExist if there is none and an error
+// wrapping ErrUnsupportedAlgorithm if the entry was compressed with another
+// algorithm than the one of the middleware.
+func (a *ArchiveReader) Open(name string) (io.ReadCloser, error) {
+	i, ok := a.byName[name]
+	if !ok {
+		return nil, fmt.Errorf("compression: archive entry %q: %w", name, fs.ErrNotExist)
+	}
+	e := a.entries[i]
+	if e.Algorithm != a.m.algorithm {
+		return nil, fmt.Errorf("%w: archive entry %q is compressed with %s, not %s", ErrUnsupportedAlgorithm, name, e.Algorithm, a.m.algorithm)
+	}
+	return a.m.ReaderE(io.NewSectionReader(a.r, e.Offset, e.CompressedSize))
+}
+
+// archiveDecoder parses the archive index, keeping the first error
+type archiveDecoder struct {
+	p   []byte
+	err error
+}
+
+func (d *archiveDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.p)
+	if n <= 0 {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	d.p = d.p[n:]
+	return v
+}
+
+func (d *archiveDecoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.p) == 0 {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	b := d.p[0]
+	d.p = d.p[1:]
+	return b
+}
+
+func (d *archiveDecoder) string() string {
+	n := d.uvarint()
+	if d.err == nil && n > uint64(len(d.p)) {
+		d.err = io.ErrUnexpectedEOF
+	}
+	if d.err != nil {
+		return ""
+	}
+	s := string(d.p[:n])
+	d.p = d.p[n:]
+	return s
+}
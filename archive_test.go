@@ -0,0 +1,139 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	requireZstd(t)
+	for name, m := range map[string]*Middleware{
+		"zstd":   New(Zstd),
+		"framed": New(S2, WithFrameSize(4096)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			aw := m.NewArchiveWriter(&buf)
+			contents := map[string]string{}
+			for i := 0; i < 20; i++ {
+				name := fmt.Sprintf("buffer-%02d", i)
+				contents[name] = strings.Repeat(name+" ", i*100)
+				meta := map[string]string{"job": "nightly", "part": fmt.Sprint(i)}
+				if err := aw.AddEntry(name, meta, strings.NewReader(contents[name])); err != nil {
+					t.Fatalf("Failed to add %s: %v", name, err)
+				}
+			}
+			if err := aw.AddEntry("buffer-00", nil, strings.NewReader("again")); err == nil {
+				t.Fatal("Expected an error for a duplicate name")
+			}
+			if err := aw.Close(); err != nil {
+				t.Fatalf("Failed to close: %v", err)
+			}
+			if err := aw.AddEntry("late", nil, strings.NewReader("")); !errors.Is(err, ErrArchiveClosed) {
+				t.Fatalf("Expected ErrArchiveClosed, got %v", err)
+			}
+
+			ar, err := m.OpenArchive(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatalf("Failed to open: %v", err)
+			}
+			entries := ar.Entries()
+			if len(entries) != 20 {
+				t.Fatalf("Expected 20 entries, got %d", len(entries))
+			}
+			// read in reverse to check entries are independent
+			for i := len(entries) - 1; i >= 0; i-- {
+				e := entries[i]
+				if e.Meta["part"] != fmt.Sprint(i) || e.Meta["job"] != "nightly" || e.Size != int64(len(contents[e.Name])) {
+					t.Fatalf("Unexpected entry %+v", e)
+				}
+				r, err := ar.Open(e.Name)
+				if err != nil {
+					t.Fatalf("Failed to open %s: %v", e.Name, err)
+				}
+				got, err := io.ReadAll(r)
+				r.Close()
+				if err != nil || string(got) != contents[e.Name] {
+					t.Fatalf("Entry %s: got %d bytes, %v", e.Name, len(got), err)
+				}
+			}
+			if _, err := ar.Open("missing"); !errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("Expected fs.ErrNotExist, got %v", err)
+			}
+		})
+	}
+}
+
+func TestArchive_Empty(t *testing.T) {
+	m := New(Gzip)
+	var buf bytes.Buffer
+	if err := m.NewArchiveWriter(&buf).Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	ar, err := m.OpenArchive(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil || len(ar.Entries()) != 0 {
+		t.Fatalf("Expected an empty archive, got %v, %v", ar, err)
+	}
+}
+
+func TestOpenArchive_Corrupt(t *testing.T) {
+	m := New(Gzip)
+	var buf bytes.Buffer
+	aw := m.NewArchiveWriter(&buf)
+	aw.AddEntry("a", map[string]string{"k": "v"}, strings.NewReader("data"))
+	aw.Close()
+	archive := buf.Bytes()
+
+	for name, p := range map[string][]byte{
+		"truncated": archive[:len(archive)-1],
+		"magic":     append([]byte("XXXX"), archive[4:]...),
+		"index": func() []byte {
+			// the name length of the entry runs past the index
+			p := bytes.Clone(archive)
+			p[binary.LittleEndian.Uint64(p[len(p)-TrailerSize:])+1] = 0x7f
+			return p
+		}(),
+		"overflow": func() []byte {
+			// the entry size overflows the end of the entry
+			p := append([]byte(archiveMagic), archiveVersion)
+			indexOffset := len(p)
+			p = binary.AppendUvarint(p, 1)
+			p = appendArchiveString(p, "a")
+			p = append(p, byte(Gzip))
+			p = binary.AppendUvarint(p, uint64(archiveHeaderSize))
+			p = binary.AppendUvarint(p, math.MaxInt64)
+			p = binary.AppendUvarint(p, 0)
+			p = binary.AppendUvarint(p, 0)
+			p = binary.LittleEndian.AppendUint64(p, uint64(indexOffset))
+			p = binary.LittleEndian.AppendUint32(p, 1)
+			p = binary.LittleEndian.AppendUint32(p, 0)
+			return append(p, archiveTrailerMagic...)
+		}(),
+	} {
+		if _, err := m.OpenArchive(bytes.NewReader(p), int64(len(p))); !errors.Is(err, ErrCorruptStream) {
+			t.Errorf("%s: expected ErrCorruptStream, got %v", name, err)
+		}
+	}
+}
+
+func TestArchive_AlgorithmMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	aw := New(Gzip).NewArchiveWriter(&buf)
+	aw.AddEntry("a", nil, strings.NewReader("data"))
+	aw.Close()
+
+	ar, err := New(S2).OpenArchive(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed to open: %v", err)
+	}
+	if _, err := ar.Open("a"); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Fatalf("Expected ErrUnsupportedAlgorithm, got %v", err)
+	}
+}
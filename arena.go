@@ -0,0 +1,89 @@
+package compression
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Arena is a slab from which the scratch buffers of one stream are
+// allocated. All allocations are released at once when the stream is
+// closed. Allocations that do not fit the slab come from the heap.
+type Arena struct {
+	slab []byte
+	off  int
+}
+
+// Alloc returns n bytes from the arena. The memory is not zeroed.
+func (a *Arena) Alloc(n int) []byte {
+	if a == nil || len(a.slab)-a.off < n {
+		return make([]byte, n)
+	}
+	p := a.slab[a.off : a.off+n : a.off+n]
+	a.off += n
+	return p
+}
+
+// Reset releases all allocations
+func (a *Arena) Reset() {
+	a.off = 0
+}
+
+// ArenaPool hands out arenas with slabs of a fixed size
+type ArenaPool struct {
+	size int
+	pool sync.Pool
+}
+
+// NewArenaPool creates a pool of arenas with slabSize byte slabs. Framed
+// streams need about twice the frame size per codec.
+func NewArenaPool(slabSize int) *ArenaPool {
+	return &ArenaPool{size: slabSize}
+}
+
+// Get returns an empty arena
+func (p *ArenaPool) Get() *Arena {
+	if a, ok := p.pool.Get().(*Arena); ok {
+		return a
+	}
+	return &Arena{slab: make([]byte, p.size)}
+}
+
+// Put releases the allocations of a and returns it to the pool
+func (p *ArenaPool) Put(a *Arena) {
+	a.Reset()
+	p.pool.Put(a)
+}
+
+// WithArena allocates the per-stream scratch memory of framed writers and
+// readers, the frame and payload buffers, from arenas of p, which are
+// released in bulk when the stream is closed. This reduces GC pressure for
+// workloads creating many short-lived streams. Readers release their arena
+// on Close; neither writers nor readers may be used after Close. Memory
+// allocated inside the codecs is not affected.
+func WithArena(p *ArenaPool) Option {
+	return func(m *Middleware) {
+		m.arenas = p
+	}
+}
+
+// useArena allocates the frame buffer and the codec output buffers from a.
+// With a nil arena they are dropped and allocated from the heap on demand.
+func (f *framedWriter) useArena(a *Arena) {
+	if a == nil {
+		f.buf = nil
+		for _, c := range f.codecs {
+			c.out = bytes.Buffer{}
+		}
+		return
+	}
+	f.buf = a.Alloc(f.size)[:0]
+	for _, c := range f.codecs {
+		c.out = *bytes.NewBuffer(a.Alloc(f.size)[:0])
+	}
+}
+
+// useArena allocates the frame and payload buffers from a
+func (f *framedReader) useArena(a *Arena, size int) {
+	f.frame = a.Alloc(size)[:0]
+	f.cr.payload = a.Alloc(size)[:0]
+}
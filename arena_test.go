@@ -0,0 +1,54 @@
+package compression
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestWithArena(t *testing.T) {
+	requireZstd(t)
+	pool := NewArenaPool(4 * 16 << 10)
+	m := New(Zstd, WithFrameSize(16<<10), WithArena(pool))
+
+	for i := 0; i < 20; i++ {
+		data := bytes.Repeat([]byte(fmt.Sprintf("short lived stream %d ", i)), 2000)
+		var buf bytes.Buffer
+		w := m.Writer(&buf)
+		w.Write(data)
+		if err := w.(io.Closer).Close(); err != nil {
+			t.Fatalf("Failed to close: %v", err)
+		}
+		if f := w.(*writer).enc.(*framedWriter); f.buf != nil {
+			t.Fatal("Expected the arena buffers to be dropped on Close")
+		}
+
+		r := m.Reader(&buf)
+		got, err := io.ReadAll(r)
+		r.(io.Closer).Close()
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("Round trip %d failed: %v", i, err)
+		}
+	}
+}
+
+func TestArena_Alloc(t *testing.T) {
+	a := &Arena{slab: make([]byte, 100)}
+	p := a.Alloc(60)
+	q := a.Alloc(40)
+	if len(p) != 60 || cap(p) != 60 || &q[0] != &a.slab[60] {
+		t.Fatal("Expected consecutive allocations from the slab")
+	}
+	if r := a.Alloc(1); &r[0] == &a.slab[0] {
+		t.Fatal("Expected allocations beyond the slab from the heap")
+	}
+	a.Reset()
+	if r := a.Alloc(10); &r[0] != &a.slab[0] {
+		t.Fatal("Expected Reset to release the slab")
+	}
+	var none *Arena
+	if len(none.Alloc(5)) != 5 {
+		t.Fatal("Expected a nil arena to allocate from the heap")
+	}
+}
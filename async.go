@@ -0,0 +1,274 @@
+package compression
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by AsyncWriter.Write with BackpressureDrop when
+// the queue is full
+var ErrQueueFull = errors.New("compression: async queue full")
+
+// DefaultAsyncQueue is the number of writes an AsyncWriter buffers
+const DefaultAsyncQueue = 64
+
+// Backpressure selects what an AsyncWriter does when its queue is full
+type Backpressure int
+
+const (
+	// BackpressureBlock blocks Write until the compressor caught up
+	BackpressureBlock Backpressure = iota
+	// BackpressureDrop rejects the write with ErrQueueFull, the caller
+	// decides whether to retry or lose the data
+	BackpressureDrop
+	// BackpressureSpill appends the write to a temporary file, which the
+	// compressor drains in order once it caught up with the queue
+	BackpressureSpill
+)
+
+// AsyncWriter compresses in a background goroutine, so that Write only
+// copies the data into a queue. Configure the exported fields before the
+// first Write.
+type AsyncWriter struct {
+	// Queue is the number of writes buffered in memory. The default is
+	// DefaultAsyncQueue.
+	Queue int
+	// Backpressure selects the behaviour when the queue is full
+	Backpressure Backpressure
+	// SpillDir is the directory of the spill file of BackpressureSpill,
+	// the default is os.TempDir
+	SpillDir string
+
+	w      io.Writer
+	chunks chan asyncChunk
+	wake   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+	closed bool
+
+	mu       sync.Mutex
+	err      error
+	spill    *os.File
+	spillEnd int64
+	spillOff int64
+}
+
+// asyncChunk is a queued write with the time it was queued at, see
+// WithStoredUnderLag
+type asyncChunk struct {
+	data []byte
+	at   time.Time
+}
+
+// AsyncWriter creates a writer compressing to w in the background
+func (m *Middleware) AsyncWriter(w io.Writer) *AsyncWriter {
+	return &AsyncWriter{w: m.Writer(w)}
+}
+
+func (a *AsyncWriter) start() {
+	queue := a.Queue
+	if queue <= 0 {
+		queue = DefaultAsyncQueue
+	}
+	a.chunks = make(chan asyncChunk, queue)
+	a.wake = make(chan struct{}, 1)
+	a.done = make(chan struct{})
+	go a.run()
+}
+
+func (a *AsyncWriter) run() {
+	defer close(a.done)
+	for {
+		select {
+		case chunk, ok := <-a.chunks:
+			if !ok {
+				a.drainSpill()
+				return
+			}
+			a.encode(chunk)
+		case <-a.wake:
+			// Spilled writes follow everything queued before them
+			a.drainQueue()
+			a.drainSpill()
+		}
+	}
+}
+
+func (a *AsyncWriter) drainQueue() {
+	for {
+		select {
+		case chunk, ok := <-a.chunks:
+			if !ok {
+				return
+			}
+			a.encode(chunk)
+		default:
+			return
+		}
+	}
+}
+
+// drainSpill encodes the spilled writes until the spill file is empty
+func (a *AsyncWriter) drainSpill() {
+	for {
+		a.mu.Lock()
+		if a.spill == nil || a.spillOff == a.spillEnd {
+			if a.spill != nil {
+				// Caught up, writes go to the queue again
+				a.spill.Truncate(0)
+				a.spillOff, a.spillEnd = 0, 0
+			}
+			a.mu.Unlock()
+			return
+		}
+		chunk, err := a.readSpill()
+		a.mu.Unlock()
+		if err != nil {
+			a.fail(err)
+			return
+		}
+		a.encode(chunk)
+	}
+}
+
+// readSpill reads the next spilled write, stored as its size (uint32 LE),
+// the time it was queued at in Unix nanoseconds (uint64 LE) and its data
+func (a *AsyncWriter) readSpill() (asyncChunk, error) {
+	var hdr [12]byte
+	if _, err := a.spill.ReadAt(hdr[:], a.spillOff); err != nil {
+		return asyncChunk{}, err
+	}
+	chunk := asyncChunk{
+		data: make([]byte, binary.LittleEndian.Uint32(hdr[:])),
+		at:   time.Unix(0, int64(binary.LittleEndian.Uint64(hdr[4:]))),
+	}
+	if _, err := a.spill.ReadAt(chunk.data, a.spillOff+int64(len(hdr))); err != nil {
+		return asyncChunk{}, err
+	}
+	a.spillOff += int64(len(hdr) + len(chunk.data))
+	return chunk, nil
+}
+
+func (a *AsyncWriter) encode(chunk asyncChunk) {
+	if a.failed() != nil {
+		return
+	}
+	var err error
+	if w, ok := a.w.(*writer); ok {
+		_, err = w.writeSince(chunk.data, chunk.at)
+	} else {
+		_, err = a.w.Write(chunk.data)
+	}
+	if err != nil {
+		a.fail(err)
+	}
+}
+
+func (a *AsyncWriter) fail(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.err == nil {
+		a.err = err
+	}
+}
+
+func (a *AsyncWriter) failed() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// Write queues a copy of p. It returns errors of earlier writes, which
+// are compressed asynchronously.
+func (a *AsyncWriter) Write(p []byte) (int, error) {
+	if a.closed {
+		return 0, ErrClosed
+	}
+	a.once.Do(a.start)
+	if err := a.failed(); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	chunk := asyncChunk{data: append([]byte(nil), p...), at: time.Now()}
+
+	if a.Backpressure == BackpressureSpill {
+		a.mu.Lock()
+		spilling := a.spillEnd > 0
+		a.mu.Unlock()
+		if !spilling {
+			select {
+			case a.chunks <- chunk:
+				return len(p), nil
+			default:
+			}
+		}
+		return a.spillChunk(chunk)
+	}
+
+	select {
+	case a.chunks <- chunk:
+		return len(p), nil
+	default:
+	}
+	if a.Backpressure == BackpressureDrop {
+		return 0, ErrQueueFull
+	}
+	a.chunks <- chunk
+	return len(p), nil
+}
+
+// spillChunk appends chunk to the spill file and wakes the compressor
+func (a *AsyncWriter) spillChunk(chunk asyncChunk) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.spill == nil {
+		f, err := os.CreateTemp(a.SpillDir, "hybridbuffer-async-*")
+		if err != nil {
+			return 0, err
+		}
+		a.spill = f
+	}
+	var hdr [12]byte
+	binary.LittleEndian.PutUint32(hdr[:], uint32(len(chunk.data)))
+	binary.LittleEndian.PutUint64(hdr[4:], uint64(chunk.at.UnixNano()))
+	if _, err := a.spill.WriteAt(hdr[:], a.spillEnd); err != nil {
+		return 0, err
+	}
+	if _, err := a.spill.WriteAt(chunk.data, a.spillEnd+int64(len(hdr))); err != nil {
+		return 0, err
+	}
+	a.spillEnd += int64(len(hdr) + len(chunk.data))
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+	return len(chunk.data), nil
+}
+
+// Close waits until all queued and spilled writes are compressed and
+// closes the stream
+func (a *AsyncWriter) Close() error {
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	a.once.Do(a.start)
+	close(a.chunks)
+	<-a.done
+
+	if a.spill != nil {
+		a.spill.Close()
+		os.Remove(a.spill.Name())
+	}
+	err := a.w.(io.Closer).Close()
+	if werr := a.failed(); werr != nil {
+		err = werr
+	}
+	return err
+}
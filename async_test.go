@@ -0,0 +1,83 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// gatedWriter blocks writes until the gate is closed
+type gatedWriter struct {
+	gate chan struct{}
+	buf  bytes.Buffer
+}
+
+func (g *gatedWriter) Write(p []byte) (int, error) {
+	<-g.gate
+	return g.buf.Write(p)
+}
+
+func TestAsyncWriter(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, 20<<18)
+	rng.Read(data)
+
+	for _, bp := range []Backpressure{BackpressureBlock, BackpressureSpill} {
+		m := New(Gzip)
+		out := &gatedWriter{gate: make(chan struct{})}
+		a := m.AsyncWriter(out)
+		a.Queue = 2
+		a.Backpressure = bp
+		a.SpillDir = t.TempDir()
+
+		if bp == BackpressureBlock {
+			time.AfterFunc(50*time.Millisecond, func() { close(out.gate) })
+		}
+		for p := data; len(p) > 0; p = p[1<<18:] {
+			if _, err := a.Write(p[:1<<18]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if bp == BackpressureSpill {
+			if a.spill == nil {
+				t.Error("writes were not spilled")
+			}
+			close(out.gate)
+		}
+		if err := a.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := io.ReadAll(m.Reader(&out.buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("backpressure %d: content mismatch", bp)
+		}
+	}
+}
+
+func TestAsyncWriter_Drop(t *testing.T) {
+	out := &gatedWriter{gate: make(chan struct{})}
+	a := New(Gzip).AsyncWriter(out)
+	a.Queue = 1
+	a.Backpressure = BackpressureDrop
+
+	p := make([]byte, 1<<18)
+	rand.New(rand.NewSource(1)).Read(p)
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		_, err = a.Write(p)
+	}
+	if !errors.Is(err, ErrQueueFull) {
+		t.Errorf("expected ErrQueueFull, got %v", err)
+	}
+	close(out.gate)
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,73 @@
+package compression
+
+import "strconv"
+
+// Backend selects the implementation of the codecs
+type Backend int
+
+const (
+	// PureGo uses the pure Go codecs of klauspost/compress, the default
+	PureGo Backend = iota
+	// CgoZstd uses the zstd C library through DataDog/zstd for Zstd, for
+	// maximum throughput. It needs cgo and the cgozstd build tag.
+	CgoZstd
+)
+
+var backendNames = map[Backend]string{
+	PureGo:  "purego",
+	CgoZstd: "cgozstd",
+}
+
+// String returns the lower-case name of the backend
+func (b Backend) String() string {
+	if name, ok := backendNames[b]; ok {
+		return name
+	}
+	return "Backend(" + strconv.Itoa(int(b)) + ")"
+}
+
+// Available reports whether the backend is compiled into this binary
+func (b Backend) Available() bool {
+	switch b {
+	case PureGo:
+		return true
+	case CgoZstd:
+		return cgoZstdAvailable
+	}
+	return false
+}
+
+// WithBackend selects the codec implementation. Both backends read and
+// write the same format, so streams can be exchanged freely. If the backend
+// is not compiled in, the warning hook is called and the pure Go backend is
+// used. The cgo backend supports levels and static dictionaries; zstd
+// streams using other zstd options, such as WithMemoryBudget,
+// WithDeclaredSizes or WithDictionaryDelta, are handled by the pure Go
+// backend.
+func WithBackend(b Backend) Option {
+	return func(m *Middleware) {
+		m.backend = b
+	}
+}
+
+// useCgoZstd reports whether zstd streams use the cgo backend
+func (m *Middleware) useCgoZstd() bool {
+	return m.backend == CgoZstd && m.layered == nil && m.dictFile == nil && m.dictStore == nil && m.swapped.Load() == nil && m.memory == nil &&
+		!m.zeroFrames && !m.declaredSizes
+}
+
+// cgoZstdLevels maps the native zstd levels of klauspost/compress, see
+// CalibrateLevels, to levels of the C library with similar ratios
+var cgoZstdLevels = map[int]int{1: 1, 2: 3, 3: 7, 4: 11}
+
+// cgoZstdLevel returns the C library level for l
+func (m *Middleware) cgoZstdLevel(l Level) int {
+	native := map[Level]int{Fastest: 1, Default: 2, Better: 3, Best: 4}[l]
+	if n, ok := m.levels.Native(Zstd, l); ok {
+		native = n
+	}
+	if level, ok := cgoZstdLevels[native]; ok {
+		return level
+	}
+	return 3
+}
@@ -0,0 +1,33 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWithBackend_Unavailable(t *testing.T) {
+	requireZstd(t)
+	if cgoZstdAvailable {
+		t.Skip("cgo zstd backend compiled in")
+	}
+	var warnings []string
+	m := New(Zstd, WithBackend(CgoZstd), WithWarningHook(func(msg string) { warnings = append(warnings, msg) }))
+	if m.backend != PureGo || len(warnings) != 1 || !strings.Contains(warnings[0], "cgozstd") {
+		t.Fatalf("Expected a warning and the pure Go backend, got %v, %v", m.backend, warnings)
+	}
+	data := []byte(strings.Repeat("pure go fallback ", 100))
+	if got, err := io.ReadAll(m.Reader(bytes.NewReader(writeContainer(t, m, data)))); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to round trip: %v", err)
+	}
+}
+
+func TestBackend_String(t *testing.T) {
+	if PureGo.String() != "purego" || CgoZstd.String() != "cgozstd" || Backend(9).String() != "Backend(9)" {
+		t.Fatal("Unexpected backend names")
+	}
+	if !PureGo.Available() || Backend(9).Available() {
+		t.Fatal("Unexpected backend availability")
+	}
+}
@@ -0,0 +1,121 @@
+package compression
+
+import (
+	"io"
+	"sync"
+)
+
+// DefaultBroadcastQueue is the number of chunks a broadcast consumer may
+// lag behind before decoding waits for it
+const DefaultBroadcastQueue = 16
+
+// broadcastChunk is the size of the chunks decoded for broadcast consumers
+const broadcastChunk = 32 << 10
+
+// Broadcast decompresses r once and returns n readers that each return the
+// whole plaintext, e.g. to hash, index and store a stream without decoding
+// it n times. Decoding starts with the first Read of any consumer. Every
+// consumer has its own queue of DefaultBroadcastQueue chunks, so consumers
+// read at their own pace and decoding only waits for a consumer whose queue
+// is full. The readers implement io.Closer; a consumer that stops reading
+// early must be closed so it does not hold up the others. Decoding stops
+// once all consumers are closed.
+func (m *Middleware) Broadcast(r io.Reader, n int) []io.Reader {
+	if n <= 0 {
+		return nil
+	}
+	b := &broadcast{src: m.Reader(r)}
+	readers := make([]io.Reader, n)
+	for i := range readers {
+		c := &broadcastReader{
+			b:      b,
+			chunks: make(chan []byte, DefaultBroadcastQueue),
+			done:   make(chan struct{}),
+		}
+		b.consumers = append(b.consumers, c)
+		readers[i] = c
+	}
+	return readers
+}
+
+// broadcast decodes its source for several consumers
+type broadcast struct {
+	src       io.Reader
+	consumers []*broadcastReader
+	start     sync.Once
+}
+
+// decode reads the source and hands every chunk to the open consumers
+func (b *broadcast) decode() {
+	var err error
+	defer func() {
+		for _, c := range b.consumers {
+			c.err = err
+			close(c.chunks)
+		}
+		if cl, ok := b.src.(io.Closer); ok {
+			cl.Close()
+		}
+	}()
+	for err == nil {
+		buf := make([]byte, broadcastChunk)
+		var n int
+		n, err = b.src.Read(buf)
+		if n > 0 && !b.deliver(buf[:n]) {
+			err = ErrClosed
+		}
+	}
+}
+
+// deliver queues chunk for every open consumer and reports whether any is
+// left
+func (b *broadcast) deliver(chunk []byte) bool {
+	open := false
+	for _, c := range b.consumers {
+		select {
+		case c.chunks <- chunk:
+			open = true
+		case <-c.done:
+		}
+	}
+	return open
+}
+
+// broadcastReader is one consumer of a broadcast. The chunks are shared
+// and must not be modified.
+type broadcastReader struct {
+	b      *broadcast
+	chunks chan []byte
+	done   chan struct{}
+	stop   sync.Once
+	cur    []byte
+	// err is set by decode before chunks is closed
+	err error
+}
+
+func (c *broadcastReader) Read(p []byte) (int, error) {
+	c.b.start.Do(func() { go c.b.decode() })
+	select {
+	case <-c.done:
+		return 0, ErrClosed
+	default:
+	}
+	for len(c.cur) == 0 {
+		chunk, ok := <-c.chunks
+		if !ok {
+			return 0, c.err
+		}
+		c.cur = chunk
+	}
+	n := copy(p, c.cur)
+	c.cur = c.cur[n:]
+	return n, nil
+}
+
+// Close detaches the consumer. Decoding starts if it has not, so the
+// source is released once all consumers are closed.
+func (c *broadcastReader) Close() error {
+	c.stop.Do(func() { close(c.done) })
+	c.b.start.Do(func() { go c.b.decode() })
+	return nil
+}
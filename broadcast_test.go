@@ -0,0 +1,78 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBroadcast(t *testing.T) {
+	requireZstd(t)
+	data := []byte(strings.Repeat("decoded once, read three times ", 20000))
+	m := New(Zstd)
+	src := &countingSource{r: bytes.NewReader(writeContainer(t, m, data))}
+	readers := m.Broadcast(src, 3)
+
+	results := make([][]byte, len(readers))
+	errs := make([]error, len(readers))
+	var wg sync.WaitGroup
+	for i, r := range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Consumers read at different paces
+			buf := make([]byte, 1000*(i+1))
+			var out bytes.Buffer
+			for {
+				n, err := r.Read(buf)
+				out.Write(buf[:n])
+				if err != nil {
+					if err != io.EOF {
+						errs[i] = err
+					}
+					break
+				}
+			}
+			results[i] = out.Bytes()
+		}()
+	}
+	wg.Wait()
+	for i := range readers {
+		if errs[i] != nil || !bytes.Equal(results[i], data) {
+			t.Fatalf("Consumer %d failed: %v", i, errs[i])
+		}
+	}
+	if got, want := src.n.Load(), int64(len(writeContainer(t, m, data))); got != want {
+		t.Fatalf("Expected the stream to be read once, read %d of %d bytes", got, want)
+	}
+}
+
+func TestBroadcast_CloseConsumer(t *testing.T) {
+	data := []byte(strings.Repeat("a closed consumer does not hold up the others ", 50000))
+	m := New(S2)
+	readers := m.Broadcast(bytes.NewReader(writeContainer(t, m, data)), 2)
+	// The first consumer never reads and would fill its queue
+	readers[0].(io.Closer).Close()
+	if _, err := readers[0].Read(make([]byte, 1)); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed, got %v", err)
+	}
+	got, err := io.ReadAll(readers[1])
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to read: %v", err)
+	}
+}
+
+func TestBroadcast_Error(t *testing.T) {
+	readers := New(S2).Broadcast(strings.NewReader("not s2"), 2)
+	for _, r := range readers {
+		if _, err := io.ReadAll(r); err == nil {
+			t.Fatal("Expected the decode error for every consumer")
+		}
+	}
+	if New(S2).Broadcast(strings.NewReader(""), 0) != nil {
+		t.Fatal("Expected no readers")
+	}
+}
@@ -0,0 +1,56 @@
+package compression
+
+import (
+	"embed"
+	"fmt"
+	"strconv"
+)
+
+//go:generate sh -c "cd dictionaries && go run gen.go"
+
+//go:embed dictionaries/*.dict
+var builtinDictionaries embed.FS
+
+// BuiltinDictionary is a zstd dictionary shipped with the package, trained
+// on a common text format. Dictionaries mostly help small payloads of a few
+// KiB, which otherwise compress poorly.
+type BuiltinDictionary int
+
+const (
+	// JSONDict is trained on JSON API objects
+	JSONDict BuiltinDictionary = iota + 1
+	// CSVDict is trained on CSV exports with a header row
+	CSVDict
+	// LogDict is trained on access logs and logfmt lines
+	LogDict
+	// HTMLDict is trained on HTML pages
+	HTMLDict
+)
+
+var builtinDictionaryNames = map[BuiltinDictionary]string{
+	JSONDict: "json",
+	CSVDict:  "csv",
+	LogDict:  "log",
+	HTMLDict: "html",
+}
+
+// String returns the lower-case name of the dictionary
+func (d BuiltinDictionary) String() string {
+	if name, ok := builtinDictionaryNames[d]; ok {
+		return name
+	}
+	return "BuiltinDictionary(" + strconv.Itoa(int(d)) + ")"
+}
+
+// WithBuiltinDictionary uses one of the dictionaries shipped with the
+// package. Like WithZstdDictionary it only applies to zstd, and readers need
+// the same dictionary.
+func WithBuiltinDictionary(d BuiltinDictionary) Option {
+	name, ok := builtinDictionaryNames[d]
+	if !ok {
+		return func(m *Middleware) {
+			m.fail(fmt.Errorf("%w: unknown %s", ErrInvalidDictionary, d))
+		}
+	}
+	return WithEmbeddedDictionary(builtinDictionaries, "dictionaries/"+name+".dict")
+}
@@ -0,0 +1,34 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestWithBuiltinDictionary(t *testing.T) {
+	requireZstd(t)
+	payloads := map[BuiltinDictionary]string{
+		JSONDict: `{"id":4711,"name":"alice","email":"bob@example.com","active":true,"created_at":"2024-03-12T10:22:01Z","tags":["info","carol"],"score":12.34}`,
+		CSVDict:  "id,name,email,created_at,amount,currency,status\n17,dave,erin@example.com,2024-05-01 12:00:00,12.50,EUR,paid\n",
+		LogDict:  `10.0.1.2 - - [03/Oct/2024:10:11:12 +0000] "GET /api/v1/users HTTP/1.1" 200 512 "-" "curl/8.4.0"` + "\n",
+		HTMLDict: `<!DOCTYPE html><html lang="en"><head><meta charset="utf-8"><title>alice</title></head><body><div class="container"><h1>Welcome</h1></div></body></html>`,
+	}
+	for d, payload := range payloads {
+		plain := writeContainer(t, New(Zstd), []byte(payload))
+		m := New(Zstd, WithBuiltinDictionary(d))
+		compressed := writeContainer(t, m, []byte(payload))
+		if len(compressed) >= len(plain) {
+			t.Errorf("%s: %d bytes with dictionary, %d without", d, len(compressed), len(plain))
+		}
+		got, err := io.ReadAll(m.Reader(bytes.NewReader(compressed)))
+		if err != nil || string(got) != payload {
+			t.Errorf("%s: round trip failed: %v", d, err)
+		}
+	}
+
+	if _, err := New(Zstd, WithBuiltinDictionary(42)).Writer(io.Discard).Write([]byte("x")); !errors.Is(err, ErrInvalidDictionary) {
+		t.Errorf("expected ErrInvalidDictionary, got %v", err)
+	}
+}
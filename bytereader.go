@@ -0,0 +1,85 @@
+package compression
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// byteReadSize is the number of bytes ReadByte and ReadRune decode ahead
+const byteReadSize = 512
+
+var (
+	_ io.ByteReader = (*reader)(nil)
+	_ io.RuneReader = (*reader)(nil)
+)
+
+// ReadByte reads the next byte, so binary decoders such as encoding/gob and
+// varint readers can consume the stream without another bufio layer. Bytes
+// are decoded ahead in small batches; Read, WriteTo and DecodeInto return
+// them first.
+func (r *reader) ReadByte() (byte, error) {
+	if len(r.ahead) == 0 {
+		if err := r.readAhead(1); err != nil {
+			return 0, err
+		}
+	}
+	c := r.ahead[0]
+	r.ahead = r.ahead[1:]
+	return c, nil
+}
+
+// ReadRune reads the next UTF-8 encoded rune like bufio.Reader.ReadRune.
+// Invalid encodings are returned as utf8.RuneError of size 1.
+func (r *reader) ReadRune() (rune, int, error) {
+	for len(r.ahead) < utf8.UTFMax && !utf8.FullRune(r.ahead) && r.aheadErr == nil {
+		r.readAhead(len(r.ahead) + 1)
+	}
+	if len(r.ahead) == 0 {
+		return 0, 0, r.aheadErr
+	}
+	c, size := rune(r.ahead[0]), 1
+	if c >= utf8.RuneSelf {
+		c, size = utf8.DecodeRune(r.ahead)
+	}
+	r.ahead = r.ahead[size:]
+	return c, size, nil
+}
+
+// readAhead decodes until at least n bytes are ahead or the stream fails.
+// It returns the error once nothing is ahead anymore.
+func (r *reader) readAhead(n int) error {
+	if r.aheadBuf == nil {
+		r.aheadBuf = make([]byte, byteReadSize)
+	}
+	m := copy(r.aheadBuf, r.ahead)
+	for m < n && r.aheadErr == nil {
+		var k int
+		k, r.aheadErr = r.read(r.aheadBuf[m:])
+		m += k
+	}
+	r.ahead = r.aheadBuf[:m]
+	if m == 0 {
+		return r.aheadErr
+	}
+	return nil
+}
+
+// writeAhead writes the bytes decoded ahead to w. done is set if the
+// stream ended while decoding ahead.
+func (r *reader) writeAhead(w io.Writer) (n int64, done bool, err error) {
+	if len(r.ahead) > 0 {
+		k, err := w.Write(r.ahead)
+		r.ahead = r.ahead[k:]
+		if err != nil {
+			return int64(k), true, err
+		}
+		n = int64(k)
+	}
+	if r.aheadErr == nil {
+		return n, false, nil
+	}
+	if r.aheadErr != io.EOF {
+		err = r.aheadErr
+	}
+	return n, true, err
+}
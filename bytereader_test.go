@@ -0,0 +1,115 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestReadByte_Varints(t *testing.T) {
+	requireZstd(t)
+	var plain []byte
+	for i := uint64(0); i < 5000; i++ {
+		plain = binary.AppendUvarint(plain, i*i)
+	}
+	m := New(Zstd)
+	r := m.Reader(bytes.NewReader(writeContainer(t, m, plain)))
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		t.Fatal("Expected readers to implement io.ByteReader")
+	}
+	for i := uint64(0); i < 5000; i++ {
+		v, err := binary.ReadUvarint(br)
+		if err != nil || v != i*i {
+			t.Fatalf("Varint %d: got %d, %v", i, v, err)
+		}
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+}
+
+func TestReadByte_Gob(t *testing.T) {
+	type record struct {
+		Name  string
+		Count int
+	}
+	var buf bytes.Buffer
+	m := New(S2)
+	w := m.Writer(&buf)
+	enc := gob.NewEncoder(w)
+	for i := 0; i < 100; i++ {
+		enc.Encode(record{"record", i})
+	}
+	w.(io.Closer).Close()
+
+	dec := gob.NewDecoder(m.Reader(&buf))
+	for i := 0; i < 100; i++ {
+		var got record
+		if err := dec.Decode(&got); err != nil || got.Count != i {
+			t.Fatalf("Record %d: got %+v, %v", i, got, err)
+		}
+	}
+}
+
+func TestReadRune(t *testing.T) {
+	// the three byte runes cross the batches of ReadRune
+	text := strings.Repeat("ab€c ünïcode ", 300) + "\xff"
+	m := New(Gzip)
+	r := m.Reader(bytes.NewReader(writeContainer(t, m, []byte(text))))
+	rr, ok := r.(io.RuneReader)
+	if !ok {
+		t.Fatal("Expected readers to implement io.RuneReader")
+	}
+	var got []rune
+	for {
+		c, size, err := rr.ReadRune()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Failed to read rune: %v", err)
+		}
+		if c == utf8.RuneError && size != 1 {
+			t.Fatalf("Expected size 1 for an invalid encoding, got %d", size)
+		}
+		got = append(got, c)
+	}
+	want := []rune(text)
+	if string(got) != string(want) {
+		t.Fatalf("Runes mismatch: got %d runes, want %d", len(got), len(want))
+	}
+}
+
+func TestReadByte_MixedReads(t *testing.T) {
+	requireZstd(t)
+	data := bytes.Repeat([]byte("bytes read ahead come first "), 100)
+	m := New(Zstd)
+	stream := writeContainer(t, m, data)
+
+	r := m.Reader(bytes.NewReader(stream))
+	first, _ := r.(io.ByteReader).ReadByte()
+	p := make([]byte, 10)
+	n, err := r.Read(p)
+	if err != nil || first != data[0] || !bytes.Equal(p[:n], data[1:1+n]) {
+		t.Fatalf("Read after ReadByte: got %q, %v", p[:n], err)
+	}
+	var rest bytes.Buffer
+	if _, err := r.(io.WriterTo).WriteTo(&rest); err != nil {
+		t.Fatalf("Failed to write to: %v", err)
+	}
+	if got := append(append([]byte{first}, p[:n]...), rest.Bytes()...); !bytes.Equal(got, data) {
+		t.Fatalf("Data mismatch: got %d bytes, want %d", len(got), len(data))
+	}
+
+	r = m.Reader(bytes.NewReader(stream))
+	r.(io.ByteReader).ReadByte()
+	dst := make([]byte, len(data))
+	n, err = r.(DirectDecoder).DecodeInto(dst[1:])
+	if err != nil || n != len(data)-1 || !bytes.Equal(dst[1:], data[1:]) {
+		t.Fatalf("DecodeInto after ReadByte: got %d, %v", n, err)
+	}
+}
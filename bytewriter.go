@@ -0,0 +1,53 @@
+package compression
+
+import "io"
+
+// byteBufferSize is the number of bytes WriteByte collects before passing
+// them to the encoder
+const byteBufferSize = 512
+
+var _ io.ByteWriter = (*writer)(nil)
+
+// WriteByte writes c. Bytes are collected in a small buffer and passed to
+// the encoder together, so serializers emitting single bytes do not pay for
+// the full Write path on each of them. Write, Flush, Close and the other
+// methods of the writer write the buffered bytes first, in order. Errors of
+// the encoder or the quota are returned by the WriteByte call that fills the
+// buffer or by the next of these methods. As the buffered bytes were
+// accepted already, such an error is sticky: all further calls return it
+// and Close does not end the stream, so readers see it truncated.
+func (w *writer) WriteByte(c byte) error {
+	w.lock()
+	defer w.unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if w.bytes == nil {
+		w.bytes = make([]byte, 0, byteBufferSize)
+	}
+	w.bytes = append(w.bytes, c)
+	if len(w.bytes) < cap(w.bytes) {
+		return nil
+	}
+	return w.drainBytes()
+}
+
+// drainBytes passes the bytes collected by WriteByte to the encoder, or
+// returns the sticky error of the writer. The caller holds the lock.
+func (w *writer) drainBytes() error {
+	if w.err != nil {
+		return w.err
+	}
+	if len(w.bytes) == 0 {
+		return nil
+	}
+	n, err := w.writeLocked(w.bytes, w.enc.Write)
+	w.bytes = w.bytes[:copy(w.bytes, w.bytes[n:])]
+	if err != nil {
+		w.err = err
+	}
+	return err
+}
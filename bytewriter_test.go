@@ -0,0 +1,119 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestWriteByte(t *testing.T) {
+	requireZstd(t)
+	data := bytes.Repeat([]byte("tokens written byte by byte and in chunks "), 200)
+	for _, opts := range [][]Option{nil, {WithFrameSize(4096)}} {
+		m := New(Zstd, opts...)
+		var buf bytes.Buffer
+		w := m.Writer(&buf)
+		bw, ok := w.(io.ByteWriter)
+		if !ok {
+			t.Fatal("Expected writers to implement io.ByteWriter")
+		}
+		// interleave single bytes with writes to check the order is kept
+		for i := 0; i < len(data); {
+			if i%3 == 0 {
+				end := min(i+37, len(data))
+				if _, err := w.Write(data[i:end]); err != nil {
+					t.Fatalf("Failed to write: %v", err)
+				}
+				i = end
+				continue
+			}
+			if err := bw.WriteByte(data[i]); err != nil {
+				t.Fatalf("Failed to write byte: %v", err)
+			}
+			i++
+		}
+		if err := w.(io.Closer).Close(); err != nil {
+			t.Fatalf("Failed to close: %v", err)
+		}
+		got, err := io.ReadAll(m.Reader(&buf))
+		if err != nil {
+			t.Fatalf("Failed to read: %v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("Data mismatch: got %d bytes, want %d", len(got), len(data))
+		}
+		if s := m.Stats(); s.BytesWritten != int64(len(data)) {
+			t.Errorf("Expected %d bytes written, got %d", len(data), s.BytesWritten)
+		}
+	}
+}
+
+func TestWriteByte_Flush(t *testing.T) {
+	m := New(S2)
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	for _, c := range []byte("flushed") {
+		w.(io.ByteWriter).WriteByte(c)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("Expected the bytes to be buffered, got %d bytes out", buf.Len())
+	}
+	if err := w.(interface{ Flush() error }).Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	got, _ := io.ReadAll(m.Reader(bytes.NewReader(buf.Bytes())))
+	if string(got) != "flushed" {
+		t.Fatalf("Expected the flushed bytes, got %q", got)
+	}
+
+	w.(io.Closer).Close()
+	if err := w.(io.ByteWriter).WriteByte('x'); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestWriteByte_Quota(t *testing.T) {
+	m := New(Gzip, WithQuota(Quota{MaxBytes: 10}))
+	w := m.Writer(io.Discard)
+	var err error
+	for i := 0; i < byteBufferSize && err == nil; i++ {
+		err = w.(io.ByteWriter).WriteByte('q')
+	}
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected the full buffer to exceed the quota, got %v", err)
+	}
+}
+
+func TestWriteByte_Sticky(t *testing.T) {
+	m := New(S2, WithFrameSize(4096), WithQuota(Quota{MaxBytes: 100}))
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	w.Write([]byte("written before"))
+	w.(interface{ Flush() error }).Flush()
+	var err error
+	for i := 0; i < byteBufferSize && err == nil; i++ {
+		err = w.(io.ByteWriter).WriteByte('q')
+	}
+	if !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected the full buffer to exceed the quota, got %v", err)
+	}
+	// The accepted bytes are lost, so the writer fails until closed
+	if _, err := w.Write([]byte("x")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected the sticky error from Write, got %v", err)
+	}
+	if err := w.(io.Closer).Close(); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected the sticky error from Close, got %v", err)
+	}
+	if _, err := io.ReadAll(m.Reader(&buf)); err == nil {
+		t.Fatal("Expected the stream to end without end marker")
+	}
+}
+
+func BenchmarkWriteByte(b *testing.B) {
+	w := New(S2).Writer(io.Discard).(io.ByteWriter)
+	b.SetBytes(1)
+	for i := 0; i < b.N; i++ {
+		w.WriteByte(byte(i))
+	}
+}
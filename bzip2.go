@@ -0,0 +1,63 @@
+package compression
+
+import (
+	"io"
+
+	"github.com/dsnet/compress/bzip2"
+)
+
+// Bzip2 compression methods
+func (m *Middleware) createBzip2Writer(w io.Writer, l Level) encoder {
+	var level int
+	switch l {
+	case Fastest:
+		level = bzip2.BestSpeed
+	case Default:
+		level = bzip2.DefaultCompression
+	case Better:
+		level = bzip2.BestCompression - 1
+	case Best:
+		level = bzip2.BestCompression
+	}
+	if n, ok := m.levels.Native(Bzip2, l); ok {
+		level = n
+	}
+
+	bzip2Writer, err := bzip2.NewWriter(w, &bzip2.WriterConfig{Level: level})
+	if err != nil {
+		panic("failed to create bzip2 writer: " + err.Error())
+	}
+	return &bzip2WriteCloser{bzip2Writer}
+}
+
+func (m *Middleware) createBzip2Reader(r io.Reader) io.Reader {
+	bzip2Reader, err := bzip2.NewReader(r, nil)
+	if err != nil {
+		return &errReader{err}
+	}
+	return bzip2Reader
+}
+
+// bzip2WriteCloser adapts the Reset of bzip2.Writer, which cannot fail for a
+// writer created with a valid level
+type bzip2WriteCloser struct {
+	*bzip2.Writer
+}
+
+func (w *bzip2WriteCloser) Reset(dst io.Writer) {
+	w.Writer.Reset(dst)
+}
+
+// bzip2BlockSize is the block size of a bzip2 level
+func bzip2BlockSize(l Level) int64 {
+	switch l {
+	case Fastest:
+		return 100_000
+	case Default:
+		return 600_000
+	case Better:
+		return 800_000
+	default:
+		return 900_000
+	}
+}
@@ -0,0 +1,51 @@
+package compression
+
+import (
+	"bytes"
+	stdbzip2 "compress/bzip2"
+	"io"
+	"os/exec"
+	"testing"
+)
+
+func TestBzip2_Levels(t *testing.T) {
+	data := bytes.Repeat([]byte("legacy partners still send bzip2 "), 2000)
+	for _, l := range []Level{Fastest, Default, Better, Best} {
+		m := New(Bzip2, WithLevel(l))
+		compressed := writeContainer(t, m, data)
+		if !bytes.HasPrefix(compressed, []byte("BZh")) {
+			t.Fatalf("%s: expected a bzip2 header, got %q", l, compressed[:3])
+		}
+		got, err := io.ReadAll(stdbzip2.NewReader(bytes.NewReader(compressed)))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%s: stdlib failed to decode: %v", l, err)
+		}
+		if err := VerifyStdlib(Bzip2, bytes.NewReader(compressed)); err != nil {
+			t.Fatalf("%s: VerifyStdlib failed: %v", l, err)
+		}
+	}
+}
+
+func TestBzip2_ReadsExternalStreams(t *testing.T) {
+	path, err := exec.LookPath("bzip2")
+	if err != nil {
+		t.Skip("bzip2 not installed")
+	}
+	data := bytes.Repeat([]byte("written by the bzip2 tool "), 500)
+	cmd := exec.Command(path, "-c")
+	cmd.Stdin = bytes.NewReader(data)
+	compressed, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("Failed to run bzip2: %v", err)
+	}
+	got, err := io.ReadAll(New(Bzip2).Reader(bytes.NewReader(compressed)))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to read the bzip2 stream: %v", err)
+	}
+}
+
+func TestBzip2_Parse(t *testing.T) {
+	if a, err := ParseAlgorithm("bz2"); err != nil || a != Bzip2 {
+		t.Fatalf("Expected bz2 to parse as Bzip2, got %v, %v", a, err)
+	}
+}
@@ -0,0 +1,198 @@
+package compression
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// CacheMode selects how a ReadCache stores decoded streams
+type CacheMode int
+
+const (
+	// CacheRaw stores the decoded bytes as is
+	CacheRaw CacheMode = iota
+	// CacheZstd stores the decoded bytes recompressed with fast zstd,
+	// trading some CPU on each hit for a smaller footprint. Without zstd
+	// support (nozstd tag) it behaves like CacheRaw.
+	CacheZstd
+)
+
+// ReadCache keeps decoded copies of recently read streams, identified by
+// the SHA-256 of their compressed bytes, so that reading the same buffer
+// again skips the slow decoder. It is bounded by the bytes it stores and
+// evicts the least recently used streams first. A ReadCache may be shared
+// by middlewares using the same dictionary.
+type ReadCache struct {
+	mode     CacheMode
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	lru     list.List
+	entries map[[sha256.Size]byte]*list.Element
+	hits    int64
+	misses  int64
+}
+
+type cacheEntry struct {
+	key  [sha256.Size]byte
+	data []byte
+}
+
+// NewReadCache creates a cache holding at most maxBytes of stored streams
+func NewReadCache(maxBytes int64, mode CacheMode) *ReadCache {
+	return &ReadCache{
+		mode:     mode,
+		maxBytes: maxBytes,
+		entries:  make(map[[sha256.Size]byte]*list.Element),
+	}
+}
+
+// ReadCacheStats counts the lookups of a ReadCache
+type ReadCacheStats struct {
+	Hits    int64
+	Misses  int64
+	Entries int
+	Bytes   int64
+}
+
+// Stats returns the current cache statistics
+func (c *ReadCache) Stats() ReadCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ReadCacheStats{Hits: c.hits, Misses: c.misses, Entries: len(c.entries), Bytes: c.size}
+}
+
+func (c *ReadCache) get(key [sha256.Size]byte) ([]byte, bool) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		c.mu.Unlock()
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(e)
+	data := e.Value.(*cacheEntry).data
+	c.mu.Unlock()
+
+	if c.mode != CacheZstd {
+		return data, true
+	}
+	p, err := zstdExpand(data)
+	if err != nil {
+		return nil, false
+	}
+	return p, true
+}
+
+func (c *ReadCache) put(key [sha256.Size]byte, p []byte) {
+	data := p
+	if c.mode == CacheZstd {
+		data = zstdCompact(p)
+	}
+	if int64(len(data)) > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, data: data})
+	c.size += int64(len(data))
+	for c.size > c.maxBytes {
+		e := c.lru.Back()
+		old := c.lru.Remove(e).(*cacheEntry)
+		delete(c.entries, old.key)
+		c.size -= int64(len(old.data))
+	}
+}
+
+// WithReadCache serves repeated reads of the same compressed stream from
+// c. Readers then consume the compressed input up front to identify it, so
+// this suits buffers that are read several times, especially gzip ones whose
+// decoder is slow. Streams whose compressed or decoded size exceeds the
+// capacity of c or the memory budget of a stream are decoded as they are
+// read and not cached.
+func WithReadCache(c *ReadCache) Option {
+	return func(m *Middleware) {
+		m.readCache = c
+	}
+}
+
+// cachedReader returns a reader over the decoded content of r, served from
+// the read cache when possible
+func (m *Middleware) cachedReader(r io.Reader) io.Reader {
+	limit := m.readCache.maxBytes
+	if budget := m.streamBudget(); budget > 0 {
+		limit = min(limit, budget)
+	}
+	in := &countingReader{r: r, n: &m.stats.bytesRead}
+	compressed, err := io.ReadAll(io.LimitReader(in, limit+1))
+	if err != nil {
+		return &errReader{err}
+	}
+	if int64(len(compressed)) > limit {
+		return m.uncachedReader(io.MultiReader(bytes.NewReader(compressed), in))
+	}
+	h := sha256.New()
+	h.Write([]byte{byte(m.algorithm)})
+	h.Write(compressed)
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+
+	if decoded, ok := m.readCache.get(key); ok {
+		if err := m.checkCached(len(compressed), len(decoded)); err != nil {
+			return &errReader{err}
+		}
+		return bytes.NewReader(decoded)
+	}
+	dec := m.uncachedReader(bytes.NewReader(compressed))
+	decoded, err := io.ReadAll(io.LimitReader(dec, limit+1))
+	if c, ok := dec.(io.Closer); ok {
+		c.Close()
+	}
+	if err != nil {
+		return &errReader{err}
+	}
+	if int64(len(decoded)) > limit {
+		// Too large to cache, decode again as it is read
+		return m.uncachedReader(bytes.NewReader(compressed))
+	}
+	m.readCache.put(key, decoded)
+	return bytes.NewReader(decoded)
+}
+
+// uncachedReader decodes r with the read limits of m. r is already counted
+// in the stats.
+func (m *Middleware) uncachedReader(r io.Reader) io.Reader {
+	in := &countingReader{r: r, n: &counter{}}
+	var dec io.Reader
+	if m.frameSize > 0 {
+		dec = m.newFramedReader(r, in)
+	} else {
+		dec = m.newDecoder(m.algorithm, in)
+	}
+	if m.readLimited() {
+		return &limitedReader{m: m, dec: dec, in: in}
+	}
+	return dec
+}
+
+// checkCached applies the read limits to a stream served from the cache,
+// which may have been filled by a middleware with other limits
+func (m *Middleware) checkCached(compressed, decoded int) error {
+	if max := m.maxDecoded; max > 0 && int64(decoded) > max {
+		return fmt.Errorf("%w: more than %d bytes", ErrDecompressedSize, max)
+	}
+	if max := m.maxExpansion; max > 0 && float64(decoded) > max*float64(compressed) {
+		return fmt.Errorf("%w: %d bytes decompressed from %d, more than %g times", ErrExpansionRatio, decoded, compressed, max)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestWithReadCache(t *testing.T) {
+	for _, mode := range []CacheMode{CacheRaw, CacheZstd} {
+		cache := NewReadCache(1<<20, mode)
+		m := New(Gzip, WithReadCache(cache))
+
+		data := bytes.Repeat([]byte("cached gzip content "), 1000)
+		compressed := writeContainer(t, m, data)
+
+		for i := 0; i < 3; i++ {
+			got, err := io.ReadAll(m.Reader(bytes.NewReader(compressed)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("mode %d read %d: content mismatch", mode, i)
+			}
+		}
+		st := cache.Stats()
+		if st.Hits != 2 || st.Misses != 1 || st.Entries != 1 {
+			t.Errorf("mode %d: stats = %+v", mode, st)
+		}
+	}
+}
+
+func TestReadCache_Evicts(t *testing.T) {
+	requireZstd(t)
+	cache := NewReadCache(2500, CacheRaw)
+	m := New(Zstd, WithReadCache(cache))
+
+	streams := make([][]byte, 3)
+	for i := range streams {
+		streams[i] = writeContainer(t, m, bytes.Repeat([]byte{byte('a' + i)}, 1000))
+		io.ReadAll(m.Reader(bytes.NewReader(streams[i])))
+	}
+	if st := cache.Stats(); st.Entries != 2 || st.Bytes != 2000 {
+		t.Fatalf("stats = %+v", st)
+	}
+	// the first stream was evicted
+	io.ReadAll(m.Reader(bytes.NewReader(streams[0])))
+	if st := cache.Stats(); st.Hits != 0 || st.Misses != 4 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestReadCache_Limits(t *testing.T) {
+	cache := NewReadCache(4096, CacheRaw)
+	m := New(Gzip, WithReadCache(cache))
+
+	// Streams decoding beyond the capacity are read but not cached
+	large := bytes.Repeat([]byte("x"), 10000)
+	compressed := writeContainer(t, m, large)
+	for range 2 {
+		got, err := io.ReadAll(m.Reader(bytes.NewReader(compressed)))
+		if err != nil || !bytes.Equal(got, large) {
+			t.Fatalf("Failed to read a stream larger than the cache: %v", err)
+		}
+	}
+	if st := cache.Stats(); st.Entries != 0 || st.Hits != 0 {
+		t.Fatalf("Expected the large stream not to be cached, stats = %+v", st)
+	}
+
+	// The read limits apply to decoded and cached streams
+	small := bytes.Repeat([]byte("y"), 2000)
+	compressed = writeContainer(t, m, small)
+	io.ReadAll(m.Reader(bytes.NewReader(compressed)))
+	limited := New(Gzip, WithReadCache(cache), WithMaxDecompressedSize(1000))
+	if _, err := io.ReadAll(limited.Reader(bytes.NewReader(compressed))); !errors.Is(err, ErrDecompressedSize) {
+		t.Fatalf("Expected ErrDecompressedSize for a cached stream, got %v", err)
+	}
+	if _, err := io.ReadAll(limited.Reader(bytes.NewReader(writeContainer(t, m, large[:1500])))); !errors.Is(err, ErrDecompressedSize) {
+		t.Fatalf("Expected ErrDecompressedSize for a decoded stream, got %v", err)
+	}
+}
@@ -0,0 +1,128 @@
+package compression
+
+import (
+	"io"
+	"time"
+)
+
+// LevelTargets sets the compression throughput in MB/s each Level must
+// reach. A Level maps to the best compressing native setting of an
+// algorithm that is at least that fast.
+type LevelTargets map[Level]float64
+
+// DefaultLevelTargets are the targets used by CalibrateLevels for levels
+// without a target
+var DefaultLevelTargets = LevelTargets{
+	Fastest: 500,
+	Default: 150,
+	Better:  50,
+	Best:    0,
+}
+
+// nativeLevels lists the native settings of each algorithm from fastest to
+// strongest: compression levels for the deflate family, encoder levels for
+// zstd and the default, better and best modes of S2
+var nativeLevels = map[Algorithm][]int{
+	Gzip:  {1, 2, 3, 4, 5, 6, 7, 8, 9},
+	Zlib:  {1, 2, 3, 4, 5, 6, 7, 8, 9},
+	Flate: {1, 2, 3, 4, 5, 6, 7, 8, 9},
+	Bzip2: {1, 2, 3, 4, 5, 6, 7, 8, 9},
+	Zstd:  {1, 2, 3, 4},
+	S2:    {1, 2, 3},
+}
+
+// LevelMap maps the abstract Levels to native settings per algorithm, see
+// CalibrateLevels
+type LevelMap struct {
+	native map[Algorithm]map[Level]int
+}
+
+// Native returns the native setting a is used with for l
+func (lm *LevelMap) Native(a Algorithm, l Level) (int, bool) {
+	if lm == nil {
+		return 0, false
+	}
+	n, ok := lm.native[a][l]
+	return n, ok
+}
+
+// CalibrateLevels measures the speed and ratio of every native setting of
+// the given algorithms, all available ones by default, on a synthetic
+// log-like sample and maps each Level to the best compressing setting that
+// meets its throughput target on this host, or the fastest setting if none
+// does. Targets not given are taken from DefaultLevelTargets. This makes a
+// Level mean roughly the same trade-off for all algorithms. Calibration
+// takes a few seconds, run it once at startup and pass the result to
+// WithLevelMap. Snappy has no settings and is not calibrated.
+func CalibrateLevels(targets LevelTargets, algorithms ...Algorithm) *LevelMap {
+	if len(algorithms) == 0 {
+		for a := range nativeLevels {
+			algorithms = append(algorithms, a)
+		}
+	}
+	sample := calibrationSample()
+	lm := &LevelMap{native: make(map[Algorithm]map[Level]int)}
+	for _, a := range algorithms {
+		if !a.Available() || nativeLevels[a] == nil {
+			continue
+		}
+		type measurement struct {
+			native int
+			mbps   float64
+			size   int64
+		}
+		var ms []measurement
+		for _, n := range nativeLevels[a] {
+			m := New(a, WithLevelMap(&LevelMap{native: map[Algorithm]map[Level]int{a: {Default: n}}}))
+			mbps, size := measureLevel(m, sample)
+			ms = append(ms, measurement{n, mbps, size})
+		}
+
+		lm.native[a] = make(map[Level]int)
+		for l := range levelNames {
+			target, ok := targets[l]
+			if !ok {
+				target = DefaultLevelTargets[l]
+			}
+			best, fastest := -1, 0
+			for i, m := range ms {
+				if m.mbps > ms[fastest].mbps {
+					fastest = i
+				}
+				if m.mbps >= target && (best < 0 || m.size < ms[best].size) {
+					best = i
+				}
+			}
+			if best < 0 {
+				best = fastest
+			}
+			lm.native[a][l] = ms[best].native
+		}
+	}
+	return lm
+}
+
+// measureLevel returns the throughput in MB/s and compressed size of m on
+// sample, the best of a few runs
+func measureLevel(m *Middleware, sample []byte) (float64, int64) {
+	var size int64
+	best := time.Duration(1<<63 - 1)
+	for i := 0; i < 3; i++ {
+		cw := &countingWriter{w: io.Discard, n: new(counter)}
+		start := time.Now()
+		w := m.Writer(cw)
+		w.Write(sample)
+		w.(io.Closer).Close()
+		best = min(best, time.Since(start))
+		size = cw.total
+	}
+	return float64(len(sample)) / 1e6 / best.Seconds(), size
+}
+
+// WithLevelMap uses the native settings of lm, e.g. from CalibrateLevels,
+// instead of the fixed mapping of Levels to native settings
+func WithLevelMap(lm *LevelMap) Option {
+	return func(m *Middleware) {
+		m.levels = lm
+	}
+}
@@ -0,0 +1,40 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestCalibrateLevels(t *testing.T) {
+	lm := CalibrateLevels(LevelTargets{Fastest: 1e9, Best: 0}, Gzip, S2)
+
+	for _, a := range []Algorithm{Gzip, S2} {
+		fastest, ok := lm.Native(a, Fastest)
+		if !ok {
+			t.Fatalf("%s not calibrated", a)
+		}
+		// No setting reaches the target, the fastest is used
+		if best, _ := lm.Native(a, Best); fastest == best {
+			t.Errorf("%s: Fastest and Best both map to %d", a, best)
+		}
+	}
+	if _, ok := lm.Native(Zstd, Default); ok {
+		t.Error("Zstd calibrated although not requested")
+	}
+
+	sample := calibrationSample()
+	var sizes []int
+	for _, l := range []Level{Fastest, Best} {
+		m := New(S2, WithLevelMap(lm), WithLevel(l))
+		compressed := writeContainer(t, m, sample)
+		got, err := io.ReadAll(m.Reader(bytes.NewReader(compressed)))
+		if err != nil || !bytes.Equal(got, sample) {
+			t.Fatalf("round trip failed: %v", err)
+		}
+		sizes = append(sizes, len(compressed))
+	}
+	if sizes[1] >= sizes[0] {
+		t.Errorf("calibrated Best (%d bytes) not smaller than Fastest (%d bytes)", sizes[1], sizes[0])
+	}
+}
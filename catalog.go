@@ -0,0 +1,241 @@
+package compression
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotCataloged is returned by CatalogStore.Lookup for unknown IDs
+var ErrNotCataloged = errors.New("compression: stream not in catalog")
+
+// CatalogEntry describes a stream recorded by WithCatalog
+type CatalogEntry struct {
+	ID           string    `json:"id"`
+	Algorithm    Algorithm `json:"algorithm"`
+	Level        Level     `json:"level"`
+	Tag          string    `json:"tag,omitempty"`
+	Uncompressed int64     `json:"uncompressed"`
+	Compressed   int64     `json:"compressed"`
+	// Checksum is the CRC-32C of the compressed stream
+	Checksum uint32 `json:"checksum"`
+	// DictionaryID is the ID of the zstd dictionary, 0 without
+	DictionaryID uint32 `json:"dictionary_id,omitempty"`
+	// Location is where the stream was written, see CatalogWriter
+	Location string `json:"location,omitempty"`
+	// Created is when the writer of the stream was created
+	Created time.Time `json:"created"`
+}
+
+// CatalogQuery selects catalog entries. Zero fields match everything.
+type CatalogQuery struct {
+	Tag string
+	// Location matches entries whose location starts with it
+	Location string
+	// Since and Until bound the creation time, Until exclusively
+	Since, Until time.Time
+	// Limit is the maximum number of entries returned
+	Limit int
+}
+
+// Match reports whether e is selected by q, for CatalogStore
+// implementations
+func (q CatalogQuery) Match(e CatalogEntry) bool {
+	return (q.Tag == "" || e.Tag == q.Tag) &&
+		strings.HasPrefix(e.Location, q.Location) &&
+		(q.Since.IsZero() || !e.Created.Before(q.Since)) &&
+		(q.Until.IsZero() || e.Created.Before(q.Until))
+}
+
+// CatalogStore persists catalog entries. Implementations must be safe for
+// concurrent use. FileCatalog stores entries in a file; the sqlutil package
+// provides a store for SQL databases such as SQLite.
+type CatalogStore interface {
+	// Record stores e
+	Record(ctx context.Context, e CatalogEntry) error
+	// Lookup returns the entry with id or ErrNotCataloged
+	Lookup(ctx context.Context, id string) (CatalogEntry, error)
+	// Query returns the entries matching q in the order recorded
+	Query(ctx context.Context, q CatalogQuery) ([]CatalogEntry, error)
+}
+
+// WithCatalog records every stream closed successfully by writers of the
+// middleware in store, for audits and restores, with its ID, algorithm,
+// sizes, a checksum of the compressed stream, the dictionary and the
+// location. Aborted streams are not recorded. If recording fails, Close
+// returns the error although the stream itself is complete.
+func WithCatalog(store CatalogStore) Option {
+	return func(m *Middleware) {
+		m.catalog = store
+	}
+}
+
+// CatalogWriter is implemented by writers returned from Middleware.Writer
+type CatalogWriter interface {
+	// CatalogID returns the ID the stream is recorded under, empty without
+	// WithCatalog
+	CatalogID() string
+	// SetCatalogLocation sets the location recorded for the stream, e.g. an
+	// object key. It defaults to the file name if the sink is an *os.File.
+	SetCatalogLocation(location string)
+}
+
+// catalogRecord collects the catalog entry of a writer
+type catalogRecord struct {
+	entry CatalogEntry
+	sum   hash.Hash32
+}
+
+// newCatalogRecord starts the entry of a stream written to sink, the sink
+// passed to Middleware.Writer
+func (m *Middleware) newCatalogRecord(sink io.Writer, level Level) (*catalogRecord, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, fmt.Errorf("compression: generating catalog ID: %w", err)
+	}
+	c := &catalogRecord{
+		entry: CatalogEntry{
+			ID:        hex.EncodeToString(id),
+			Algorithm: m.algorithm,
+			Level:     level,
+			Tag:       m.tag,
+			Created:   time.Now(),
+		},
+		sum: crc32.New(crc32.MakeTable(crc32.Castagnoli)),
+	}
+	if m.layered != nil {
+		c.entry.DictionaryID = m.layered.id
+	} else if dict := m.encoderDictionary(); dict != nil {
+		c.entry.DictionaryID = zstdDictionaryID(dict)
+	}
+	if f, ok := sink.(*os.File); ok {
+		c.entry.Location = f.Name()
+	}
+	return c, nil
+}
+
+// record stores the entry of the finished stream
+func (w *writer) record() error {
+	c := w.catalog
+	c.entry.Uncompressed = w.in
+	c.entry.Compressed = w.out.total
+	c.entry.Checksum = c.sum.Sum32()
+	if err := w.m.catalog.Record(w.m.context(), c.entry); err != nil {
+		return fmt.Errorf("compression: recording stream in catalog: %w", err)
+	}
+	return nil
+}
+
+func (w *writer) CatalogID() string {
+	if w.catalog == nil {
+		return ""
+	}
+	return w.catalog.entry.ID
+}
+
+func (w *writer) SetCatalogLocation(location string) {
+	w.lock()
+	defer w.unlock()
+	if w.catalog != nil {
+		w.catalog.entry.Location = location
+	}
+}
+
+// FileCatalog is a CatalogStore appending entries as JSON lines to a file.
+// Lookups and queries scan the file, which suits audits and restores rather
+// than frequent queries.
+type FileCatalog struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFileCatalog returns a catalog stored in the file at path, which is
+// created on the first Record
+func NewFileCatalog(path string) *FileCatalog {
+	return &FileCatalog{path: path}
+}
+
+func (c *FileCatalog) Record(ctx context.Context, e CatalogEntry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, err := os.OpenFile(c.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (c *FileCatalog) Lookup(ctx context.Context, id string) (CatalogEntry, error) {
+	var found *CatalogEntry
+	err := c.scan(ctx, func(e CatalogEntry) bool {
+		if e.ID == id {
+			found = &e
+		}
+		return found == nil
+	})
+	if err != nil {
+		return CatalogEntry{}, err
+	}
+	if found == nil {
+		return CatalogEntry{}, fmt.Errorf("%w: %s", ErrNotCataloged, id)
+	}
+	return *found, nil
+}
+
+func (c *FileCatalog) Query(ctx context.Context, q CatalogQuery) ([]CatalogEntry, error) {
+	var entries []CatalogEntry
+	err := c.scan(ctx, func(e CatalogEntry) bool {
+		if q.Match(e) {
+			entries = append(entries, e)
+		}
+		return q.Limit <= 0 || len(entries) < q.Limit
+	})
+	return entries, err
+}
+
+// scan calls fn with every entry until it returns false
+func (c *FileCatalog) scan(ctx context.Context, fn func(CatalogEntry) bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, err := os.Open(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	lines := bufio.NewScanner(f)
+	lines.Buffer(nil, 1<<20)
+	for lines.Scan() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var e CatalogEntry
+		if err := json.Unmarshal(lines.Bytes(), &e); err != nil {
+			return fmt.Errorf("compression: reading catalog %s: %w", c.path, err)
+		}
+		if !fn(e) {
+			return nil
+		}
+	}
+	return lines.Err()
+}
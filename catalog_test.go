@@ -0,0 +1,142 @@
+package compression
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWithCatalog(t *testing.T) {
+	requireZstd(t)
+	store := NewFileCatalog(filepath.Join(t.TempDir(), "catalog.jsonl"))
+	m := New(Zstd, WithCatalog(store), WithTag("backups"))
+	data := bytes.Repeat([]byte("cataloged stream "), 1000)
+
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	created := time.Now()
+	id := w.(CatalogWriter).CatalogID()
+	if len(id) != 32 {
+		t.Fatalf("Expected a 32 character ID, got %q", id)
+	}
+	w.(CatalogWriter).SetCatalogLocation("s3://bucket/one")
+	w.Write(data)
+	time.Sleep(10 * time.Millisecond)
+	if err := w.(io.Closer).Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	e, err := store.Lookup(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Failed to look up %s: %v", id, err)
+	}
+	if e.Algorithm != Zstd || e.Level != Default || e.Tag != "backups" || e.Location != "s3://bucket/one" {
+		t.Errorf("Unexpected entry %+v", e)
+	}
+	if e.Uncompressed != int64(len(data)) || e.Compressed != int64(buf.Len()) {
+		t.Errorf("Expected sizes %d/%d, got %d/%d", len(data), buf.Len(), e.Uncompressed, e.Compressed)
+	}
+	if want := crc32.Checksum(buf.Bytes(), crc32.MakeTable(crc32.Castagnoli)); e.Checksum != want {
+		t.Errorf("Expected checksum %08x, got %08x", want, e.Checksum)
+	}
+	if e.Created.After(created) || time.Since(e.Created) > time.Minute {
+		t.Errorf("Expected the creation time of the writer, got %v", e.Created)
+	}
+
+	// aborted streams are not recorded
+	w = m.Writer(&bytes.Buffer{})
+	w.Write(data)
+	w.(Aborter).Abort()
+	if _, err := store.Lookup(context.Background(), w.(CatalogWriter).CatalogID()); !errors.Is(err, ErrNotCataloged) {
+		t.Errorf("Expected ErrNotCataloged for an aborted stream, got %v", err)
+	}
+}
+
+func TestWithCatalog_FileLocation(t *testing.T) {
+	store := NewFileCatalog(filepath.Join(t.TempDir(), "catalog.jsonl"))
+	f, err := os.Create(filepath.Join(t.TempDir(), "out.s2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// The location is also taken from sinks wrapped for resumable writes
+	for _, m := range []*Middleware{New(S2, WithCatalog(store)), New(S2, WithCatalog(store), WithResumableWrites())} {
+		w := m.Writer(f)
+		w.(io.Closer).Close()
+
+		e, err := store.Lookup(context.Background(), w.(CatalogWriter).CatalogID())
+		if err != nil {
+			t.Fatalf("Failed to look up: %v", err)
+		}
+		if e.Location != f.Name() {
+			t.Errorf("Expected location %s, got %s", f.Name(), e.Location)
+		}
+	}
+}
+
+type failingCatalog struct{ *FileCatalog }
+
+var errCatalogDown = errors.New("catalog down")
+
+func (failingCatalog) Record(context.Context, CatalogEntry) error { return errCatalogDown }
+
+func TestWithCatalog_RecordError(t *testing.T) {
+	var buf bytes.Buffer
+	w := New(Gzip, WithCatalog(&failingCatalog{})).Writer(&buf)
+	w.Write([]byte("data"))
+	if err := w.(io.Closer).Close(); !errors.Is(err, errCatalogDown) {
+		t.Fatalf("Expected the catalog error, got %v", err)
+	}
+	got, err := io.ReadAll(New(Gzip).Reader(&buf))
+	if err != nil || string(got) != "data" {
+		t.Errorf("Expected the stream to be complete, got %q, %v", got, err)
+	}
+}
+
+func TestFileCatalog_Query(t *testing.T) {
+	ctx := context.Background()
+	store := NewFileCatalog(filepath.Join(t.TempDir(), "catalog.jsonl"))
+	if entries, err := store.Query(ctx, CatalogQuery{}); err != nil || len(entries) != 0 {
+		t.Fatalf("Expected an empty catalog, got %v, %v", entries, err)
+	}
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, e := range []CatalogEntry{
+		{ID: "a", Tag: "db", Location: "s3://db/1", Created: base},
+		{ID: "b", Tag: "logs", Location: "s3://logs/1", Created: base.Add(time.Hour)},
+		{ID: "c", Tag: "db", Location: "s3://db/2", Created: base.Add(2 * time.Hour)},
+	} {
+		if err := store.Record(ctx, e); err != nil {
+			t.Fatalf("Failed to record entry %d: %v", i, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		q    CatalogQuery
+		want string
+	}{
+		{CatalogQuery{}, "abc"},
+		{CatalogQuery{Tag: "db"}, "ac"},
+		{CatalogQuery{Location: "s3://logs/"}, "b"},
+		{CatalogQuery{Since: base.Add(time.Hour)}, "bc"},
+		{CatalogQuery{Until: base.Add(time.Hour)}, "a"},
+		{CatalogQuery{Limit: 2}, "ab"},
+	} {
+		entries, err := store.Query(ctx, tt.q)
+		if err != nil {
+			t.Fatalf("Failed to query %+v: %v", tt.q, err)
+		}
+		var got string
+		for _, e := range entries {
+			got += e.ID
+		}
+		if got != tt.want {
+			t.Errorf("Query %+v returned %q, want %q", tt.q, got, tt.want)
+		}
+	}
+}
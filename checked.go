@@ -0,0 +1,40 @@
+package compression
+
+import "io"
+
+// WriterE is like Writer, but returns failures to set up the stream, such
+// as ErrMemoryBudget, ErrQuotaExceeded or ErrGlobalLimit, instead of a
+// writer failing every Write. Panics of the codec constructors are returned
+// as errors wrapping ErrPanic, whether or not a panic handler is set.
+func (m *Middleware) WriterE(w io.Writer) (io.WriteCloser, error) {
+	wr, err := m.newWriter(w, guardAll)
+	if err != nil {
+		return nil, err
+	}
+	return wr, nil
+}
+
+// ReaderE is like Reader, but returns failures to set up the stream
+// instead of a reader failing every Read. Unlike Reader, it reads the gzip
+// or zlib header up front and returns a corrupt header as the error of the
+// codec, e.g. gzip.ErrHeader. Panics of the codec constructors are returned
+// as errors wrapping ErrPanic.
+func (m *Middleware) ReaderE(r io.Reader) (io.ReadCloser, error) {
+	rd, err := m.newReader(r, guardAll)
+	if err != nil {
+		return nil, err
+	}
+	if r, ok := rd.(*reader); ok {
+		dec := r.dec
+		if e, ok := dec.(*limitedReader); ok {
+			dec = e.dec
+		}
+		if lazy, ok := dec.(*lazyReader); ok {
+			if err := lazy.init(); err != nil && err != io.EOF {
+				r.Close()
+				return nil, err
+			}
+		}
+	}
+	return rd.(io.ReadCloser), nil
+}
@@ -0,0 +1,54 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zlib"
+)
+
+func TestReaderE_CorruptHeader(t *testing.T) {
+	for a, want := range map[Algorithm]error{Gzip: gzip.ErrHeader, Zlib: zlib.ErrHeader} {
+		r, err := New(a).ReaderE(bytes.NewReader([]byte("not compressed at all")))
+		if r != nil || !errors.Is(err, want) {
+			t.Fatalf("Expected %v for %s, got %v", want, a, err)
+		}
+	}
+}
+
+func TestWriterE_ReaderE(t *testing.T) {
+	m := New(Gzip)
+	var buf bytes.Buffer
+	w, err := m.WriterE(&buf)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	data := []byte(strings.Repeat("errors instead of panics ", 100))
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	r, err := m.ReaderE(&buf)
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+	defer r.Close()
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to round trip: %v", err)
+	}
+}
+
+func TestWriterE_SetupFailure(t *testing.T) {
+	m := New(Zstd, WithMemoryBudget(1<<10))
+	if w, err := m.WriterE(io.Discard); w != nil || !errors.Is(err, ErrMemoryBudget) {
+		t.Fatalf("Expected ErrMemoryBudget, got %v", err)
+	}
+	if r, err := m.ReaderE(bytes.NewReader(nil)); r != nil || !errors.Is(err, ErrMemoryBudget) {
+		t.Fatalf("Expected ErrMemoryBudget, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"strconv"
+	"strings"
+
+	"github.com/zeebo/blake3"
+	"github.com/zeebo/xxh3"
+)
+
+// ErrChecksumMismatch is returned by readers of framed streams whose data
+// does not match the checksum recorded by the writer
+var ErrChecksumMismatch = fmt.Errorf("%w: checksum mismatch", ErrCorruptStream)
+
+// ErrUnsupportedChecksum is returned by ParseChecksum for unknown names
+var ErrUnsupportedChecksum = errors.New("compression: unsupported checksum")
+
+// Checksum is the algorithm of the stream checksum, see WithChecksum
+type Checksum int
+
+const (
+	// CRC32C is the Castagnoli CRC-32, hardware accelerated on amd64
+	// (SSE 4.2) and arm64
+	CRC32C Checksum = iota + 1
+	// CRC64 is the ECMA CRC-64
+	CRC64
+	// XXH3 is the 64-bit XXH3 hash, the fastest of the checksums
+	XXH3
+	// BLAKE3 is the 256-bit BLAKE3 hash, a cryptographic hash also
+	// detecting deliberate modification
+	BLAKE3
+)
+
+var checksumNames = map[Checksum]string{
+	CRC32C: "crc32c",
+	CRC64:  "crc64",
+	XXH3:   "xxh3",
+	BLAKE3: "blake3",
+}
+
+// String returns the name of the checksum algorithm
+func (c Checksum) String() string {
+	if name, ok := checksumNames[c]; ok {
+		return name
+	}
+	return "Checksum(" + strconv.Itoa(int(c)) + ")"
+}
+
+// ParseChecksum parses a checksum algorithm name as returned by String
+func ParseChecksum(s string) (Checksum, error) {
+	for c, name := range checksumNames {
+		if strings.EqualFold(s, name) {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: unknown checksum %q", ErrUnsupportedChecksum, s)
+}
+
+func (c Checksum) new() hash.Hash {
+	switch c {
+	case CRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case CRC64:
+		return crc64.New(crc64.MakeTable(crc64.ECMA))
+	case XXH3:
+		return xxh3.New()
+	case BLAKE3:
+		return blake3.New()
+	}
+	return nil
+}
+
+// WithChecksum records a checksum of the uncompressed data at the end of
+// framed streams, computed with the given algorithm, so corruption is
+// detected end to end. Readers with the option verify it and return
+// ErrChecksumMismatch, also for streams whose data is not followed by a
+// checksum of the algorithm, e.g. if the checksum was stripped; readers
+// without the option skip it. Data copied by the kernel (see WithKernelCopy)
+// and streams with frames skipped by WithRecoverCorruptFrames are not
+// verified. The option implies framing with DefaultFrameSize unless
+// WithFrameSize is set.
+func WithChecksum(c Checksum) Option {
+	return func(m *Middleware) {
+		m.checksum = c
+		if m.frameSize == 0 {
+			m.frameSize = DefaultFrameSize
+		}
+	}
+}
+
+// verify compares the checksum of the data read with the checksum frame rec
+func (f *framedReader) verify(rec frameRecord) error {
+	if f.sum == nil || rec.checksum != f.m.checksum {
+		return nil
+	}
+	if !bytes.Equal(f.sum.Sum(nil), rec.payload) {
+		return ErrChecksumMismatch
+	}
+	f.unverified = false
+	return nil
+}
+
+// checkVerified fails at the end of the stream if data read since the last
+// checksum was not verified
+func (f *framedReader) checkVerified() error {
+	if f.sum != nil && f.unverified {
+		return fmt.Errorf("%w: no %s checksum after the data", ErrChecksumMismatch, f.m.checksum)
+	}
+	return nil
+}
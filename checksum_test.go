@@ -0,0 +1,83 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestWithChecksum(t *testing.T) {
+	requireZstd(t)
+	data := bytes.Repeat([]byte("checksummed data "), 5000)
+	for _, c := range []Checksum{CRC32C, CRC64, XXH3, BLAKE3} {
+		t.Run(c.String(), func(t *testing.T) {
+			m := New(Zstd, WithChecksum(c), WithFrameSize(16<<10))
+			compressed := writeContainer(t, m, data)
+
+			got, err := io.ReadAll(m.Reader(bytes.NewReader(compressed)))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("Round trip failed: %v", err)
+			}
+			var out bytes.Buffer
+			if _, err := io.Copy(&out, m.Reader(bytes.NewReader(compressed))); err != nil || !bytes.Equal(out.Bytes(), data) {
+				t.Fatalf("WriteTo failed: %v", err)
+			}
+			// Readers without the option skip the checksum
+			if got, err := io.ReadAll(New(Zstd, WithFrameSize(16<<10)).Reader(bytes.NewReader(compressed))); err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("Reading without checksum failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestWithChecksum_Mismatch(t *testing.T) {
+	// Random data is stored raw, so a flipped bit decodes but fails the checksum
+	data := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(data)
+	m := New(S2, WithChecksum(CRC32C), WithFrameSize(4096))
+	compressed := writeContainer(t, m, data)
+	compressed[100] ^= 1
+
+	if _, err := io.ReadAll(m.Reader(bytes.NewReader(compressed))); !errors.Is(err, ErrChecksumMismatch) || !errors.Is(err, ErrCorruptStream) {
+		t.Fatalf("Expected ErrChecksumMismatch, got %v", err)
+	}
+	var dst [20000]byte
+	if _, err := m.Reader(bytes.NewReader(compressed)).(DirectDecoder).DecodeInto(dst[:]); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Expected ErrChecksumMismatch from DecodeInto, got %v", err)
+	}
+}
+
+func TestParseChecksum(t *testing.T) {
+	for _, c := range []Checksum{CRC32C, CRC64, XXH3, BLAKE3} {
+		if got, err := ParseChecksum(c.String()); err != nil || got != c {
+			t.Fatalf("Expected %v, got %v, %v", c, got, err)
+		}
+	}
+	if _, err := ParseChecksum("md5"); !errors.Is(err, ErrUnsupportedChecksum) {
+		t.Fatalf("Expected ErrUnsupportedChecksum, got %v", err)
+	}
+	if s := Checksum(9).String(); s != "Checksum(9)" {
+		t.Fatalf("Unexpected name %q of an unknown checksum", s)
+	}
+}
+
+func TestWithChecksum_Stripped(t *testing.T) {
+	data := bytes.Repeat([]byte("stripped checksum "), 1000)
+	// A stream without checksum, as if the checksum frame was removed
+	compressed := writeContainer(t, New(S2, WithFrameSize(4096)), data)
+	m := New(S2, WithChecksum(CRC32C), WithFrameSize(4096))
+	if _, err := io.ReadAll(m.Reader(bytes.NewReader(compressed))); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Expected ErrChecksumMismatch, got %v", err)
+	}
+	if _, err := io.Copy(io.Discard, m.Reader(bytes.NewReader(compressed))); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Expected ErrChecksumMismatch from WriteTo, got %v", err)
+	}
+
+	// A checksum of another algorithm does not verify the data either
+	compressed = writeContainer(t, New(S2, WithChecksum(CRC64), WithFrameSize(4096)), data)
+	if _, err := io.ReadAll(New(S2, WithChecksum(CRC32C), WithFrameSize(4096)).Reader(bytes.NewReader(compressed))); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Expected ErrChecksumMismatch for another checksum, got %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package compression
+
+import (
+	stdbzip2 "compress/bzip2"
+	stdflate "compress/flate"
+	stdgzip "compress/gzip"
+	stdzlib "compress/zlib"
+	"errors"
+	"io"
+)
+
+// ErrNotStdlibFormat is returned by VerifyStdlib for algorithms that have no
+// stdlib implementation
+var ErrNotStdlibFormat = errors.New("compression: algorithm has no stdlib implementation")
+
+// WithStdlibCompat makes Gzip, Zlib and Flate writers use the stdlib
+// compress packages, so the output is byte-identical to what compress/gzip,
+// compress/zlib and compress/flate produce at the same level. This is slower
+// than the default klauspost encoders and only needed when consumers
+// fingerprint producers by their output. Readers are not affected.
+func WithStdlibCompat() Option {
+	return func(m *Middleware) {
+		m.stdlibCompat = true
+	}
+}
+
+func newStdlibGzipWriter(w io.Writer, level int) encoder {
+	gzipWriter, err := stdgzip.NewWriterLevel(w, level)
+	if err != nil {
+		panic("failed to create gzip writer: " + err.Error())
+	}
+	return gzipWriter
+}
+
+func newStdlibZlibWriter(w io.Writer, level int, dict []byte) encoder {
+	zlibWriter, err := stdzlib.NewWriterLevelDict(w, level, dict)
+	if err != nil {
+		panic("failed to create zlib writer: " + err.Error())
+	}
+	return zlibWriter
+}
+
+func newStdlibFlateWriter(w io.Writer, level int) encoder {
+	flateWriter, err := stdflate.NewWriter(w, level)
+	if err != nil {
+		panic("failed to create flate writer: " + err.Error())
+	}
+	return flateWriter
+}
+
+// VerifyStdlib fully decodes a Gzip, Zlib, Flate or Bzip2 stream with the
+// stdlib decoder and returns the first error encountered, confirming that
+// consumers using only the standard library can read it
+func VerifyStdlib(a Algorithm, r io.Reader) error {
+	var dec io.ReadCloser
+	var err error
+	switch a {
+	case Gzip:
+		dec, err = stdgzip.NewReader(r)
+	case Zlib:
+		dec, err = stdzlib.NewReader(r)
+	case Flate:
+		dec = stdflate.NewReader(r)
+	case Bzip2:
+		dec = io.NopCloser(stdbzip2.NewReader(r))
+	default:
+		return ErrNotStdlibFormat
+	}
+	if err != nil {
+		return err
+	}
+	defer dec.Close()
+
+	_, err = io.Copy(io.Discard, dec)
+	return err
+}
@@ -0,0 +1,59 @@
+package compression
+
+import (
+	"bytes"
+	stdflate "compress/flate"
+	stdgzip "compress/gzip"
+	stdzlib "compress/zlib"
+	"io"
+	"testing"
+)
+
+func TestStdlibCompat_ByteIdentical(t *testing.T) {
+	testData := bytes.Repeat([]byte("stdlib compatible output, byte for byte. "), 100)
+
+	stdlib := map[Algorithm]func(io.Writer) io.WriteCloser{
+		Gzip: func(w io.Writer) io.WriteCloser {
+			zw, _ := stdgzip.NewWriterLevel(w, stdgzip.BestCompression)
+			return zw
+		},
+		Zlib: func(w io.Writer) io.WriteCloser {
+			zw, _ := stdzlib.NewWriterLevel(w, stdzlib.BestCompression)
+			return zw
+		},
+		Flate: func(w io.Writer) io.WriteCloser {
+			zw, _ := stdflate.NewWriter(w, stdflate.BestCompression)
+			return zw
+		},
+	}
+
+	for alg, newStdlib := range stdlib {
+		t.Run(alg.String(), func(t *testing.T) {
+			var expected bytes.Buffer
+			zw := newStdlib(&expected)
+			zw.Write(testData)
+			zw.Close()
+
+			var got bytes.Buffer
+			w := New(alg, WithLevel(Best), WithStdlibCompat()).Writer(&got)
+			w.Write(testData)
+			w.(io.Closer).Close()
+
+			if !bytes.Equal(expected.Bytes(), got.Bytes()) {
+				t.Fatal("Output differs from stdlib")
+			}
+			if err := VerifyStdlib(alg, &got); err != nil {
+				t.Fatalf("Stdlib failed to decode: %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyStdlib(t *testing.T) {
+	if err := VerifyStdlib(Gzip, bytes.NewReader([]byte("not gzip"))); err == nil {
+		t.Fatal("Expected error for invalid stream")
+	}
+	if err := VerifyStdlib(Zstd, bytes.NewReader(nil)); err != ErrNotStdlibFormat {
+		t.Fatalf("Expected ErrNotStdlibFormat, got %v", err)
+	}
+}
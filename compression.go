@@ -2,10 +2,18 @@
 package compression
 
 import (
+	"bufio"
+	"context"
+	"fmt"
+	"hash"
 	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/klauspost/compress/gzip"
-	"github.com/klauspost/compress/zstd"
 	"github.com/klauspost/compress/s2"
 	"github.com/klauspost/compress/snappy"
 	"github.com/klauspost/compress/zlib"
@@ -29,8 +37,49 @@ const (
 	Zlib
 	// Flate compression (raw deflate)
 	Flate
+	// Bzip2 compression using dsnet/compress/bzip2, slow but widely
+	// supported by legacy tools
+	Bzip2
+	// None passes data through unchanged, so the middleware can stay in a
+	// chain while compression is turned off by configuration
+	None
+	// SnappyBlock is the raw snappy block format without stream framing, as
+	// used by Cassandra and LevelDB. Streams are buffered in memory and
+	// compressed as one block on Close, so use it with WithFrameSize for
+	// large data.
+	SnappyBlock
 )
 
+var algorithmNames = map[Algorithm]string{
+	Gzip:        "gzip",
+	Zstd:        "zstd",
+	S2:          "s2",
+	Snappy:      "snappy",
+	Zlib:        "zlib",
+	Flate:       "flate",
+	Bzip2:       "bzip2",
+	None:        "none",
+	SnappyBlock: "snappy-block",
+}
+
+// String returns the lower-case name of the algorithm
+func (a Algorithm) String() string {
+	if name, ok := algorithmNames[a]; ok {
+		return name
+	}
+	return "Algorithm(" + strconv.Itoa(int(a)) + ")"
+}
+
+// Available reports whether the algorithm is known and compiled into this
+// binary
+func (a Algorithm) Available() bool {
+	if a == Zstd {
+		return zstdAvailable
+	}
+	_, ok := algorithmNames[a]
+	return ok
+}
+
 // Level represents compression level
 type Level int
 
@@ -45,10 +94,179 @@ const (
 	Best
 )
 
+var levelNames = map[Level]string{
+	Fastest: "fastest",
+	Default: "default",
+	Better:  "better",
+	Best:    "best",
+}
+
+// String returns the lower-case name of the level
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return "Level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// ParseAlgorithm returns the algorithm with the given name as returned by
+// Algorithm.String, or with one of its aliases such as "deflate"
+func ParseAlgorithm(name string) (Algorithm, error) {
+	for a, n := range algorithmNames {
+		if strings.EqualFold(n, name) {
+			return a, nil
+		}
+	}
+	if a, ok := resolveAlias(name); ok {
+		return a, nil
+	}
+	return 0, fmt.Errorf("%w: unknown algorithm %q", ErrUnsupportedAlgorithm, name)
+}
+
+// ParseLevel returns the level with the given name as returned by
+// Level.String
+func ParseLevel(name string) (Level, error) {
+	for l, n := range levelNames {
+		if strings.EqualFold(n, name) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: unknown level %q", ErrInvalidLevel, name)
+}
+
+// MarshalText encodes the algorithm by name, e.g. in JSON configs
+func (a Algorithm) MarshalText() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+// UnmarshalText decodes an algorithm name
+func (a *Algorithm) UnmarshalText(text []byte) error {
+	parsed, err := ParseAlgorithm(string(text))
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+// MarshalText encodes the level by name
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText decodes a level name
+func (l *Level) UnmarshalText(text []byte) error {
+	parsed, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = parsed
+	return nil
+}
+
 // Middleware implements compression/decompression
 type Middleware struct {
-	algorithm Algorithm
-	level     Level
+	algorithm  Algorithm
+	level      Level
+	dictionary []byte
+	zlibDict   []byte
+	quota      Quota
+	// quotaBytes counts the bytes reserved against Quota.MaxBytes
+	quotaBytes atomic.Int64
+	fallback   *Algorithm
+	warn       func(msg string)
+
+	stdlibCompat bool
+	s2Index      bool
+	frameSize    int
+	bestOf       []Algorithm
+	tuner        *Tuner
+	tag          string
+	keepalive    time.Duration
+	checksum     Checksum
+	memoryBudget int64
+	memory       *memoryPlan
+	zeroFrames   bool
+	sparse       bool
+	sparseFiles  bool
+	entropyCheck bool
+	arenas       *ArenaPool
+	readCache    *ReadCache
+	dictzipChunk int
+	refreshEvery int
+	refreshSize  int
+	dictFile     *dictionaryFile
+	levels       *LevelMap
+	matrix       LevelMatrix
+	frameLevel   func(frameIdx int, sample []byte) Level
+	kernelCopy   bool
+	trackLatency bool
+	resumable    bool
+	readahead    int
+	allocSample  int
+	dictDelta    []byte
+	layered      *layeredDictionary
+	pgzipBlock   int
+	pgzipBlocks  int
+	backend      Backend
+	streamID     *StreamIdentifier
+	onCorruption func(CorruptionEvent)
+	spill        bool
+	spillDir     string
+	workerCount  func() int
+	catalog      CatalogStore
+	maxExpansion float64
+	maxDecoded   int64
+	readTimeout  time.Duration
+	strictFrames bool
+	salvage      bool
+	dedupFrames  int
+	dedupLimit   int
+	annotate     bool
+	panicFree    bool
+	maxLag       time.Duration
+	resolver     func(ctx context.Context, id uint32) ([]byte, error)
+	resolved     *resolvedDictionaries
+	eofMode      EOFMode
+	dictStore    DictionaryStore
+	ctx          context.Context
+	storeDict    atomic.Pointer[[]byte]
+
+	// swapped holds the dictionary set with SetDictionary followed by the
+	// replaced ones, dictGen counts the swaps to drop pooled encoders
+	swapped atomic.Pointer[[][]byte]
+	swapMu  sync.Mutex
+	dictGen atomic.Uint64
+
+	// contentHash creates the digest of the content of every writer,
+	// expectedDigest is verified by readers, see WithContentHash and
+	// WithExpectedContentHash
+	contentHash    func() hash.Hash
+	expectedDigest []byte
+	newContentHash func() hash.Hash
+
+	// flushCallback is called at frame and flush boundaries, see
+	// WithFlushCallback
+	flushCallback func(compressedOffset, uncompressedOffset int64)
+
+	// declaredSizes makes zstd frames declare their size, see
+	// WithDeclaredSizes
+	declaredSizes bool
+
+	// wrapPayload and unwrapPayload transform frame payloads, see
+	// WithFramePayloadHooks
+	wrapPayload   func(uint64, byte, []byte) ([]byte, error)
+	unwrapPayload func(uint64, byte, []byte) ([]byte, error)
+
+	// err fails all streams, e.g. when the configuration cannot be honored
+	err error
+	// entropyWarned is set once WithEntropyCheck warned
+	entropyWarned atomic.Bool
+
+	stats stats
+	// baseline holds the stats restored with ImportState
+	baseline atomic.Pointer[Stats]
+	encoders sync.Pool
 }
 
 // Ensure Middleware implements middleware.Middleware interface
@@ -64,47 +282,328 @@ func WithLevel(level Level) Option {
 	}
 }
 
-// New creates a new compression middleware with the given algorithm
-func New(algorithm Algorithm, opts ...Option) *Middleware {
-	m := &Middleware{
-		algorithm: algorithm,
-		level:     Default, // Default compression level
+// WithZstdDictionary sets a zstd dictionary used by writers and readers.
+// The dictionary must be in zstd dictionary format and is ignored by the
+// other algorithms.
+func WithZstdDictionary(dict []byte) Option {
+	return func(m *Middleware) {
+		m.dictionary = dict
 	}
+}
+
+// WithZlibDictionary sets a preset dictionary used by zlib writers and
+// readers, as with zlib.NewWriterLevelDict and zlib.NewReaderDict. Readers
+// fail with zlib.ErrDictionary on streams written with another dictionary.
+// It is ignored by the other algorithms.
+func WithZlibDictionary(dict []byte) Option {
+	return func(m *Middleware) {
+		m.zlibDict = dict
+	}
+}
+
+// WithQuota limits the resources the middleware may consume
+func WithQuota(q Quota) Option {
+	return func(m *Middleware) {
+		m.quota = q
+	}
+}
+
+// WithFallbackAlgorithm sets the algorithm used when the requested one is
+// not available in this binary, e.g. zstd in builds with the nozstd tag.
+// Without a fallback, Writer and Reader panic for unavailable algorithms.
+func WithFallbackAlgorithm(a Algorithm) Option {
+	return func(m *Middleware) {
+		m.fallback = &a
+	}
+}
+
+// WithWarningHook sets a function receiving warnings about degraded
+// configurations, such as falling back to another algorithm
+func WithWarningHook(fn func(msg string)) Option {
+	return func(m *Middleware) {
+		m.warn = fn
+	}
+}
+
+// New creates a new compression middleware with the given algorithm. It does
+// not fail on misconfiguration, which surfaces when streams are created; use
+// NewE or Validate to detect it up front.
+func New(algorithm Algorithm, opts ...Option) *Middleware {
+	m := newMiddleware(algorithm)
 
 	// Apply options
 	for _, opt := range opts {
 		opt(m)
 	}
 
+	if m.tag != "" {
+		s, _ := tagStats.LoadOrStore(m.tag, &stats{})
+		m.stats.linkTag(s.(*stats))
+	}
+
+	if !m.algorithm.Available() && m.fallback != nil && m.fallback.Available() {
+		m.warnf("compression algorithm %s not available, falling back to %s", m.algorithm, *m.fallback)
+		m.algorithm = *m.fallback
+	}
+
+	if m.matrix != nil {
+		if err := m.applyMatrix(); err != nil {
+			m.fail(err)
+		}
+	}
+
+	if !m.backend.Available() {
+		m.warnf("compression backend %s not available, using %s", m.backend, PureGo)
+		m.backend = PureGo
+	}
+
+	if m.dictDelta != nil && m.err == nil {
+		if err := m.layerDictionary(); err != nil {
+			m.fail(err)
+		}
+	}
+
+	if m.memoryBudget > 0 && m.err == nil {
+		if err := m.planMemory(); err != nil {
+			m.fail(err)
+		}
+	}
+	if m.err != nil {
+		m.warnf("%v", m.err)
+	}
+
 	return m
 }
 
-// Writer wraps an io.Writer with compression
+// newMiddleware returns a middleware with the defaults options are applied
+// to
+func newMiddleware(algorithm Algorithm) *Middleware {
+	return &Middleware{
+		algorithm: resolveRetired(algorithm),
+		level:     Default, // Default compression level
+	}
+}
+
+// fail makes all streams of the middleware fail with err. The first error is
+// kept.
+func (m *Middleware) fail(err error) {
+	if m.err == nil {
+		m.err = err
+	}
+}
+
+func (m *Middleware) warnf(format string, args ...any) {
+	if m.warn != nil {
+		m.warn(fmt.Sprintf(format, args...))
+	}
+}
+
+// Writer wraps an io.Writer with compression. The returned writer
+// implements io.Closer, which must be called to complete the stream. Closing
+// without any Write produces a valid empty stream for every algorithm;
+// closing again is safe and returns nil.
 func (m *Middleware) Writer(w io.Writer) io.Writer {
-	switch m.algorithm {
+	wr, err := m.newWriter(w, m.guardNew())
+	if err != nil {
+		return &errWriter{err}
+	}
+	return wr
+}
+
+// newWriter creates a writer, running the codec constructors with run
+func (m *Middleware) newWriter(w io.Writer, run func(func() error) error) (*writer, error) {
+	level := m.level
+	if m.tuner != nil {
+		level = m.tuner.next(&m.stats.lockWaits)
+	}
+	if m.err != nil {
+		return nil, m.err
+	}
+	if m.dictFile != nil {
+		m.dictFile.reload(m)
+	}
+	if m.dictStore != nil {
+		if err := m.loadCurrentDictionary(); err != nil {
+			return nil, err
+		}
+	}
+	var catalog *catalogRecord
+	if m.catalog != nil {
+		var err error
+		if catalog, err = m.newCatalogRecord(w, level); err != nil {
+			return nil, err
+		}
+	}
+	var resume *resumeWriter
+	if m.resumable {
+		resume = &resumeWriter{w: w}
+		w = resume
+	}
+	if catalog != nil {
+		w = io.MultiWriter(w, catalog.sum)
+	}
+	out := &countingWriter{w: w, n: &m.stats.bytesCompressed}
+	dictGen := m.dictGen.Load()
+	var enc encoder
+	if err := run(func() error { enc = m.encoder(level, out, dictGen); return nil }); err != nil {
+		return nil, err
+	}
+	if err := m.acquire(); err != nil {
+		m.putEncoder(level, enc, dictGen)
+		return nil, err
+	}
+	memory := m.encoderMemory(level)
+	if err := acquireGlobal(memory); err != nil {
+		m.release()
+		m.putEncoder(level, enc, dictGen)
+		return nil, err
+	}
+	m.stats.writers.Add(1)
+	wr := &writer{m: m, enc: enc, level: level, dictGen: dictGen, out: out, resume: resume, memory: memory, catalog: catalog}
+	if m.contentHash != nil {
+		wr.hash = m.contentHash()
+	}
+	if f, ok := enc.(*framedWriter); ok && m.arenas != nil {
+		wr.arena = m.arenas.Get()
+		f.useArena(wr.arena)
+	}
+	if m.keepalive > 0 {
+		wr.startKeepalive()
+	}
+	return wr, nil
+}
+
+// encoder returns an encoder for level writing to w. Encoders for the
+// configured level come from the pool if possible, those of dictionary
+// generation dictGen.
+func (m *Middleware) encoder(l Level, w io.Writer, dictGen uint64) encoder {
+	if l == m.level {
+		if p, ok := m.encoders.Get().(pooledEncoder); ok {
+			m.stats.idleEncoders.Add(-1)
+			// Encoders created for another worker count or with a replaced
+			// dictionary are dropped
+			if p.workers == m.workers() && p.dictGen == dictGen {
+				m.stats.poolHits.Add(1)
+				p.enc.Reset(w)
+				return p.enc
+			}
+		}
+		m.stats.poolMisses.Add(1)
+	}
+	if m.frameSize > 0 {
+		return newFramedWriter(m, l, w)
+	}
+	return m.newEncoder(m.algorithm, l, w)
+}
+
+// putEncoder returns an encoder for level, created with dictionary
+// generation dictGen, to the pool
+func (m *Middleware) putEncoder(l Level, enc encoder, dictGen uint64) {
+	if l == m.level && m.dictFile == nil && m.dictStore == nil {
+		m.stats.idleEncoders.Add(1)
+		m.encoders.Put(pooledEncoder{enc, m.workers(), dictGen})
+	}
+}
+
+func (m *Middleware) newEncoder(a Algorithm, l Level, w io.Writer) encoder {
+	switch a {
 	case Gzip:
-		return m.createGzipWriter(w)
+		return m.createGzipWriter(w, l)
 	case Zstd:
-		return m.createZstdWriter(w)
+		if m.useCgoZstd() {
+			return m.createCgoZstdWriter(w, l)
+		}
+		return m.createZstdWriter(w, l)
 	case S2:
-		return m.createS2Writer(w)
+		return m.createS2Writer(w, l)
 	case Snappy:
-		return m.createSnappyWriter(w)
+		return m.createSnappyWriter(w, l)
 	case Zlib:
-		return m.createZlibWriter(w)
+		return m.createZlibWriter(w, l)
 	case Flate:
-		return m.createFlateWriter(w)
+		return m.createFlateWriter(w, l)
+	case Bzip2:
+		return m.createBzip2Writer(w, l)
+	case None:
+		return &passthrough{w}
+	case SnappyBlock:
+		return m.createSnappyBlockWriter(w)
 	default:
-		panic("unsupported compression algorithm")
+		panic(fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, a))
 	}
 }
 
 // Reader wraps an io.Reader with decompression
 func (m *Middleware) Reader(r io.Reader) io.Reader {
-	switch m.algorithm {
+	rd, err := m.newReader(r, m.guardNew())
+	if err != nil {
+		return &errReader{err}
+	}
+	return rd
+}
+
+// newReader creates a reader, running the codec constructors with run
+func (m *Middleware) newReader(r io.Reader, run func(func() error) error) (io.Reader, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	if m.dictFile != nil {
+		m.dictFile.reload(m)
+	}
+	if err := acquireGlobal(0); err != nil {
+		return nil, err
+	}
+	in := &countingReader{r: r, n: &m.stats.bytesRead}
+	var dec io.Reader
+	var src *bufio.Reader
+	err := run(func() error {
+		if m.readCache != nil {
+			dec = m.cachedReader(r)
+		} else if m.frameSize > 0 && m.readahead > 0 {
+			dec = newReadaheadReader(m, m.newFramedReader(r, in), m.readahead, m.frameSize)
+		} else if m.frameSize > 0 {
+			dec = m.newFramedReader(r, in)
+		} else if m.salvage && salvageable(m.algorithm) {
+			dec = m.newSalvageReader(in)
+		} else if m.strictFrames && m.algorithm != None {
+			// codecs read exactly up to the end of the stream from a
+			// bufio.Reader, the rest is checked for trailing data
+			src = bufio.NewReader(in)
+			dec = m.newDecoder(m.algorithm, src)
+		} else {
+			dec = m.newDecoder(m.algorithm, in)
+		}
+		return nil
+	})
+	if err != nil {
+		releaseGlobal(0)
+		return nil, err
+	}
+	m.stats.readers.Add(1)
+	rd := &reader{m: m, dec: dec, global: true, in: in}
+	if f, ok := dec.(*framedReader); ok && m.arenas != nil {
+		rd.arena = m.arenas.Get()
+		f.useArena(rd.arena, m.frameSize)
+	}
+	if m.newContentHash != nil {
+		rd.hash = m.newContentHash()
+	}
+	if m.readLimited() && m.readCache == nil && (dec != io.Reader(in) || m.maxDecoded > 0 || m.readTimeout > 0) {
+		rd.dec = &limitedReader{m: m, dec: dec, in: in, src: src}
+	} else if dec == io.Reader(in) {
+		return rd.seekable(r), nil
+	}
+	return rd, nil
+}
+
+func (m *Middleware) newDecoder(a Algorithm, r io.Reader) io.Reader {
+	switch a {
 	case Gzip:
 		return m.createGzipReader(r)
 	case Zstd:
+		if m.useCgoZstd() {
+			return m.createCgoZstdReader(r)
+		}
 		return m.createZstdReader(r)
 	case S2:
 		return m.createS2Reader(r)
@@ -114,15 +613,22 @@ func (m *Middleware) Reader(r io.Reader) io.Reader {
 		return m.createZlibReader(r)
 	case Flate:
 		return m.createFlateReader(r)
+	case Bzip2:
+		return m.createBzip2Reader(r)
+	case None:
+		// Readers pass Seek and ReadAt of the source through
+		return r
+	case SnappyBlock:
+		return m.createSnappyBlockReader(r)
 	default:
-		panic("unsupported compression algorithm")
+		panic(fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, a))
 	}
 }
 
 // Gzip compression methods
-func (m *Middleware) createGzipWriter(w io.Writer) io.Writer {
+func (m *Middleware) createGzipWriter(w io.Writer, l Level) encoder {
 	var level int
-	switch m.level {
+	switch l {
 	case Fastest:
 		level = gzip.BestSpeed
 	case Default:
@@ -132,7 +638,20 @@ func (m *Middleware) createGzipWriter(w io.Writer) io.Writer {
 	case Best:
 		level = gzip.BestCompression
 	}
+	if n, ok := m.levels.Native(Gzip, l); ok {
+		level = n
+	}
 	
+	if m.dictzipChunk > 0 {
+		return newDictzipWriter(w, level, m.dictzipChunk)
+	}
+	if m.stdlibCompat {
+		return newStdlibGzipWriter(w, level)
+	}
+	if m.pgzipBlock > 0 {
+		return m.createParallelGzipWriter(w, level)
+	}
+
 	gzipWriter, err := gzip.NewWriterLevel(w, level)
 	if err != nil {
 		panic("failed to create gzip writer: " + err.Error())
@@ -141,64 +660,53 @@ func (m *Middleware) createGzipWriter(w io.Writer) io.Writer {
 }
 
 func (m *Middleware) createGzipReader(r io.Reader) io.Reader {
-	gzipReader, err := gzip.NewReader(r)
-	if err != nil {
-		panic("failed to create gzip reader: " + err.Error())
-	}
-	return gzipReader
+	return newLazyReader(r, func(r io.Reader) (io.Reader, error) {
+		gzipReader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return gzipReader, nil
+	})
 }
 
-// Zstd compression methods
-func (m *Middleware) createZstdWriter(w io.Writer) io.Writer {
-	var level zstd.EncoderLevel
-	switch m.level {
-	case Fastest:
-		level = zstd.SpeedFastest
-	case Default:
-		level = zstd.SpeedDefault
-	case Better:
-		level = zstd.SpeedBetterCompression
-	case Best:
-		level = zstd.SpeedBestCompression
-	}
-	
-	zstdWriter, err := zstd.NewWriter(w, zstd.WithEncoderLevel(level))
-	if err != nil {
-		panic("failed to create zstd writer: " + err.Error())
+// S2 compression methods
+func (m *Middleware) createS2Writer(w io.Writer, l Level) encoder {
+	var opts []s2.WriterOption
+	switch n, _ := m.levels.Native(S2, l); n {
+	case 2:
+		opts = append(opts, s2.WriterBetterCompression())
+	case 3:
+		opts = append(opts, s2.WriterBestCompression())
 	}
-	return &zstdWriteCloser{zstdWriter}
-}
-
-func (m *Middleware) createZstdReader(r io.Reader) io.Reader {
-	zstdReader, err := zstd.NewReader(r)
-	if err != nil {
-		panic("failed to create zstd reader: " + err.Error())
+	if m.memory != nil {
+		opts = append(opts, s2.WriterBlockSize(m.memory.s2Block), s2.WriterConcurrency(1))
+	} else {
+		opts = append(opts, s2.WriterConcurrency(m.workers()))
 	}
-	return &zstdReadCloser{zstdReader}
-}
-
-// S2 compression methods
-func (m *Middleware) createS2Writer(w io.Writer) io.Writer {
-	return s2.NewWriter(w)
+	return m.withStreamIdentifier(s2.NewWriter(w, opts...), w)
 }
 
 func (m *Middleware) createS2Reader(r io.Reader) io.Reader {
-	return s2.NewReader(r)
+	var opts []s2.ReaderOption
+	if m.memory != nil {
+		opts = append(opts, s2.ReaderMaxBlockSize(m.memory.s2Block))
+	}
+	return s2.NewReader(m.withStreamIdentifierReader(r, s2Identifier), opts...)
 }
 
 // Snappy compression methods
-func (m *Middleware) createSnappyWriter(w io.Writer) io.Writer {
-	return snappy.NewBufferedWriter(w)
+func (m *Middleware) createSnappyWriter(w io.Writer, l Level) encoder {
+	return m.withStreamIdentifier(snappy.NewBufferedWriter(w), w)
 }
 
 func (m *Middleware) createSnappyReader(r io.Reader) io.Reader {
-	return snappy.NewReader(r)
+	return snappy.NewReader(m.withStreamIdentifierReader(r, snappyIdentifier))
 }
 
 // Zlib compression methods
-func (m *Middleware) createZlibWriter(w io.Writer) io.Writer {
+func (m *Middleware) createZlibWriter(w io.Writer, l Level) encoder {
 	var level int
-	switch m.level {
+	switch l {
 	case Fastest:
 		level = zlib.BestSpeed
 	case Default:
@@ -208,8 +716,15 @@ func (m *Middleware) createZlibWriter(w io.Writer) io.Writer {
 	case Best:
 		level = zlib.BestCompression
 	}
+	if n, ok := m.levels.Native(Zlib, l); ok {
+		level = n
+	}
 	
-	zlibWriter, err := zlib.NewWriterLevel(w, level)
+	if m.stdlibCompat {
+		return newStdlibZlibWriter(w, level, m.zlibDict)
+	}
+
+	zlibWriter, err := zlib.NewWriterLevelDict(w, level, m.zlibDict)
 	if err != nil {
 		panic("failed to create zlib writer: " + err.Error())
 	}
@@ -217,17 +732,19 @@ func (m *Middleware) createZlibWriter(w io.Writer) io.Writer {
 }
 
 func (m *Middleware) createZlibReader(r io.Reader) io.Reader {
-	zlibReader, err := zlib.NewReader(r)
-	if err != nil {
-		panic("failed to create zlib reader: " + err.Error())
-	}
-	return &zlibReadCloser{zlibReader}
+	return newLazyReader(r, func(r io.Reader) (io.Reader, error) {
+		zlibReader, err := zlib.NewReaderDict(r, m.zlibDict)
+		if err != nil {
+			return nil, err
+		}
+		return &zlibReadCloser{zlibReader}, nil
+	})
 }
 
 // Flate compression methods
-func (m *Middleware) createFlateWriter(w io.Writer) io.Writer {
+func (m *Middleware) createFlateWriter(w io.Writer, l Level) encoder {
 	var level int
-	switch m.level {
+	switch l {
 	case Fastest:
 		level = flate.BestSpeed
 	case Default:
@@ -237,7 +754,14 @@ func (m *Middleware) createFlateWriter(w io.Writer) io.Writer {
 	case Best:
 		level = flate.BestCompression
 	}
+	if n, ok := m.levels.Native(Flate, l); ok {
+		level = n
+	}
 	
+	if m.stdlibCompat {
+		return newStdlibFlateWriter(w, level)
+	}
+
 	flateWriter, err := flate.NewWriter(w, level)
 	if err != nil {
 		panic("failed to create flate writer: " + err.Error())
@@ -260,23 +784,6 @@ func (w *gzipWriteCloser) Close() error {
 	return w.Writer.Close()
 }
 
-type zstdWriteCloser struct {
-	*zstd.Encoder
-}
-
-func (w *zstdWriteCloser) Close() error {
-	return w.Encoder.Close()
-}
-
-type zstdReadCloser struct {
-	*zstd.Decoder
-}
-
-func (r *zstdReadCloser) Close() error {
-	r.Decoder.Close()
-	return nil
-}
-
 type zlibWriteCloser struct {
 	*zlib.Writer
 }
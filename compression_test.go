@@ -31,10 +31,16 @@ func TestAllAlgorithms(t *testing.T) {
 		{"Snappy", Snappy},
 		{"Zlib", Zlib},
 		{"Flate", Flate},
+		{"Bzip2", Bzip2},
+		{"None", None},
+		{"SnappyBlock", SnappyBlock},
 	}
 
 	for _, alg := range algorithms {
 		t.Run(alg.name, func(t *testing.T) {
+			if alg.algorithm == Zstd {
+				requireZstd(t)
+			}
 			testCompressionAlgorithm(t, alg.algorithm, alg.name)
 		})
 	}
@@ -92,6 +98,7 @@ func testCompressionAlgorithm(t *testing.T, algorithm Algorithm, name string) {
 }
 
 func TestCompressionLevels(t *testing.T) {
+	requireZstd(t)
 	levels := []struct {
 		name  string
 		level Level
@@ -196,6 +203,7 @@ func TestLargeData(t *testing.T) {
 }
 
 func TestMultipleWrites(t *testing.T) {
+	requireZstd(t)
 	// Test multiple writes with Zstd
 	m := New(Zstd)
 
@@ -247,9 +255,12 @@ func TestMultipleWrites(t *testing.T) {
 
 func TestEmptyData(t *testing.T) {
 	// Test compression of empty data with all algorithms
-	algorithms := []Algorithm{Gzip, Zstd, S2, Snappy, Zlib, Flate}
+	algorithms := []Algorithm{Gzip, Zstd, S2, Snappy, Zlib, Flate, Bzip2, None, SnappyBlock}
 	
 	for _, alg := range algorithms {
+		if alg == Zstd && !zstdAvailable {
+			continue
+		}
 		m := New(alg)
 		
 		var compressedBuf bytes.Buffer
@@ -292,6 +303,27 @@ func TestUnsupportedAlgorithm(t *testing.T) {
 	m.Writer(&bytes.Buffer{})
 }
 
+func TestFallbackAlgorithm(t *testing.T) {
+	var warnings []string
+	m := New(Algorithm(999),
+		WithFallbackAlgorithm(S2),
+		WithWarningHook(func(msg string) { warnings = append(warnings, msg) }),
+	)
+	if m.algorithm != S2 {
+		t.Fatalf("Expected fallback to S2, got %v", m.algorithm)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("Expected one warning, got %v", warnings)
+	}
+	testCompressionAlgorithm(t, m.algorithm, "fallback")
+
+	// Available algorithms are kept
+	m = New(Gzip, WithFallbackAlgorithm(S2))
+	if m.algorithm != Gzip {
+		t.Fatalf("Expected Gzip to be kept, got %v", m.algorithm)
+	}
+}
+
 // Benchmark different algorithms
 func BenchmarkCompression(b *testing.B) {
 	// Create test data
@@ -332,4 +364,19 @@ func BenchmarkCompression(b *testing.B) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestParseAlgorithmAndLevel(t *testing.T) {
+	for _, alg := range []Algorithm{Gzip, Zstd, S2, Snappy, Zlib, Flate, Bzip2, None, SnappyBlock} {
+		parsed, err := ParseAlgorithm(alg.String())
+		if err != nil || parsed != alg {
+			t.Fatalf("ParseAlgorithm(%q) = %v, %v", alg.String(), parsed, err)
+		}
+	}
+	if _, err := ParseAlgorithm("lzma"); err == nil {
+		t.Fatal("Expected error for unknown algorithm")
+	}
+	if l, err := ParseLevel("BEST"); err != nil || l != Best {
+		t.Fatalf("ParseLevel(BEST) = %v, %v", l, err)
+	}
+}
@@ -0,0 +1,81 @@
+package compression
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotConcatenable is returned by Concat for algorithms whose streams
+// cannot be joined without recompression
+var ErrNotConcatenable = errors.New("compression: streams cannot be concatenated")
+
+// Concat joins streams compressed by the middleware into one valid stream
+// without decompressing them: gzip members, zstd frames and S2, Snappy,
+// Bzip2 or None streams are copied back to back, and the frames of framed streams are
+// copied into a single container with a combined index. Zlib, Flate and
+// SnappyBlock streams cannot be concatenated, nor can containers written with
+// WithFramePayloadHooks or ended early with Abort.
+func (m *Middleware) Concat(dst io.Writer, srcs ...io.Reader) error {
+	if m.frameSize > 0 {
+		return m.concatContainers(dst, srcs)
+	}
+	switch m.algorithm {
+	case Gzip, Zstd, S2, Snappy, Bzip2, None:
+	default:
+		return fmt.Errorf("%w: %s", ErrNotConcatenable, m.algorithm)
+	}
+	for _, src := range srcs {
+		if _, err := io.Copy(dst, src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// concatContainers copies the frames of all containers into one
+func (m *Middleware) concatContainers(dst io.Writer, srcs []io.Reader) error {
+	if m.wrapPayload != nil {
+		return fmt.Errorf("%w: frame payloads are hooked", ErrNotConcatenable)
+	}
+	var cw containerWriter
+	cw.reset(dst, ContainerVersion, m.algorithm)
+	for i, src := range srcs {
+		cr := containerReader{r: bufio.NewReader(src)}
+		for {
+			rec, err := cr.next()
+			if err == io.EOF {
+				if err := concatFlags(cr.flags); err != nil {
+					return fmt.Errorf("compression: source %d: %w", i, err)
+				}
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("compression: source %d: %w", i, err)
+			}
+			if rec.typ == frameChecksum {
+				// Checksums cover a single source
+				continue
+			}
+			if rec.typ == frameData && rec.algorithm != m.algorithm {
+				rec.typ = frameCoded
+			}
+			if err := cw.writeFrame(rec); err != nil {
+				return err
+			}
+		}
+	}
+	return cw.close()
+}
+
+// concatFlags fails for trailer flags of containers that cannot be joined
+func concatFlags(flags uint32) error {
+	if flags&TrailerHooked != 0 {
+		return fmt.Errorf("%w: frame payloads are hooked", ErrNotConcatenable)
+	}
+	if flags&TrailerAborted != 0 {
+		return fmt.Errorf("%w: %w", ErrNotConcatenable, ErrAborted)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestConcat(t *testing.T) {
+	requireZstd(t)
+	parts := [][]byte{
+		bytes.Repeat([]byte("first part "), 1000),
+		bytes.Repeat([]byte("second part "), 2000),
+		[]byte("third"),
+	}
+	want := bytes.Join(parts, nil)
+
+	for _, tc := range []struct {
+		name string
+		m    *Middleware
+	}{
+		{"gzip", New(Gzip)},
+		{"zstd", New(Zstd)},
+		{"s2", New(S2)},
+		{"snappy", New(Snappy)},
+		{"bzip2", New(Bzip2)},
+		{"framed", New(Zstd, WithFrameSize(4096))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var srcs []io.Reader
+			for _, p := range parts {
+				var buf bytes.Buffer
+				w := tc.m.Writer(&buf)
+				w.Write(p)
+				w.(io.Closer).Close()
+				srcs = append(srcs, &buf)
+			}
+
+			var joined bytes.Buffer
+			if err := tc.m.Concat(&joined, srcs...); err != nil {
+				t.Fatalf("Failed to concat: %v", err)
+			}
+			got, err := io.ReadAll(tc.m.Reader(bytes.NewReader(joined.Bytes())))
+			if err != nil {
+				t.Fatalf("Failed to read joined stream: %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("Expected %d bytes, got %d", len(want), len(got))
+			}
+		})
+	}
+}
+
+func TestConcat_Index(t *testing.T) {
+	requireZstd(t)
+	m := New(Zstd, WithFrameSize(1000))
+	a := writeContainer(t, m, bytes.Repeat([]byte("a"), 2500))
+	b := writeContainer(t, New(S2, WithFrameSize(1000)), bytes.Repeat([]byte("b"), 1500))
+
+	var joined bytes.Buffer
+	if err := m.Concat(&joined, bytes.NewReader(a), bytes.NewReader(b)); err != nil {
+		t.Fatalf("Failed to concat: %v", err)
+	}
+	index, err := ReadContainerIndex(bytes.NewReader(joined.Bytes()), int64(joined.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read index: %v", err)
+	}
+	if len(index) != 5 || index[4].UncompressedOffset != 3500 || index[4].Size != 500 {
+		t.Fatalf("Unexpected index %+v", index)
+	}
+	got, _ := io.ReadAll(m.Reader(&joined))
+	if want := append(bytes.Repeat([]byte("a"), 2500), bytes.Repeat([]byte("b"), 1500)...); !bytes.Equal(got, want) {
+		t.Fatal("Joined stream mismatch")
+	}
+}
+
+func TestConcat_NotConcatenable(t *testing.T) {
+	for _, a := range []Algorithm{Zlib, Flate} {
+		if err := New(a).Concat(io.Discard); !errors.Is(err, ErrNotConcatenable) {
+			t.Fatalf("Expected ErrNotConcatenable for %s, got %v", a, err)
+		}
+	}
+}
+
+func TestConcat_HookedOrAborted(t *testing.T) {
+	xor := func(_ uint64, _ byte, p []byte) ([]byte, error) {
+		out := bytes.Clone(p)
+		for i := range out {
+			out[i] ^= 0x5a
+		}
+		return out, nil
+	}
+	data := bytes.Repeat([]byte("hooked"), 500)
+	hooked := writeContainer(t, New(S2, WithFrameSize(1000), WithFramePayloadHooks(xor, xor)), data)
+	if err := New(S2, WithFrameSize(1000), WithFramePayloadHooks(xor, xor)).Concat(io.Discard, bytes.NewReader(hooked)); !errors.Is(err, ErrNotConcatenable) {
+		t.Fatalf("Expected ErrNotConcatenable for a hooked middleware, got %v", err)
+	}
+	m := New(S2, WithFrameSize(1000))
+	if err := m.Concat(io.Discard, bytes.NewReader(hooked)); !errors.Is(err, ErrNotConcatenable) {
+		t.Fatalf("Expected ErrNotConcatenable for a hooked source, got %v", err)
+	}
+	if err := ExtractFrames(bytes.NewReader(hooked), io.Discard, FrameRange(0, 1)); err == nil {
+		t.Fatal("Expected extracting frames of a hooked source to fail")
+	}
+
+	var aborted bytes.Buffer
+	w := m.Writer(&aborted)
+	w.Write(data)
+	w.(Aborter).Abort()
+	if err := m.Concat(io.Discard, bytes.NewReader(aborted.Bytes()), bytes.NewReader(writeContainer(t, m, data))); !errors.Is(err, ErrAborted) {
+		t.Fatalf("Expected ErrAborted for an aborted source, got %v", err)
+	}
+	var extracted bytes.Buffer
+	if err := ExtractFrames(bytes.NewReader(aborted.Bytes()), &extracted, FrameRange(0, 1)); err != nil {
+		t.Fatalf("Failed to extract frames: %v", err)
+	}
+	if _, err := io.ReadAll(m.Reader(&extracted)); !errors.Is(err, ErrAborted) {
+		t.Fatalf("Expected extraction to keep the abort flag, got %v", err)
+	}
+}
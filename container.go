@@ -0,0 +1,493 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Container format versions
+//
+// Version 1 consists of the header, the frames and the end marker.
+//
+// Version 2 appends an index of all data and dictionary frames and a fixed
+// size trailer after the end marker, so readers with random access can
+// locate frames without scanning the container.
+//
+// The layout is specified in header.go.
+const (
+	// ContainerVersion is the container version written by this package
+	ContainerVersion = 2
+)
+
+// ErrAborted is returned by readers at the end of a framed stream that was
+// ended early with Abort. All data before it is valid.
+var ErrAborted = errors.New("compression: stream aborted by writer")
+
+// frameRecord is a frame as stored in the container
+type frameRecord struct {
+	typ       byte
+	algorithm Algorithm
+	checksum  Checksum
+	size      int
+	// payload holds the compressed frame, or the raw data for stored frames
+	payload []byte
+}
+
+// FrameInfo describes the location of a frame in a container
+type FrameInfo struct {
+	// CompressedOffset is the offset of the frame record in the container
+	CompressedOffset int64
+	// UncompressedOffset is the offset of the frame data in the uncompressed stream
+	UncompressedOffset int64
+	// Size is the uncompressed size of the frame, 0 for dictionary frames
+	Size int64
+}
+
+// containerWriter writes the container records to w
+type containerWriter struct {
+	w         io.Writer
+	version   byte
+	algorithm Algorithm
+	flags     uint32
+
+	headerWritten bool
+	offset        int64
+	uncompressed  int64
+	index         []FrameInfo
+}
+
+func (c *containerWriter) reset(w io.Writer, version byte, algorithm Algorithm) {
+	c.w = w
+	c.version = version
+	c.algorithm = algorithm
+	c.flags = 0
+	c.headerWritten = false
+	c.offset = 0
+	c.uncompressed = 0
+	c.index = c.index[:0]
+}
+
+func (c *containerWriter) write(p []byte) error {
+	n, err := c.w.Write(p)
+	c.offset += int64(n)
+	return err
+}
+
+func (c *containerWriter) writeHeader() error {
+	if c.headerWritten {
+		return nil
+	}
+	c.headerWritten = true
+	return c.write(append([]byte(HeaderMagic), c.version, byte(c.algorithm)))
+}
+
+// writeFrame writes a frame record
+func (c *containerWriter) writeFrame(rec frameRecord) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	if rec.size > 0 || rec.typ == frameDictionary {
+		// Other empty frames are keepalives or describe the frame
+		// following them and are not indexed
+		c.index = append(c.index, FrameInfo{
+			CompressedOffset:   c.offset,
+			UncompressedOffset: c.uncompressed,
+			Size:               int64(rec.size),
+		})
+		c.uncompressed += int64(rec.size)
+	}
+
+	header := make([]byte, 1, 2+2*binary.MaxVarintLen64)
+	header[0] = rec.typ
+	switch rec.typ {
+	case frameCoded:
+		header = append(header, byte(rec.algorithm))
+	case frameChecksum:
+		header = append(header, byte(rec.checksum))
+	}
+	header = binary.AppendUvarint(header, uint64(rec.size))
+	header = binary.AppendUvarint(header, uint64(len(rec.payload)))
+	if err := c.write(header); err != nil {
+		return err
+	}
+	return c.write(rec.payload)
+}
+
+// keepalive writes an empty stored frame
+func (c *containerWriter) keepalive() error {
+	return c.writeFrame(frameRecord{typ: frameStored})
+}
+
+// close writes the end marker and, from version 2 on, the index and trailer
+func (c *containerWriter) close() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	if err := c.write([]byte{frameEnd}); err != nil {
+		return err
+	}
+	if c.version < 2 {
+		return nil
+	}
+
+	indexOffset := c.offset
+	index := binary.AppendUvarint(nil, uint64(len(c.index)))
+	for _, fi := range c.index {
+		index = binary.AppendUvarint(index, uint64(fi.CompressedOffset))
+		index = binary.AppendUvarint(index, uint64(fi.UncompressedOffset))
+		index = binary.AppendUvarint(index, uint64(fi.Size))
+	}
+	if err := c.write(index); err != nil {
+		return err
+	}
+
+	trailer := binary.LittleEndian.AppendUint64(nil, uint64(indexOffset))
+	trailer = binary.LittleEndian.AppendUint32(trailer, uint32(len(c.index)))
+	trailer = binary.LittleEndian.AppendUint32(trailer, c.flags)
+	trailer = append(trailer, TrailerMagic...)
+	return c.write(trailer)
+}
+
+// containerReader reads the container records from r
+type containerReader struct {
+	r *bufio.Reader
+
+	version    byte
+	algorithm  Algorithm
+	flags      uint32
+	headerRead bool
+	payload    []byte
+	index      []FrameInfo
+}
+
+func (c *containerReader) readHeader() error {
+	header := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return fmt.Errorf("%w: reading container header: %v", ErrCorruptStream, err)
+	}
+	if err := ValidateHeader(header); err != nil {
+		return err
+	}
+	c.version = header[HeaderVersionOffset]
+	c.algorithm = Algorithm(header[HeaderAlgorithmOffset])
+	if !c.algorithm.Available() {
+		return unavailableAlgorithm(c.algorithm)
+	}
+	c.headerRead = true
+	return nil
+}
+
+// next returns the next frame. The payload is only valid until the next
+// call. It returns io.EOF after the end of the container.
+func (c *containerReader) next() (frameRecord, error) {
+	rec, compressedSize, err := c.nextHeader()
+	if err != nil {
+		return rec, err
+	}
+	rec.payload, err = c.readPayload(compressedSize)
+	return rec, err
+}
+
+// readPayload reads the n byte payload following a frame header
+func (c *containerReader) readPayload(n int) ([]byte, error) {
+	c.payload = grow(c.payload, n)
+	if _, err := io.ReadFull(c.r, c.payload); err != nil {
+		return nil, fmt.Errorf("%w: truncated frame", io.ErrUnexpectedEOF)
+	}
+	return c.payload, nil
+}
+
+// nextHeader reads the header of the next frame and returns it along with
+// the size of the payload that follows
+func (c *containerReader) nextHeader() (frameRecord, int, error) {
+	var rec frameRecord
+	if !c.headerRead {
+		if err := c.readHeader(); err != nil {
+			return rec, 0, err
+		}
+	}
+
+	typ, err := c.r.ReadByte()
+	if err != nil {
+		return rec, 0, fmt.Errorf("%w: missing end of container", io.ErrUnexpectedEOF)
+	}
+	switch typ {
+	case frameEnd:
+		if err := c.readIndex(); err != nil {
+			return rec, 0, err
+		}
+		return rec, 0, io.EOF
+	case frameData, frameStored, frameZero:
+		rec.algorithm = c.algorithm
+	case frameCoded:
+		a, err := c.r.ReadByte()
+		if err != nil {
+			return rec, 0, fmt.Errorf("%w: truncated frame", io.ErrUnexpectedEOF)
+		}
+		if rec.algorithm = Algorithm(a); !rec.algorithm.Available() {
+			return rec, 0, unavailableAlgorithm(rec.algorithm)
+		}
+	case frameDictionary, frameDedup, frameRef, frameAnnotation:
+	case frameChecksum:
+		c, err := c.r.ReadByte()
+		if err != nil {
+			return rec, 0, fmt.Errorf("%w: truncated frame", io.ErrUnexpectedEOF)
+		}
+		rec.checksum = Checksum(c)
+	default:
+		return rec, 0, fmt.Errorf("%w: unknown frame type %d", ErrCorruptStream, typ)
+	}
+
+	size, err := binary.ReadUvarint(c.r)
+	if err != nil || size > MaxFrameSize {
+		return rec, 0, fmt.Errorf("%w: bad frame size", ErrCorruptStream)
+	}
+	compressedSize, err := binary.ReadUvarint(c.r)
+	if err != nil || compressedSize > 2*MaxFrameSize || typ == frameStored && compressedSize != size ||
+		typ == frameChecksum && size != 0 ||
+		typ == frameDictionary && (size != 0 || compressedSize < 4) ||
+		typ == frameDedup && (size != 0 || compressedSize == 0) || typ == frameRef && (size == 0 || compressedSize == 0) ||
+		typ == frameAnnotation && (size != 0 || compressedSize == 0) {
+		return rec, 0, fmt.Errorf("%w: bad compressed frame size", ErrCorruptStream)
+	}
+	rec.typ = typ
+	rec.size = int(size)
+	return rec, int(compressedSize), nil
+}
+
+// readIndex reads the index and trailer following the end marker
+func (c *containerReader) readIndex() error {
+	if c.version < 2 {
+		return nil
+	}
+	count, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return fmt.Errorf("%w: reading index: %v", io.ErrUnexpectedEOF, err)
+	}
+	c.index = c.index[:0]
+	for i := uint64(0); i < count; i++ {
+		var v [3]uint64
+		for j := range v {
+			if v[j], err = binary.ReadUvarint(c.r); err != nil {
+				return fmt.Errorf("%w: reading index: %v", io.ErrUnexpectedEOF, err)
+			}
+		}
+		c.index = append(c.index, FrameInfo{
+			CompressedOffset:   int64(v[0]),
+			UncompressedOffset: int64(v[1]),
+			Size:               int64(v[2]),
+		})
+	}
+
+	trailer := make([]byte, TrailerSize)
+	if _, err := io.ReadFull(c.r, trailer); err != nil {
+		return fmt.Errorf("%w: reading trailer: %v", io.ErrUnexpectedEOF, err)
+	}
+	if string(trailer[16:]) != TrailerMagic || binary.LittleEndian.Uint32(trailer[8:]) != uint32(count) {
+		return fmt.Errorf("%w: bad container trailer", ErrCorruptStream)
+	}
+	c.flags = binary.LittleEndian.Uint32(trailer[12:])
+	return nil
+}
+
+// ReadContainerIndex reads the frame index of a version 2 container of the
+// given size without scanning the frames
+func ReadContainerIndex(r io.ReaderAt, size int64) ([]FrameInfo, error) {
+	if size < int64(HeaderSize+1+TrailerSize) {
+		return nil, fmt.Errorf("%w: container too small", ErrCorruptStream)
+	}
+	header := make([]byte, HeaderSize)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, err
+	}
+	if err := ValidateHeader(header); err != nil {
+		return nil, err
+	}
+	if version := header[HeaderVersionOffset]; version < 2 {
+		return nil, fmt.Errorf("%w: %d has no index", ErrUnsupportedVersion, version)
+	}
+
+	trailer := make([]byte, TrailerSize)
+	if _, err := r.ReadAt(trailer, size-TrailerSize); err != nil {
+		return nil, err
+	}
+	if string(trailer[16:]) != TrailerMagic {
+		return nil, fmt.Errorf("%w: bad container trailer", ErrCorruptStream)
+	}
+	indexOffset := int64(binary.LittleEndian.Uint64(trailer))
+	if indexOffset <= 0 || indexOffset > size-TrailerSize {
+		return nil, fmt.Errorf("%w: bad index offset", ErrCorruptStream)
+	}
+
+	c := containerReader{
+		r:       bufio.NewReader(io.NewSectionReader(r, indexOffset-1, size-indexOffset+1)),
+		version: header[HeaderVersionOffset],
+	}
+	if b, err := c.r.ReadByte(); err != nil || b != frameEnd {
+		return nil, fmt.Errorf("%w: bad index offset", ErrCorruptStream)
+	}
+	if err := c.readIndex(); err != nil {
+		return nil, err
+	}
+	return c.index, nil
+}
+
+// MigrateContainer rewrites the container read from r into w using the
+// given container version. Frames are copied without recompression.
+func MigrateContainer(r io.Reader, w io.Writer, targetVersion int) error {
+	if targetVersion < 1 || targetVersion > ContainerVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, targetVersion)
+	}
+
+	cr := containerReader{r: bufio.NewReader(r)}
+	if err := cr.readHeader(); err != nil {
+		return err
+	}
+	var cw containerWriter
+	cw.reset(w, byte(targetVersion), cr.algorithm)
+
+	for {
+		rec, err := cr.next()
+		if err == io.EOF {
+			cw.flags = cr.flags
+			return cw.close()
+		}
+		if err != nil {
+			return err
+		}
+		if err := cw.writeFrame(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// FramePredicate selects frames of a container by their position and index
+// entry
+type FramePredicate func(n int, fi FrameInfo) bool
+
+// FrameRange selects the frames first through last, counted from 0
+func FrameRange(first, last int) FramePredicate {
+	return func(n int, fi FrameInfo) bool {
+		return n >= first && n <= last
+	}
+}
+
+// OffsetRange selects the frames overlapping the uncompressed byte range
+// [start, end)
+func OffsetRange(start, end int64) FramePredicate {
+	return func(n int, fi FrameInfo) bool {
+		return fi.UncompressedOffset < end && fi.UncompressedOffset+fi.Size > start
+	}
+}
+
+// ExtractFrames copies the frames of the container read from src that match
+// keep into a new container written to dst, without recompression. Since
+// frames are independent, the result is a valid container holding the
+// concatenated data of the selected frames. References of WithFrameDedup
+// are replaced by the frame they repeat. Containers flagged as aborted
+// remain flagged, containers written with WithFramePayloadHooks are
+// rejected once their trailer is read.
+func ExtractFrames(src io.Reader, dst io.Writer, keep FramePredicate) error {
+	var consumed counter
+	cr := containerReader{r: bufio.NewReader(&countingReader{r: src, n: &consumed})}
+	if err := cr.readHeader(); err != nil {
+		return err
+	}
+	var cw containerWriter
+	cw.reset(dst, ContainerVersion, cr.algorithm)
+
+	fi := FrameInfo{}
+	// dict is the current dictionary frame and written the one last
+	// written to dst
+	var dict, written *frameRecord
+	writeDict := func(d *frameRecord) error {
+		if d == nil || d == written {
+			return nil
+		}
+		written = d
+		return cw.writeFrame(*d)
+	}
+	// window holds the frames references may repeat, with their dictionary
+	var window *extractWindow
+	// annotated is set if the next frame was annotated
+	annotated := false
+	for n := 0; ; n++ {
+		fi.CompressedOffset = consumed.Load() - int64(cr.r.Buffered())
+		rec, size, err := cr.nextHeader()
+		if err == io.EOF {
+			if cr.flags&TrailerHooked != 0 {
+				return errors.New("compression: frames with hooked payloads cannot be extracted")
+			}
+			cw.flags = cr.flags & TrailerAborted
+			return cw.close()
+		}
+		if err != nil {
+			return err
+		}
+		if rec.typ == frameDictionary {
+			if rec.payload, err = cr.readPayload(size); err != nil {
+				return err
+			}
+			rec.payload = bytes.Clone(rec.payload)
+			dict = &rec
+			n--
+			continue
+		}
+		if rec.typ == frameDedup {
+			if rec.payload, err = cr.readPayload(size); err != nil {
+				return err
+			}
+			if window, err = newExtractWindow(rec.payload); err != nil {
+				return err
+			}
+			n--
+			continue
+		}
+		if rec.size == 0 {
+			// Drop keepalives, and checksums that do not cover the selection.
+			// Annotations are written again for the frames kept.
+			if _, err := cr.r.Discard(size); err != nil {
+				return fmt.Errorf("%w: truncated frame", io.ErrUnexpectedEOF)
+			}
+			annotated = annotated || rec.typ == frameAnnotation
+			n--
+			continue
+		}
+		fi.Size = int64(rec.size)
+		kept := keep(n, fi)
+		if kept || window != nil && (dedupable(rec.typ) || rec.typ == frameRef) {
+			if rec.payload, err = cr.readPayload(size); err != nil {
+				return err
+			}
+		} else if _, err := cr.r.Discard(size); err != nil {
+			return fmt.Errorf("%w: truncated frame", io.ErrUnexpectedEOF)
+		}
+		recDict := dict
+		if rec.typ == frameRef {
+			if rec, recDict, err = window.lookup(rec); err != nil {
+				return err
+			}
+		} else if window != nil && dedupable(rec.typ) {
+			window.add(rec, dict)
+		}
+		if kept {
+			if err := writeDict(recDict); err != nil {
+				return err
+			}
+			if annotated {
+				if err := cw.writeFrame(annotationRecord(rec)); err != nil {
+					return err
+				}
+			}
+			if err := cw.writeFrame(rec); err != nil {
+				return err
+			}
+		}
+		annotated = false
+		fi.UncompressedOffset += fi.Size
+	}
+}
@@ -0,0 +1,130 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+// requireZstd skips tests that need zstd in builds with the nozstd tag
+func requireZstd(t testing.TB) {
+	t.Helper()
+	if !zstdAvailable {
+		t.Skip("zstd support not compiled in")
+	}
+}
+
+func writeContainer(t *testing.T, m *Middleware, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if err := w.(io.Closer).Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadContainerIndex(t *testing.T) {
+	m := New(S2, WithFrameSize(1000))
+	container := writeContainer(t, m, bytes.Repeat([]byte("x"), 2500))
+
+	index, err := ReadContainerIndex(bytes.NewReader(container), int64(len(container)))
+	if err != nil {
+		t.Fatalf("Failed to read index: %v", err)
+	}
+	if len(index) != 3 {
+		t.Fatalf("Expected 3 frames, got %d", len(index))
+	}
+	for i, fi := range index {
+		if fi.UncompressedOffset != int64(i*1000) {
+			t.Fatalf("Frame %d: unexpected uncompressed offset %d", i, fi.UncompressedOffset)
+		}
+		if container[fi.CompressedOffset] != frameData {
+			t.Fatalf("Frame %d: compressed offset does not point to a frame", i)
+		}
+	}
+	if index[2].Size != 500 {
+		t.Fatalf("Expected last frame size 500, got %d", index[2].Size)
+	}
+}
+
+func TestMigrateContainer(t *testing.T) {
+	requireZstd(t)
+	m := New(Zstd, WithFrameSize(1000))
+	testData := bytes.Repeat([]byte("migrate me "), 500)
+	v2 := writeContainer(t, m, testData)
+
+	var v1 bytes.Buffer
+	if err := MigrateContainer(bytes.NewReader(v2), &v1, 1); err != nil {
+		t.Fatalf("Failed to migrate to version 1: %v", err)
+	}
+	if v1.Bytes()[HeaderVersionOffset] != 1 || v1.Len() >= len(v2) {
+		t.Fatal("Expected a version 1 container without index")
+	}
+	if _, err := ReadContainerIndex(bytes.NewReader(v1.Bytes()), int64(v1.Len())); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("Expected ErrUnsupportedVersion for version 1 index, got %v", err)
+	}
+
+	// Version 1 containers remain readable
+	data, err := io.ReadAll(m.Reader(bytes.NewReader(v1.Bytes())))
+	if err != nil || !bytes.Equal(data, testData) {
+		t.Fatalf("Failed to read version 1 container: %v", err)
+	}
+
+	var back bytes.Buffer
+	if err := MigrateContainer(&v1, &back, ContainerVersion); err != nil {
+		t.Fatalf("Failed to migrate to version 2: %v", err)
+	}
+	if !bytes.Equal(back.Bytes(), v2) {
+		t.Fatal("Expected round trip migration to reproduce the original container")
+	}
+
+	if err := MigrateContainer(bytes.NewReader(v2), io.Discard, 99); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("Expected ErrUnsupportedVersion, got %v", err)
+	}
+}
+
+func TestExtractFrames(t *testing.T) {
+	requireZstd(t)
+	m := New(Zstd, WithFrameSize(1000))
+	var data []byte
+	for i := 0; i < 5; i++ {
+		data = append(data, bytes.Repeat([]byte{'a' + byte(i)}, 1000)...)
+	}
+	compressed := writeContainer(t, m, data)
+	index, err := ReadContainerIndex(bytes.NewReader(compressed), int64(len(compressed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var seen []FrameInfo
+	var out bytes.Buffer
+	err = ExtractFrames(bytes.NewReader(compressed), &out, func(n int, fi FrameInfo) bool {
+		seen = append(seen, fi)
+		return FrameRange(1, 2)(n, fi)
+	})
+	if err != nil {
+		t.Fatalf("Failed to extract frames: %v", err)
+	}
+	if !reflect.DeepEqual(seen, index) {
+		t.Fatalf("Expected predicate to see the index %+v, got %+v", index, seen)
+	}
+	got, _ := io.ReadAll(m.Reader(&out))
+	if !bytes.Equal(got, data[1000:3000]) {
+		t.Fatalf("Unexpected extracted data %q...", got[:min(len(got), 10)])
+	}
+
+	out.Reset()
+	if err := ExtractFrames(bytes.NewReader(compressed), &out, OffsetRange(3999, 4001)); err != nil {
+		t.Fatalf("Failed to extract frames: %v", err)
+	}
+	got, _ = io.ReadAll(m.Reader(&out))
+	if !bytes.Equal(got, data[3000:]) {
+		t.Fatalf("Expected the last two frames, got %d bytes", len(got))
+	}
+}
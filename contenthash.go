@@ -0,0 +1,74 @@
+package compression
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+)
+
+// WithContentHash digests the uncompressed bytes accepted by every writer
+// with a hash created by newHash while compressing, so the digest of the
+// plain content, e.g. for dedup or ETags, is available without a second
+// pass. Writers report it through ContentHasher.
+func WithContentHash(newHash func() hash.Hash) Option {
+	return func(m *Middleware) {
+		m.contentHash = newHash
+	}
+}
+
+// ContentHasher is implemented by writers returned from Middleware.Writer
+type ContentHasher interface {
+	// ContentHash returns the digest of the content written so far, nil
+	// without WithContentHash
+	ContentHash() []byte
+}
+
+func (w *writer) ContentHash() []byte {
+	w.lock()
+	defer w.unlock()
+	if w.hash == nil {
+		return nil
+	}
+	return w.hash.Sum(nil)
+}
+
+// ContentHashError is returned by readers at the end of a stream whose
+// uncompressed content does not match the digest set with
+// WithExpectedContentHash
+type ContentHashError struct {
+	Expected []byte
+	Actual   []byte
+}
+
+func (e *ContentHashError) Error() string {
+	return fmt.Sprintf("compression: content hash mismatch: expected %x, got %x", e.Expected, e.Actual)
+}
+
+// Unwrap makes the error match ErrCorruptStream
+func (e *ContentHashError) Unwrap() error {
+	return ErrCorruptStream
+}
+
+// WithExpectedContentHash makes readers digest the uncompressed content with
+// a hash created by newHash and compare it with digest at the end of the
+// stream, returning a *ContentHashError on mismatch. This verifies the data
+// end to end, independent of codec checksums.
+func WithExpectedContentHash(digest []byte, newHash func() hash.Hash) Option {
+	return func(m *Middleware) {
+		m.expectedDigest = digest
+		m.newContentHash = newHash
+	}
+}
+
+// sum adds p to the content hash of r and, at the end of the stream,
+// compares the digest
+func (r *reader) sum(p []byte, end bool) error {
+	r.hash.Write(p)
+	if !end {
+		return nil
+	}
+	if actual := r.hash.Sum(nil); !bytes.Equal(actual, r.m.expectedDigest) {
+		return &ContentHashError{Expected: r.m.expectedDigest, Actual: actual}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package compression
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestWithContentHash(t *testing.T) {
+	requireZstd(t)
+	data := bytes.Repeat([]byte("hash me while compressing "), 1000)
+	m := New(Zstd, WithContentHash(sha256.New))
+
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	for p := data; len(p) > 0; p = p[min(len(p), 1000):] {
+		w.Write(p[:min(len(p), 1000)])
+	}
+	w.(io.Closer).Close()
+
+	want := sha256.Sum256(data)
+	if got := w.(ContentHasher).ContentHash(); !bytes.Equal(got, want[:]) {
+		t.Fatalf("Expected content digest %x, got %x", want, got)
+	}
+	if got, _ := io.ReadAll(m.Reader(&buf)); !bytes.Equal(got, data) {
+		t.Fatal("Round trip failed")
+	}
+	if got := New(Zstd).Writer(io.Discard).(ContentHasher).ContentHash(); got != nil {
+		t.Fatalf("Expected no digest without the option, got %x", got)
+	}
+}
+
+func TestWithContentHash_Concurrent(t *testing.T) {
+	m := New(S2, WithContentHash(sha256.New))
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data := bytes.Repeat([]byte{byte(i)}, 100000)
+			w := m.Writer(io.Discard)
+			for p := data; len(p) > 0; p = p[min(len(p), 1000):] {
+				w.Write(p[:min(len(p), 1000)])
+			}
+			w.(io.Closer).Close()
+			if want := sha256.Sum256(data); !bytes.Equal(w.(ContentHasher).ContentHash(), want[:]) {
+				t.Errorf("Writer %d: digest mixed with other writers", i)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestWithExpectedContentHash(t *testing.T) {
+	data := bytes.Repeat([]byte("verify me at EOF "), 2000)
+	digest := sha256.Sum256(data)
+	var compressed bytes.Buffer
+	w := New(S2).Writer(&compressed)
+	w.Write(data)
+	w.(io.Closer).Close()
+
+	m := New(S2, WithExpectedContentHash(digest[:], sha256.New))
+	if got, err := io.ReadAll(m.Reader(bytes.NewReader(compressed.Bytes()))); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Expected verified read, got %v", err)
+	}
+	var out bytes.Buffer
+	if _, err := io.Copy(&out, m.Reader(bytes.NewReader(compressed.Bytes()))); err != nil {
+		t.Fatalf("Expected verified WriteTo, got %v", err)
+	}
+	dst := make([]byte, len(data))
+	if _, err := m.Reader(bytes.NewReader(compressed.Bytes())).(DirectDecoder).DecodeInto(dst); err != nil {
+		t.Fatalf("Expected verified DecodeInto, got %v", err)
+	}
+
+	wrong := sha256.Sum256([]byte("something else"))
+	m = New(S2, WithExpectedContentHash(wrong[:], sha256.New))
+	_, err := io.ReadAll(m.Reader(bytes.NewReader(compressed.Bytes())))
+	var hashErr *ContentHashError
+	if !errors.As(err, &hashErr) || !errors.Is(err, ErrCorruptStream) {
+		t.Fatalf("Expected *ContentHashError, got %v", err)
+	}
+	if !bytes.Equal(hashErr.Actual, digest[:]) || !bytes.Equal(hashErr.Expected, wrong[:]) {
+		t.Fatalf("Unexpected digests in %v", hashErr)
+	}
+	if _, err := io.Copy(io.Discard, m.Reader(bytes.NewReader(compressed.Bytes()))); !errors.As(err, &hashErr) {
+		t.Fatalf("Expected *ContentHashError from WriteTo, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Corpus is a set of sample files used to compare algorithms and levels on
+// representative data
+type Corpus struct {
+	Files []CorpusFile
+}
+
+// CorpusFile is a named sample of a corpus
+type CorpusFile struct {
+	Name string
+	Data []byte
+}
+
+// LoadCorpus loads all regular files below dir into a corpus
+func LoadCorpus(dir string) (Corpus, error) {
+	var c Corpus
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name, _ := filepath.Rel(dir, path)
+		c.Files = append(c.Files, CorpusFile{Name: name, Data: data})
+		return nil
+	})
+	return c, err
+}
+
+// Size returns the total size of the corpus in bytes
+func (c Corpus) Size() int64 {
+	var size int64
+	for _, f := range c.Files {
+		size += int64(len(f.Data))
+	}
+	return size
+}
+
+// BenchmarkResult is the outcome of compressing a corpus with one algorithm
+// and level
+type BenchmarkResult struct {
+	Algorithm         Algorithm     `json:"algorithm"`
+	Level             Level         `json:"level"`
+	UncompressedBytes int64         `json:"uncompressed_bytes"`
+	CompressedBytes   int64         `json:"compressed_bytes"`
+	Ratio             float64       `json:"ratio"`
+	CompressTime      time.Duration `json:"compress_ns"`
+	DecompressTime    time.Duration `json:"decompress_ns"`
+	CompressMBps      float64       `json:"compress_mbps"`
+	DecompressMBps    float64       `json:"decompress_mbps"`
+}
+
+// BenchmarkReport holds the results of a corpus benchmark
+type BenchmarkReport struct {
+	Files   int               `json:"files"`
+	Bytes   int64             `json:"bytes"`
+	Results []BenchmarkResult `json:"results"`
+}
+
+// WriteJSON writes the report as indented JSON
+func (r BenchmarkReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Benchmark compresses and decompresses every file of the corpus with each
+// combination of algorithms and levels and verifies the round trip
+func (c Corpus) Benchmark(algorithms []Algorithm, levels []Level) (BenchmarkReport, error) {
+	report := BenchmarkReport{Files: len(c.Files), Bytes: c.Size()}
+	for _, a := range algorithms {
+		for _, l := range levels {
+			result, err := c.benchmark(New(a, WithLevel(l)))
+			if err != nil {
+				return report, fmt.Errorf("benchmark %s/%s: %w", a, l, err)
+			}
+			report.Results = append(report.Results, result)
+		}
+	}
+	return report, nil
+}
+
+func (c Corpus) benchmark(m *Middleware) (BenchmarkResult, error) {
+	result := BenchmarkResult{Algorithm: m.algorithm, Level: m.level}
+	var compressed bytes.Buffer
+	for _, f := range c.Files {
+		compressed.Reset()
+		start := time.Now()
+		w := m.Writer(&compressed)
+		if _, err := w.Write(f.Data); err != nil {
+			w.(io.Closer).Close()
+			return result, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if err := w.(io.Closer).Close(); err != nil {
+			return result, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		result.CompressTime += time.Since(start)
+		result.UncompressedBytes += int64(len(f.Data))
+		result.CompressedBytes += int64(compressed.Len())
+
+		start = time.Now()
+		r := m.Reader(&compressed)
+		data, err := io.ReadAll(r)
+		if c, ok := r.(io.Closer); ok {
+			// Releases decoders and their goroutines
+			c.Close()
+		}
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		result.DecompressTime += time.Since(start)
+		if !bytes.Equal(data, f.Data) {
+			return result, fmt.Errorf("%s: round trip mismatch", f.Name)
+		}
+	}
+
+	if result.UncompressedBytes > 0 {
+		result.Ratio = float64(result.CompressedBytes) / float64(result.UncompressedBytes)
+	}
+	result.CompressMBps = mbps(result.UncompressedBytes, result.CompressTime)
+	result.DecompressMBps = mbps(result.UncompressedBytes, result.DecompressTime)
+	return result, nil
+}
+
+func mbps(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / d.Seconds() / 1e6
+}
@@ -0,0 +1,53 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCorpus_Benchmark(t *testing.T) {
+	requireZstd(t)
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.json"), bytes.Repeat([]byte(`{"key":"value"}`), 200), 0o644)
+	os.MkdirAll(filepath.Join(dir, "logs"), 0o755)
+	os.WriteFile(filepath.Join(dir, "logs", "b.log"), bytes.Repeat([]byte("INFO request handled\n"), 200), 0o644)
+
+	corpus, err := LoadCorpus(dir)
+	if err != nil {
+		t.Fatalf("Failed to load corpus: %v", err)
+	}
+	if len(corpus.Files) != 2 {
+		t.Fatalf("Expected 2 files, got %d", len(corpus.Files))
+	}
+
+	report, err := corpus.Benchmark([]Algorithm{Zstd, S2}, []Level{Fastest, Best})
+	if err != nil {
+		t.Fatalf("Benchmark failed: %v", err)
+	}
+	if len(report.Results) != 4 {
+		t.Fatalf("Expected 4 results, got %d", len(report.Results))
+	}
+	for _, r := range report.Results {
+		if r.UncompressedBytes != corpus.Size() || r.Ratio <= 0 || r.Ratio >= 1 {
+			t.Fatalf("Unexpected result %+v", r)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatalf("Failed to write JSON: %v", err)
+	}
+	var decoded BenchmarkReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("Failed to decode JSON report: %v", err)
+	}
+	if decoded.Results[0].Algorithm != Zstd || decoded.Results[3].Level != Best {
+		t.Fatalf("Unexpected decoded report %+v", decoded.Results)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(`"algorithm": "zstd"`)) {
+		t.Fatal("Expected algorithms to be encoded by name")
+	}
+}
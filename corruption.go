@@ -0,0 +1,167 @@
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	dsnet "github.com/dsnet/compress"
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zlib"
+)
+
+// CorruptionKind classifies a CorruptionEvent
+type CorruptionKind int
+
+const (
+	// CorruptionChecksum is a mismatching checksum or content hash
+	CorruptionChecksum CorruptionKind = iota
+	// CorruptionTruncated is a stream that ends early
+	CorruptionTruncated
+	// CorruptionDecode is data the codec or container cannot decode
+	CorruptionDecode
+	// CorruptionSkipped is damaged data skipped by WithRecoverCorruptFrames
+	CorruptionSkipped
+)
+
+var corruptionKindNames = map[CorruptionKind]string{
+	CorruptionChecksum:  "checksum",
+	CorruptionTruncated: "truncated",
+	CorruptionDecode:    "decode",
+	CorruptionSkipped:   "skipped",
+}
+
+// String returns the lower-case name of the kind
+func (k CorruptionKind) String() string {
+	if name, ok := corruptionKindNames[k]; ok {
+		return name
+	}
+	return "CorruptionKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// TruncatedError is returned by readers of streams that end early, e.g. a
+// spilled file cut short by a full disk. It wraps io.ErrUnexpectedEOF and
+// matches ErrTruncated.
+type TruncatedError struct {
+	// CompressedOffset is the number of compressed bytes read from the
+	// source, where the stream was cut
+	CompressedOffset int64
+	// DecompressedOffset is the number of bytes decoded before the cut
+	DecompressedOffset int64
+	// Err is the error of the codec or container
+	Err error
+}
+
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf("compression: stream truncated at compressed offset %d, decompressed offset %d: %v", e.CompressedOffset, e.DecompressedOffset, e.Err)
+}
+
+func (e *TruncatedError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is ErrTruncated
+func (e *TruncatedError) Is(target error) bool {
+	return target == ErrTruncated
+}
+
+// CorruptionEvent describes corruption detected by a reader, see
+// WithCorruptionCallback
+type CorruptionEvent struct {
+	Kind      CorruptionKind
+	Algorithm Algorithm
+	Tag       string
+	// Offset is the uncompressed offset up to which the stream was read
+	// when the corruption was detected
+	Offset int64
+	// CompressedOffset and CompressedSize are the compressed range skipped
+	// by events of kind CorruptionSkipped
+	CompressedOffset int64
+	CompressedSize   int64
+	// Err is the error returned to the caller, or the error of the skipped
+	// data
+	Err error
+}
+
+// WithCorruptionCallback calls fn whenever a reader detects corruption, such
+// as a checksum mismatch or a truncated stream, so durability monitoring sees
+// it even if callers only log or retry the returned error. fn is called once
+// per reader and once per range skipped by WithRecoverCorruptFrames,
+// synchronously from the reading goroutine. Stats.Corruptions counts the
+// events with or without a callback.
+func WithCorruptionCallback(fn func(CorruptionEvent)) Option {
+	return func(m *Middleware) {
+		m.onCorruption = fn
+	}
+}
+
+// corruptionKind classifies err, reporting false for errors that do not
+// indicate corrupt data, such as errors of the source
+func corruptionKind(err error) (CorruptionKind, bool) {
+	var hashErr *ContentHashError
+	var flateErr flate.CorruptInputError
+	var bzip2Err dsnet.Error
+	switch {
+	case errors.Is(err, ErrChecksumMismatch), errors.As(err, &hashErr),
+		errors.Is(err, gzip.ErrChecksum), errors.Is(err, zlib.ErrChecksum), errors.Is(err, s2.ErrCRC):
+		return CorruptionChecksum, true
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return CorruptionTruncated, true
+	case errors.Is(err, ErrCorruptStream), errors.As(err, &flateErr), errors.Is(err, s2.ErrCorrupt), errors.Is(err, s2.ErrUnsupported),
+		errors.Is(err, gzip.ErrHeader), errors.Is(err, zlib.ErrHeader), errors.As(err, &bzip2Err) && bzip2Err.IsCorrupted():
+		return CorruptionDecode, true
+	}
+	return zstdCorruptionKind(err)
+}
+
+// classify wraps err like classifyError and adds the offsets of r to
+// truncation errors
+func (r *reader) classify(err error) error {
+	var truncated *TruncatedError
+	if err == nil || err == io.EOF || errors.As(err, &truncated) {
+		return err
+	}
+	if r.cutChunk(err) {
+		err = &sentinelError{err, io.ErrUnexpectedEOF}
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		return classifyError(err)
+	}
+	var compressed int64
+	if r.in != nil {
+		compressed = r.in.total.Load()
+	}
+	return &TruncatedError{CompressedOffset: compressed, DecompressedOffset: r.off, Err: err}
+}
+
+// cutChunk reports whether err is an S2 or Snappy stream cut within a
+// chunk, which their readers report as corrupt
+func (r *reader) cutChunk(err error) bool {
+	a := r.m.algorithm
+	return (a == S2 || a == Snappy) && r.m.frameSize == 0 && r.in != nil && r.in.eof.Load() && errors.Is(err, s2.ErrCorrupt)
+}
+
+// corrupted reports err once per reader if it indicates corruption
+func (r *reader) corrupted(err error) {
+	if err == nil || err == io.EOF || r.corrupt {
+		return
+	}
+	kind, ok := corruptionKind(err)
+	if !ok {
+		return
+	}
+	r.corrupt = true
+	r.m.stats.corruptions.Add(1)
+	if r.m.onCorruption != nil {
+		r.m.onCorruption(CorruptionEvent{
+			Kind:      kind,
+			Algorithm: r.m.algorithm,
+			Tag:       r.m.tag,
+			Offset:    r.off,
+			Err:       err,
+		})
+	}
+}
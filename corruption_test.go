@@ -0,0 +1,121 @@
+package compression
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestWithCorruptionCallback(t *testing.T) {
+	requireZstd(t)
+	data := []byte(strings.Repeat("durability monitoring sees corruption ", 2000))
+	tests := []struct {
+		name    string
+		a       Algorithm
+		opts    []Option
+		corrupt func([]byte) []byte
+		kind    CorruptionKind
+	}{
+		{"gzip-crc", Gzip, nil, func(b []byte) []byte { b[len(b)-8] ^= 0xff; return b }, CorruptionChecksum},
+		{"zstd-truncated", Zstd, nil, func(b []byte) []byte { return b[:len(b)/2] }, CorruptionTruncated},
+		{"framed-truncated", S2, []Option{WithFrameSize(4096)}, func(b []byte) []byte { return b[:len(b)/2] }, CorruptionTruncated},
+		{"s2-decode", S2, nil, func(b []byte) []byte { b[20] ^= 0xff; return b }, CorruptionDecode},
+		{"content-hash", Zstd, []Option{WithExpectedContentHash([]byte("wrong"), sha256.New)}, func(b []byte) []byte { return b }, CorruptionChecksum},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var events []CorruptionEvent
+			m := New(tt.a, append(tt.opts, WithTag("corruption-"+tt.name), WithCorruptionCallback(func(e CorruptionEvent) {
+				events = append(events, e)
+			}))...)
+			stream := tt.corrupt(writeContainer(t, New(tt.a, WithFrameSize(m.frameSize)), data))
+
+			r := m.Reader(bytes.NewReader(stream))
+			n, err := io.Copy(io.Discard, struct{ io.Reader }{r})
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			// Reading again returns the error again but reports nothing new
+			r.Read(make([]byte, 1))
+			if len(events) != 1 {
+				t.Fatalf("Expected one event, got %d", len(events))
+			}
+			e := events[0]
+			if e.Kind != tt.kind || e.Algorithm != tt.a || e.Tag != "corruption-"+tt.name || e.Offset != n || e.Err != err {
+				t.Fatalf("Unexpected event %+v after %d bytes, %v", e, n, err)
+			}
+			if s := m.Stats(); s.Corruptions != 1 || StatsByTag()["corruption-"+tt.name].Corruptions != 1 {
+				t.Fatalf("Expected one corruption in the stats, got %d", s.Corruptions)
+			}
+		})
+	}
+}
+
+func TestWithCorruptionCallback_SourceErrors(t *testing.T) {
+	requireZstd(t)
+	called := false
+	m := New(Zstd, WithCorruptionCallback(func(CorruptionEvent) { called = true }))
+	stream := writeContainer(t, m, []byte(strings.Repeat("source errors are no corruption ", 100)))
+
+	errSource := errors.New("connection reset")
+	src := io.MultiReader(bytes.NewReader(stream[:len(stream)/2]), &errReader{errSource})
+	if _, err := io.ReadAll(m.Reader(src)); !errors.Is(err, errSource) {
+		t.Fatalf("Expected the source error, got %v", err)
+	}
+	if _, err := io.ReadAll(m.Reader(bytes.NewReader(stream))); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if called || m.Stats().Corruptions != 0 {
+		t.Fatal("Expected no corruption to be reported")
+	}
+}
+
+func TestCorruptionKind_String(t *testing.T) {
+	if CorruptionTruncated.String() != "truncated" || CorruptionKind(7).String() != "CorruptionKind(7)" {
+		t.Fatal("Unexpected kind names")
+	}
+}
+
+func TestTruncatedError(t *testing.T) {
+	requireZstd(t)
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, 256<<10)
+	for i := range data {
+		data[i] = 'a' + byte(rng.Intn(8))
+	}
+	for name, m := range map[string]*Middleware{
+		"gzip":   New(Gzip),
+		"zstd":   New(Zstd),
+		"s2":     New(S2),
+		"snappy": New(Snappy),
+		"zlib":   New(Zlib),
+		"flate":  New(Flate),
+		"bzip2":  New(Bzip2),
+		"framed": New(Zstd, WithFrameSize(16<<10)),
+	} {
+		t.Run(name, func(t *testing.T) {
+			stream := writeContainer(t, m, data)
+			cut := len(stream) / 2
+			got, err := io.ReadAll(m.Reader(bytes.NewReader(stream[:cut])))
+			var truncated *TruncatedError
+			if !errors.As(err, &truncated) || !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("Expected a TruncatedError wrapping io.ErrUnexpectedEOF, got %v", err)
+			}
+			if truncated.CompressedOffset != int64(cut) || truncated.DecompressedOffset != int64(len(got)) {
+				t.Fatalf("Expected offsets %d/%d, got %d/%d", cut, len(got), truncated.CompressedOffset, truncated.DecompressedOffset)
+			}
+			if !bytes.Equal(got, data[:len(got)]) {
+				t.Fatal("Expected the data before the cut")
+			}
+
+			n, err := io.Copy(io.Discard, m.Reader(bytes.NewReader(stream[:cut])))
+			if !errors.As(err, &truncated) || truncated.DecompressedOffset != n {
+				t.Fatalf("Expected a TruncatedError at %d from WriteTo, got %v", n, err)
+			}
+		})
+	}
+}
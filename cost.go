@@ -0,0 +1,97 @@
+package compression
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// costSampleSize is the size of the calibration sample of CostEstimate
+const costSampleSize = 256 << 10
+
+var (
+	costMu sync.Mutex
+	// costNsPerByte holds the calibrated compression cost per algorithm
+	// and level
+	costNsPerByte = make(map[[2]int]float64)
+	costSample    = sync.OnceValue(calibrationSample)
+)
+
+// CostEstimate estimates the CPU time in milliseconds and the memory in bytes
+// needed to compress size bytes with algorithm and level on this machine, so
+// schedulers can decide where to run compression and how many concurrent
+// streams a node can afford. The CPU cost is calibrated with a micro-benchmark
+// on a synthetic, moderately compressible sample the first time an algorithm
+// and level is queried; call CalibrateCosts at startup to avoid the delay.
+// Costs for real data vary with its compressibility. It fails with
+// ErrUnsupportedAlgorithm for algorithms not available in this build.
+func CostEstimate(algorithm Algorithm, level Level, size int64) (cpuMs float64, memBytes int64, err error) {
+	if !algorithm.Available() {
+		return 0, 0, fmt.Errorf("%w: %s is not available in this build", ErrUnsupportedAlgorithm, algorithm)
+	}
+	nsPerByte := calibrateCost(algorithm, level)
+	enc, _ := codecMemory(algorithm, level, &memoryPlan{zstdWindow: maxZstdWindow, s2Block: maxS2Block})
+	return nsPerByte * float64(size) / 1e6, enc, nil
+}
+
+// CalibrateCosts runs the micro-benchmarks of CostEstimate for all available
+// algorithms and levels
+func CalibrateCosts() {
+	for a := range algorithmNames {
+		if !a.Available() {
+			continue
+		}
+		for l := range levelNames {
+			calibrateCost(a, l)
+		}
+	}
+}
+
+// calibrateCost returns the compression cost of the available algorithm
+// and level in nanoseconds per byte, measuring it on first use. The
+// measurement runs without holding costMu, so concurrent first queries of
+// the same algorithm and level may both measure it.
+func calibrateCost(algorithm Algorithm, level Level) float64 {
+	key := [2]int{int(algorithm), int(level)}
+	costMu.Lock()
+	ns, ok := costNsPerByte[key]
+	costMu.Unlock()
+	if ok {
+		return ns
+	}
+
+	sample := costSample()
+	m := New(algorithm, WithLevel(level))
+	compress := func() {
+		w := m.Writer(io.Discard)
+		w.Write(sample)
+		w.(io.Closer).Close()
+	}
+	// Warm up the encoder pool, then take the fastest of a few runs
+	compress()
+	best := time.Duration(1<<63 - 1)
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		compress()
+		best = min(best, time.Since(start))
+	}
+	ns = float64(best) / costSampleSize
+
+	costMu.Lock()
+	defer costMu.Unlock()
+	costNsPerByte[key] = ns
+	return ns
+}
+
+// calibrationSample returns log-like text with some randomness
+func calibrationSample() []byte {
+	words := []string{"request", "response", "user", "status", "error", "latency", "GET", "POST", "/api/v1/items", "ok"}
+	rng := rand.New(rand.NewSource(1))
+	sample := make([]byte, 0, costSampleSize+64)
+	for len(sample) < costSampleSize {
+		sample = fmt.Appendf(sample, "%d %s %s id=%x\n", rng.Int63n(1e9), words[rng.Intn(len(words))], words[rng.Intn(len(words))], rng.Uint32())
+	}
+	return sample[:costSampleSize]
+}
@@ -0,0 +1,31 @@
+package compression
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCostEstimate(t *testing.T) {
+	requireZstd(t)
+	cpu, mem, err := CostEstimate(S2, Default, 1<<20)
+	if err != nil || cpu <= 0 || mem <= 0 {
+		t.Fatalf("Expected positive costs, got %vms %d bytes, %v", cpu, mem, err)
+	}
+	if double, _, _ := CostEstimate(S2, Default, 2<<20); double != 2*cpu {
+		t.Fatalf("Expected the CPU cost to scale with size, got %v and %v", cpu, double)
+	}
+	if _, zstdMem, _ := CostEstimate(Zstd, Best, 1<<20); zstdMem <= mem {
+		t.Fatalf("Expected zstd Best to need more memory than S2, got %d and %d", zstdMem, mem)
+	}
+	gzipFast, _, _ := CostEstimate(Gzip, Fastest, 1<<20)
+	gzipBest, _, _ := CostEstimate(Gzip, Best, 1<<20)
+	if gzipBest <= gzipFast {
+		t.Fatalf("Expected gzip Best to cost more CPU than Fastest, got %v and %v", gzipBest, gzipFast)
+	}
+}
+
+func TestCostEstimate_Unavailable(t *testing.T) {
+	if _, _, err := CostEstimate(Algorithm(99), Default, 1<<20); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Fatalf("Expected ErrUnsupportedAlgorithm, got %v", err)
+	}
+}
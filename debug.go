@@ -0,0 +1,167 @@
+package compression
+
+import (
+	"encoding/json"
+	"hash/adler32"
+)
+
+// DebugDump reports the effective configuration, pools, dictionary and
+// cumulative stats of the middleware, meant to be attached to support
+// bundles when diagnosing compression issues. The keys are stable, the
+// values are JSON friendly.
+func (m *Middleware) DebugDump() map[string]any {
+	config := map[string]any{
+		"algorithm":          m.algorithm.String(),
+		"level":              m.level.String(),
+		"frame_size":         m.frameSize,
+		"tag":                m.tag,
+		"stdlib_compat":      m.stdlibCompat,
+		"s2_index":           m.s2Index,
+		"keepalive":          m.keepalive.String(),
+		"memory_budget":      m.memoryBudget,
+		"zero_frames":        m.zeroFrames,
+		"sparse":             m.sparse,
+		"sparse_files":       m.sparseFiles,
+		"entropy_check":      m.entropyCheck,
+		"kernel_copy":        m.kernelCopy,
+		"track_latency":      m.trackLatency,
+		"resumable_writes":   m.resumable,
+		"readahead":          m.readahead,
+		"declared_sizes":     m.declaredSizes,
+		"alloc_sample":       m.allocSample,
+		"pgzip_blocks":       m.pgzipBlocks,
+		"workers":            m.workers(),
+		"backend":            m.backend.String(),
+		"content_hash":       m.newContentHash != nil,
+		"catalog":            m.catalog != nil,
+		"max_expansion":      m.maxExpansion,
+		"max_decompressed":   m.maxDecoded,
+		"decode_timeout":     m.readTimeout.String(),
+		"strict_framing":     m.strictFrames,
+		"recover_frames":     m.salvage,
+		"per_frame_level":    m.frameLevel != nil,
+		"frame_dedup":        m.dedupFrames,
+		"max_dedup_window":   m.maxDedupWindow(),
+		"ratio_annotations":  m.annotate,
+		"panic_free":         m.panicFree,
+		"stored_under_lag":   m.maxLag.String(),
+		"dict_resolver":      m.resolver != nil,
+		"dict_store":         m.dictStore != nil,
+		"eof_mode":           m.eofMode.String(),
+		"max_active_writers": m.quota.MaxActiveWriters,
+		"max_bytes":          m.quota.MaxBytes,
+	}
+	if m.checksum != 0 {
+		config["checksum"] = m.checksum.String()
+	}
+	if m.streamID != nil {
+		config["stream_identifier"] = map[string]any{
+			"body":     m.streamID.Body,
+			"omit":     m.streamID.Omit,
+			"optional": m.streamID.Optional,
+		}
+	}
+	if m.fallback != nil {
+		config["fallback"] = m.fallback.String()
+	}
+	if len(m.bestOf) > 0 {
+		names := make([]string, len(m.bestOf))
+		for i, a := range m.bestOf {
+			names[i] = a.String()
+		}
+		config["best_of"] = names
+	}
+	if len(m.matrix) > 0 {
+		matrix := make(map[string]any, len(m.matrix))
+		for a, s := range m.matrix {
+			matrix[a.String()] = map[string]any{"level": s.Level.String(), "native": s.Native}
+		}
+		config["level_matrix"] = matrix
+	}
+	if m.refreshEvery > 0 {
+		config["dictionary_refresh"] = map[string]any{
+			"frames": m.refreshEvery,
+			"size":   m.refreshSize,
+		}
+	}
+	if m.memory != nil {
+		config["memory_plan"] = map[string]any{
+			"zstd_window": m.memory.zstdWindow,
+			"s2_block":    m.memory.s2Block,
+			"gzip_blocks": m.memory.gzipBlocks,
+		}
+	}
+	if m.pgzipBlock > 0 {
+		config["parallel_gzip"] = m.gzipWorkers()
+	}
+	if m.spill {
+		config["spill_dir"] = m.spillDir
+	}
+
+	pools := map[string]any{
+		"idle_encoders": m.stats.idleEncoders.Load(),
+		"hits":          m.stats.poolHits.Load(),
+		"misses":        m.stats.poolMisses.Load(),
+	}
+	if m.arenas != nil {
+		pools["arena_slab_size"] = m.arenas.size
+	}
+	if m.readCache != nil {
+		st := m.readCache.Stats()
+		pools["read_cache"] = map[string]any{
+			"max_bytes": m.readCache.maxBytes,
+			"bytes":     st.Bytes,
+			"entries":   st.Entries,
+			"hits":      st.Hits,
+			"misses":    st.Misses,
+		}
+	}
+
+	s := m.Stats()
+	dump := map[string]any{
+		"config": config,
+		"pools":  pools,
+		"stats": map[string]any{
+			"writers":            s.Writers,
+			"readers":            s.Readers,
+			"active_writers":     s.ActiveWriters,
+			"bytes_written":      s.BytesWritten,
+			"bytes_compressed":   s.BytesCompressed,
+			"bytes_read":         s.BytesRead,
+			"bytes_decompressed": s.BytesDecompressed,
+			"corruptions":        s.Corruptions,
+			"ratio":              s.Ratio(),
+		},
+	}
+	if d := m.encoderDictionary(); len(d) > 0 {
+		dict := map[string]any{
+			"size":    len(d),
+			"adler32": adler32.Checksum(d),
+		}
+		if id := zstdDictionaryID(d); id != 0 {
+			dict["zstd_id"] = id
+		}
+		dump["dictionary"] = dict
+	}
+	if len(m.zlibDict) > 0 {
+		dump["zlib_dictionary"] = map[string]any{
+			"size":    len(m.zlibDict),
+			"adler32": adler32.Checksum(m.zlibDict),
+		}
+	}
+	if m.layered != nil {
+		dump["dictionary_delta"] = map[string]any{
+			"size":    len(m.dictDelta),
+			"zstd_id": m.layered.id,
+		}
+	}
+	if m.err != nil {
+		dump["error"] = m.err.Error()
+	}
+	return dump
+}
+
+// DebugDumpJSON returns DebugDump encoded as indented JSON
+func (m *Middleware) DebugDumpJSON() ([]byte, error) {
+	return json.MarshalIndent(m.DebugDump(), "", "  ")
+}
@@ -0,0 +1,45 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestDebugDump(t *testing.T) {
+	requireZstd(t)
+	dict := testZstdDictionary(t, 42)
+	m := New(Zstd, WithLevel(Best), WithZstdDictionary(dict), WithFrameSize(1<<16))
+	writeContainer(t, m, bytes.Repeat([]byte("debug "), 100))
+
+	dump := m.DebugDump()
+	config := dump["config"].(map[string]any)
+	if config["algorithm"] != "zstd" || config["level"] != Best.String() || config["frame_size"] != 1<<16 {
+		t.Errorf("config = %v", config)
+	}
+	if id := dump["dictionary"].(map[string]any)["zstd_id"]; id != uint32(42) {
+		t.Errorf("dictionary id = %v", id)
+	}
+	if w := dump["stats"].(map[string]any)["writers"]; w != int64(1) {
+		t.Errorf("writers = %v", w)
+	}
+
+	p, err := m.DebugDumpJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(p, &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if _, ok := decoded["pools"]; !ok {
+		t.Error("JSON dump lacks pools")
+	}
+}
+
+func TestDebugDump_ParallelGzip(t *testing.T) {
+	config := New(Gzip, WithParallelGzip(0, 3)).DebugDump()["config"].(map[string]any)
+	if config["pgzip_blocks"] != 3 || config["parallel_gzip"] != 3 {
+		t.Errorf("Expected the configured blocks and the workers, got %v and %v", config["pgzip_blocks"], config["parallel_gzip"])
+	}
+}
@@ -0,0 +1,56 @@
+package compression
+
+// MaxDeclaredBuffer is the most data a writer of WithDeclaredSizes buffers
+// to declare the size of a frame
+const MaxDeclaredBuffer = 8 << 20
+
+// WithDeclaredSizes makes Zstd writers declare the content size in the
+// header of every zstd frame, as required by tools that preallocate the
+// output. Data is buffered until Flush or Close, which end the current frame,
+// unless the size of the next frame is announced with SizeDeclarer, e.g. by
+// CompressFromFile. A frame growing beyond MaxDeclaredBuffer, or the memory
+// budget of a stream if smaller, is streamed without a declared size
+// instead. Frames of framed streams always declare their size with the
+// option. Other algorithms ignore it.
+func WithDeclaredSizes() Option {
+	return func(m *Middleware) {
+		m.declaredSizes = true
+	}
+}
+
+// SizeDeclarer is implemented by writers returned from Middleware.Writer
+type SizeDeclarer interface {
+	// DeclareSize ends the current frame and announces that the next size
+	// bytes form one frame, which is then compressed without buffering.
+	// Close fails if fewer bytes are written. Writers without
+	// WithDeclaredSizes ignore it.
+	DeclareSize(size int64) error
+}
+
+// sizeDeclarer is implemented by encoders of WithDeclaredSizes
+type sizeDeclarer interface {
+	declareSize(size int64) error
+}
+
+func (w *writer) DeclareSize(size int64) error {
+	w.lock()
+	defer w.unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if err := w.drainBytes(); err != nil {
+		return err
+	}
+	if d, ok := w.enc.(sizeDeclarer); ok {
+		return d.declareSize(size)
+	}
+	return nil
+}
+
+// declaredBuffer returns the most data writers of WithDeclaredSizes buffer
+func (m *Middleware) declaredBuffer() int {
+	if budget := m.streamBudget(); budget > 0 && budget < MaxDeclaredBuffer {
+		return int(budget)
+	}
+	return MaxDeclaredBuffer
+}
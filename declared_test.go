@@ -0,0 +1,117 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWithDeclaredSizes(t *testing.T) {
+	requireZstd(t)
+	m := New(Zstd, WithDeclaredSizes())
+	first := bytes.Repeat([]byte("a"), 1000)
+	second := bytes.Repeat([]byte("b"), 500)
+
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	w.Write(first)
+	if buf.Len() != 0 {
+		t.Fatalf("Expected the frame to be buffered, got %d bytes", buf.Len())
+	}
+	if err := w.(interface{ Flush() error }).Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	end := buf.Len()
+	w.Write(second)
+	if err := w.(io.Closer).Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	if size := zstdContentSize(bufio.NewReader(bytes.NewReader(buf.Bytes()))); size != 1000 {
+		t.Fatalf("Expected the first frame to declare 1000 bytes, got %d", size)
+	}
+	if size := zstdContentSize(bufio.NewReader(bytes.NewReader(buf.Bytes()[end:]))); size != 500 {
+		t.Fatalf("Expected the second frame to declare 500 bytes, got %d", size)
+	}
+	got, err := io.ReadAll(m.Reader(&buf))
+	if err != nil || !bytes.Equal(got, append(first, second...)) {
+		t.Fatalf("Failed to read: %v", err)
+	}
+}
+
+func TestWithDeclaredSizes_DeclareSize(t *testing.T) {
+	requireZstd(t)
+	m := New(Zstd, WithDeclaredSizes())
+	data := []byte(strings.Repeat("declared up front ", 20000))
+
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	if err := w.(SizeDeclarer).DeclareSize(int64(len(data))); err != nil {
+		t.Fatalf("Failed to declare the size: %v", err)
+	}
+	for p := data; len(p) > 0; p = p[min(len(p), 4096):] {
+		w.Write(p[:min(len(p), 4096)])
+	}
+	if buf.Len() == 0 {
+		t.Fatal("Expected the declared frame to be streamed")
+	}
+	if err := w.(io.Closer).Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if size := zstdContentSize(bufio.NewReader(bytes.NewReader(buf.Bytes()))); size != int64(len(data)) {
+		t.Fatalf("Expected the frame to declare %d bytes, got %d", len(data), size)
+	}
+	if got, err := io.ReadAll(m.Reader(&buf)); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to read: %v", err)
+	}
+
+	w = m.Writer(io.Discard)
+	w.(SizeDeclarer).DeclareSize(100)
+	w.Write(make([]byte, 10))
+	if err := w.(io.Closer).Close(); err == nil {
+		t.Fatal("Expected Close to fail for a short declared frame")
+	}
+}
+
+func TestWithDeclaredSizes_File(t *testing.T) {
+	requireZstd(t)
+	m := New(Zstd, WithDeclaredSizes())
+	data := []byte(strings.Repeat("file ", 10000))
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := m.CompressFromFile(path, &buf); err != nil {
+		t.Fatalf("Failed to compress: %v", err)
+	}
+	if size := zstdContentSize(bufio.NewReader(bytes.NewReader(buf.Bytes()))); size != int64(len(data)) {
+		t.Fatalf("Expected the frame to declare %d bytes, got %d", len(data), size)
+	}
+}
+
+func TestWithDeclaredSizes_Framed(t *testing.T) {
+	requireZstd(t)
+	m := New(Zstd, WithDeclaredSizes(), WithFrameSize(4096))
+	data := []byte(strings.Repeat("framed ", 3000))
+	compressed := writeContainer(t, m, data)
+
+	cr := containerReader{r: bufio.NewReader(bytes.NewReader(compressed))}
+	if err := cr.readHeader(); err != nil {
+		t.Fatalf("Failed to read the header: %v", err)
+	}
+	rec, err := cr.next()
+	if err != nil || rec.typ != frameData {
+		t.Fatalf("Expected a data frame, got %v, %v", rec.typ, err)
+	}
+	if size := zstdContentSize(bufio.NewReader(bytes.NewReader(rec.payload))); size != int64(rec.size) {
+		t.Fatalf("Expected the frame to declare %d bytes, got %d", rec.size, size)
+	}
+	if got, err := io.ReadAll(m.Reader(bytes.NewReader(compressed))); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to read: %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package compression
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+)
+
+// WithFrameDedup makes framed writers store a frame identical to one of the
+// last frames distinct frames of the stream as a reference to it, so
+// repeated content such as unchanged parts of periodic snapshots is stored
+// once. Frames are compared by their SHA-256. Readers expand references
+// without the option but keep up to frames compressed frames in memory, see
+// WithMaxDedupWindow. The
+// option implies framing with DefaultFrameSize unless WithFrameSize is set;
+// streams using it cannot be read by versions of this package before it.
+func WithFrameDedup(frames int) Option {
+	return func(m *Middleware) {
+		m.dedupFrames = frames
+		if m.frameSize == 0 {
+			m.frameSize = DefaultFrameSize
+		}
+	}
+}
+
+// defaultDedupLimit is the largest window readers accept by default
+const defaultDedupLimit = 1 << 10
+
+// WithMaxDedupWindow makes readers fail with ErrCorruptStream for streams
+// declaring a deduplication window of more than frames frames, as readers
+// keep the compressed frames of the window in memory. Without it, readers
+// accept the window set with WithFrameDedup or 1024 frames, whichever is
+// larger. With WithMemoryBudget, readers also fail with ErrMemoryBudget once
+// the kept frames of a stream exceed its budget.
+func WithMaxDedupWindow(frames int) Option {
+	return func(m *Middleware) {
+		m.dedupLimit = frames
+	}
+}
+
+// maxDedupWindow returns the largest window readers accept
+func (m *Middleware) maxDedupWindow() uint64 {
+	if m.dedupLimit > 0 {
+		return uint64(m.dedupLimit)
+	}
+	return uint64(max(m.dedupFrames, defaultDedupLimit))
+}
+
+// dedupable reports whether frames of type typ can be referenced
+func dedupable(typ byte) bool {
+	return typ == frameData || typ == frameCoded || typ == frameStored
+}
+
+// dedupWriter remembers the hashes of the last size distinct frames written
+type dedupWriter struct {
+	size      int
+	announced bool
+	// next is the sequence number of the next distinct frame, seqs maps
+	// the hashes of the frames in the window to theirs
+	next   uint64
+	seqs   map[[sha256.Size]byte]uint64
+	hashes map[uint64][sha256.Size]byte
+}
+
+func newDedupWriter(size int) *dedupWriter {
+	return &dedupWriter{size: size, seqs: make(map[[sha256.Size]byte]uint64), hashes: make(map[uint64][sha256.Size]byte)}
+}
+
+// find returns the sequence number of the frame with hash h
+func (d *dedupWriter) find(h [sha256.Size]byte) (uint64, bool) {
+	seq, ok := d.seqs[h]
+	return seq, ok
+}
+
+// add records the frame with hash h, evicting the oldest frame of the
+// window
+func (d *dedupWriter) add(h [sha256.Size]byte) {
+	if d.next >= uint64(d.size) {
+		old := d.next - uint64(d.size)
+		if evicted, ok := d.hashes[old]; ok {
+			delete(d.hashes, old)
+			if d.seqs[evicted] == old {
+				delete(d.seqs, evicted)
+			}
+		}
+	}
+	d.seqs[h] = d.next
+	d.hashes[d.next] = h
+	d.next++
+}
+
+func (d *dedupWriter) reset() {
+	d.announced = false
+	d.next = 0
+	clear(d.seqs)
+	clear(d.hashes)
+}
+
+// reference writes f.buf as a reference if an identical frame is in the
+// window. Otherwise it returns the hash to record once the frame is written.
+func (f *framedWriter) reference() (bool, [sha256.Size]byte, error) {
+	d := f.dedup
+	if !d.announced {
+		d.announced = true
+		rec := frameRecord{typ: frameDedup, payload: binary.AppendUvarint(nil, uint64(d.size))}
+		if err := f.writeRecord(rec); err != nil {
+			return false, [sha256.Size]byte{}, err
+		}
+	}
+	h := sha256.Sum256(f.buf)
+	seq, ok := d.find(h)
+	if !ok {
+		return false, h, nil
+	}
+	return true, h, f.commit(frameRecord{typ: frameRef, size: len(f.buf), payload: binary.AppendUvarint(nil, seq)})
+}
+
+// dedupReader holds the compressed frames of the last size distinct frames
+// read, decoding them again when referenced
+type dedupReader struct {
+	size   uint64
+	next   uint64
+	frames map[uint64]dedupFrame
+	// kept is the size of the kept payloads, at most budget if positive
+	kept, budget int64
+}
+
+// dedupFrame is a kept frame with the dictionary it was compressed with
+type dedupFrame struct {
+	rec    frameRecord
+	dict   []byte
+	dictID uint32
+}
+
+// maxDedupWindow bounds the window declared by a stream
+const maxDedupWindow = 1 << 20
+
+// setDedup starts the window declared by rec. Every declaration starts a
+// new window, so concatenated containers number their frames on their own.
+func (f *framedReader) setDedup(rec frameRecord) error {
+	size, err := dedupWindow(rec.payload)
+	if err != nil {
+		return err
+	}
+	if limit := f.m.maxDedupWindow(); size > limit {
+		return fmt.Errorf("%w: deduplication window of %d frames, at most %d accepted", ErrCorruptStream, size, limit)
+	}
+	f.dedup = &dedupReader{size: size, frames: make(map[uint64]dedupFrame), budget: f.m.streamBudget()}
+	return nil
+}
+
+// dedupWindow parses the payload of a deduplication frame
+func dedupWindow(payload []byte) (uint64, error) {
+	size, n := binary.Uvarint(payload)
+	if n != len(payload) || size == 0 || size > maxDedupWindow {
+		return 0, fmt.Errorf("%w: bad deduplication window", ErrCorruptStream)
+	}
+	return size, nil
+}
+
+// evict drops the frame leaving the window when the next one is added
+func (d *dedupReader) evict() {
+	if old, ok := d.frames[d.next-d.size]; ok {
+		d.kept -= int64(len(old.rec.payload))
+		delete(d.frames, d.next-d.size)
+	}
+}
+
+// add records the compressed frame rec of a distinct frame with the
+// dictionary of the following zstd frames
+func (d *dedupReader) add(rec frameRecord, dictID uint32, dict []byte) error {
+	if d == nil {
+		return nil
+	}
+	d.evict()
+	d.kept += int64(len(rec.payload))
+	if d.budget > 0 && d.kept > d.budget {
+		return fmt.Errorf("%w: deduplication window holds %d bytes, %d available", ErrMemoryBudget, d.kept, d.budget)
+	}
+	rec.payload = bytes.Clone(rec.payload)
+	d.frames[d.next] = dedupFrame{rec: rec, dict: dict, dictID: dictID}
+	d.next++
+	return nil
+}
+
+// skipped records a distinct frame that was skipped, which cannot be
+// referenced
+func (d *dedupReader) skipped() {
+	if d == nil {
+		return
+	}
+	d.evict()
+	d.next++
+}
+
+// expand decodes the frame referenced by rec into dst
+func (f *framedReader) expand(dst []byte, rec frameRecord) error {
+	if f.dedup == nil {
+		return fmt.Errorf("%w: frame reference without deduplication", ErrCorruptStream)
+	}
+	seq, n := binary.Uvarint(rec.payload)
+	ref, ok := f.dedup.frames[seq]
+	if n != len(rec.payload) || !ok || ref.rec.size != len(dst) {
+		return fmt.Errorf("%w: bad frame reference", ErrCorruptStream)
+	}
+	if ref.rec.typ == frameStored {
+		copy(dst, ref.rec.payload)
+		return nil
+	}
+	return f.decompress(dst, ref.rec, ref.dictID, ref.dict)
+}
+
+// extractWindow holds the frames references may repeat for ExtractFrames
+type extractWindow struct {
+	size   uint64
+	next   uint64
+	frames map[uint64]extractedFrame
+}
+
+// extractedFrame is a frame with the dictionary frame it depends on
+type extractedFrame struct {
+	rec  frameRecord
+	dict *frameRecord
+}
+
+func newExtractWindow(payload []byte) (*extractWindow, error) {
+	size, err := dedupWindow(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &extractWindow{size: size, frames: make(map[uint64]extractedFrame)}, nil
+}
+
+func (w *extractWindow) add(rec frameRecord, dict *frameRecord) {
+	delete(w.frames, w.next-w.size)
+	rec.payload = bytes.Clone(rec.payload)
+	w.frames[w.next] = extractedFrame{rec, dict}
+	w.next++
+}
+
+// lookup returns the frame repeated by the reference rec
+func (w *extractWindow) lookup(rec frameRecord) (frameRecord, *frameRecord, error) {
+	if w == nil {
+		return rec, nil, fmt.Errorf("%w: frame reference without deduplication", ErrCorruptStream)
+	}
+	seq, n := binary.Uvarint(rec.payload)
+	f, ok := w.frames[seq]
+	if n != len(rec.payload) || !ok || f.rec.size != rec.size {
+		return rec, nil, fmt.Errorf("%w: bad frame reference", ErrCorruptStream)
+	}
+	return f.rec, f.dict, nil
+}
@@ -0,0 +1,153 @@
+package compression
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"testing"
+)
+
+// snapshots returns frames of incompressible data following pattern, equal
+// letters denoting equal frames
+func snapshots(t *testing.T, pattern string, size int) []byte {
+	t.Helper()
+	frames := make(map[rune][]byte)
+	var data []byte
+	for _, c := range pattern {
+		if frames[c] == nil {
+			frames[c] = make([]byte, size)
+			rand.Read(frames[c])
+		}
+		data = append(data, frames[c]...)
+	}
+	return data
+}
+
+func TestWithFrameDedup(t *testing.T) {
+	requireZstd(t)
+	xorPayload := func(_ uint64, _ byte, p []byte) ([]byte, error) {
+		out := make([]byte, len(p))
+		for i, b := range p {
+			out[i] = b ^ 0x5a
+		}
+		return out, nil
+	}
+	data := snapshots(t, "abcabcabcd", 4096)
+	plain := writeContainer(t, New(Zstd, WithFrameSize(4096)), data)
+	for name, opts := range map[string][]Option{
+		"zstd":     {WithFrameDedup(8)},
+		"checksum": {WithFrameDedup(8), WithChecksum(CRC32C)},
+		"hooks":    {WithFrameDedup(8), WithFramePayloadHooks(xorPayload, xorPayload)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := New(Zstd, append(opts, WithFrameSize(4096))...)
+			compressed := writeContainer(t, m, data)
+			if len(compressed) > len(plain)/2 {
+				t.Fatalf("Expected repeated frames to be referenced, got %d bytes, %d without", len(compressed), len(plain))
+			}
+
+			// Readers expand references without the option
+			reader := New(Zstd, append(opts[1:], WithFrameSize(4096))...)
+			got, err := io.ReadAll(reader.Reader(bytes.NewReader(compressed)))
+			if err != nil {
+				t.Fatalf("Failed to read: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatal("Data mismatch")
+			}
+
+			var out bytes.Buffer
+			if _, err := io.Copy(&out, reader.Reader(bytes.NewReader(compressed))); err != nil || !bytes.Equal(out.Bytes(), data) {
+				t.Fatalf("Failed to copy: %v", err)
+			}
+			dst := make([]byte, len(data))
+			if n, err := reader.Reader(bytes.NewReader(compressed)).(DirectDecoder).DecodeInto(dst); err != nil || !bytes.Equal(dst[:n], data) {
+				t.Fatalf("Failed to decode into a buffer: %d, %v", n, err)
+			}
+		})
+	}
+}
+
+func TestWithFrameDedup_Window(t *testing.T) {
+	data := snapshots(t, "abcabc", 4096)
+	narrow := writeContainer(t, New(S2, WithFrameSize(4096), WithFrameDedup(2)), data)
+	wide := writeContainer(t, New(S2, WithFrameSize(4096), WithFrameDedup(3)), data)
+	if len(narrow) < 5*4096 || len(wide) > 4*4096 {
+		t.Fatalf("Expected frames beyond the window to be stored again, got %d bytes with 2 frames, %d with 3", len(narrow), len(wide))
+	}
+	for _, compressed := range [][]byte{narrow, wide} {
+		got, err := io.ReadAll(New(S2, WithFrameSize(4096)).Reader(bytes.NewReader(compressed)))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("Failed to read: %v", err)
+		}
+	}
+}
+
+func TestWithFrameDedup_PooledWriters(t *testing.T) {
+	requireZstd(t)
+	data := snapshots(t, "aab", 1024)
+	m := New(Zstd, WithFrameSize(1024), WithFrameDedup(4))
+	// The second writer reuses the pooled encoder of the first
+	writeContainer(t, m, data)
+	got, err := io.ReadAll(m.Reader(bytes.NewReader(writeContainer(t, m, data))))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to read the stream of a pooled writer: %v", err)
+	}
+}
+
+func TestWithFrameDedup_ExtractFrames(t *testing.T) {
+	requireZstd(t)
+	data := snapshots(t, "abab", 1000)
+	m := New(Zstd, WithFrameSize(1000), WithFrameDedup(4))
+	compressed := writeContainer(t, m, data)
+
+	var out bytes.Buffer
+	if err := ExtractFrames(bytes.NewReader(compressed), &out, FrameRange(2, 3)); err != nil {
+		t.Fatalf("Failed to extract frames: %v", err)
+	}
+	got, err := io.ReadAll(New(Zstd, WithFrameSize(1000)).Reader(&out))
+	if err != nil || !bytes.Equal(got, data[2000:]) {
+		t.Fatalf("Failed to read the extracted references: %v", err)
+	}
+}
+
+func TestWithFrameDedup_BadReference(t *testing.T) {
+	requireZstd(t)
+	data := snapshots(t, "aa", 1000)
+	compressed := writeContainer(t, New(Zstd, WithFrameSize(1000), WithFrameDedup(4)), data)
+	// The reference to frame 0 of 1000 bytes
+	ref := bytes.Index(compressed, []byte{frameRef, 0xe8, 0x07, 1, 0})
+	if ref < 0 {
+		t.Fatal("Expected a reference")
+	}
+	compressed[ref+4] = 5
+	if _, err := io.ReadAll(New(Zstd, WithFrameSize(1000)).Reader(bytes.NewReader(compressed))); err == nil {
+		t.Fatal("Expected an error for a reference outside the window")
+	}
+}
+
+func TestWithMaxDedupWindow(t *testing.T) {
+	data := snapshots(t, "abcab", 4096)
+	compressed := writeContainer(t, New(S2, WithFrameSize(4096), WithFrameDedup(2000)), data)
+
+	// The window is larger than readers accept by default
+	if _, err := io.ReadAll(New(S2, WithFrameSize(4096)).Reader(bytes.NewReader(compressed))); !errors.Is(err, ErrCorruptStream) {
+		t.Fatalf("Expected ErrCorruptStream for a window beyond the default, got %v", err)
+	}
+	if _, err := io.ReadAll(New(S2, WithFrameSize(4096), WithMaxDedupWindow(100)).Reader(bytes.NewReader(compressed))); !errors.Is(err, ErrCorruptStream) {
+		t.Fatalf("Expected ErrCorruptStream for a window beyond the limit, got %v", err)
+	}
+	got, err := io.ReadAll(New(S2, WithFrameSize(4096), WithMaxDedupWindow(2000)).Reader(bytes.NewReader(compressed)))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to read within the limit: %v", err)
+	}
+
+	// The kept frames count against the memory budget, lowered after New as
+	// it would not fit the codec
+	budget := New(S2, WithFrameSize(4096), WithMaxDedupWindow(2000), WithMemoryBudget(8<<20))
+	budget.memoryBudget = 2 * 4096
+	if _, err := io.ReadAll(budget.Reader(bytes.NewReader(compressed))); !errors.Is(err, ErrMemoryBudget) {
+		t.Fatalf("Expected ErrMemoryBudget, got %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+// Package dict trains zstd dictionaries from samples of the data to be
+// compressed, replacing the zstd CLI's --train.
+//
+// The content of a dictionary is selected from the samples with a
+// simplified fastCover: the samples are split into one epoch per segment,
+// and from each epoch the segment holding the d-byte substrings found in the
+// most samples is chosen. The entropy tables are built with zstd.BuildDict.
+// Trained dictionaries can be passed to compression.WithZstdDictionary:
+//
+//	d, err := dict.Train(samples, 16<<10)
+//	if err != nil {
+//		return err
+//	}
+//	m := compression.New(compression.Zstd, compression.WithZstdDictionary(d))
+package dict
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// dmerSize is the length of the substrings counted in the samples
+	dmerSize = 8
+	// segmentSize is the length of the segments selected into the content
+	segmentSize = 1 << 10
+	// minContentSize is the smallest content zstd accepts
+	minContentSize = 8
+	// privateIDs and publicIDs bound the dictionary IDs zstd leaves for
+	// private use
+	privateIDs = 1 << 15
+	publicIDs  = 1 << 31
+)
+
+// ErrNoSamples is returned for training without samples or with samples too
+// small to select content from
+var ErrNoSamples = errors.New("dict: not enough samples")
+
+// Dictionary is a dictionary in zstd format
+type Dictionary []byte
+
+// ID returns the ID of the dictionary, or 0 if it is not in zstd format
+func (d Dictionary) ID() uint32 {
+	info, err := zstd.InspectDictionary(d)
+	if err != nil {
+		return 0
+	}
+	return info.ID()
+}
+
+// Train builds a dictionary with at most targetSize bytes of content from
+// samples, plus the entropy tables of a few hundred bytes. The ID is derived
+// from the content, so the same samples yield the same dictionary.
+func Train(samples [][]byte, targetSize int) (Dictionary, error) {
+	return TrainWithID(samples, targetSize, 0)
+}
+
+// TrainWithID builds a dictionary like Train with the given ID, which must
+// be at least 32768 and below 2^31 as lower and higher IDs are reserved by
+// zstd. An ID of 0 is derived from the content.
+func TrainWithID(samples [][]byte, targetSize int, id uint32) (Dictionary, error) {
+	if id != 0 && (id < privateIDs || id >= publicIDs) {
+		return nil, fmt.Errorf("dict: ID %d outside the private range [%d, %d)", id, privateIDs, publicIDs)
+	}
+	if targetSize < minContentSize {
+		return nil, fmt.Errorf("dict: target size %d below %d", targetSize, minContentSize)
+	}
+	var contents [][]byte
+	total := 0
+	for _, s := range samples {
+		if len(s) > 0 {
+			contents = append(contents, s)
+			total += len(s)
+		}
+	}
+	if total < minContentSize {
+		return nil, ErrNoSamples
+	}
+	content := selectContent(contents, total, targetSize)
+	if id == 0 {
+		id = privateIDs + crc32.ChecksumIEEE(content)%(publicIDs-privateIDs)
+	}
+	return build(zstd.BuildDictOptions{
+		ID:       id,
+		Contents: contents,
+		History:  content,
+		Offsets:  [3]int{1, 4, 8},
+	})
+}
+
+// build runs zstd.BuildDict, which panics with a division by zero when the
+// samples compress without literals against the content, e.g. when they all
+// fit into it. Other panics are not ours to handle.
+func build(o zstd.BuildDictOptions) (d Dictionary, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if re, ok := r.(runtime.Error); !ok || !strings.Contains(re.Error(), "divide by zero") {
+				panic(r)
+			}
+			d, err = nil, fmt.Errorf("%w: no literals left to build entropy tables from", ErrNoSamples)
+		}
+	}()
+	d, err = zstd.BuildDict(o)
+	if err != nil {
+		return nil, fmt.Errorf("dict: building dictionary: %w", err)
+	}
+	return d, nil
+}
+
+// segment is a part of the samples selected into the content
+type segment struct {
+	data  []byte
+	score int
+}
+
+// selectContent returns at most targetSize bytes of the samples, the most
+// useful segments last where zstd reaches them with the shortest offsets
+func selectContent(samples [][]byte, total, targetSize int) []byte {
+	all := bytes.Join(samples, nil)
+	if total <= targetSize {
+		return all
+	}
+	freq := dmerFrequencies(samples)
+	k := min(segmentSize, targetSize)
+	epochs := max(1, targetSize/k)
+	epochSize := total / epochs
+	var segments []segment
+	for i := 0; i < epochs; i++ {
+		epoch := all[i*epochSize : min(total, (i+1)*epochSize)]
+		if s, ok := bestSegment(epoch, freq, k); ok {
+			segments = append(segments, s)
+			// Later segments gain nothing from the substrings of this one
+			for j := 0; j+dmerSize <= len(s.data); j++ {
+				delete(freq, dmer(s.data[j:]))
+			}
+		}
+	}
+	sort.SliceStable(segments, func(i, j int) bool { return segments[i].score < segments[j].score })
+	var content []byte
+	for _, s := range segments {
+		content = append(content, s.data...)
+	}
+	if len(content) < minContentSize {
+		return all[len(all)-targetSize:]
+	}
+	return content
+}
+
+// dmerFrequencies counts the samples containing each d-byte substring
+func dmerFrequencies(samples [][]byte) map[uint64]int {
+	freq := make(map[uint64]int)
+	for _, s := range samples {
+		seen := make(map[uint64]bool)
+		for i := 0; i+dmerSize <= len(s); i++ {
+			h := dmer(s[i:])
+			if !seen[h] {
+				seen[h] = true
+				freq[h]++
+			}
+		}
+	}
+	return freq
+}
+
+// bestSegment returns the k-byte segment of data whose distinct substrings
+// are found in the most samples
+func bestSegment(data []byte, freq map[uint64]int, k int) (segment, bool) {
+	if len(data) < dmerSize {
+		return segment{}, false
+	}
+	k = min(k, len(data))
+	window := k - dmerSize + 1
+	active := make(map[uint64]int)
+	score, best, bestStart := 0, -1, 0
+	for i := 0; i+dmerSize <= len(data); i++ {
+		h := dmer(data[i:])
+		if active[h] == 0 {
+			score += freq[h]
+		}
+		active[h]++
+		if i >= window {
+			old := dmer(data[i-window:])
+			if active[old]--; active[old] == 0 {
+				score -= freq[old]
+				delete(active, old)
+			}
+		}
+		if i >= window-1 && score > best {
+			best, bestStart = score, i-window+1
+		}
+	}
+	if best <= 0 {
+		return segment{}, false
+	}
+	return segment{data: data[bestStart : bestStart+k], score: best}, true
+}
+
+// dmer returns the first dmerSize bytes of p as an integer
+func dmer(p []byte) uint64 {
+	var h uint64
+	for _, b := range p[:dmerSize] {
+		h = h<<8 | uint64(b)
+	}
+	return h
+}
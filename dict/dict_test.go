@@ -0,0 +1,98 @@
+//go:build !nozstd
+
+package dict
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+
+	"schneider.vip/hybridbuffer/middleware/compression"
+)
+
+// logLines returns n JSON log lines
+func logLines(rng *rand.Rand, n int) [][]byte {
+	services := []string{"auth", "billing", "search", "gateway"}
+	var lines [][]byte
+	for i := 0; i < n; i++ {
+		lines = append(lines, []byte(fmt.Sprintf(`{"level":"info","service":"%s","request_id":"%08x","message":"request handled","duration_ms":%d}`,
+			services[rng.Intn(len(services))], rng.Uint32(), rng.Intn(500))))
+	}
+	return lines
+}
+
+// compressedSize returns the total size of the lines compressed one by one
+func compressedSize(t *testing.T, m *compression.Middleware, lines [][]byte) int {
+	t.Helper()
+	total := 0
+	for _, line := range lines {
+		var buf bytes.Buffer
+		w := m.Writer(&buf)
+		w.Write(line)
+		if err := w.(io.Closer).Close(); err != nil {
+			t.Fatalf("Failed to close: %v", err)
+		}
+		total += buf.Len()
+		if got, err := io.ReadAll(m.Reader(&buf)); err != nil || !bytes.Equal(got, line) {
+			t.Fatalf("Failed to read: %v", err)
+		}
+	}
+	return total
+}
+
+func TestTrain(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	d, err := Train(logLines(rng, 300), 4<<10)
+	if err != nil {
+		t.Fatalf("Failed to train: %v", err)
+	}
+	if id := d.ID(); id < privateIDs || id >= publicIDs {
+		t.Fatalf("Expected an ID in the private range, got %d", id)
+	}
+	if len(d) > 8<<10 {
+		t.Fatalf("Expected about 4KB, got %d bytes", len(d))
+	}
+
+	lines := logLines(rng, 100)
+	without := compressedSize(t, compression.New(compression.Zstd), lines)
+	with := compressedSize(t, compression.New(compression.Zstd, compression.WithZstdDictionary(d)), lines)
+	if with*2 > without {
+		t.Fatalf("Expected the dictionary to at least halve the size, got %d bytes with and %d without", with, without)
+	}
+
+	// Training is deterministic
+	again, err := Train(logLines(rand.New(rand.NewSource(1)), 300), 4<<10)
+	if err != nil || !bytes.Equal(again, d) {
+		t.Fatalf("Expected the same dictionary from the same samples: %v", err)
+	}
+}
+
+func TestTrainWithID(t *testing.T) {
+	samples := logLines(rand.New(rand.NewSource(2)), 50)
+	d, err := TrainWithID(samples, 1<<10, 40000)
+	if err != nil || d.ID() != 40000 {
+		t.Fatalf("Expected dictionary 40000, got %d: %v", d.ID(), err)
+	}
+	// Samples fitting into the content leave nothing to build tables from
+	if _, err := Train(samples[:2], 64<<10); !errors.Is(err, ErrNoSamples) {
+		t.Fatalf("Expected ErrNoSamples for samples smaller than the target, got %v", err)
+	}
+
+	for _, id := range []uint32{1, 1 << 31} {
+		if _, err := TrainWithID(samples, 1<<10, id); err == nil {
+			t.Fatalf("Expected an error for ID %d", id)
+		}
+	}
+	if _, err := Train([][]byte{nil, []byte("abc")}, 1<<10); !errors.Is(err, ErrNoSamples) {
+		t.Fatalf("Expected ErrNoSamples, got %v", err)
+	}
+	if _, err := Train(samples, 4); err == nil {
+		t.Fatal("Expected an error for a tiny target size")
+	}
+	if Dictionary("raw content").ID() != 0 {
+		t.Fatal("Expected no ID for raw content")
+	}
+}
@@ -0,0 +1,328 @@
+//go:build ignore
+
+// gen builds the dictionaries embedded by WithBuiltinDictionary. Each
+// dictionary is trained on the files in corpus/<format>/ if that directory
+// exists, e.g. exported API responses, CSV exports, access logs and crawled
+// pages, otherwise on synthetic samples modeled on them. Run it with go
+// generate after changing the corpus or the samples; the output is
+// deterministic.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Dictionary IDs. zstd reserves IDs below 32768 for a registrar and IDs of
+// 2^31 and above, so the IDs lie between these ranges.
+const idBase = 0x48420000 // "HB"
+
+// samplesPerFormat is the number of synthetic samples of each format
+const samplesPerFormat = 5000
+
+// historySize is the size of the dictionary content
+const historySize = 64 << 10
+
+// recordsPerSample is the number of lines of the log and CSV samples,
+// matching small payloads of a few KiB
+const recordsPerSample = 20
+
+var (
+	corpus = flag.String("corpus", "corpus", "directory with one subdirectory of sample files per format")
+
+	firstNames = []string{"alice", "bob", "carol", "dave", "erin", "frank", "grace", "heidi", "ivan", "judy", "mallory", "niaj", "olivia", "peggy", "rupert", "sybil", "trent", "victor", "walter", "yara"}
+	lastNames  = []string{"smith", "johnson", "garcia", "miller", "davis", "martinez", "lopez", "wilson", "anderson", "thomas", "moore", "jackson", "martin", "lee", "thompson", "white"}
+	domains    = []string{"example.com", "example.org", "mail.example.net", "corp.example.com"}
+	cities     = []string{"Berlin", "Hamburg", "Munich", "Vienna", "Zurich", "Amsterdam", "Paris", "London", "New York", "San Francisco", "Toronto", "Sydney"}
+	countries  = []string{"DE", "AT", "CH", "NL", "FR", "GB", "US", "CA", "AU"}
+	currencies = []string{"EUR", "EUR", "USD", "GBP", "CHF"}
+	orderState = []string{"pending", "paid", "shipped", "delivered", "cancelled", "refunded"}
+	products   = []string{"Wireless Mouse", "USB-C Cable 2m", "Mechanical Keyboard", "27\" Monitor", "Laptop Stand", "Noise Cancelling Headphones", "Webcam 1080p", "Desk Lamp"}
+	levels     = []string{"debug", "info", "info", "info", "info", "warn", "error"}
+	methods    = []string{"GET", "GET", "GET", "GET", "POST", "POST", "PUT", "PATCH", "DELETE", "HEAD"}
+	paths      = []string{"/", "/index.html", "/api/v1/users", "/api/v1/users/%d", "/api/v1/orders", "/api/v1/orders/%d/items", "/api/v2/search?q=%s&page=%d", "/static/js/app.%x.js", "/static/css/main.%x.css", "/login", "/logout", "/healthz", "/metrics", "/favicon.ico", "/robots.txt"}
+	statuses   = []int{200, 200, 200, 200, 200, 201, 204, 301, 302, 304, 304, 400, 401, 403, 404, 404, 429, 500, 502, 503}
+	messages   = []string{"request handled", "request failed", "cache miss", "cache hit", "connection reset by peer", "retrying request", "user logged in", "session expired", "order created", "payment authorized", "slow query", "upstream timeout"}
+	services   = []string{"api", "auth", "billing", "search", "worker", "gateway"}
+	agents     = []string{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+		"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+		"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+		"curl/8.4.0",
+		"Go-http-client/1.1",
+		"python-requests/2.31.0",
+		"Googlebot/2.1 (+http://www.google.com/bot.html)",
+	}
+)
+
+// gen generates synthetic samples
+type gen struct {
+	rng *rand.Rand
+}
+
+func (g gen) pick(s []string) string { return s[g.rng.Intn(len(s))] }
+
+func (g gen) name() string { return g.pick(firstNames) + " " + g.pick(lastNames) }
+
+func (g gen) email() string {
+	return g.pick(firstNames) + "." + g.pick(lastNames) + "@" + g.pick(domains)
+}
+
+func (g gen) ip() string {
+	if g.rng.Intn(4) == 0 {
+		return fmt.Sprintf("2001:db8:%x::%x", g.rng.Intn(1<<16), g.rng.Intn(1<<16))
+	}
+	return fmt.Sprintf("%d.%d.%d.%d", []int{10, 172, 192, 203}[g.rng.Intn(4)], g.rng.Intn(256), g.rng.Intn(256), 1+g.rng.Intn(254))
+}
+
+func (g gen) timestamp() string {
+	return fmt.Sprintf("2024-%02d-%02dT%02d:%02d:%02d.%03dZ", 1+g.rng.Intn(12), 1+g.rng.Intn(28), g.rng.Intn(24), g.rng.Intn(60), g.rng.Intn(60), g.rng.Intn(1000))
+}
+
+func (g gen) path() string {
+	p := g.pick(paths)
+	switch strings.Count(p, "%") {
+	case 1:
+		if strings.Contains(p, "%x") {
+			return fmt.Sprintf(p, g.rng.Uint32())
+		}
+		return fmt.Sprintf(p, g.rng.Intn(100000))
+	case 2:
+		return fmt.Sprintf(p, g.pick(products[:4]), 1+g.rng.Intn(10))
+	}
+	return p
+}
+
+func (g gen) json() string {
+	switch g.rng.Intn(3) {
+	case 0:
+		return fmt.Sprintf(`{"id":%d,"name":%q,"email":%q,"active":%t,"role":%q,"created_at":%q,"updated_at":%q,"address":{"street":"%d Main St","city":%q,"postal_code":"%05d","country":%q},"tags":[%q,%q],"preferences":{"newsletter":%t,"language":"en","timezone":"Europe/Berlin"}}`,
+			g.rng.Intn(1000000), g.name(), g.email(), g.rng.Intn(5) > 0, []string{"admin", "user", "user", "viewer"}[g.rng.Intn(4)],
+			g.timestamp(), g.timestamp(), 1+g.rng.Intn(300), g.pick(cities), g.rng.Intn(100000), g.pick(countries),
+			g.pick(services), g.pick(levels), g.rng.Intn(2) == 0)
+	case 1:
+		var items []string
+		for i := 0; i < 1+g.rng.Intn(4); i++ {
+			items = append(items, fmt.Sprintf(`{"sku":"SKU-%06d","name":%q,"quantity":%d,"unit_price":%d.%02d}`,
+				g.rng.Intn(1000000), g.pick(products), 1+g.rng.Intn(5), g.rng.Intn(500), g.rng.Intn(100)))
+		}
+		return fmt.Sprintf(`{"order_id":"ord_%016x","customer":{"id":%d,"name":%q,"email":%q},"status":%q,"currency":%q,"total":%d.%02d,"items":[%s],"shipping":{"method":"standard","city":%q,"country":%q},"created_at":%q}`,
+			g.rng.Uint64(), g.rng.Intn(1000000), g.name(), g.email(), g.pick(orderState), g.pick(currencies),
+			g.rng.Intn(2000), g.rng.Intn(100), strings.Join(items, ","), g.pick(cities), g.pick(countries), g.timestamp())
+	default:
+		return fmt.Sprintf(`{"data":[{"type":"event","id":"%08x-%04x-%04x","attributes":{"name":%q,"service":%q,"level":%q,"timestamp":%q,"duration_ms":%d}}],"meta":{"page":%d,"per_page":50,"total":%d},"links":{"self":"https://api.example.com%s","next":"https://api.example.com%s"}}`,
+			g.rng.Uint32(), g.rng.Intn(1<<16), g.rng.Intn(1<<16), g.pick(messages), g.pick(services), g.pick(levels),
+			g.timestamp(), g.rng.Intn(5000), 1+g.rng.Intn(20), g.rng.Intn(10000), g.path(), g.path())
+	}
+}
+
+func (g gen) csv() string {
+	var b strings.Builder
+	switch g.rng.Intn(3) {
+	case 0:
+		b.WriteString("id,name,email,created_at,amount,currency,status\n")
+		for i := 0; i < recordsPerSample; i++ {
+			fmt.Fprintf(&b, "%d,%s,%s,%s,%d.%02d,%s,%s\n", g.rng.Intn(1000000), g.name(), g.email(),
+				g.timestamp()[:19], g.rng.Intn(1000), g.rng.Intn(100), g.pick(currencies), g.pick(orderState))
+		}
+	case 1:
+		b.WriteString("\"customer_id\",\"first_name\",\"last_name\",\"email\",\"city\",\"country\",\"signup_date\",\"active\"\n")
+		for i := 0; i < recordsPerSample; i++ {
+			fmt.Fprintf(&b, "%d,%q,%q,%q,%q,%q,\"2024-%02d-%02d\",%t\n", g.rng.Intn(1000000), g.pick(firstNames), g.pick(lastNames),
+				g.email(), g.pick(cities), g.pick(countries), 1+g.rng.Intn(12), 1+g.rng.Intn(28), g.rng.Intn(4) > 0)
+		}
+	default:
+		b.WriteString("timestamp,host,service,cpu_percent,memory_mb,requests,errors,p99_ms\n")
+		for i := 0; i < recordsPerSample; i++ {
+			fmt.Fprintf(&b, "%s,web-%02d,%s,%d.%d,%d,%d,%d,%d\n", g.timestamp(), g.rng.Intn(32), g.pick(services),
+				g.rng.Intn(100), g.rng.Intn(10), 128+g.rng.Intn(8192), g.rng.Intn(5000), g.rng.Intn(20), g.rng.Intn(2000))
+		}
+	}
+	return b.String()
+}
+
+func (g gen) log() string {
+	var b strings.Builder
+	format := g.rng.Intn(4)
+	for i := 0; i < recordsPerSample; i++ {
+		ts := g.timestamp()
+		switch format {
+		case 0:
+			fmt.Fprintf(&b, "%s - - [%02d/%s/2024:%s +0000] \"%s %s HTTP/1.1\" %d %d \"%s\" \"%s\"\n",
+				g.ip(), 1+g.rng.Intn(28), g.pick([]string{"Jan", "Feb", "Mar", "Apr", "May", "Jun", "Jul", "Aug", "Sep", "Oct", "Nov", "Dec"}), ts[11:19],
+				g.pick(methods), g.path(), g.statuses(), g.rng.Intn(100000), g.pick([]string{"-", "-", "https://www.example.com/", "https://www.google.com/"}), g.pick(agents))
+		case 1:
+			fmt.Fprintf(&b, "time=%s level=%s service=%s msg=%q method=%s path=%s status=%d duration=%dms remote_addr=%s request_id=%016x\n",
+				ts, g.pick(levels), g.pick(services), g.pick(messages), g.pick(methods), g.path(), g.statuses(), g.rng.Intn(2000), g.ip(), g.rng.Uint64())
+		case 2:
+			fmt.Fprintf(&b, `{"time":%q,"level":%q,"service":%q,"msg":%q,"method":%q,"path":%q,"status":%d,"duration_ms":%d,"remote_addr":%q,"user_agent":%q,"trace_id":"%016x%016x"}`+"\n",
+				ts, strings.ToUpper(g.pick(levels)), g.pick(services), g.pick(messages), g.pick(methods), g.path(), g.statuses(), g.rng.Intn(2000), g.ip(), g.pick(agents), g.rng.Uint64(), g.rng.Uint64())
+		default:
+			fmt.Fprintf(&b, "%s web-%02d %s[%d]: %s: %s (user=%s, elapsed=%d.%03ds)\n",
+				ts, g.rng.Intn(32), g.pick(services), 1000+g.rng.Intn(60000), strings.ToUpper(g.pick(levels)), g.pick(messages), g.pick(firstNames), g.rng.Intn(10), g.rng.Intn(1000))
+		}
+	}
+	return b.String()
+}
+
+func (g gen) statuses() int { return statuses[g.rng.Intn(len(statuses))] }
+
+func (g gen) html() string {
+	var b strings.Builder
+	title := g.pick(products)
+	fmt.Fprintf(&b, `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="description" content="Buy %s online">
+<title>%s | Example Shop</title>
+<link rel="stylesheet" href="/static/css/main.%x.css">
+<link rel="icon" href="/favicon.ico">
+</head>
+<body class="page-%s">
+<header class="site-header">
+<nav class="navbar navbar-expand-lg">
+<a class="navbar-brand" href="/">Example Shop</a>
+<ul class="navbar-nav">
+<li class="nav-item"><a class="nav-link" href="/">Home</a></li>
+<li class="nav-item"><a class="nav-link" href="/products">Products</a></li>
+<li class="nav-item"><a class="nav-link" href="/account">Account</a></li>
+</ul>
+</nav>
+</header>
+<main class="container">
+<h1>%s</h1>
+`, title, title, g.rng.Uint32(), g.pick([]string{"home", "product", "list", "account"}), title)
+	switch g.rng.Intn(3) {
+	case 0:
+		b.WriteString("<div class=\"row\">\n")
+		for i := 0; i < 2+g.rng.Intn(6); i++ {
+			p := g.pick(products)
+			fmt.Fprintf(&b, "<div class=\"col-md-4\"><div class=\"card\"><img class=\"card-img-top\" src=\"/img/%x.jpg\" alt=%q><div class=\"card-body\"><h5 class=\"card-title\">%s</h5><p class=\"card-text\">%d.%02d %s</p><a href=\"/products/%d\" class=\"btn btn-primary\">Add to cart</a></div></div></div>\n",
+				g.rng.Uint32(), p, p, g.rng.Intn(500), g.rng.Intn(100), g.pick(currencies), g.rng.Intn(100000))
+		}
+		b.WriteString("</div>\n")
+	case 1:
+		b.WriteString("<table class=\"table table-striped\">\n<thead><tr><th scope=\"col\">Order</th><th scope=\"col\">Date</th><th scope=\"col\">Status</th><th scope=\"col\">Total</th></tr></thead>\n<tbody>\n")
+		for i := 0; i < 2+g.rng.Intn(8); i++ {
+			fmt.Fprintf(&b, "<tr><td><a href=\"/orders/%d\">#%d</a></td><td>%s</td><td><span class=\"badge\">%s</span></td><td>%d.%02d</td></tr>\n",
+				g.rng.Intn(100000), g.rng.Intn(100000), g.timestamp()[:10], g.pick(orderState), g.rng.Intn(2000), g.rng.Intn(100))
+		}
+		b.WriteString("</tbody>\n</table>\n")
+	default:
+		fmt.Fprintf(&b, `<form method="post" action="/login" class="form-signin">
+<input type="hidden" name="csrf_token" value="%016x">
+<div class="form-group"><label for="email">Email address</label><input type="email" class="form-control" id="email" name="email" placeholder="%s" required></div>
+<div class="form-group"><label for="password">Password</label><input type="password" class="form-control" id="password" name="password" required></div>
+<button type="submit" class="btn btn-primary">Sign in</button>
+</form>
+`, g.rng.Uint64(), g.email())
+	}
+	fmt.Fprintf(&b, `</main>
+<footer class="footer"><p>&copy; 2024 Example Shop. All rights reserved.</p><p><a href="/privacy">Privacy</a> &middot; <a href="/terms">Terms</a></p></footer>
+<script src="/static/js/app.%x.js" defer></script>
+</body>
+</html>
+`, g.rng.Uint32())
+	return b.String()
+}
+
+// split splits the files of a corpus into samples: JSON and HTML files are
+// samples, or their lines for newline delimited JSON, logs and CSV files
+// are split into chunks of lines, each CSV chunk led by the header
+func split(format string, data []byte) [][]byte {
+	lines := bytes.SplitAfter(bytes.TrimRight(data, "\n"), []byte("\n"))
+	switch format {
+	case "json":
+		if len(lines) > 1 && bytes.HasPrefix(bytes.TrimSpace(lines[1]), []byte("{")) {
+			return lines
+		}
+		return [][]byte{data}
+	case "csv", "log":
+		var header []byte
+		if format == "csv" {
+			header, lines = lines[0], lines[1:]
+		}
+		var samples [][]byte
+		for len(lines) > 0 {
+			n := min(len(lines), recordsPerSample)
+			samples = append(samples, bytes.Join(append([][]byte{header}, lines[:n]...), nil))
+			lines = lines[n:]
+		}
+		return samples
+	}
+	return [][]byte{data}
+}
+
+// load returns the samples of format in the corpus, or nil without any
+func load(format string) ([][]byte, error) {
+	files, err := filepath.Glob(filepath.Join(*corpus, format, "*"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	var samples [][]byte
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, split(format, data)...)
+	}
+	return samples, nil
+}
+
+// history joins the first samples up to historySize bytes
+func history(contents [][]byte) []byte {
+	var h []byte
+	for _, c := range contents {
+		if len(h)+len(c) > historySize {
+			break
+		}
+		h = append(h, c...)
+	}
+	return h
+}
+
+func main() {
+	flag.Parse()
+	g := gen{rng: rand.New(rand.NewSource(1))}
+	synthetic := map[string]func() string{"json": g.json, "csv": g.csv, "log": g.log, "html": g.html}
+
+	for i, name := range []string{"json", "csv", "log", "html"} {
+		contents, err := load(name)
+		if err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+		if len(contents) == 0 {
+			log.Printf("%s: no corpus in %s, using synthetic samples", name, filepath.Join(*corpus, name))
+			for j := 0; j < samplesPerFormat; j++ {
+				contents = append(contents, []byte(synthetic[name]()))
+			}
+		}
+		dict, err := zstd.BuildDict(zstd.BuildDictOptions{
+			ID:       uint32(idBase + i + 1),
+			Contents: contents,
+			History:  history(contents),
+			Offsets:  [3]int{1, 4, 8},
+		})
+		if err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+		if err := os.WriteFile(name+".dict", dict, 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrInvalidDictionary is returned by writers and readers of middleware
+// whose dictionary could not be loaded or is not a zstd dictionary
+var ErrInvalidDictionary = errors.New("compression: invalid dictionary")
+
+// WithEmbeddedDictionary loads the zstd dictionary at path from fsys, e.g. an
+// embed.FS holding dictionaries shipped with the binary, and validates it when
+// the middleware is created. If the dictionary cannot be loaded, the warning
+// hook is called and writers and readers fail with ErrInvalidDictionary.
+func WithEmbeddedDictionary(fsys fs.FS, path string) Option {
+	return func(m *Middleware) {
+		dict, err := fs.ReadFile(fsys, path)
+		if err == nil {
+			err = validateZstdDictionary(dict)
+		}
+		if err != nil {
+			m.fail(fmt.Errorf("%w %s: %v", ErrInvalidDictionary, path, err))
+			return
+		}
+		m.dictionary = dict
+	}
+}
+
+// dictionaryPollInterval is the minimum time between checks of a watched
+// dictionary file
+var dictionaryPollInterval = time.Second
+
+// keptDictionaries is the number of replaced dictionaries readers still
+// accept, for streams written before a reload
+const keptDictionaries = 4
+
+// WithDictionaryFile loads the zstd dictionary at path, e.g. distributed by
+// configuration management, and validates it when the middleware is created.
+// With watch, new streams check at most once per second whether the file
+// changed and pick up the new dictionary; readers keep accepting the
+// previous dictionaries for streams written before. A changed file that
+// cannot be loaded is reported to the warning hook and the current
+// dictionary stays in use. Watching middleware do not pool encoders, as
+// pooled encoders would keep their dictionary.
+func WithDictionaryFile(path string, watch bool) Option {
+	return func(m *Middleware) {
+		fi, err := os.Stat(path)
+		var dict []byte
+		if err == nil {
+			dict, err = readDictionaryFile(path)
+		}
+		if err != nil {
+			m.fail(fmt.Errorf("%w %s: %v", ErrInvalidDictionary, path, err))
+			return
+		}
+		m.dictionary = dict
+		if watch {
+			d := &dictionaryFile{path: path, modTime: fi.ModTime(), size: fi.Size()}
+			d.checked.Store(time.Now().UnixNano())
+			d.dicts.Store(&[][]byte{dict})
+			m.dictFile = d
+		}
+	}
+}
+
+func readDictionaryFile(path string) ([]byte, error) {
+	dict, err := os.ReadFile(path)
+	if err == nil {
+		err = validateZstdDictionary(dict)
+	}
+	return dict, err
+}
+
+// dictionaryFile is a watched dictionary file, see WithDictionaryFile
+type dictionaryFile struct {
+	path string
+	// dicts holds the current dictionary followed by the replaced ones
+	dicts   atomic.Pointer[[][]byte]
+	checked atomic.Int64
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+}
+
+// reload loads the dictionary file if it changed since the last check
+func (d *dictionaryFile) reload(m *Middleware) {
+	now := time.Now().UnixNano()
+	last := d.checked.Load()
+	if now-last < int64(dictionaryPollInterval) || !d.checked.CompareAndSwap(last, now) {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fi, err := os.Stat(d.path)
+	if err != nil {
+		m.warnf("checking compression dictionary %s failed: %v", d.path, err)
+		return
+	}
+	if fi.ModTime().Equal(d.modTime) && fi.Size() == d.size {
+		return
+	}
+	dict, err := readDictionaryFile(d.path)
+	if err == nil {
+		err = checkDictionaryID(*d.dicts.Load(), dict)
+	}
+	if err != nil {
+		m.warnf("reloading compression dictionary %s failed, keeping the current one: %v", d.path, err)
+		return
+	}
+	d.modTime, d.size = fi.ModTime(), fi.Size()
+	d.push(dict)
+}
+
+// push makes dict the current dictionary, keeping the replaced ones for
+// readers. d.mu must be held.
+func (d *dictionaryFile) push(dict []byte) {
+	d.dicts.Store(pushDictionary(*d.dicts.Load(), dict))
+}
+
+// checkDictionaryID fails if a dictionary of kept has the ID of dict but
+// other content, as readers pick dictionaries by ID and would decode the
+// streams written before with dict
+func checkDictionaryID(kept [][]byte, dict []byte) error {
+	id := zstdDictionaryID(dict)
+	for _, k := range kept {
+		if zstdDictionaryID(k) == id && !bytes.Equal(k, dict) {
+			return fmt.Errorf("dictionary ID %d is already used by another dictionary", id)
+		}
+	}
+	return nil
+}
+
+// pushDictionary returns dict followed by the most recent keptDictionaries
+// of old
+func pushDictionary(old [][]byte, dict []byte) *[][]byte {
+	dicts := append([][]byte{dict}, old[:min(len(old), keptDictionaries)]...)
+	return &dicts
+}
+
+// SetDictionary atomically replaces the zstd dictionary of the middleware,
+// e.g. with a newly trained one, without recreating it. Streams created
+// afterwards are written with dict; readers keep accepting the replaced
+// dictionaries for streams written before, up to the last four. Pooled
+// encoders holding the replaced dictionary are dropped. It fails with
+// ErrInvalidDictionary for dictionaries not in zstd format, for dictionaries
+// reusing the ID of an accepted dictionary with other content and for
+// middleware whose dictionaries come from WithDictionaryDelta or
+// WithDictionaryStore. With WithDictionaryFile, dict is used until the file
+// changes.
+func (m *Middleware) SetDictionary(dict []byte) error {
+	if err := validateZstdDictionary(dict); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDictionary, err)
+	}
+	if m.layered != nil || m.dictStore != nil {
+		return fmt.Errorf("%w: dictionaries of a delta or store cannot be replaced", ErrInvalidDictionary)
+	}
+	if d := m.dictFile; d != nil {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if err := checkDictionaryID(*d.dicts.Load(), dict); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidDictionary, err)
+		}
+		d.push(dict)
+		return nil
+	}
+	m.swapMu.Lock()
+	defer m.swapMu.Unlock()
+	if err := checkDictionaryID(m.decoderDictionaries(), dict); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidDictionary, err)
+	}
+	m.swapped.Store(pushDictionary(m.decoderDictionaries(), dict))
+	// Encoders labeled with the new generation are created after the swap
+	m.dictGen.Add(1)
+	return nil
+}
+
+// encoderDictionary returns the dictionary new streams are written with
+func (m *Middleware) encoderDictionary() []byte {
+	if m.dictFile != nil {
+		return (*m.dictFile.dicts.Load())[0]
+	}
+	if dicts := m.swapped.Load(); dicts != nil {
+		return (*dicts)[0]
+	}
+	if m.dictStore != nil {
+		if dict := m.storeDict.Load(); dict != nil {
+			return *dict
+		}
+		return nil
+	}
+	return m.dictionary
+}
+
+// decoderDictionaries returns the dictionaries readers accept
+func (m *Middleware) decoderDictionaries() [][]byte {
+	if m.dictFile != nil {
+		return *m.dictFile.dicts.Load()
+	}
+	if dicts := m.swapped.Load(); dicts != nil {
+		return *dicts
+	}
+	if m.dictionary != nil {
+		return [][]byte{m.dictionary}
+	}
+	return nil
+}
+
+// WithDictionaryDelta layers a small per-tenant delta dictionary on top of
+// the base zstd dictionary set with the other dictionary options or a
+// Factory default, so only the delta has to be distributed per tenant.
+// Writers compress with the content of the base followed by delta as a raw
+// dictionary under an ID derived from both; the entropy tables of the base
+// are not used. Readers need the same base and delta and still accept
+// streams written with the base alone. The base is taken when the
+// middleware is created and is not reloaded by WithDictionaryFile. Without
+// a base, delta alone is the dictionary.
+func WithDictionaryDelta(delta []byte) Option {
+	return func(m *Middleware) {
+		m.dictDelta = delta
+	}
+}
+
+// layeredDictionary is the raw dictionary of WithDictionaryDelta
+type layeredDictionary struct {
+	id      uint32
+	content []byte
+}
+
+// layerDictionary combines the base dictionary with the delta
+func (m *Middleware) layerDictionary() error {
+	var content []byte
+	var baseID uint32
+	if base := m.encoderDictionary(); base != nil {
+		var err error
+		if content, baseID, err = zstdDictionaryContent(base); err != nil {
+			return fmt.Errorf("%w: base of the delta: %v", ErrInvalidDictionary, err)
+		}
+	}
+	content = append(content[:len(content):len(content)], m.dictDelta...)
+	id := baseID ^ crc32.ChecksumIEEE(m.dictDelta)
+	if id == 0 || id == baseID {
+		id = ^baseID
+	}
+	m.layered = &layeredDictionary{id: id, content: content}
+	return nil
+}
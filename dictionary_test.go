@@ -0,0 +1,237 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestWithEmbeddedDictionary(t *testing.T) {
+	requireZstd(t)
+	fsys := fstest.MapFS{
+		"dicts/events.dict": {Data: testZstdDictionary(t, 7)},
+		"dicts/broken.dict": {Data: []byte("not a dictionary")},
+	}
+
+	m := New(Zstd, WithEmbeddedDictionary(fsys, "dicts/events.dict"))
+	data := []byte(`{"event":"login","user":"alice","ok":true}`)
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	w.Write(data)
+	w.(io.Closer).Close()
+	if got, err := io.ReadAll(m.Reader(&buf)); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Round trip failed: %v", err)
+	}
+
+	for _, path := range []string{"dicts/broken.dict", "dicts/missing.dict"} {
+		var warning string
+		m := New(Zstd, WithEmbeddedDictionary(fsys, path), WithWarningHook(func(msg string) { warning = msg }))
+		if warning == "" {
+			t.Fatalf("Expected a warning for %s", path)
+		}
+		if _, err := m.Writer(io.Discard).Write(data); !errors.Is(err, ErrInvalidDictionary) {
+			t.Fatalf("Expected ErrInvalidDictionary for %s, got %v", path, err)
+		}
+	}
+}
+
+func TestWithDictionaryFile(t *testing.T) {
+	requireZstd(t)
+	path := filepath.Join(t.TempDir(), "dict")
+	if err := os.WriteFile(path, testZstdDictionary(t, 1), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(d time.Duration) { dictionaryPollInterval = d }(dictionaryPollInterval)
+	dictionaryPollInterval = 0
+
+	var warnings []string
+	m := New(Zstd, WithDictionaryFile(path, true), WithWarningHook(func(msg string) { warnings = append(warnings, msg) }))
+	payload := []byte(`{"level":"info","service":"svc-3","message":"request 93 handled in 3ms"}`)
+	before := writeContainer(t, m, payload)
+
+	// Replace the dictionary, new streams use it
+	if err := os.WriteFile(path, testZstdDictionary(t, 2), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(path, time.Now(), time.Now().Add(time.Minute))
+	after := writeContainer(t, m, payload)
+	if id := zstdDictionaryID(m.encoderDictionary()); id != 2 {
+		t.Fatalf("dictionary %d in use after reload", id)
+	}
+
+	// Streams of both dictionaries remain readable
+	for _, compressed := range [][]byte{before, after} {
+		got, err := io.ReadAll(m.Reader(bytes.NewReader(compressed)))
+		if err != nil || !bytes.Equal(got, payload) {
+			t.Errorf("round trip failed: %v", err)
+		}
+	}
+
+	// A broken file keeps the current dictionary
+	os.WriteFile(path, []byte("garbage"), 0o644)
+	os.Chtimes(path, time.Now(), time.Now().Add(2*time.Minute))
+	writeContainer(t, m, payload)
+	if id := zstdDictionaryID(m.encoderDictionary()); id != 2 || len(warnings) != 1 {
+		t.Errorf("dictionary %d in use, warnings %q", id, warnings)
+	}
+
+	if _, err := New(Zstd, WithDictionaryFile(path, false)).Writer(io.Discard).Write(payload); !errors.Is(err, ErrInvalidDictionary) {
+		t.Errorf("expected ErrInvalidDictionary, got %v", err)
+	}
+
+	// A dictionary reusing the ID of a kept one with other content is rejected
+	os.WriteFile(path, otherContent(testZstdDictionary(t, 1)), 0o644)
+	os.Chtimes(path, time.Now(), time.Now().Add(3*time.Minute))
+	writeContainer(t, m, payload)
+	if id := zstdDictionaryID(m.encoderDictionary()); id != 2 || len(warnings) != 2 {
+		t.Errorf("dictionary %d in use, warnings %q", id, warnings)
+	}
+	if got, err := io.ReadAll(m.Reader(bytes.NewReader(before))); err != nil || !bytes.Equal(got, payload) {
+		t.Errorf("round trip of the first dictionary failed: %v", err)
+	}
+}
+
+func TestWithDictionaryDelta(t *testing.T) {
+	requireZstd(t)
+	base := testZstdDictionary(t, 1)
+	delta := []byte(`{"tenant":"acme-industries","region":"eu-central-1","plan":"enterprise-gold"}`)
+	data := []byte(`{"tenant":"acme-industries","region":"eu-central-1","plan":"enterprise-gold","level":"info","service":"svc-3","message":"request 93 handled in 3ms"}`)
+
+	f := NewFactory(Config{Algorithm: Zstd, Dictionary: base})
+	layered := f.Tenant("acme", WithDictionaryDelta(delta))
+	if layered.layered == nil || layered.layered.id == 1 {
+		t.Fatalf("Expected a layered dictionary with its own ID, got %+v", layered.layered)
+	}
+	plain := f.Tenant("other")
+
+	withDelta := writeContainer(t, layered, data)
+	withBase := writeContainer(t, plain, data)
+	if len(withDelta) >= len(withBase) {
+		t.Fatalf("Expected the delta to improve the ratio, got %d bytes, %d with the base alone", len(withDelta), len(withBase))
+	}
+
+	for name, compressed := range map[string][]byte{"layered": withDelta, "base": withBase} {
+		got, err := io.ReadAll(layered.Reader(bytes.NewReader(compressed)))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("%s: failed to read with the layered dictionary: %v", name, err)
+		}
+	}
+	if _, err := io.ReadAll(plain.Reader(bytes.NewReader(withDelta))); err == nil {
+		t.Fatal("Expected readers without the delta to fail")
+	}
+}
+
+func TestWithDictionaryDelta_InvalidBase(t *testing.T) {
+	requireZstd(t)
+	m := New(Zstd, WithZstdDictionary([]byte("not a dictionary")), WithDictionaryDelta([]byte("delta")))
+	if _, err := m.Writer(io.Discard).Write([]byte("x")); !errors.Is(err, ErrInvalidDictionary) {
+		t.Fatalf("Expected ErrInvalidDictionary, got %v", err)
+	}
+}
+
+func TestSetDictionary(t *testing.T) {
+	requireZstd(t)
+	payload := []byte(`{"level":"info","service":"svc-3","message":"request 93 handled in 3ms"}`)
+	dictID := func(stream []byte) uint32 { return zstdDictionaryIDOf(bufio.NewReader(bytes.NewReader(stream))) }
+
+	m := New(Zstd, WithZstdDictionary(testZstdDictionary(t, 1)))
+	before := writeContainer(t, m, payload)
+	if err := m.SetDictionary(testZstdDictionary(t, 2)); err != nil {
+		t.Fatalf("Failed to set the dictionary: %v", err)
+	}
+	// The pooled encoder of the first stream holds the replaced dictionary
+	after := writeContainer(t, m, payload)
+	if dictID(before) != 1 || dictID(after) != 2 {
+		t.Fatalf("Expected dictionaries 1 and 2, got %d and %d", dictID(before), dictID(after))
+	}
+	for name, stream := range map[string][]byte{"before": before, "after": after} {
+		if got, err := io.ReadAll(m.Reader(bytes.NewReader(stream))); err != nil || !bytes.Equal(got, payload) {
+			t.Fatalf("%s: failed to read: %v", name, err)
+		}
+	}
+
+	// Middleware without a dictionary pick it up as well
+	m = New(Zstd)
+	if err := m.SetDictionary(testZstdDictionary(t, 3)); err != nil {
+		t.Fatalf("Failed to set the dictionary: %v", err)
+	}
+	if id := dictID(writeContainer(t, m, payload)); id != 3 {
+		t.Fatalf("Expected dictionary 3, got %d", id)
+	}
+}
+
+func TestSetDictionary_Errors(t *testing.T) {
+	requireZstd(t)
+	if err := New(Zstd).SetDictionary([]byte("not a dictionary")); !errors.Is(err, ErrInvalidDictionary) {
+		t.Fatalf("Expected ErrInvalidDictionary, got %v", err)
+	}
+	dict := testZstdDictionary(t, 1)
+	for name, m := range map[string]*Middleware{
+		"delta": New(Zstd, WithZstdDictionary(dict), WithDictionaryDelta([]byte("delta"))),
+		"store": New(Zstd, WithDictionaryStore(NewMemoryDictionaryStore())),
+	} {
+		if err := m.SetDictionary(dict); !errors.Is(err, ErrInvalidDictionary) {
+			t.Fatalf("%s: expected ErrInvalidDictionary, got %v", name, err)
+		}
+	}
+}
+
+// otherContent returns dict with its last content byte changed
+func otherContent(dict []byte) []byte {
+	dict = bytes.Clone(dict)
+	dict[len(dict)-1]++
+	return dict
+}
+
+func TestSetDictionary_ReusedID(t *testing.T) {
+	requireZstd(t)
+	dict := testZstdDictionary(t, 1)
+	m := New(Zstd, WithZstdDictionary(dict))
+	if err := m.SetDictionary(testZstdDictionary(t, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetDictionary(otherContent(dict)); !errors.Is(err, ErrInvalidDictionary) {
+		t.Fatalf("Expected ErrInvalidDictionary for a reused ID, got %v", err)
+	}
+	// Setting a kept dictionary again is fine
+	if err := m.SetDictionary(dict); err != nil {
+		t.Fatalf("Failed to set a kept dictionary: %v", err)
+	}
+}
+
+func TestSetDictionary_Concurrent(t *testing.T) {
+	requireZstd(t)
+	payload := []byte(`{"level":"info","service":"svc-3","message":"request 93 handled in 3ms"}`)
+	dicts := [][]byte{testZstdDictionary(t, 1), testZstdDictionary(t, 2)}
+	m := New(Zstd, WithZstdDictionary(dicts[0]))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				var buf bytes.Buffer
+				w := m.Writer(&buf)
+				w.Write(payload)
+				w.(io.Closer).Close()
+				if got, err := io.ReadAll(m.Reader(&buf)); err != nil || !bytes.Equal(got, payload) {
+					t.Errorf("Failed to read: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := m.SetDictionary(dicts[i%2]); err != nil {
+			t.Fatalf("Failed to set the dictionary: %v", err)
+		}
+	}
+	wg.Wait()
+}
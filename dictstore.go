@@ -0,0 +1,108 @@
+package compression
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// DictionaryStore holds zstd dictionaries by ID, e.g. in S3 or a database,
+// see WithDictionaryStore. Implementations must be safe for concurrent use.
+type DictionaryStore interface {
+	// Current returns the dictionary new streams are written with, nil for
+	// none. It is called for every new stream, so remote stores should cache
+	// it.
+	Current(ctx context.Context) ([]byte, error)
+	// Dictionary returns the dictionary with id, or an error wrapping
+	// ErrDictionaryMissing
+	Dictionary(ctx context.Context, id uint32) ([]byte, error)
+}
+
+// WithDictionaryStore makes writers compress every stream with the current
+// dictionary of store and readers fetch the dictionary recorded in a stream
+// from store by its ID, see WithDictionaryResolver. Dictionaries can then be
+// rotated in the store without breaking streams written before. The ID is
+// recorded in the zstd frame headers; other algorithms ignore the store.
+// Writers do not pool encoders, as pooled encoders would keep their
+// dictionary.
+func WithDictionaryStore(store DictionaryStore) Option {
+	return func(m *Middleware) {
+		m.dictStore = store
+		withResolver(store.Dictionary)(m)
+	}
+}
+
+// WithContext sets the context of the calls made on behalf of streams, to
+// the DictionaryStore of WithDictionaryStore and the CatalogStore of
+// WithCatalog. Canceling it, e.g. on shutdown, fails these calls and with
+// them the streams. Without it, the calls use context.Background().
+func WithContext(ctx context.Context) Option {
+	return func(m *Middleware) {
+		m.ctx = ctx
+	}
+}
+
+// context returns the context of WithContext
+func (m *Middleware) context() context.Context {
+	if m.ctx == nil {
+		return context.Background()
+	}
+	return m.ctx
+}
+
+// loadCurrentDictionary loads the dictionary new streams are written with
+// from the store
+func (m *Middleware) loadCurrentDictionary() error {
+	dict, err := m.dictStore.Current(m.context())
+	if err != nil {
+		return fmt.Errorf("compression: loading the current dictionary: %w", err)
+	}
+	if dict != nil {
+		if err := validateZstdDictionary(dict); err != nil {
+			return fmt.Errorf("%w: current dictionary of the store: %v", ErrInvalidDictionary, err)
+		}
+	}
+	m.storeDict.Store(&dict)
+	return nil
+}
+
+// MemoryDictionaryStore is a DictionaryStore keeping dictionaries in memory,
+// for tests and for dictionaries shipped with the binary
+type MemoryDictionaryStore struct {
+	mu      sync.RWMutex
+	dicts   map[uint32][]byte
+	current []byte
+}
+
+// NewMemoryDictionaryStore returns an empty store
+func NewMemoryDictionaryStore() *MemoryDictionaryStore {
+	return &MemoryDictionaryStore{dicts: make(map[uint32][]byte)}
+}
+
+// Add adds the zstd dictionary dict and makes it the current one
+func (s *MemoryDictionaryStore) Add(dict []byte) error {
+	id := zstdDictionaryID(dict)
+	if id == 0 {
+		return fmt.Errorf("%w: not a zstd dictionary with an ID", ErrInvalidDictionary)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dicts[id] = dict
+	s.current = dict
+	return nil
+}
+
+func (s *MemoryDictionaryStore) Current(ctx context.Context) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.current, nil
+}
+
+func (s *MemoryDictionaryStore) Dictionary(ctx context.Context, id uint32) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if dict, ok := s.dicts[id]; ok {
+		return dict, nil
+	}
+	return nil, fmt.Errorf("%w: %d", ErrDictionaryMissing, id)
+}
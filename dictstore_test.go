@@ -0,0 +1,100 @@
+package compression
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+)
+
+// failingStore is a DictionaryStore whose current dictionary cannot be
+// loaded
+type failingStore struct{ err error }
+
+func (s failingStore) Current(context.Context) ([]byte, error) { return nil, s.err }
+
+func (s failingStore) Dictionary(context.Context, uint32) ([]byte, error) { return nil, s.err }
+
+func TestWithDictionaryStore(t *testing.T) {
+	requireZstd(t)
+	payload := []byte(`{"level":"info","service":"svc-3","message":"request 93 handled in 3ms"}`)
+	store := NewMemoryDictionaryStore()
+	m := New(Zstd, WithDictionaryStore(store))
+	plain := writeContainer(t, m, payload)
+
+	// Rotate the dictionary between streams
+	if err := store.Add(testZstdDictionary(t, 1)); err != nil {
+		t.Fatalf("Failed to add dictionary: %v", err)
+	}
+	first := writeContainer(t, m, payload)
+	if err := store.Add(testZstdDictionary(t, 2)); err != nil {
+		t.Fatalf("Failed to add dictionary: %v", err)
+	}
+	second := writeContainer(t, m, payload)
+	if len(first) >= len(plain) || bytes.Equal(first, second) {
+		t.Fatalf("Expected smaller streams with distinct dictionaries, got %d, %d and %d bytes", len(plain), len(first), len(second))
+	}
+
+	// Readers of another middleware fetch the dictionaries from the store
+	reader := New(Zstd, WithDictionaryStore(store))
+	for i, stream := range [][]byte{plain, first, second} {
+		got, err := io.ReadAll(reader.Reader(bytes.NewReader(stream)))
+		if err != nil || !bytes.Equal(got, payload) {
+			t.Fatalf("Stream %d: failed to read: %v", i, err)
+		}
+	}
+	if _, err := io.ReadAll(New(Zstd, WithDictionaryStore(NewMemoryDictionaryStore())).Reader(bytes.NewReader(first))); !errors.Is(err, ErrDictionaryMissing) {
+		t.Fatalf("Expected ErrDictionaryMissing from an empty store, got %v", err)
+	}
+}
+
+func TestWithDictionaryStore_Errors(t *testing.T) {
+	errStore := errors.New("store unavailable")
+	if _, err := New(Zstd, WithDictionaryStore(failingStore{errStore})).Writer(io.Discard).Write([]byte("x")); !errors.Is(err, errStore) {
+		t.Fatalf("Expected the store error, got %v", err)
+	}
+
+	store := NewMemoryDictionaryStore()
+	if err := store.Add([]byte("not a dictionary")); !errors.Is(err, ErrInvalidDictionary) {
+		t.Fatalf("Expected ErrInvalidDictionary, got %v", err)
+	}
+	if _, err := store.Dictionary(context.Background(), 7); !errors.Is(err, ErrDictionaryMissing) {
+		t.Fatalf("Expected ErrDictionaryMissing, got %v", err)
+	}
+}
+
+// contextStore is a MemoryDictionaryStore failing calls whose context is done
+type contextStore struct{ *MemoryDictionaryStore }
+
+func (s contextStore) Current(ctx context.Context) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.MemoryDictionaryStore.Current(ctx)
+}
+
+func (s contextStore) Dictionary(ctx context.Context, id uint32) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.MemoryDictionaryStore.Dictionary(ctx, id)
+}
+
+func TestWithContext(t *testing.T) {
+	requireZstd(t)
+	payload := []byte(`{"level":"info","service":"svc-3","message":"request 93 handled in 3ms"}`)
+	store := contextStore{NewMemoryDictionaryStore()}
+	store.Add(testZstdDictionary(t, 1))
+	compressed := writeContainer(t, New(Zstd, WithDictionaryStore(store)), payload)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m := New(Zstd, WithDictionaryStore(store), WithContext(ctx))
+	if _, err := m.Writer(io.Discard).Write(payload); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the writer to fail with the canceled context, got %v", err)
+	}
+	if _, err := io.ReadAll(m.Reader(bytes.NewReader(compressed))); !errors.Is(err, ErrDictionaryMissing) {
+		t.Errorf("Expected ErrDictionaryMissing with the canceled context, got %v", err)
+	}
+}
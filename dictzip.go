@@ -0,0 +1,358 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// ErrNotDictzip is returned by OpenDictzip for gzip data without a dictzip
+// index
+var ErrNotDictzip = errors.New("compression: not a dictzip file")
+
+const (
+	// DictzipChunkSize is the largest dictzip chunk, chosen so that the
+	// compressed size of a chunk fits the 16 bit index entries
+	DictzipChunkSize = 58315
+
+	// dictzipMemberChunks bounds the chunks per gzip member, and so the
+	// output the writer buffers, as the index precedes the data
+	dictzipMemberChunks = 256
+)
+
+// WithDictzip makes gzip writers follow the dictzip/idzip convention: the
+// stream is compressed in chunks of chunkSize bytes that can be decoded
+// independently, and every gzip member records the compressed chunk sizes
+// in its header (extra field "RA"). The output remains a standard, possibly
+// multi-member, gzip stream; OpenDictzip reads it with random access.
+// chunkSize is capped at DictzipChunkSize, which is also the default. The
+// option only applies to Gzip.
+func WithDictzip(chunkSize int) Option {
+	return func(m *Middleware) {
+		if chunkSize <= 0 || chunkSize > DictzipChunkSize {
+			chunkSize = DictzipChunkSize
+		}
+		m.dictzipChunk = chunkSize
+	}
+}
+
+// dictzipWriter writes gzip members with a dictzip index. Each chunk is
+// compressed by a freshly reset deflate writer and ends with a sync flush,
+// so it starts byte aligned without references to earlier chunks.
+type dictzipWriter struct {
+	w     io.Writer
+	chunk int
+	fw    *flate.Writer
+
+	buf     []byte
+	data    bytes.Buffer
+	sizes   []int
+	crc     uint32
+	isize   uint32
+	members int
+	err     error
+}
+
+func newDictzipWriter(w io.Writer, level, chunk int) encoder {
+	fw, err := flate.NewWriter(nil, level)
+	if err != nil {
+		panic("failed to create flate writer: " + err.Error())
+	}
+	return &dictzipWriter{w: w, chunk: chunk, fw: fw, buf: make([]byte, 0, chunk)}
+}
+
+func (d *dictzipWriter) Write(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		k := min(len(p), d.chunk-len(d.buf))
+		d.buf = append(d.buf, p[:k]...)
+		p = p[k:]
+		if len(d.buf) == d.chunk {
+			d.compressChunk()
+			if len(d.sizes) == dictzipMemberChunks {
+				d.endMember()
+			}
+		}
+		if d.err != nil {
+			return n - len(p), d.err
+		}
+	}
+	return n, nil
+}
+
+func (d *dictzipWriter) compressChunk() {
+	start := d.data.Len()
+	d.fw.Reset(&d.data)
+	d.fw.Write(d.buf)
+	d.fw.Flush()
+	d.sizes = append(d.sizes, d.data.Len()-start)
+	d.crc = crc32.Update(d.crc, crc32.IEEETable, d.buf)
+	d.isize += uint32(len(d.buf))
+	d.buf = d.buf[:0]
+}
+
+// endMember writes the buffered chunks as one gzip member
+func (d *dictzipWriter) endMember() {
+	if len(d.buf) > 0 {
+		d.compressChunk()
+	}
+	// The final block belongs to the last chunk
+	start := d.data.Len()
+	d.fw.Reset(&d.data)
+	d.fw.Close()
+	if len(d.sizes) == 0 {
+		d.sizes = append(d.sizes, 0)
+	}
+	d.sizes[len(d.sizes)-1] += d.data.Len() - start
+
+	extra := make([]byte, 0, 10+2*len(d.sizes))
+	extra = append(extra, 'R', 'A')
+	extra = binary.LittleEndian.AppendUint16(extra, uint16(6+2*len(d.sizes)))
+	extra = binary.LittleEndian.AppendUint16(extra, 1)
+	extra = binary.LittleEndian.AppendUint16(extra, uint16(d.chunk))
+	extra = binary.LittleEndian.AppendUint16(extra, uint16(len(d.sizes)))
+	for _, s := range d.sizes {
+		extra = binary.LittleEndian.AppendUint16(extra, uint16(s))
+	}
+	header := []byte{0x1f, 0x8b, 8, gzipFlagExtra, 0, 0, 0, 0, 0, 255}
+	header = binary.LittleEndian.AppendUint16(header, uint16(len(extra)))
+	trailer := binary.LittleEndian.AppendUint32(nil, d.crc)
+	trailer = binary.LittleEndian.AppendUint32(trailer, d.isize)
+
+	for _, p := range [][]byte{header, extra, d.data.Bytes(), trailer} {
+		if _, err := d.w.Write(p); err != nil {
+			d.err = err
+			break
+		}
+	}
+	d.members++
+	d.data.Reset()
+	d.sizes = d.sizes[:0]
+	d.crc = 0
+	d.isize = 0
+}
+
+// Flush ends the current member, so that everything written so far can be
+// decoded
+func (d *dictzipWriter) Flush() error {
+	if d.err == nil && (len(d.buf) > 0 || len(d.sizes) > 0) {
+		d.endMember()
+	}
+	return d.err
+}
+
+func (d *dictzipWriter) Close() error {
+	if d.err == nil && (len(d.buf) > 0 || len(d.sizes) > 0 || d.members == 0) {
+		d.endMember()
+	}
+	return d.err
+}
+
+func (d *dictzipWriter) Reset(w io.Writer) {
+	d.w = w
+	d.buf = d.buf[:0]
+	d.data.Reset()
+	d.sizes = d.sizes[:0]
+	d.crc = 0
+	d.isize = 0
+	d.members = 0
+	d.err = nil
+}
+
+// DictzipReader reads a dictzip file with random access. It is safe for
+// parallel calls of ReadAt.
+type DictzipReader struct {
+	r      io.ReaderAt
+	chunks []dictzipChunk
+	size   int64
+
+	// last decoded chunk, for sequential reads
+	mu     sync.Mutex
+	cached int
+	buf    []byte
+}
+
+type dictzipChunk struct {
+	offset       int64
+	compressed   int64
+	uncompressed int64
+	size         int
+}
+
+// OpenDictzip parses the indexes of all members of the dictzip file r of
+// size bytes, e.g. written with WithDictzip
+func OpenDictzip(r io.ReaderAt, size int64) (*DictzipReader, error) {
+	d := &DictzipReader{r: r, cached: -1}
+	for off := int64(0); off < size; {
+		end, err := d.parseMember(off, size)
+		if err != nil {
+			return nil, err
+		}
+		off = end
+	}
+	return d, nil
+}
+
+// parseMember adds the chunks of the member at off and returns its end
+func (d *DictzipReader) parseMember(off, size int64) (int64, error) {
+	br := bufio.NewReader(io.NewSectionReader(d.r, off, size-off))
+	var header [10]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrNotDictzip, err)
+	}
+	if header[0] != 0x1f || header[1] != 0x8b || header[2] != 8 || header[3]&gzipFlagExtra == 0 {
+		return 0, ErrNotDictzip
+	}
+	pos := int64(len(header))
+	var xlen [2]byte
+	if _, err := io.ReadFull(br, xlen[:]); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrNotDictzip, err)
+	}
+	extra := make([]byte, binary.LittleEndian.Uint16(xlen[:]))
+	if _, err := io.ReadFull(br, extra); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrNotDictzip, err)
+	}
+	pos += 2 + int64(len(extra))
+	for _, flag := range []byte{gzipFlagName, gzipFlagComment} {
+		if header[3]&flag != 0 {
+			s, err := br.ReadBytes(0)
+			if err != nil {
+				return 0, fmt.Errorf("%w: %v", ErrNotDictzip, err)
+			}
+			pos += int64(len(s))
+		}
+	}
+	if header[3]&gzipFlagHCRC != 0 {
+		pos += 2
+	}
+
+	chunkLen, sizes, err := parseDictzipExtra(extra)
+	if err != nil {
+		return 0, err
+	}
+	var uncompressed int64
+	if n := len(d.chunks); n > 0 {
+		last := d.chunks[n-1]
+		uncompressed = last.uncompressed + int64(last.size)
+	}
+	offset := off + pos
+	for _, s := range sizes {
+		d.chunks = append(d.chunks, dictzipChunk{offset: offset, compressed: int64(s), uncompressed: uncompressed, size: chunkLen})
+		offset += int64(s)
+		uncompressed += int64(chunkLen)
+	}
+
+	var trailer [8]byte
+	if _, err := d.r.ReadAt(trailer[:], offset); err != nil {
+		return 0, fmt.Errorf("%w: reading member trailer: %v", ErrCorruptStream, err)
+	}
+	// The last chunk holds the remainder of the member size
+	if len(sizes) > 0 {
+		last := &d.chunks[len(d.chunks)-1]
+		memberSize := binary.LittleEndian.Uint32(trailer[4:])
+		last.size = int(memberSize) - (len(sizes)-1)*chunkLen
+		if last.size < 0 || last.size > chunkLen {
+			return 0, fmt.Errorf("%w: member size %d does not match its index", ErrCorruptStream, memberSize)
+		}
+	}
+	d.size = uncompressed
+	if len(sizes) > 0 {
+		last := d.chunks[len(d.chunks)-1]
+		d.size = last.uncompressed + int64(last.size)
+	}
+	return offset + int64(len(trailer)), nil
+}
+
+// parseDictzipExtra returns the chunk length and compressed chunk sizes of
+// the "RA" subfield of a gzip extra field
+func parseDictzipExtra(extra []byte) (int, []int, error) {
+	for len(extra) >= 4 {
+		n := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+n {
+			break
+		}
+		field := extra[4 : 4+n]
+		if extra[0] == 'R' && extra[1] == 'A' && n >= 6 && binary.LittleEndian.Uint16(field) == 1 {
+			chunkLen := int(binary.LittleEndian.Uint16(field[2:]))
+			count := int(binary.LittleEndian.Uint16(field[4:]))
+			if len(field) < 6+2*count {
+				break
+			}
+			sizes := make([]int, count)
+			for i := range sizes {
+				sizes[i] = int(binary.LittleEndian.Uint16(field[6+2*i:]))
+			}
+			return chunkLen, sizes, nil
+		}
+		extra = extra[4+n:]
+	}
+	return 0, nil, ErrNotDictzip
+}
+
+// Size returns the uncompressed size
+func (d *DictzipReader) Size() int64 {
+	return d.size
+}
+
+// ReadAt reads uncompressed data at off, decoding only the chunks
+// overlapping p
+func (d *DictzipReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("compression: negative offset")
+	}
+	n := 0
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= d.size {
+			return n, io.EOF
+		}
+		i := sort.Search(len(d.chunks), func(i int) bool {
+			return d.chunks[i].uncompressed+int64(d.chunks[i].size) > pos
+		})
+		d.mu.Lock()
+		data, err := d.decodeChunk(i)
+		if err != nil {
+			d.mu.Unlock()
+			return n, err
+		}
+		n += copy(p[n:], data[pos-d.chunks[i].uncompressed:])
+		d.mu.Unlock()
+	}
+	return n, nil
+}
+
+// decodeChunk returns chunk i, decoding it into the cache unless it is
+// cached. The caller holds d.mu.
+func (d *DictzipReader) decodeChunk(i int) ([]byte, error) {
+	if d.cached == i {
+		return d.buf, nil
+	}
+	c := d.chunks[i]
+	if cap(d.buf) < c.size {
+		d.buf = make([]byte, c.size)
+	}
+	d.buf = d.buf[:c.size]
+	fr := flate.NewReader(io.NewSectionReader(d.r, c.offset, c.compressed))
+	defer fr.Close()
+	if _, err := io.ReadFull(fr, d.buf); err != nil {
+		d.cached = -1
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// The chunk is shorter than its index says
+			err = &sentinelError{err, ErrCorruptStream}
+		}
+		return nil, fmt.Errorf("compression: dictzip chunk %d: %w", i, classifyError(err))
+	}
+	d.cached = i
+	return d.buf, nil
+}
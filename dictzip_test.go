@@ -0,0 +1,139 @@
+package compression
+
+import (
+	"bytes"
+	stdgzip "compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestWithDictzip(t *testing.T) {
+	var text bytes.Buffer
+	rng := rand.New(rand.NewSource(1))
+	for text.Len() < 600000 {
+		fmt.Fprintf(&text, "record %d value %d\n", text.Len(), rng.Intn(1000))
+	}
+	data := text.Bytes()
+
+	m := New(Gzip, WithDictzip(4096))
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	w.Write(data[:1000])
+	w.(interface{ Flush() error }).Flush() // ends a member early
+	w.Write(data[1000:])
+	w.(io.Closer).Close()
+
+	// Standard gunzip reads the multi-member stream
+	zr, err := stdgzip.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("gunzip failed: %v", err)
+	}
+
+	d, err := OpenDictzip(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Size() != int64(len(data)) {
+		t.Fatalf("Size = %d, want %d", d.Size(), len(data))
+	}
+	for _, off := range []int64{0, 500, 999, 4095, 4096, 300001, int64(len(data)) - 10} {
+		p := make([]byte, 5000)
+		n, err := d.ReadAt(p, off)
+		if err != nil && err != io.EOF {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p[:n], data[off:off+int64(n)]) {
+			t.Errorf("ReadAt(%d) returned wrong data", off)
+		}
+	}
+	if _, err := d.ReadAt(make([]byte, 1), int64(len(data))); err != io.EOF {
+		t.Errorf("ReadAt past the end: %v", err)
+	}
+
+	// Parallel reads share the chunk cache
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := make([]byte, 3000)
+			for i := 0; i < 50; i++ {
+				off := int64((g*7919 + i*4099) % (len(data) - len(p)))
+				if n, err := d.ReadAt(p, off); err != nil || !bytes.Equal(p[:n], data[off:off+int64(n)]) {
+					t.Errorf("parallel ReadAt(%d) returned wrong data: %v", off, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestWithDictzip_Empty(t *testing.T) {
+	compressed := writeContainer(t, New(Gzip, WithDictzip(0)), nil)
+	if got, err := io.ReadAll(New(Gzip).Reader(bytes.NewReader(compressed))); err != nil || len(got) != 0 {
+		t.Errorf("reading empty stream: %q, %v", got, err)
+	}
+	d, err := OpenDictzip(bytes.NewReader(compressed), int64(len(compressed)))
+	if err != nil || d.Size() != 0 {
+		t.Errorf("OpenDictzip = %v, %v", d, err)
+	}
+}
+
+func TestOpenDictzip_PlainGzip(t *testing.T) {
+	compressed := writeContainer(t, New(Gzip), []byte("plain"))
+	if _, err := OpenDictzip(bytes.NewReader(compressed), int64(len(compressed))); !errors.Is(err, ErrNotDictzip) {
+		t.Errorf("expected ErrNotDictzip, got %v", err)
+	}
+}
+
+// failingReaderAt fails all reads once fail is set
+type failingReaderAt struct {
+	r    io.ReaderAt
+	fail bool
+}
+
+var errSource = errors.New("source failed")
+
+func (f *failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if f.fail {
+		return 0, errSource
+	}
+	return f.r.ReadAt(p, off)
+}
+
+func TestDictzipReader_Errors(t *testing.T) {
+	data := bytes.Repeat([]byte("dictzip chunk "), 10000)
+	compressed := writeContainer(t, New(Gzip, WithDictzip(4096)), data)
+
+	src := &failingReaderAt{r: bytes.NewReader(compressed)}
+	d, err := OpenDictzip(src, int64(len(compressed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.fail = true
+	if _, err := d.ReadAt(make([]byte, 10), 0); !errors.Is(err, errSource) || errors.Is(err, ErrCorruptStream) {
+		t.Errorf("Expected the source error, got %v", err)
+	}
+
+	// Corrupt the middle of the deflate data
+	corrupt := bytes.Clone(compressed)
+	for i := len(corrupt) / 2; i < len(corrupt)/2+64; i++ {
+		corrupt[i] ^= 0xff
+	}
+	d, err = OpenDictzip(bytes.NewReader(corrupt), int64(len(corrupt)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ReadAt(make([]byte, len(data)), 0); !errors.Is(err, ErrCorruptStream) {
+		t.Errorf("Expected ErrCorruptStream, got %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package compression
+
+import "math"
+
+// entropySample is the number of input bytes probed by WithEntropyCheck, and
+// entropyThreshold the entropy in bits per byte above which input is
+// considered random. Random 4 KiB samples measure about 7.95.
+const (
+	entropySample    = 4096
+	entropyThreshold = 7.8
+)
+
+// WithEntropyCheck probes the first bytes written to every writer and calls
+// the warning hook, once per middleware, if they look random. Random input
+// usually means the middleware is wrapped outside an encryption middleware,
+// compressing ciphertext, which wastes CPU without saving space; compression
+// has to happen before encryption. Already compressed input such as images
+// triggers the warning as well.
+func WithEntropyCheck() Option {
+	return func(m *Middleware) {
+		m.entropyCheck = true
+	}
+}
+
+// probeEntropy collects the start of the input of w and checks its entropy
+// once the sample is complete
+func (w *writer) probeEntropy(p []byte) {
+	if w.probed {
+		return
+	}
+	w.probe = append(w.probe, p[:min(len(p), entropySample-len(w.probe))]...)
+	if len(w.probe) < entropySample {
+		return
+	}
+	if e := entropy(w.probe); e > entropyThreshold && !w.m.entropyWarned.Swap(true) {
+		w.m.warnf("compression input has %.2f bits of entropy per byte; the data looks encrypted or already compressed, compress before encrypting", e)
+	}
+	w.probe, w.probed = nil, true
+}
+
+// entropy returns the Shannon entropy of p in bits per byte
+func entropy(p []byte) float64 {
+	var counts [256]int
+	for _, b := range p {
+		counts[b]++
+	}
+	var e float64
+	for _, c := range counts {
+		if c > 0 {
+			f := float64(c) / float64(len(p))
+			e -= f * math.Log2(f)
+		}
+	}
+	return e
+}
@@ -0,0 +1,48 @@
+package compression
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+func TestWithEntropyCheck(t *testing.T) {
+	requireZstd(t)
+	var warnings []string
+	m := New(Zstd, WithEntropyCheck(), WithWarningHook(func(msg string) { warnings = append(warnings, msg) }))
+
+	plain := bytes.Repeat([]byte("plain text compresses well "), 500)
+	w := m.Writer(io.Discard)
+	w.Write(plain)
+	w.(io.Closer).Close()
+	if len(warnings) != 0 {
+		t.Fatalf("Expected no warning for plain text, got %v", warnings)
+	}
+
+	ciphertext := make([]byte, 3*entropySample)
+	rand.Read(ciphertext)
+	for i := 0; i < 2; i++ {
+		w := m.Writer(io.Discard)
+		// The sample may span several writes
+		w.Write(ciphertext[:100])
+		w.Write(ciphertext[100:])
+		w.(io.Closer).Close()
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("Expected exactly one warning, got %v", warnings)
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	if e := entropy(bytes.Repeat([]byte{'a'}, 100)); e != 0 {
+		t.Fatalf("Expected 0 bits for a constant, got %v", e)
+	}
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	if e := entropy(all); e != 8 {
+		t.Fatalf("Expected 8 bits for uniform bytes, got %v", e)
+	}
+}
@@ -0,0 +1,55 @@
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// EOFMode controls how readers treat a source that ends cleanly in the middle
+// of a frame, see WithEOFMode
+type EOFMode int
+
+const (
+	// EOFStrict fails with a TruncatedError matching ErrTruncated, for
+	// storage where a cut stream means data loss. It is the default.
+	EOFStrict EOFMode = iota
+	// EOFLenient ends the stream with io.EOF after the data decoded before
+	// the cut, for tailing network streams that may stop at any point
+	EOFLenient
+)
+
+var eofModeNames = map[EOFMode]string{
+	EOFStrict:  "strict",
+	EOFLenient: "lenient",
+}
+
+// String returns the lower-case name of the mode
+func (m EOFMode) String() string {
+	if name, ok := eofModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("EOFMode(%d)", int(m))
+}
+
+// WithEOFMode sets how readers treat a source returning io.EOF in the middle
+// of a frame. With EOFLenient the data decoded before the cut is returned
+// and Read then reports io.EOF, WriteTo and DecodeInto succeed. Tolerated
+// truncations are still counted in Stats.Corruptions and reported to the
+// corruption callback. Errors of the source other than io.EOF are returned
+// in both modes.
+func WithEOFMode(mode EOFMode) Option {
+	return func(m *Middleware) {
+		m.eofMode = mode
+	}
+}
+
+// tolerate returns io.EOF for a truncation at a clean EOF of the source if
+// the middleware tolerates it, see WithEOFMode
+func (r *reader) tolerate(err error) error {
+	var truncated *TruncatedError
+	if r.m.eofMode == EOFLenient && r.in != nil && r.in.eof.Load() && errors.As(err, &truncated) {
+		return io.EOF
+	}
+	return err
+}
@@ -0,0 +1,65 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestWithEOFMode(t *testing.T) {
+	data := snapshots(t, "abcdefgh", 16<<10)
+	for name, opts := range map[string][]Option{
+		"gzip":   nil,
+		"framed": {WithFrameSize(16 << 10)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			stream := writeContainer(t, New(Gzip, opts...), data)
+			cut := stream[:len(stream)/2]
+
+			strict := New(Gzip, opts...)
+			if _, err := io.ReadAll(strict.Reader(bytes.NewReader(cut))); !errors.Is(err, ErrTruncated) {
+				t.Fatalf("Expected ErrTruncated by default, got %v", err)
+			}
+
+			var events []CorruptionEvent
+			lenient := New(Gzip, append(opts, WithEOFMode(EOFLenient), WithCorruptionCallback(func(e CorruptionEvent) {
+				events = append(events, e)
+			}))...)
+			got, err := io.ReadAll(lenient.Reader(bytes.NewReader(cut)))
+			if err != nil || len(got) == 0 || !bytes.Equal(got, data[:len(got)]) {
+				t.Fatalf("Expected the data before the cut without an error, got %d bytes: %v", len(got), err)
+			}
+			var out bytes.Buffer
+			if n, err := io.Copy(&out, lenient.Reader(bytes.NewReader(cut))); err != nil || n != int64(len(got)) {
+				t.Fatalf("Expected WriteTo to copy %d bytes without an error, got %d: %v", len(got), n, err)
+			}
+			// Tolerated truncations are still reported
+			if len(events) != 2 || events[0].Kind != CorruptionTruncated || lenient.Stats().Corruptions != 2 {
+				t.Fatalf("Expected two truncation events, got %+v", events)
+			}
+
+			// Complete streams are unaffected
+			if got, err := io.ReadAll(lenient.Reader(bytes.NewReader(stream))); err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("Failed to read the complete stream: %v", err)
+			}
+		})
+	}
+}
+
+func TestWithEOFMode_SourceError(t *testing.T) {
+	stream := writeContainer(t, New(Gzip), snapshots(t, "ab", 16<<10))
+	errSource := errors.New("connection reset")
+	m := New(Gzip, WithEOFMode(EOFLenient))
+	src := io.MultiReader(bytes.NewReader(stream[:len(stream)/2]), iotest.ErrReader(errSource))
+	if _, err := io.ReadAll(m.Reader(src)); !errors.Is(err, errSource) {
+		t.Fatalf("Expected the source error, got %v", err)
+	}
+}
+
+func TestEOFMode_String(t *testing.T) {
+	if EOFStrict.String() != "strict" || EOFLenient.String() != "lenient" || EOFMode(9).String() != "EOFMode(9)" {
+		t.Fatalf("Unexpected names %s, %s, %s", EOFStrict, EOFLenient, EOFMode(9))
+	}
+}
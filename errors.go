@@ -0,0 +1,71 @@
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrUnsupportedAlgorithm is returned for algorithms that are unknown or
+	// not compiled into this binary, e.g. zstd in builds with the nozstd tag
+	ErrUnsupportedAlgorithm = errors.New("compression: unsupported algorithm")
+	// ErrLimitExceeded is wrapped by the errors of all limits, such as
+	// ErrGlobalLimit, ErrQuotaExceeded and ErrMemoryBudget, and by decoders
+	// refusing streams that exceed their configured window
+	ErrLimitExceeded = errors.New("compression: limit exceeded")
+	// ErrDictionaryMissing is returned by readers of streams compressed with a
+	// dictionary the reader does not have
+	ErrDictionaryMissing = errors.New("compression: dictionary missing")
+	// ErrTruncated is matched by the TruncatedError of readers of streams
+	// that end early, see WithEOFMode
+	ErrTruncated = errors.New("compression: stream truncated")
+	// ErrInvalidLevel is returned for levels other than the Level constants
+	ErrInvalidLevel = errors.New("compression: invalid level")
+)
+
+// sentinelError adds a sentinel to a codec error without changing its
+// message, so callers can match both with errors.Is
+type sentinelError struct {
+	err      error
+	sentinel error
+}
+
+func (e *sentinelError) Error() string {
+	return e.err.Error()
+}
+
+func (e *sentinelError) Unwrap() []error {
+	return []error{e.err, e.sentinel}
+}
+
+// classifyError wraps the codec error err returned by a reader or writer
+// into the matching sentinel: ErrCorruptStream for checksum mismatches and undecodable
+// data, ErrLimitExceeded and ErrDictionaryMissing. Truncated streams keep
+// io.ErrUnexpectedEOF, errors of the source are returned unchanged.
+func classifyError(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	for _, s := range []error{ErrCorruptStream, ErrLimitExceeded, ErrDictionaryMissing, ErrUnsupportedAlgorithm} {
+		if errors.Is(err, s) {
+			return err
+		}
+	}
+	if sentinel := zstdSentinel(err); sentinel != nil {
+		return &sentinelError{err, sentinel}
+	}
+	if kind, ok := corruptionKind(err); ok && kind != CorruptionTruncated {
+		return &sentinelError{err, ErrCorruptStream}
+	}
+	return err
+}
+
+// unavailableAlgorithm is the error for an algorithm recorded in a stream
+// that this build cannot decode. Values no build knows indicate corruption.
+func unavailableAlgorithm(a Algorithm) error {
+	if _, ok := algorithmNames[a]; ok {
+		return fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, a)
+	}
+	return fmt.Errorf("%w: unknown algorithm %s", ErrCorruptStream, a)
+}
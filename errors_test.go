@@ -0,0 +1,97 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+func TestErrUnsupportedAlgorithm(t *testing.T) {
+	if _, err := ParseAlgorithm("lz4"); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("Expected ErrUnsupportedAlgorithm from ParseAlgorithm, got %v", err)
+	}
+	if _, err := New(Algorithm(200)).WriterE(io.Discard); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("Expected ErrUnsupportedAlgorithm from WriterE, got %v", err)
+	}
+	if _, err := New(Algorithm(200)).ReaderE(bytes.NewReader(nil)); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("Expected ErrUnsupportedAlgorithm from ReaderE, got %v", err)
+	}
+}
+
+func TestErrCorruptStream_Codecs(t *testing.T) {
+	requireZstd(t)
+	data := bytes.Repeat([]byte("sentinel errors for corrupt streams "), 500)
+	for _, a := range []Algorithm{Gzip, Zstd, S2, Zlib, Flate, Bzip2} {
+		t.Run(a.String(), func(t *testing.T) {
+			m := New(a)
+			var buf bytes.Buffer
+			w := m.Writer(&buf)
+			w.Write(data)
+			w.(io.Closer).Close()
+			p := buf.Bytes()
+			for i := len(p) / 2; i < len(p)/2+8; i++ {
+				p[i] ^= 0xff
+			}
+			_, err := io.ReadAll(m.Reader(bytes.NewReader(p)))
+			if !errors.Is(err, ErrCorruptStream) {
+				t.Fatalf("Expected ErrCorruptStream, got %v", err)
+			}
+		})
+	}
+}
+
+func TestErrCorruptStream_KeepsCodecError(t *testing.T) {
+	var buf bytes.Buffer
+	w := New(Gzip).Writer(&buf)
+	w.Write([]byte("checksummed by gzip"))
+	w.(io.Closer).Close()
+	p := buf.Bytes()
+	p[len(p)-5] ^= 0xff
+
+	_, err := io.ReadAll(New(Gzip).Reader(bytes.NewReader(p)))
+	if !errors.Is(err, ErrCorruptStream) || !errors.Is(err, gzip.ErrChecksum) {
+		t.Fatalf("Expected ErrCorruptStream wrapping gzip.ErrChecksum, got %v", err)
+	}
+	if err.Error() != gzip.ErrChecksum.Error() {
+		t.Errorf("Expected the codec message, got %q", err.Error())
+	}
+
+	// truncation and source errors are not corruption sentinels
+	_, err = io.ReadAll(New(Gzip).Reader(bytes.NewReader(p[:len(p)/2])))
+	if !errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrCorruptStream) {
+		t.Errorf("Expected io.ErrUnexpectedEOF only, got %v", err)
+	}
+	src := io.MultiReader(bytes.NewReader(p[:20]), &errReader{errOutage})
+	if _, err = io.ReadAll(New(Gzip).Reader(src)); err != errOutage {
+		t.Errorf("Expected the source error unchanged, got %v", err)
+	}
+}
+
+func TestErrLimitExceeded(t *testing.T) {
+	for _, err := range []error{ErrGlobalLimit, ErrQuotaExceeded, ErrMemoryBudget} {
+		if !errors.Is(err, ErrLimitExceeded) {
+			t.Errorf("Expected %v to wrap ErrLimitExceeded", err)
+		}
+	}
+	_, err := New(Zstd, WithMemoryBudget(1)).Writer(io.Discard).Write([]byte("x"))
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Expected ErrLimitExceeded for a tiny budget, got %v", err)
+	}
+}
+
+func TestErrDictionaryMissing(t *testing.T) {
+	requireZstd(t)
+	payload := []byte(`{"id":1,"name":"alice","email":"alice@example.com","active":true}`)
+	var buf bytes.Buffer
+	w := New(Zstd, WithBuiltinDictionary(JSONDict)).Writer(&buf)
+	w.Write(payload)
+	w.(io.Closer).Close()
+
+	_, err := io.ReadAll(New(Zstd).Reader(&buf))
+	if !errors.Is(err, ErrDictionaryMissing) {
+		t.Fatalf("Expected ErrDictionaryMissing, got %v", err)
+	}
+}
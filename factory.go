@@ -0,0 +1,130 @@
+package compression
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ErrQuotaExceeded is returned when a stream would exceed the middleware quota
+var ErrQuotaExceeded = fmt.Errorf("%w: quota", ErrLimitExceeded)
+
+// Quota limits the resources a middleware may consume. Zero values mean
+// unlimited.
+type Quota struct {
+	// MaxActiveWriters limits the number of concurrently open writers
+	MaxActiveWriters int64
+	// MaxBytes limits the total uncompressed bytes accepted by all writers
+	MaxBytes int64
+}
+
+// acquire registers a new writer against the quota
+func (m *Middleware) acquire() error {
+	active := m.stats.activeWriters.Add(1)
+	if m.quota.MaxActiveWriters > 0 && active > m.quota.MaxActiveWriters {
+		m.stats.activeWriters.Add(-1)
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+func (m *Middleware) release() {
+	m.stats.activeWriters.Add(-1)
+}
+
+// reserve reserves n more uncompressed bytes of the quota. The reservation
+// is a compare-and-swap, so concurrent writers cannot overshoot the quota.
+func (m *Middleware) reserve(n int) error {
+	if m.quota.MaxBytes <= 0 {
+		return nil
+	}
+	for {
+		used := m.quotaBytes.Load()
+		if used+int64(n) > m.quota.MaxBytes {
+			return ErrQuotaExceeded
+		}
+		if m.quotaBytes.CompareAndSwap(used, used+int64(n)) {
+			return nil
+		}
+	}
+}
+
+// unreserve returns n reserved bytes that were not written
+func (m *Middleware) unreserve(n int) {
+	if m.quota.MaxBytes > 0 && n > 0 {
+		m.quotaBytes.Add(-int64(n))
+	}
+}
+
+// Config describes a middleware configuration as a plain value
+type Config struct {
+	Algorithm Algorithm
+	Level     Level
+	// Dictionary is a zstd dictionary, see WithZstdDictionary
+	Dictionary []byte
+	Quota      Quota
+	// FrameSize enables framed streams if positive, see WithFrameSize
+	FrameSize int
+	// Matrix holds tuned settings per algorithm, see WithLevelMatrix
+	Matrix LevelMatrix
+}
+
+// Options returns the options equivalent to the config
+func (c Config) Options() []Option {
+	opts := []Option{
+		WithLevel(c.Level),
+		WithZstdDictionary(c.Dictionary),
+		WithQuota(c.Quota),
+	}
+	if c.FrameSize > 0 {
+		opts = append(opts, WithFrameSize(c.FrameSize))
+	}
+	if c.Matrix != nil {
+		opts = append(opts, WithLevelMatrix(c.Matrix))
+	}
+	return opts
+}
+
+// Factory creates per-tenant middleware from a shared default config.
+// Each tenant gets its own encoder pool, stats and quota, so the load of
+// one tenant does not affect the others, while dictionaries are shared.
+type Factory struct {
+	defaults Config
+
+	mu      sync.Mutex
+	tenants map[string]*Middleware
+}
+
+// NewFactory creates a factory producing middleware configured by defaults
+func NewFactory(defaults Config) *Factory {
+	return &Factory{
+		defaults: defaults,
+		tenants:  make(map[string]*Middleware),
+	}
+}
+
+// Tenant returns the middleware of the given tenant, creating it on first
+// use. The options are applied on top of the defaults when the middleware is
+// created and ignored afterwards.
+func (f *Factory) Tenant(id string, opts ...Option) *Middleware {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if m, ok := f.tenants[id]; ok {
+		return m
+	}
+	m := New(f.defaults.Algorithm, append(f.defaults.Options(), opts...)...)
+	f.tenants[id] = m
+	return m
+}
+
+// Stats returns a stats snapshot for every tenant created so far
+func (f *Factory) Stats() map[string]Stats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	stats := make(map[string]Stats, len(f.tenants))
+	for id, m := range f.tenants {
+		stats[id] = m.Stats()
+	}
+	return stats
+}
@@ -0,0 +1,131 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestFactory_TenantIsolation(t *testing.T) {
+	requireZstd(t)
+	f := NewFactory(Config{Algorithm: Zstd, Quota: Quota{MaxBytes: 1024}})
+
+	a := f.Tenant("a")
+	b := f.Tenant("b")
+	if a == b {
+		t.Fatal("Expected separate middleware per tenant")
+	}
+	if f.Tenant("a") != a {
+		t.Fatal("Expected the same middleware for the same tenant")
+	}
+
+	// Exhaust the quota of tenant a
+	w := a.Writer(io.Discard)
+	if _, err := w.Write(make([]byte, 1024)); err != nil {
+		t.Fatalf("Failed to write within quota: %v", err)
+	}
+	if _, err := w.Write([]byte{0}); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected ErrQuotaExceeded, got %v", err)
+	}
+	w.(io.Closer).Close()
+
+	// Tenant b is unaffected
+	var buf bytes.Buffer
+	w = b.Writer(&buf)
+	if _, err := w.Write(make([]byte, 512)); err != nil {
+		t.Fatalf("Tenant b failed to write: %v", err)
+	}
+	w.(io.Closer).Close()
+
+	stats := f.Stats()
+	if stats["a"].BytesWritten != 1024 || stats["b"].BytesWritten != 512 {
+		t.Fatalf("Unexpected per-tenant stats: %+v", stats)
+	}
+}
+
+func TestFactory_SharedDictionary(t *testing.T) {
+	requireZstd(t)
+	sample := []byte(`{"level":"info","service":"billing","message":"request handled"}`)
+	dict := testZstdDictionary(t, 1)
+
+	f := NewFactory(Config{Algorithm: Zstd, Dictionary: dict})
+
+	var buf bytes.Buffer
+	w := f.Tenant("a").Writer(&buf)
+	w.Write(sample)
+	w.(io.Closer).Close()
+
+	// A reader of another tenant decodes with the same dictionary
+	data, err := io.ReadAll(f.Tenant("b").Reader(&buf))
+	if err != nil {
+		t.Fatalf("Failed to decompress: %v", err)
+	}
+	if !bytes.Equal(data, sample) {
+		t.Fatal("Data mismatch")
+	}
+}
+
+func TestQuota_MaxActiveWriters(t *testing.T) {
+	m := New(S2, WithQuota(Quota{MaxActiveWriters: 1}))
+
+	w1 := m.Writer(io.Discard)
+	w2 := m.Writer(io.Discard)
+	if _, err := w2.Write([]byte("x")); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Expected ErrQuotaExceeded, got %v", err)
+	}
+
+	w1.(io.Closer).Close()
+	w3 := m.Writer(io.Discard)
+	if _, err := w3.Write([]byte("x")); err != nil {
+		t.Fatalf("Expected writer after close to succeed, got %v", err)
+	}
+	w3.(io.Closer).Close()
+}
+
+func TestQuota_MaxBytesConcurrent(t *testing.T) {
+	m := New(S2, WithQuota(Quota{MaxBytes: 5000}))
+	var written atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := m.Writer(io.Discard)
+			defer w.(io.Closer).Close()
+			for j := 0; j < 100; j++ {
+				n, _ := w.Write(make([]byte, 100))
+				written.Add(int64(n))
+			}
+		}()
+	}
+	wg.Wait()
+	if got := written.Load(); got != 5000 {
+		t.Fatalf("Expected exactly the quota of 5000 bytes written, got %d", got)
+	}
+}
+
+// testZstdDictionary builds a small zstd dictionary for JSON log lines
+func testZstdDictionary(t testing.TB, id uint32) []byte {
+	t.Helper()
+	var contents [][]byte
+	for i := 0; i < 64; i++ {
+		contents = append(contents, []byte(fmt.Sprintf(
+			`{"level":"info","service":"svc-%d","message":"request %d handled in %dms"}`, i%7, i*31, i%13)))
+	}
+	dict, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       id,
+		Contents: contents,
+		History:  bytes.Join(contents[:16], nil),
+		Offsets:  [3]int{1, 4, 8},
+	})
+	if err != nil {
+		t.Fatalf("Failed to build dictionary: %v", err)
+	}
+	return dict
+}
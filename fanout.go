@@ -0,0 +1,186 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+var (
+	// ErrSinkTooSlow is recorded for fan out sinks that fell further behind
+	// than their queue allows
+	ErrSinkTooSlow = errors.New("compression: fan out sink too slow")
+	// ErrAllSinksFailed is returned by FanOut writers once no sink is left
+	ErrAllSinksFailed = errors.New("compression: all fan out sinks failed")
+)
+
+// DefaultFanOutQueue is the number of chunks a fan out sink may lag behind
+const DefaultFanOutQueue = 64
+
+// FanOut replicates one compressed stream to several sinks. The stream is
+// compressed once; the compressed output of every Write, Flush and Close
+// call is delivered to each sink as one chunk by a goroutine per sink, so
+// sinks never see partial frames and a slow or failing sink does not block
+// or corrupt the others. Configure the exported fields before the first
+// Write.
+type FanOut struct {
+	// Retry is called when writing a chunk to a sink fails. Returning true
+	// retries the chunk, false detaches the sink. The default detaches.
+	Retry func(sink int, attempt int, err error) bool
+	// Queue is the number of chunks a sink may lag behind before it is
+	// detached with ErrSinkTooSlow. The default is DefaultFanOutQueue.
+	Queue int
+
+	w      io.Writer
+	buf    bytes.Buffer
+	sinks  []*fanOutSink
+	once   sync.Once
+	wg     sync.WaitGroup
+	closed bool
+}
+
+type fanOutSink struct {
+	w      io.Writer
+	chunks chan []byte
+
+	mu  sync.Mutex
+	err error
+}
+
+// FanOut creates a writer replicating the compressed stream to sinks
+func (m *Middleware) FanOut(sinks ...io.Writer) *FanOut {
+	f := &FanOut{}
+	for _, w := range sinks {
+		f.sinks = append(f.sinks, &fanOutSink{w: w})
+	}
+	f.w = m.Writer(&f.buf)
+	return f
+}
+
+func (f *FanOut) start() {
+	queue := f.Queue
+	if queue <= 0 {
+		queue = DefaultFanOutQueue
+	}
+	for i, s := range f.sinks {
+		s.chunks = make(chan []byte, queue)
+		f.wg.Add(1)
+		go f.run(i, s)
+	}
+}
+
+func (f *FanOut) run(i int, s *fanOutSink) {
+	defer f.wg.Done()
+	for chunk := range s.chunks {
+		if s.failed() {
+			continue
+		}
+		for attempt := 1; ; attempt++ {
+			_, err := s.w.Write(chunk)
+			if err == nil {
+				break
+			}
+			if f.Retry == nil || !f.Retry(i, attempt, err) {
+				s.fail(err)
+				break
+			}
+		}
+	}
+}
+
+func (s *fanOutSink) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *fanOutSink) failed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err != nil
+}
+
+// dispatch hands the compressed output produced so far to every sink
+func (f *FanOut) dispatch() error {
+	f.once.Do(f.start)
+	if f.buf.Len() > 0 {
+		chunk := bytes.Clone(f.buf.Bytes())
+		f.buf.Reset()
+		for _, s := range f.sinks {
+			if s.failed() {
+				continue
+			}
+			select {
+			case s.chunks <- chunk:
+			default:
+				s.fail(ErrSinkTooSlow)
+			}
+		}
+	}
+	for _, s := range f.sinks {
+		if !s.failed() {
+			return nil
+		}
+	}
+	return ErrAllSinksFailed
+}
+
+func (f *FanOut) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if dispatchErr := f.dispatch(); err == nil {
+		err = dispatchErr
+	}
+	return n, err
+}
+
+// Flush flushes the compressor and hands the output to the sinks
+func (f *FanOut) Flush() error {
+	if fl, ok := f.w.(interface{ Flush() error }); ok {
+		if err := fl.Flush(); err != nil {
+			return err
+		}
+	}
+	return f.dispatch()
+}
+
+// Close finishes the stream and waits until every sink received it. It
+// returns an error only if all sinks failed; use Errors for the state of
+// individual sinks.
+func (f *FanOut) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	err := f.w.(io.Closer).Close()
+	if dispatchErr := f.dispatch(); err == nil {
+		err = dispatchErr
+	}
+	for _, s := range f.sinks {
+		close(s.chunks)
+	}
+	f.wg.Wait()
+
+	if err == nil {
+		err = ErrAllSinksFailed
+		for _, s := range f.sinks {
+			if !s.failed() {
+				err = nil
+			}
+		}
+	}
+	return err
+}
+
+// Errors returns the error of each sink, nil for healthy sinks
+func (f *FanOut) Errors() []error {
+	errs := make([]error, len(f.sinks))
+	for i, s := range f.sinks {
+		s.mu.Lock()
+		errs[i] = s.err
+		s.mu.Unlock()
+	}
+	return errs
+}
@@ -0,0 +1,57 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFanOut_ErrorIsolation(t *testing.T) {
+	m := New(S2, WithFrameSize(1024))
+	testData := bytes.Repeat([]byte("replicated frames "), 1000)
+
+	var healthy, flaky bytes.Buffer
+	flakyFailures := 2
+	flakySink := writerFunc(func(p []byte) (int, error) {
+		if flakyFailures > 0 {
+			flakyFailures--
+			return 0, errors.New("transient")
+		}
+		return flaky.Write(p)
+	})
+	broken := writerFunc(func(p []byte) (int, error) { return 0, errors.New("replica down") })
+
+	f := m.FanOut(&healthy, flakySink, broken)
+	f.Retry = func(sink int, attempt int, err error) bool {
+		return sink == 1 && attempt < 5
+	}
+	for i := 0; i < len(testData); i += 700 {
+		if _, err := f.Write(testData[i:min(i+700, len(testData))]); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	errs := f.Errors()
+	if errs[0] != nil || errs[1] != nil || errs[2] == nil {
+		t.Fatalf("Unexpected sink errors %v", errs)
+	}
+	for _, sink := range []*bytes.Buffer{&healthy, &flaky} {
+		data, err := io.ReadAll(m.Reader(bytes.NewReader(sink.Bytes())))
+		if err != nil || !bytes.Equal(data, testData) {
+			t.Fatalf("Replica data mismatch: %v", err)
+		}
+	}
+}
+
+func TestFanOut_AllSinksFailed(t *testing.T) {
+	broken := writerFunc(func(p []byte) (int, error) { return 0, errors.New("down") })
+	f := New(Gzip).FanOut(broken)
+	f.Write([]byte("data"))
+	if err := f.Close(); err != ErrAllSinksFailed {
+		t.Fatalf("Expected ErrAllSinksFailed, got %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package compression
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"sync"
+)
+
+// fileBufferSize is the size of the buffers used to copy to and from files
+const fileBufferSize = 1 << 20
+
+// ErrFileTruncated is returned by CompressFromFile if the file shrinks while
+// it is being compressed
+var ErrFileTruncated = errors.New("compression: file truncated while being compressed")
+
+var fileBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, fileBufferSize)
+		return &b
+	},
+}
+
+// DecompressToFile decompresses r into the file at path, which is created or
+// truncated. When the uncompressed size is known up front, from the index of
+// a framed stream read from an io.ReadSeeker such as *os.File or from the
+// zstd frame header, the file is preallocated to avoid fragmentation. As the
+// size is taken from the input, the preallocation is capped at the limits of
+// WithMaxDecompressedSize and WithMemoryBudget. It returns the number of
+// bytes written; on error, including a failed preallocation, the file is
+// removed.
+func (m *Middleware) DecompressToFile(r io.Reader, path string) (n int64, err error) {
+	size, r := m.uncompressedSize(r)
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+	if size = m.preallocation(size); size > 0 && !m.sparseFiles {
+		// Preallocated blocks would defeat holes
+		if err := preallocate(f, size); err != nil {
+			return 0, err
+		}
+	}
+
+	dec := m.Reader(r)
+	if c, ok := dec.(io.Closer); ok {
+		defer c.Close()
+	}
+	if m.frameSize > 0 {
+		// Framed readers write whole frames, possibly in the kernel
+		n, err = io.Copy(f, dec)
+	} else {
+		var dst io.Writer = f
+		sparse := &sparseWriter{f: f}
+		if m.sparseFiles {
+			dst = sparse
+		}
+		buf := fileBuffers.Get().(*[]byte)
+		defer fileBuffers.Put(buf)
+		// Hide WriterTo and ReaderFrom to copy with the large buffer
+		n, err = io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{dec}, *buf)
+		if err == nil {
+			err = sparse.finish()
+		}
+	}
+	if err != nil {
+		return n, err
+	}
+	if n != size {
+		// The size was only a hint, drop the preallocated tail
+		err = f.Truncate(n)
+	}
+	return n, err
+}
+
+// preallocation caps the preallocation of a file for an uncompressed size
+// taken from untrusted input at the read limits
+func (m *Middleware) preallocation(size int64) int64 {
+	if m.maxDecoded > 0 {
+		size = min(size, m.maxDecoded)
+	}
+	if m.memoryBudget > 0 {
+		size = min(size, m.memoryBudget)
+	}
+	return size
+}
+
+// uncompressedSize returns the uncompressed size of the stream r if it can be
+// determined without consuming it, or 0. The returned reader must be used in
+// place of r.
+func (m *Middleware) uncompressedSize(r io.Reader) (int64, io.Reader) {
+	if m.frameSize > 0 {
+		rs, ok := r.(interface {
+			io.ReaderAt
+			io.Seeker
+		})
+		if !ok {
+			return 0, r
+		}
+		start, err := rs.Seek(0, io.SeekCurrent)
+		if err != nil || start != 0 {
+			return 0, r
+		}
+		end, err := rs.Seek(0, io.SeekEnd)
+		if _, serr := rs.Seek(start, io.SeekStart); err != nil || serr != nil {
+			return 0, r
+		}
+		index, err := ReadContainerIndex(rs, end)
+		if err != nil || len(index) == 0 {
+			return 0, r
+		}
+		last := index[len(index)-1]
+		return last.UncompressedOffset + last.Size, r
+	}
+
+	if m.algorithm == Zstd && zstdAvailable {
+		br := bufio.NewReader(r)
+		return zstdContentSize(br), br
+	}
+	return 0, r
+}
+
+// CompressFromFile compresses the file at path into w and returns the number
+// of uncompressed bytes read. Regular files are memory-mapped where supported
+// and fed to the encoder in chunks of fileBufferSize bytes; otherwise the file
+// is read through a large buffer. Accessing a mapped page past the end of a
+// truncated file raises SIGBUS, so the size is checked before every chunk
+// and the call fails with ErrFileTruncated if the file shrank. A truncation
+// racing with the access of a chunk is also reported as ErrFileTruncated,
+// except for encoders reading the data in other goroutines, such as
+// parallel gzip, which then crash: files that may be truncated concurrently
+// should not be compressed with this method.
+func (m *Middleware) CompressFromFile(path string, w io.Writer) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	enc := m.Writer(w)
+	var n int64
+	if data, ok := mapFile(f); ok {
+		defer munmapFile(data)
+		if d, ok := enc.(SizeDeclarer); ok {
+			if err := d.DeclareSize(int64(len(data))); err != nil {
+				enc.(io.Closer).Close()
+				return 0, err
+			}
+		}
+		for len(data) > 0 {
+			chunk := data[:min(len(data), fileBufferSize)]
+			if fi, err := f.Stat(); err != nil || fi.Size() < n+int64(len(chunk)) {
+				enc.(io.Closer).Close()
+				return n, fmt.Errorf("%w: %s", ErrFileTruncated, path)
+			}
+			written, err := writeMapped(enc, chunk)
+			n += int64(written)
+			if err != nil {
+				enc.(io.Closer).Close()
+				return n, err
+			}
+			data = data[len(chunk):]
+		}
+	} else {
+		buf := fileBuffers.Get().(*[]byte)
+		defer fileBuffers.Put(buf)
+		n, err = io.CopyBuffer(struct{ io.Writer }{enc}, struct{ io.Reader }{f}, *buf)
+		if err != nil {
+			enc.(io.Closer).Close()
+			return n, err
+		}
+	}
+	return n, enc.(io.Closer).Close()
+}
+
+// writeMapped writes the mapped chunk to enc, reporting a fault on a page of
+// the file truncated meanwhile as ErrFileTruncated instead of crashing
+func writeMapped(enc io.Writer, chunk []byte) (n int, err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(interface{ Addr() uintptr }); !ok {
+				panic(r)
+			}
+			err = ErrFileTruncated
+		}
+	}()
+	return enc.Write(chunk)
+}
+
+// mapFile memory-maps f if it is a non-empty regular file that fits into the
+// address space
+func mapFile(f *os.File) ([]byte, bool) {
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() || fi.Size() == 0 || int64(int(fi.Size())) != fi.Size() {
+		return nil, false
+	}
+	data, err := mmapFile(f, int(fi.Size()))
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
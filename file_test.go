@@ -0,0 +1,154 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestDecompressToFile(t *testing.T) {
+	requireZstd(t)
+	data := bytes.Repeat([]byte("restore me "), 50000)
+	dir := t.TempDir()
+
+	for _, tc := range []struct {
+		name string
+		m    *Middleware
+	}{
+		{"gzip", New(Gzip)},
+		{"zstd", New(Zstd)},
+		{"framed", New(Zstd, WithFrameSize(64<<10))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			src := filepath.Join(dir, tc.name+".z")
+			f, err := os.Create(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w := tc.m.Writer(f)
+			w.Write(data)
+			w.(io.Closer).Close()
+			f.Close()
+
+			f, err = os.Open(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			dst := filepath.Join(dir, tc.name+".out")
+			n, err := tc.m.DecompressToFile(f, dst)
+			if err != nil {
+				t.Fatalf("Failed to decompress: %v", err)
+			}
+			if n != int64(len(data)) {
+				t.Fatalf("Expected %d bytes, got %d", len(data), n)
+			}
+			got, _ := os.ReadFile(dst)
+			if !bytes.Equal(got, data) {
+				t.Fatal("Decompressed file does not match")
+			}
+		})
+	}
+}
+
+func TestDecompressToFile_Size(t *testing.T) {
+	requireZstd(t)
+	data := bytes.Repeat([]byte("sized "), 30000)
+	m := New(Zstd, WithFrameSize(16<<10))
+	compressed := writeContainer(t, m, data)
+
+	if size, _ := m.uncompressedSize(bytes.NewReader(compressed)); size != int64(len(data)) {
+		t.Fatalf("Expected size %d from the index, got %d", len(data), size)
+	}
+	if size, _ := m.uncompressedSize(bytes.NewBuffer(compressed)); size != 0 {
+		t.Fatalf("Expected no size for a non-seekable reader, got %d", size)
+	}
+
+	// The size is taken from the input, the preallocation is capped
+	if got := New(Zstd, WithMaxDecompressedSize(1<<20)).preallocation(1 << 40); got != 1<<20 {
+		t.Errorf("Expected the preallocation capped at the decompressed size limit, got %d", got)
+	}
+	if got := New(Zstd, WithMemoryBudget(32<<20)).preallocation(1 << 40); got != 32<<20 {
+		t.Errorf("Expected the preallocation capped at the memory budget, got %d", got)
+	}
+}
+
+func TestDecompressToFile_Corrupt(t *testing.T) {
+	requireZstd(t)
+	dst := filepath.Join(t.TempDir(), "out")
+	if _, err := New(Zstd).DecompressToFile(bytes.NewReader([]byte("not zstd at all")), dst); err == nil {
+		t.Fatal("Expected error for corrupt input")
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Fatal("Expected the destination to be removed")
+	}
+}
+
+func TestCompressFromFile(t *testing.T) {
+	requireZstd(t)
+	data := bytes.Repeat([]byte("back me up "), 300000)
+	src := filepath.Join(t.TempDir(), "src")
+	if err := os.WriteFile(src, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range []*Middleware{New(S2), New(Zstd, WithFrameSize(256<<10))} {
+		var buf bytes.Buffer
+		n, err := m.CompressFromFile(src, &buf)
+		if err != nil {
+			t.Fatalf("Failed to compress: %v", err)
+		}
+		if n != int64(len(data)) {
+			t.Fatalf("Expected %d bytes read, got %d", len(data), n)
+		}
+		got, _ := io.ReadAll(m.Reader(&buf))
+		if !bytes.Equal(got, data) {
+			t.Fatal("Round trip mismatch")
+		}
+	}
+}
+
+func TestCompressFromFile_NotRegular(t *testing.T) {
+	// Empty files and other files that cannot be mapped use buffered reads
+	src := filepath.Join(t.TempDir(), "empty")
+	os.WriteFile(src, nil, 0o644)
+
+	m := New(Gzip)
+	var buf bytes.Buffer
+	if n, err := m.CompressFromFile(src, &buf); err != nil || n != 0 {
+		t.Fatalf("Expected empty compression, got n=%d err=%v", n, err)
+	}
+	if got, _ := io.ReadAll(m.Reader(&buf)); len(got) != 0 {
+		t.Fatalf("Expected empty output, got %d bytes", len(got))
+	}
+}
+
+// truncatingWriter truncates the file at path on the first write
+type truncatingWriter struct {
+	path string
+	once sync.Once
+}
+
+func (w *truncatingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { os.Truncate(w.path, 0) })
+	return len(p), nil
+}
+
+func TestCompressFromFile_Truncated(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("files are only mapped on linux")
+	}
+	src := filepath.Join(t.TempDir(), "src")
+	if err := os.WriteFile(src, bytes.Repeat([]byte("shrinking "), 400000), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := New(S2, WithFrameSize(64<<10))
+	if _, err := m.CompressFromFile(src, &truncatingWriter{path: src}); !errors.Is(err, ErrFileTruncated) {
+		t.Fatalf("Expected ErrFileTruncated, got %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestWithFlushCallback(t *testing.T) {
+	requireZstd(t)
+	type boundary struct{ compressed, uncompressed int64 }
+	var got []boundary
+	record := WithFlushCallback(func(c, u int64) { got = append(got, boundary{c, u}) })
+
+	// Framed streams report every frame
+	data := bytes.Repeat([]byte("boundary "), 1000)
+	compressed := writeContainer(t, New(Zstd, WithFrameSize(4096), record), data)
+	if len(got) != 3 || got[2].uncompressed != int64(len(data)) {
+		t.Fatalf("framed boundaries = %v", got)
+	}
+	index, err := ReadContainerIndex(bytes.NewReader(compressed), int64(len(compressed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(index); i++ {
+		if got[i-1].compressed != index[i].CompressedOffset || got[i-1].uncompressed != index[i].UncompressedOffset {
+			t.Errorf("boundary %d = %v, next frame at %+v", i-1, got[i-1], index[i])
+		}
+	}
+
+	// Other streams report every flush
+	got = nil
+	var buf bytes.Buffer
+	w := New(Zstd, record).Writer(&buf)
+	w.Write([]byte("first"))
+	w.(interface{ Flush() error }).Flush()
+	if len(got) != 1 || got[0] != (boundary{int64(buf.Len()), 5}) {
+		t.Errorf("flush boundary = %v, want {%d 5}", got, buf.Len())
+	}
+	w.(io.Closer).Close()
+}
@@ -0,0 +1,843 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrCorruptStream is returned when compressed data cannot be decoded
+	ErrCorruptStream = errors.New("compression: corrupt stream")
+	// ErrUnsupportedVersion is returned for containers written by a newer
+	// version of this package
+	ErrUnsupportedVersion = errors.New("compression: unsupported container version")
+)
+
+// Frame record types, specified in header.go
+const (
+	frameEnd    = 0
+	frameData   = 1
+	frameStored = 2
+	frameCoded  = 3
+	// frameChecksum carries the checksum of the uncompressed stream, see
+	// WithChecksum
+	frameChecksum = 4
+	// frameZero is a frame of zero bytes without payload, see
+	// WithSparseDetection
+	frameZero = 5
+	// frameDictionary carries a dictionary for the frames following it, see
+	// WithDictionaryRefresh
+	frameDictionary = 6
+	// frameDedup starts the window of the frameRef frames following it and
+	// frameRef repeats an earlier frame, see WithFrameDedup
+	frameDedup = 7
+	frameRef   = 8
+	// frameAnnotation describes the frame following it, see
+	// WithRatioAnnotations
+	frameAnnotation = 9
+
+	// DefaultFrameSize is the frame size of options that imply framing
+	DefaultFrameSize = 1 << 20
+	// MaxFrameSize is the largest frame size accepted by WithFrameSize
+	MaxFrameSize = 64 << 20
+)
+
+// WithFrameSize splits the stream into independently decodable frames of n
+// uncompressed bytes (the last frame may be shorter), stored in a small
+// container format common to all algorithms. Both the writing and the reading
+// middleware need the option. Frames allow per-frame processing such as
+// encryption or retries downstream. n is capped at MaxFrameSize.
+func WithFrameSize(n int) Option {
+	return func(m *Middleware) {
+		m.frameSize = min(n, MaxFrameSize)
+	}
+}
+
+// FrameSize returns the frame size of framed streams, or 0 if the middleware
+// writes plain streams
+func (m *Middleware) FrameSize() int {
+	return m.frameSize
+}
+
+// WithPerFrameBestOf compresses every frame with all given algorithms
+// concurrently and keeps the smallest result, recording the algorithm per
+// frame. This trades CPU for size, e.g. for archival. It implies framing
+// with DefaultFrameSize unless WithFrameSize is set.
+func WithPerFrameBestOf(algorithms ...Algorithm) Option {
+	return func(m *Middleware) {
+		m.bestOf = algorithms
+		if m.frameSize == 0 {
+			m.frameSize = DefaultFrameSize
+		}
+	}
+}
+
+// WithFrameLevel chooses the compression level of every frame with fn, which
+// is called with the index of the frame in the stream and its uncompressed
+// data, e.g. to write the hot head of a stream fast and the bulk of it with
+// maximum compression. The sample must not be retained. The option implies
+// framing with DefaultFrameSize unless WithFrameSize is set.
+func WithFrameLevel(fn func(frameIdx int, sample []byte) Level) Option {
+	return func(m *Middleware) {
+		m.frameLevel = fn
+		if m.frameSize == 0 {
+			m.frameSize = DefaultFrameSize
+		}
+	}
+}
+
+// WithDictionaryRefresh improves the ratio of long zstd streams whose content
+// drifts over time: every frames frames, the last size bytes written become
+// the dictionary of the following frames. The dictionary is stored in the
+// stream, so frames remain decodable given the dictionary preceding them.
+// It replaces a dictionary set with WithZstdDictionary from the first
+// refresh on and implies framing with DefaultFrameSize unless WithFrameSize
+// is set. Streams without zstd frames are not refreshed.
+func WithDictionaryRefresh(frames, size int) Option {
+	return func(m *Middleware) {
+		m.refreshEvery, m.refreshSize = frames, size
+		if m.frameSize == 0 {
+			m.frameSize = DefaultFrameSize
+		}
+	}
+}
+
+// WithFramePayloadHooks passes the payload of every frame through wrap
+// before it is written and through unwrap after it is read, so that
+// per-frame encryption or signing can be layered on the container without
+// reimplementing the framing. Both functions must not retain their payload.
+// They receive the index of the frame among the wrapped frames, counted from
+// 0, and its type byte, which an AEAD uses as associated data to detect
+// reordered, duplicated or dropped frames; with WithChecksum, the checksum
+// frame is wrapped as well, so that truncated streams are detected. Zero
+// frames are wrapped with an empty payload and keepalives are not wrapped.
+// Frames are never stored uncompressed with hooks, and containers written
+// with hooks are flagged with TrailerHooked and rejected by ExtractFrames and
+// Concat, which renumber their frames. The option implies framing with DefaultFrameSize
+// unless WithFrameSize is set.
+func WithFramePayloadHooks(wrap, unwrap func(idx uint64, typ byte, payload []byte) ([]byte, error)) Option {
+	return func(m *Middleware) {
+		m.wrapPayload, m.unwrapPayload = wrap, unwrap
+		if m.frameSize == 0 {
+			m.frameSize = DefaultFrameSize
+		}
+	}
+}
+
+// framedWriter writes the container format. It buffers up to frameSize bytes
+// and compresses each full buffer into its own frame.
+type framedWriter struct {
+	codecs []*frameCodec
+	buf    []byte
+	size   int
+	cw     containerWriter
+	sparse bool
+	// sum is the checksum of the stream, nil without WithChecksum
+	sum      hash.Hash
+	checksum Checksum
+	// frames counts the frames written, for WithFrameLevel
+	frames int
+	// history holds the most recent data and dictID the current dictionary,
+	// see WithDictionaryRefresh. refreshing is set if a codec uses zstd.
+	refreshing bool
+	history    []byte
+	dictID     uint32
+	// dedup is the window of frames to reference, see WithFrameDedup
+	dedup *dedupWriter
+	// annotate precedes frames with annotations, see WithRatioAnnotations
+	annotate bool
+	// wrapped counts the frames passed through the wrap hook
+	wrapped uint64
+	// maxLag is the lag beyond which frames are stored uncompressed and
+	// since when the data being written was handed to the middleware, see
+	// WithStoredUnderLag
+	maxLag time.Duration
+	since  time.Time
+}
+
+// frameCodec compresses frames with one algorithm
+type frameCodec struct {
+	m         *Middleware
+	algorithm Algorithm
+	level     Level
+	enc       encoder
+	out       bytes.Buffer
+	err       error
+	// encoders holds the encoders of other levels, see WithFrameLevel
+	encoders map[Level]encoder
+	// dict is the raw dictionary with ID dictID, see WithDictionaryRefresh
+	dict   []byte
+	dictID uint32
+}
+
+func newFramedWriter(m *Middleware, l Level, w io.Writer) *framedWriter {
+	f := &framedWriter{buf: make([]byte, 0, m.frameSize), size: m.frameSize, sparse: m.sparse, annotate: m.annotate, maxLag: m.maxLag}
+	algorithms := m.bestOf
+	if len(algorithms) == 0 {
+		algorithms = []Algorithm{m.algorithm}
+	}
+	for _, a := range algorithms {
+		c := &frameCodec{m: m, algorithm: a, level: m.codecLevel(a, l)}
+		c.enc = m.newEncoder(a, c.level, &c.out)
+		f.codecs = append(f.codecs, c)
+		f.refreshing = f.refreshing || a == Zstd && m.refreshEvery > 0
+	}
+	f.cw.reset(w, ContainerVersion, m.algorithm)
+	if m.checksum != 0 {
+		f.sum, f.checksum = m.checksum.new(), m.checksum
+	}
+	if m.dedupFrames > 0 {
+		f.dedup = newDedupWriter(m.dedupFrames)
+	}
+	return f
+}
+
+func (f *framedWriter) Write(p []byte) (int, error) {
+	if f.buf == nil {
+		f.buf = make([]byte, 0, f.size)
+	}
+	defer f.arrived()()
+	written := 0
+	for len(p) > 0 {
+		n := copy(f.buf[len(f.buf):cap(f.buf)], p)
+		f.buf = f.buf[:len(f.buf)+n]
+		p = p[n:]
+		written += n
+
+		if len(f.buf) == cap(f.buf) {
+			if err := f.writeFrame(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// WriteNoCopy compresses full frames directly from p, without copying them
+// into the frame buffer first
+func (f *framedWriter) WriteNoCopy(p []byte) (int, error) {
+	if f.buf == nil {
+		f.buf = make([]byte, 0, f.size)
+	}
+	defer f.arrived()()
+	written := 0
+	if len(f.buf) > 0 {
+		// Complete the buffered frame first
+		n := min(len(p), cap(f.buf)-len(f.buf))
+		if _, err := f.Write(p[:n]); err != nil {
+			return 0, err
+		}
+		written, p = n, p[n:]
+	}
+	for len(p) >= cap(f.buf) {
+		buf := f.buf
+		f.buf = p[:cap(buf)]
+		err := f.writeFrame()
+		f.buf = buf
+		if err != nil {
+			return written, err
+		}
+		written, p = written+cap(buf), p[cap(buf):]
+	}
+	n, err := f.Write(p)
+	return written + n, err
+}
+
+// Flush writes the buffered data as a frame, even if it is not full
+func (f *framedWriter) Flush() error {
+	if len(f.buf) == 0 {
+		return nil
+	}
+	return f.writeFrame()
+}
+
+func (f *framedWriter) Close() error {
+	if err := f.Flush(); err != nil {
+		return err
+	}
+	if f.sum != nil {
+		rec := frameRecord{typ: frameChecksum, checksum: f.checksum, payload: f.sum.Sum(nil)}
+		if err := f.writeRecord(rec); err != nil {
+			return err
+		}
+	}
+	return f.cw.close()
+}
+
+// abort drops the buffered data and ends the container after the frames
+// written so far, flagged as aborted
+func (f *framedWriter) abort() error {
+	f.buf = f.buf[:0]
+	f.cw.flags |= TrailerAborted
+	return f.cw.close()
+}
+
+func (f *framedWriter) Reset(w io.Writer) {
+	f.buf = f.buf[:0]
+	f.frames = 0
+	f.wrapped = 0
+	f.history = f.history[:0]
+	if f.dictID != 0 {
+		f.dictID = 0
+		for _, c := range f.codecs {
+			c.useDictionary(0, nil)
+		}
+	}
+	if f.sum != nil {
+		f.sum.Reset()
+	}
+	if f.dedup != nil {
+		f.dedup.reset()
+	}
+	f.cw.reset(w, f.cw.version, f.cw.algorithm)
+}
+
+func (f *framedWriter) writeFrame() error {
+	if f.sum != nil {
+		f.sum.Write(f.buf)
+	}
+	if f.sparse && isZero(f.buf) {
+		return f.commit(frameRecord{typ: frameZero, size: len(f.buf)})
+	}
+	var hash [sha256.Size]byte
+	if f.dedup != nil {
+		ref, h, err := f.reference()
+		if ref || err != nil {
+			return err
+		}
+		hash = h
+	}
+	var rec frameRecord
+	if f.lagging() {
+		rec = f.uncompressed()
+	} else {
+		var err error
+		if rec, err = f.compress(); err != nil {
+			return err
+		}
+	}
+	if err := f.commit(rec); err != nil {
+		return err
+	}
+	if f.dedup != nil {
+		f.dedup.add(hash)
+	}
+	return nil
+}
+
+// compress compresses the buffer with every codec and returns the smallest
+// frame
+func (f *framedWriter) compress() (frameRecord, error) {
+	if fn := f.codecs[0].m.frameLevel; fn != nil {
+		l := fn(f.frames, f.buf)
+		for _, c := range f.codecs {
+			c.setLevel(l)
+		}
+	}
+	if len(f.codecs) == 1 {
+		f.codecs[0].compress(f.buf)
+	} else {
+		var wg sync.WaitGroup
+		for _, c := range f.codecs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.compress(f.buf)
+			}()
+		}
+		wg.Wait()
+	}
+
+	var best *frameCodec
+	for _, c := range f.codecs {
+		if c.err != nil {
+			return frameRecord{}, c.err
+		}
+		if best == nil || c.out.Len() < best.out.Len() {
+			best = c
+		}
+	}
+
+	rec := frameRecord{typ: frameData, algorithm: best.algorithm, size: len(f.buf), payload: best.out.Bytes()}
+	if best.algorithm != f.cw.algorithm {
+		rec.typ = frameCoded
+	}
+	if len(rec.payload) >= len(f.buf) && f.codecs[0].m.wrapPayload == nil {
+		// Wrapped payloads have their own size, stored frames cannot be
+		// told apart from them
+		rec.typ, rec.payload = frameStored, f.buf
+	}
+	return rec, nil
+}
+
+// writeRecord writes rec, passing its payload through the wrap hook
+func (f *framedWriter) writeRecord(rec frameRecord) error {
+	rec, err := f.wrap(rec)
+	if err != nil {
+		return err
+	}
+	return f.cw.writeFrame(rec)
+}
+
+// wrap passes the payload of rec through the wrap hook
+func (f *framedWriter) wrap(rec frameRecord) (frameRecord, error) {
+	if wrap := f.codecs[0].m.wrapPayload; wrap != nil && (len(rec.payload) > 0 || rec.typ == frameZero) {
+		payload, err := wrap(f.wrapped, rec.typ, rec.payload)
+		if err != nil {
+			return rec, fmt.Errorf("compression: wrapping frame payload: %w", err)
+		}
+		rec.payload = payload
+		f.wrapped++
+		f.cw.flags |= TrailerHooked
+	}
+	return rec, nil
+}
+
+// commit writes rec and empties the buffer
+func (f *framedWriter) commit(rec frameRecord) error {
+	rec, err := f.wrap(rec)
+	if err != nil {
+		return err
+	}
+	if f.annotate {
+		if err := f.cw.writeFrame(annotationRecord(rec)); err != nil {
+			return err
+		}
+	}
+	if err := f.cw.writeFrame(rec); err != nil {
+		return err
+	}
+	f.frames++
+	m := f.codecs[0].m
+	if m.flushCallback != nil {
+		m.flushCallback(f.cw.offset, f.cw.uncompressed)
+	}
+	if f.refreshing {
+		if err := f.refresh(m.refreshEvery, m.refreshSize); err != nil {
+			return err
+		}
+	}
+	f.buf = f.buf[:0]
+	return nil
+}
+
+// refresh records the frame just written in the history and, every n
+// frames, writes the history as the dictionary of the following frames
+func (f *framedWriter) refresh(n, size int) error {
+	f.history = append(f.history, f.buf[max(0, len(f.buf)-size):]...)
+	if len(f.history) > size {
+		f.history = append(f.history[:0], f.history[len(f.history)-size:]...)
+	}
+	if f.frames%n != 0 || len(f.history) == 0 {
+		return nil
+	}
+	f.dictID++
+	dict := bytes.Clone(f.history)
+	payload := binary.LittleEndian.AppendUint32(nil, f.dictID)
+	if err := f.writeRecord(frameRecord{typ: frameDictionary, payload: append(payload, zstdCompact(dict)...)}); err != nil {
+		return err
+	}
+	for _, c := range f.codecs {
+		c.useDictionary(f.dictID, dict)
+	}
+	return nil
+}
+
+// setLevel switches the codec to the encoder of level l, creating it on
+// first use
+func (c *frameCodec) setLevel(l Level) {
+	if l == c.level {
+		return
+	}
+	if c.encoders == nil {
+		c.encoders = make(map[Level]encoder)
+	}
+	c.encoders[c.level] = c.enc
+	enc, ok := c.encoders[l]
+	if !ok {
+		enc = c.newEncoder(l)
+	}
+	c.enc, c.level = enc, l
+}
+
+// useDictionary makes zstd codecs compress with the raw dictionary dict,
+// or without a refreshed dictionary if dict is nil
+func (c *frameCodec) useDictionary(id uint32, dict []byte) {
+	if c.algorithm != Zstd {
+		return
+	}
+	c.dict, c.dictID = dict, id
+	c.encoders = nil
+	c.enc = c.newEncoder(c.level)
+}
+
+func (c *frameCodec) newEncoder(l Level) encoder {
+	if c.dict != nil {
+		return c.m.createZstdRefreshWriter(&c.out, l, c.dictID, c.dict)
+	}
+	return c.m.newEncoder(c.algorithm, l, &c.out)
+}
+
+// compress compresses p into c.out as a complete stream
+func (c *frameCodec) compress(p []byte) {
+	c.out.Reset()
+	c.enc.Reset(&c.out)
+	if d, ok := c.enc.(sizeDeclarer); ok {
+		// Stream the frame instead of buffering it
+		d.declareSize(int64(len(p)))
+	}
+	if _, c.err = c.enc.Write(p); c.err == nil {
+		c.err = c.enc.Close()
+	}
+}
+
+// framedReader reads the container format frame by frame
+type framedReader struct {
+	m  *Middleware
+	cr containerReader
+	// file is the source file if stored frames may be copied in the kernel
+	file *os.File
+	// sum is the checksum of the data read so far, nil if not verified
+	sum hash.Hash
+	// unverified is set by data read since the last checksum
+	unverified bool
+	// dict is the dictionary of the following zstd frames, see
+	// WithDictionaryRefresh
+	dict   []byte
+	dictID uint32
+	// in counts the compressed input, start is the compressed offset of the
+	// current frame and decoded the uncompressed offset after it
+	in      *countingReader
+	start   int64
+	decoded int64
+	// dedup holds the frames references may repeat, see WithFrameDedup
+	dedup *dedupReader
+	// unwrapped counts the frames passed through the unwrap hook
+	unwrapped uint64
+
+	frame []byte
+	off   int
+	err   error
+}
+
+func newFramedReader(m *Middleware, r io.Reader) *framedReader {
+	f := &framedReader{m: m}
+	f.cr.r = bufio.NewReader(r)
+	if m.checksum != 0 {
+		f.sum = m.checksum.new()
+	}
+	return f
+}
+
+func (f *framedReader) Read(p []byte) (int, error) {
+	for f.off == len(f.frame) {
+		if f.err != nil {
+			return 0, f.err
+		}
+		rec, err := f.next()
+		if err != nil {
+			f.err = f.end(err)
+			continue
+		}
+		if ok, err := f.control(rec); ok {
+			f.err = err
+			continue
+		}
+		if rec.size > 0 && rec.size <= len(p) {
+			// The whole frame fits, decode it in place without staging
+			err := f.decodeFrame(p[:rec.size], rec)
+			if err == nil {
+				return rec.size, nil
+			}
+			if f.err = f.skip(rec, err); f.err != nil {
+				return 0, f.err
+			}
+			continue
+		}
+		f.err = f.bufferFrame(rec)
+	}
+	n := copy(p, f.frame[f.off:])
+	f.off += n
+	return n, nil
+}
+
+// DecodeInto decodes the remaining stream into dst, decoding frames in place.
+// It returns io.ErrShortBuffer if the stream does not fit; the rest can then
+// still be consumed with Read.
+func (f *framedReader) DecodeInto(dst []byte) (int, error) {
+	n := copy(dst, f.frame[f.off:])
+	f.off += n
+	for f.off == len(f.frame) {
+		if f.err != nil {
+			if f.err == io.EOF {
+				return n, nil
+			}
+			return n, f.err
+		}
+		rec, err := f.next()
+		if err != nil {
+			f.err = f.end(err)
+			continue
+		}
+		if ok, err := f.control(rec); ok {
+			f.err = err
+			continue
+		}
+		if rec.size > len(dst)-n {
+			if f.err = f.bufferFrame(rec); f.err != nil {
+				return n, f.err
+			}
+			break
+		}
+		if err := f.decodeFrame(dst[n:n+rec.size], rec); err != nil {
+			if f.err = f.skip(rec, err); f.err != nil {
+				return n, f.err
+			}
+			continue
+		}
+		n += rec.size
+	}
+	m := copy(dst[n:], f.frame[f.off:])
+	f.off += m
+	return n + m, io.ErrShortBuffer
+}
+
+// WriteTo writes the remaining stream to w. With WithKernelCopy, stored
+// frames are copied from the source file to a file sink by the kernel.
+func (f *framedReader) WriteTo(w io.Writer) (int64, error) {
+	var total int64
+	for {
+		if f.off < len(f.frame) {
+			n, err := w.Write(f.frame[f.off:])
+			f.off += n
+			total += int64(n)
+			if err != nil {
+				return total, err
+			}
+		}
+		if f.err != nil {
+			if f.err == io.EOF {
+				return total, nil
+			}
+			return total, f.err
+		}
+
+		f.start = f.position()
+		rec, size, err := f.cr.nextHeader()
+		if err != nil {
+			f.err = f.end(err)
+			continue
+		}
+		if sw, ok := w.(*sparseWriter); ok && rec.typ == frameZero && size == 0 && f.sum == nil {
+			sw.skip(int64(rec.size))
+			total += int64(rec.size)
+			f.decoded += int64(rec.size)
+			continue
+		}
+		if dst := fileOf(w); dst != nil && f.file != nil && rec.typ == frameStored && f.dedup == nil {
+			// Data copied by the kernel cannot be checksummed
+			f.sum = nil
+			n, err := f.transferStored(dst, size)
+			total += n
+			f.decoded += n
+			if err != nil {
+				f.err = err
+				return total, err
+			}
+			continue
+		}
+		if rec.payload, err = f.cr.readPayload(size); err == nil {
+			err = f.unwrap(&rec)
+		}
+		if err != nil {
+			f.err = err
+			continue
+		}
+		if ok, err := f.control(rec); ok {
+			f.err = err
+			continue
+		}
+		f.err = f.bufferFrame(rec)
+	}
+}
+
+// next returns the next frame with its payload unwrapped
+func (f *framedReader) next() (frameRecord, error) {
+	f.start = f.position()
+	rec, err := f.cr.next()
+	if err == nil {
+		err = f.unwrap(&rec)
+	}
+	return rec, err
+}
+
+// control handles rec if it is a record without data, reporting whether it
+// was one
+func (f *framedReader) control(rec frameRecord) (bool, error) {
+	switch rec.typ {
+	case frameChecksum:
+		return true, f.verify(rec)
+	case frameDictionary:
+		return true, f.setDictionary(rec)
+	case frameDedup:
+		return true, f.setDedup(rec)
+	case frameAnnotation:
+		return true, nil
+	}
+	return false, nil
+}
+
+// unwrap passes the payload of rec through the unwrap hook. Annotations are
+// not wrapped.
+func (f *framedReader) unwrap(rec *frameRecord) error {
+	if unwrap := f.m.unwrapPayload; unwrap != nil && (len(rec.payload) > 0 || rec.typ == frameZero) && rec.typ != frameAnnotation {
+		payload, err := unwrap(f.unwrapped, rec.typ, rec.payload)
+		if err != nil {
+			return fmt.Errorf("compression: unwrapping frame payload: %w", err)
+		}
+		rec.payload = payload
+		f.unwrapped++
+	}
+	if rec.typ == frameZero && len(rec.payload) > 0 {
+		return fmt.Errorf("%w: zero frame with payload", ErrCorruptStream)
+	}
+	return nil
+}
+
+// end translates the end of the container into ErrAborted for aborted
+// containers
+func (f *framedReader) end(err error) error {
+	if err == io.EOF && f.cr.flags&TrailerAborted != 0 {
+		return ErrAborted
+	}
+	if err == io.EOF {
+		if verr := f.checkVerified(); verr != nil {
+			return verr
+		}
+	}
+	return err
+}
+
+// transferStored copies the n byte payload of a stored frame from the source
+// file to dst. Only the part already buffered is copied in user space.
+func (f *framedReader) transferStored(dst *os.File, n int) (int64, error) {
+	buffered := min(f.cr.r.Buffered(), n)
+	p, _ := f.cr.r.Peek(buffered)
+	written, err := dst.Write(p)
+	f.cr.r.Discard(written)
+	if err != nil {
+		return int64(written), err
+	}
+
+	copied, err := transferFile(dst, f.file, int64(n-buffered))
+	f.m.stats.bytesRead.Add(copied)
+	if err == nil && copied < int64(n-buffered) {
+		err = fmt.Errorf("%w: truncated frame", io.ErrUnexpectedEOF)
+	}
+	return int64(written) + copied, err
+}
+
+// readFrame decodes the next frame into f.frame. It returns io.EOF at the
+// end of the container.
+func (f *framedReader) readFrame() error {
+	rec, err := f.next()
+	if err != nil {
+		return err
+	}
+	return f.bufferFrame(rec)
+}
+
+// bufferFrame decodes rec into f.frame
+func (f *framedReader) bufferFrame(rec frameRecord) error {
+	f.frame = grow(f.frame, rec.size)
+	f.off = 0
+	if err := f.decodeFrame(f.frame, rec); err != nil {
+		f.frame = f.frame[:0]
+		return f.skip(rec, err)
+	}
+	return nil
+}
+
+// decodeFrame decodes rec into dst, which has the uncompressed frame size
+func (f *framedReader) decodeFrame(dst []byte, rec frameRecord) error {
+	switch rec.typ {
+	case frameStored:
+		copy(dst, rec.payload)
+	case frameZero:
+		clear(dst)
+	case frameRef:
+		if err := f.expand(dst, rec); err != nil {
+			return err
+		}
+	default:
+		if err := f.decompress(dst, rec, f.dictID, f.dict); err != nil {
+			return err
+		}
+	}
+	if dedupable(rec.typ) {
+		if err := f.dedup.add(rec, f.dictID, f.dict); err != nil {
+			return err
+		}
+	}
+	if f.sum != nil {
+		f.sum.Write(dst)
+		f.unverified = true
+	}
+	f.decoded += int64(len(dst))
+	return nil
+}
+
+// setDictionary makes rec the dictionary of the following frames
+func (f *framedReader) setDictionary(rec frameRecord) error {
+	if !Zstd.Available() {
+		return unavailableAlgorithm(Zstd)
+	}
+	dec := f.m.newDecoder(Zstd, bytes.NewReader(rec.payload[4:]))
+	if c, ok := dec.(io.Closer); ok {
+		defer c.Close()
+	}
+	dict, err := io.ReadAll(io.LimitReader(dec, MaxFrameSize+1))
+	if err == nil && len(dict) > MaxFrameSize {
+		err = errors.New("dictionary exceeds the frame size limit")
+	}
+	if err != nil {
+		return fmt.Errorf("%w: decoding dictionary: %v", ErrCorruptStream, err)
+	}
+	f.dictID, f.dict = binary.LittleEndian.Uint32(rec.payload), dict
+	return nil
+}
+
+// decompress decodes rec into dst, zstd frames with the refreshed dictionary
+// dict if set
+func (f *framedReader) decompress(dst []byte, rec frameRecord, dictID uint32, dict []byte) error {
+	var dec io.Reader
+	if dict != nil && rec.algorithm == Zstd {
+		dec = f.m.createZstdRefreshReader(bytes.NewReader(rec.payload), dictID, dict)
+	} else {
+		dec = f.m.newDecoder(rec.algorithm, bytes.NewReader(rec.payload))
+	}
+	if c, ok := dec.(io.Closer); ok {
+		defer c.Close()
+	}
+	if _, err := io.ReadFull(dec, dst); err != nil {
+		return fmt.Errorf("%w: decoding frame: %v", ErrCorruptStream, err)
+	}
+	return nil
+}
+
+// grow returns b resized to n bytes, reusing its storage when possible
+func grow(b []byte, n int) []byte {
+	if cap(b) < n {
+		return make([]byte, n)
+	}
+	return b[:n]
+}
@@ -0,0 +1,379 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestFrameSize_AllAlgorithms(t *testing.T) {
+	requireZstd(t)
+	testData := bytes.Repeat([]byte("framed container test data "), 2000)
+
+	for _, alg := range []Algorithm{Gzip, Zstd, S2, Snappy, Zlib, Flate} {
+		t.Run(alg.String(), func(t *testing.T) {
+			m := New(alg, WithFrameSize(4096))
+
+			var buf bytes.Buffer
+			w := m.Writer(&buf)
+			if _, err := w.Write(testData); err != nil {
+				t.Fatalf("Failed to write: %v", err)
+			}
+			if err := w.(io.Closer).Close(); err != nil {
+				t.Fatalf("Failed to close: %v", err)
+			}
+
+			data, err := io.ReadAll(m.Reader(&buf))
+			if err != nil {
+				t.Fatalf("Failed to read: %v", err)
+			}
+			if !bytes.Equal(data, testData) {
+				t.Fatal("Data mismatch")
+			}
+		})
+	}
+}
+
+func TestFrameSize_IndependentFrames(t *testing.T) {
+	requireZstd(t)
+	m := New(Zstd, WithFrameSize(100))
+
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	w.Write(bytes.Repeat([]byte("a"), 250))
+	w.(io.Closer).Close()
+
+	// Walk the container and decode every frame on its own
+	r := newFramedReader(m, &buf)
+	var sizes []int
+	for {
+		err := r.readFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		sizes = append(sizes, len(r.frame))
+	}
+	if len(sizes) != 3 || sizes[0] != 100 || sizes[1] != 100 || sizes[2] != 50 {
+		t.Fatalf("Unexpected frame sizes %v", sizes)
+	}
+}
+
+func TestFrameSize_EmptyAndCorrupt(t *testing.T) {
+	m := New(S2, WithFrameSize(1024))
+
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	w.(io.Closer).Close()
+	data, err := io.ReadAll(m.Reader(bytes.NewReader(buf.Bytes())))
+	if err != nil || len(data) != 0 {
+		t.Fatalf("Expected empty stream, got %d bytes, err %v", len(data), err)
+	}
+
+	if _, err := io.ReadAll(m.Reader(bytes.NewReader([]byte("garbage")))); !errors.Is(err, ErrCorruptStream) {
+		t.Fatalf("Expected ErrCorruptStream, got %v", err)
+	}
+
+	// Missing end marker
+	truncated := buf.Bytes()[:buf.Len()-1]
+	if _, err := io.ReadAll(m.Reader(bytes.NewReader(truncated))); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestDecodeInto(t *testing.T) {
+	requireZstd(t)
+	testData := bytes.Repeat([]byte("decode into the caller buffer "), 1000)
+
+	for _, m := range []*Middleware{New(Zstd), New(Gzip, WithFrameSize(4096))} {
+		var buf bytes.Buffer
+		w := m.Writer(&buf)
+		w.Write(testData)
+		w.(io.Closer).Close()
+		compressed := buf.Bytes()
+
+		dst := make([]byte, len(testData))
+		n, err := m.Reader(bytes.NewReader(compressed)).(DirectDecoder).DecodeInto(dst)
+		if err != nil || n != len(testData) {
+			t.Fatalf("%v: DecodeInto returned %d, %v", m.algorithm, n, err)
+		}
+		if !bytes.Equal(dst, testData) {
+			t.Fatalf("%v: Data mismatch", m.algorithm)
+		}
+
+		// A short buffer reports io.ErrShortBuffer and leaves the rest readable
+		r := m.Reader(bytes.NewReader(compressed))
+		short := make([]byte, 10000)
+		n, err = r.(DirectDecoder).DecodeInto(short)
+		if err != io.ErrShortBuffer || n != len(short) {
+			t.Fatalf("%v: Expected io.ErrShortBuffer with full buffer, got %d, %v", m.algorithm, n, err)
+		}
+		rest, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(append(short, rest...), testData) {
+			t.Fatalf("%v: Failed to read the rest after short buffer: %v", m.algorithm, err)
+		}
+	}
+}
+
+func TestPerFrameBestOf(t *testing.T) {
+	requireZstd(t)
+	testData := bytes.Repeat([]byte("archival data compresses best with the strongest codec "), 2000)
+
+	best := New(S2, WithFrameSize(16<<10), WithPerFrameBestOf(S2, Zstd, Gzip), WithLevel(Best))
+	bestOf := writeContainer(t, best, testData)
+
+	// Coded frames spend one byte on the algorithm
+	frames := len(testData)/(16<<10) + 1
+	for _, alg := range []Algorithm{S2, Zstd, Gzip} {
+		single := writeContainer(t, New(alg, WithFrameSize(16<<10), WithLevel(Best)), testData)
+		if len(bestOf) > len(single)+frames {
+			t.Fatalf("Best-of container (%d bytes) larger than %s container (%d bytes)", len(bestOf), alg, len(single))
+		}
+	}
+
+	// Frames record their codec, so a reader configured for the container
+	// algorithm only decodes them
+	cr := containerReader{r: bufio.NewReader(bytes.NewReader(bestOf))}
+	rec, err := cr.next()
+	if err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	if rec.typ != frameCoded || rec.algorithm == S2 {
+		t.Fatalf("Expected a coded frame with a stronger codec, got type %d algorithm %s", rec.typ, rec.algorithm)
+	}
+
+	data, err := io.ReadAll(New(S2, WithFrameSize(16<<10)).Reader(bytes.NewReader(bestOf)))
+	if err != nil || !bytes.Equal(data, testData) {
+		t.Fatalf("Failed to read best-of container: %v", err)
+	}
+}
+
+func TestAbort(t *testing.T) {
+	requireZstd(t)
+	m := New(Zstd, WithFrameSize(1000))
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	data := bytes.Repeat([]byte("abcdefghij"), 250)
+	w.Write(data)
+	if err := w.(Aborter).Abort(); err != nil {
+		t.Fatalf("Failed to abort: %v", err)
+	}
+	if _, err := w.Write([]byte("more")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed after Abort, got %v", err)
+	}
+
+	got, err := io.ReadAll(m.Reader(bytes.NewReader(buf.Bytes())))
+	if !errors.Is(err, ErrAborted) {
+		t.Fatalf("Expected ErrAborted, got %v", err)
+	}
+	if !bytes.Equal(got, data[:2000]) {
+		t.Fatalf("Expected the 2 complete frames, got %d bytes", len(got))
+	}
+
+	var migrated bytes.Buffer
+	if err := MigrateContainer(bytes.NewReader(buf.Bytes()), &migrated, ContainerVersion); err != nil {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	if _, err := io.ReadAll(m.Reader(&migrated)); !errors.Is(err, ErrAborted) {
+		t.Fatalf("Expected migration to keep the abort flag, got %v", err)
+	}
+}
+
+func TestAbort_Unframed(t *testing.T) {
+	m := New(Gzip)
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	w.Write([]byte("partial"))
+	if err := w.(Aborter).Abort(); err != nil {
+		t.Fatalf("Failed to abort: %v", err)
+	}
+	if got, err := io.ReadAll(m.Reader(&buf)); err != nil || string(got) != "partial" {
+		t.Fatalf("Expected the written data, got %q, %v", got, err)
+	}
+}
+
+func TestWithFrameLevel(t *testing.T) {
+	requireZstd(t)
+	var calls []int
+	m := New(Zstd, WithFrameSize(4096), WithFrameLevel(func(frameIdx int, sample []byte) Level {
+		if len(sample) == 0 {
+			t.Error("Expected the frame data as sample")
+		}
+		calls = append(calls, frameIdx)
+		if frameIdx == 0 {
+			return Fastest
+		}
+		return Best
+	}))
+
+	data := bytes.Repeat([]byte("hot head, cold tail "), 1000)
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	w.Write(data)
+	w.(io.Closer).Close()
+
+	if want := (len(data) + 4095) / 4096; len(calls) != want || calls[0] != 0 || calls[want-1] != want-1 {
+		t.Fatalf("Expected %d calls with increasing indices, got %v", want, calls)
+	}
+	if encs := w.(*writer).enc.(*framedWriter).codecs[0].encoders; len(encs) != 2 {
+		t.Fatalf("Expected encoders for 2 levels, got %d", len(encs))
+	}
+	if got, err := io.ReadAll(m.Reader(&buf)); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Round trip failed: %v", err)
+	}
+}
+
+func TestWithDictionaryRefresh(t *testing.T) {
+	requireZstd(t)
+	// Content drifts: every 32 frames use their own vocabulary
+	var data []byte
+	for i := 0; i < 64; i++ {
+		for len(data) < (i+1)*16384 {
+			data = append(data, fmt.Sprintf(`{"epoch":%d,"sensor":"sensor-%d-%d","reading":%d,"status":"nominal-%d"}`+"\n",
+				i/8, i/8*7, len(data)%13, len(data)%97, i/8)...)
+		}
+		data = data[:(i+1)*16384]
+	}
+
+	plain := New(Zstd, WithFrameSize(4096))
+	m := New(Zstd, WithFrameSize(4096), WithDictionaryRefresh(16, 4096))
+	compressed := writeContainer(t, m, data)
+	if baseline := writeContainer(t, plain, data); len(compressed) >= len(baseline) {
+		t.Errorf("refresh did not improve the ratio: %d >= %d bytes", len(compressed), len(baseline))
+	}
+
+	got, err := io.ReadAll(m.Reader(bytes.NewReader(compressed)))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("round trip failed: %v", err)
+	}
+	// Any framed reader decodes the stream, the dictionaries are embedded
+	if got, err = io.ReadAll(plain.Reader(bytes.NewReader(compressed))); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("reading without the option failed: %v", err)
+	}
+
+	// Extracted frames keep the dictionary they depend on
+	var extracted bytes.Buffer
+	if err := ExtractFrames(bytes.NewReader(compressed), &extracted, FrameRange(40, 41)); err != nil {
+		t.Fatal(err)
+	}
+	got, err = io.ReadAll(plain.Reader(&extracted))
+	if err != nil || !bytes.Equal(got, data[40*4096:42*4096]) {
+		t.Errorf("extracted frames: %v", err)
+	}
+
+	// Dictionary frames are indexed with size 0
+	index, err := ReadContainerIndex(bytes.NewReader(compressed), int64(len(compressed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dicts := 0
+	for _, fi := range index {
+		if fi.Size == 0 {
+			dicts++
+		}
+	}
+	if dicts != 16 || len(index) != 272 {
+		t.Errorf("Expected 256 data and 16 dictionary frames in the index, got %d of %d", dicts, len(index))
+	}
+
+	// Streams without zstd frames are not refreshed
+	compressed = writeContainer(t, New(S2, WithFrameSize(4096), WithDictionaryRefresh(16, 4096)), data)
+	if index, err = ReadContainerIndex(bytes.NewReader(compressed), int64(len(compressed))); err != nil || len(index) != 256 {
+		t.Errorf("Expected 256 frames without dictionaries, got %d: %v", len(index), err)
+	}
+}
+
+func TestWithFramePayloadHooks(t *testing.T) {
+	errTampered := errors.New("tampered")
+	// A toy cipher with an integrity tag over the frame index, type and
+	// payload
+	tag := func(idx uint64, typ byte, p []byte) byte {
+		tag := byte(idx)*31 + typ
+		for _, b := range p {
+			tag += b
+		}
+		return tag
+	}
+	wrap := func(idx uint64, typ byte, p []byte) ([]byte, error) {
+		out := make([]byte, len(p)+1)
+		for i, b := range p {
+			out[i] = b ^ 0x5a
+		}
+		out[len(p)] = tag(idx, typ, p)
+		return out, nil
+	}
+	unwrap := func(idx uint64, typ byte, p []byte) ([]byte, error) {
+		out := make([]byte, len(p)-1)
+		for i, b := range p[:len(out)] {
+			out[i] = b ^ 0x5a
+		}
+		if tag(idx, typ, out) != p[len(out)] {
+			return nil, errTampered
+		}
+		return out, nil
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	data := make([]byte, 15000)
+	rng.Read(data[:5000]) // an incompressible frame
+	copy(data[5000:10000], bytes.Repeat([]byte("secret "), 1000))
+	// and a zero frame
+
+	m := New(S2, WithFrameSize(5000), WithChecksum(CRC32C), WithSparseDetection(), WithFramePayloadHooks(wrap, unwrap))
+	compressed := writeContainer(t, m, data)
+	if bytes.Contains(compressed, data[:100]) {
+		t.Error("payload written unwrapped")
+	}
+	got, err := io.ReadAll(m.Reader(bytes.NewReader(compressed)))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("round trip failed: %v", err)
+	}
+
+	tampered := bytes.Clone(compressed)
+	tampered[len(tampered)/2] ^= 1
+	if _, err := io.ReadAll(m.Reader(bytes.NewReader(tampered))); !errors.Is(err, errTampered) {
+		t.Errorf("expected the unwrap error, got %v", err)
+	}
+
+	// Swapping the data frames is detected through the frame index
+	cr := containerReader{r: bufio.NewReader(bytes.NewReader(compressed))}
+	if err := cr.readHeader(); err != nil {
+		t.Fatal(err)
+	}
+	var recs []frameRecord
+	for {
+		rec, err := cr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec.payload = bytes.Clone(rec.payload)
+		recs = append(recs, rec)
+	}
+	if len(recs) != 4 || recs[2].typ != frameZero {
+		t.Fatalf("Expected 3 data frames, one of them zero, and a checksum frame, got %d frames", len(recs))
+	}
+	recs[0], recs[1] = recs[1], recs[0]
+	var swapped bytes.Buffer
+	var cw containerWriter
+	cw.reset(&swapped, ContainerVersion, S2)
+	for _, rec := range recs {
+		if err := cw.writeFrame(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cw.close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(m.Reader(&swapped)); !errors.Is(err, errTampered) {
+		t.Errorf("expected the unwrap error for reordered frames, got %v", err)
+	}
+}
@@ -5,6 +5,16 @@ go 1.23.0
 toolchain go1.24.0
 
 require (
+	github.com/DataDog/zstd v1.5.7
+	github.com/dsnet/compress v0.0.1
 	github.com/klauspost/compress v1.18.0
+	github.com/klauspost/pgzip v1.2.6
+	github.com/zeebo/blake3 v0.2.4
+	github.com/zeebo/xxh3 v1.1.0
 	schneider.vip/hybridbuffer/middleware v1.0.6
 )
+
+require (
+	github.com/klauspost/cpuid/v2 v2.2.10 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+)
@@ -0,0 +1,121 @@
+package compression
+
+import "fmt"
+
+// Layout of the framed container, for readers in other languages.
+// Multi-byte integers are little-endian, uvarints are unsigned varints as
+// written by encoding/binary, offsets and sizes are in bytes.
+//
+// A container is a header, a sequence of frame records, an end record and,
+// from version 2 on, an index and a trailer.
+//
+// Header, at the start of every container:
+//
+//	offset  size  field
+//	0       4     magic "HBCF" (48 42 43 46)
+//	4       1     container version, 1 to ContainerVersion
+//	5       1     algorithm of the frames, the value of its Algorithm
+//	              constant: 0 gzip, 1 zstd, 2 s2, 3 snappy, 4 zlib,
+//	              5 flate, 6 bzip2, 7 none, 8 snappy-block
+//
+// Frame record:
+//
+//	size    field
+//	1       type, see below
+//	1       algorithm of a coded frame or checksum of a checksum frame,
+//	        absent for other types
+//	uvarint uncompressed size, at most MaxFrameSize
+//	uvarint payload size, at most twice MaxFrameSize
+//	n       payload
+//
+// Frame types:
+//
+//	type  name        size     payload
+//	0     end         -        none, the record is the type byte alone
+//	1     data        data     a complete stream of the container algorithm
+//	2     stored      data     the data itself, payload size equals size;
+//	                           empty stored frames are keepalives
+//	3     coded       data     a complete stream of the algorithm of the
+//	                           record, overriding the container algorithm
+//	4     checksum    0        the digest of all uncompressed data, computed
+//	                           with the checksum of the record: 1 crc32c,
+//	                           2 crc64, 3 xxh3, 4 blake3; written before the
+//	                           end record
+//	5     zero        data     empty; the data is size zero bytes
+//	6     dictionary  0        dictionary ID (uint32) followed by the raw
+//	                           dictionary content as a zstd stream; the zstd
+//	                           frames following it are compressed with it
+//	7     dedup       0        window size (uvarint) of the ref frames
+//	                           following it
+//	8     ref         data     sequence number (uvarint) of an earlier data,
+//	                           stored or coded frame with the same data,
+//	                           counted from the dedup frame
+//	9     annotation  0        uncompressed size, payload size and ratio in
+//	                           hundredths (uvarints) of the frame following
+//	                           it, see FrameAnnotation
+//
+// Frames are decodable independently of each other, given the dictionary
+// and dedup frames preceding them. With WithFramePayloadHooks, the payloads
+// of all frames but annotations and keepalives are passed through the hooks,
+// zero frames included, and the payload sizes are those of the wrapped
+// payloads.
+//
+// Index, following the end record of version 2 and later containers, with
+// one entry per dictionary frame and per frame with a nonzero size:
+//
+//	uvarint frame count
+//	per frame: uvarint offset of the frame record | uvarint offset of its
+//	data in the uncompressed stream | uvarint uncompressed size, 0 for
+//	dictionary frames
+//
+// Trailer, at the end of version 2 and later containers:
+//
+//	offset  size  field
+//	0       8     offset of the index (uint64), the byte after the end record
+//	8       4     number of frames in the index (uint32)
+//	12      4     flags (uint32), see TrailerAborted and TrailerHooked;
+//	              other bits are zero
+//	16      4     magic "HBCT" (48 42 43 54)
+//
+// Readers must reject other magics, versions they do not know, algorithm
+// values not listed and unknown frame types, see ValidateHeader.
+const (
+	// HeaderMagic starts every container
+	HeaderMagic = "HBCF"
+	// HeaderSize is the size of the container header
+	HeaderSize = 4 + 1 + 1
+	// HeaderVersionOffset and HeaderAlgorithmOffset locate the version and
+	// algorithm bytes in the header
+	HeaderVersionOffset   = 4
+	HeaderAlgorithmOffset = 5
+
+	// TrailerMagic ends every version 2 and later container
+	TrailerMagic = "HBCT"
+	// TrailerSize is the size of the container trailer
+	TrailerSize = 8 + 4 + 4 + 4
+	// TrailerAborted is the flag of containers ended early by Abort
+	TrailerAborted = 1 << 0
+	// TrailerHooked is the flag of containers whose frame payloads were
+	// passed through the hooks of WithFramePayloadHooks
+	TrailerHooked = 1 << 1
+)
+
+// ValidateHeader checks that p starts with a container header as specified
+// with HeaderMagic. Bytes following the header are ignored. It fails with
+// ErrUnsupportedVersion for unknown versions and with ErrCorruptStream
+// otherwise. Algorithms known but not compiled into this binary are valid.
+func ValidateHeader(p []byte) error {
+	if len(p) < HeaderSize {
+		return fmt.Errorf("%w: container header of %d bytes, want %d", ErrCorruptStream, len(p), HeaderSize)
+	}
+	if string(p[:len(HeaderMagic)]) != HeaderMagic {
+		return fmt.Errorf("%w: bad container magic", ErrCorruptStream)
+	}
+	if version := p[HeaderVersionOffset]; version < 1 || version > ContainerVersion {
+		return fmt.Errorf("%w: %d", ErrUnsupportedVersion, version)
+	}
+	if a := Algorithm(p[HeaderAlgorithmOffset]); algorithmNames[a] == "" {
+		return fmt.Errorf("%w: unknown algorithm %s", ErrCorruptStream, a)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestValidateHeader(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		header []byte
+		want   error
+	}{
+		{"gzip v1", []byte("HBCF\x01\x00"), nil},
+		{"snappy-block v2", []byte("HBCF\x02\x08"), nil},
+		{"trailing bytes", []byte("HBCF\x02\x01\x01\x05"), nil},
+		{"short", []byte("HBCF\x02"), ErrCorruptStream},
+		{"magic", []byte("HBCX\x02\x01"), ErrCorruptStream},
+		{"version 0", []byte("HBCF\x00\x01"), ErrUnsupportedVersion},
+		{"future version", []byte("HBCF\x03\x01"), ErrUnsupportedVersion},
+		{"unknown algorithm", []byte("HBCF\x02\x09"), ErrCorruptStream},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateHeader(tc.header)
+			if tc.want == nil && err != nil || !errors.Is(err, tc.want) {
+				t.Fatalf("Expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+// TestHeader_Layout pins the byte layout of the header and trailer that
+// readers in other languages implement
+func TestHeader_Layout(t *testing.T) {
+	container := writeContainer(t, New(S2, WithFrameSize(1000)), bytes.Repeat([]byte("x"), 2500))
+	if got := container[:HeaderSize]; string(got) != "HBCF\x02\x02" {
+		t.Fatalf("Unexpected header % x", got)
+	}
+	if err := ValidateHeader(container); err != nil {
+		t.Fatalf("Failed to validate the header: %v", err)
+	}
+
+	trailer := container[len(container)-TrailerSize:]
+	indexOffset := binary.LittleEndian.Uint64(trailer)
+	if container[indexOffset-1] != frameEnd || binary.LittleEndian.Uint32(trailer[8:]) != 3 ||
+		binary.LittleEndian.Uint32(trailer[12:]) != 0 || string(trailer[16:]) != TrailerMagic {
+		t.Fatalf("Unexpected trailer % x", trailer)
+	}
+}
+
+func TestValidateHeader_Vectors(t *testing.T) {
+	requireZstd(t)
+	vectors, err := GenerateTestVectors(Zstd, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range vectors {
+		if v.FrameSize == 0 {
+			continue
+		}
+		if err := ValidateHeader(v.Compressed); err != nil || Algorithm(v.Compressed[HeaderAlgorithmOffset]) != Zstd {
+			t.Fatalf("%s: invalid header: %v", v.Name, err)
+		}
+	}
+}
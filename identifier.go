@@ -0,0 +1,175 @@
+package compression
+
+import (
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// Stream identifier chunks starting Snappy and S2 streams, see
+// https://github.com/google/snappy/blob/main/framing_format.txt
+const (
+	snappyIdentifier = "\xff\x06\x00\x00sNaPpY"
+	s2Identifier     = "\xff\x06\x00\x00S2sTwO"
+)
+
+// StreamIdentifier controls the stream identifier chunk of Snappy and S2
+// streams, see WithStreamIdentifier
+type StreamIdentifier struct {
+	// Body replaces the identifier of the codec ("sNaPpY" or "S2sTwO") at the
+	// start of written streams. Readers accept it in place of the codec's.
+	// If empty, the codec's identifier is kept.
+	Body string
+	// Omit writes streams without the identifier chunk
+	Omit bool
+	// Optional makes readers accept streams that start without the
+	// identifier chunk
+	Optional bool
+}
+
+// WithStreamIdentifier customizes the stream identifier chunk of Snappy and
+// S2 streams for interop with producers and consumers of non-standard
+// framing, such as some Hadoop tools that omit it. Only the chunk at the
+// start of a stream is affected, so concatenated streams need the codec's
+// identifier. WithS2Index adds no index to these streams, as the offsets
+// would not match. Other algorithms ignore the option.
+func WithStreamIdentifier(id StreamIdentifier) Option {
+	return func(m *Middleware) {
+		m.streamID = &id
+	}
+}
+
+// identifierEncoder is a Snappy or S2 encoder writing a custom identifier
+type identifierEncoder struct {
+	*s2.Writer
+	out *identifierWriter
+}
+
+func (m *Middleware) withStreamIdentifier(enc *s2.Writer, w io.Writer) encoder {
+	if m.streamID == nil {
+		return enc
+	}
+	out := &identifierWriter{id: m.streamID}
+	out.reset(w)
+	enc.Reset(out)
+	return &identifierEncoder{Writer: enc, out: out}
+}
+
+func (e *identifierEncoder) Reset(w io.Writer) {
+	e.out.reset(w)
+	e.Writer.Reset(e.out)
+}
+
+// identifierWriter replaces the identifier chunk the encoder writes first,
+// which it keeps in chunk
+type identifierWriter struct {
+	w     io.Writer
+	id    *StreamIdentifier
+	skip  int
+	chunk []byte
+}
+
+func (w *identifierWriter) reset(out io.Writer) {
+	w.w = out
+	w.skip = len(s2Identifier)
+	w.chunk = w.chunk[:0]
+}
+
+func (w *identifierWriter) Write(p []byte) (int, error) {
+	if w.skip == 0 {
+		return w.w.Write(p)
+	}
+	n := min(w.skip, len(p))
+	w.skip -= n
+	w.chunk = append(w.chunk, p[:n]...)
+	if w.skip == 0 && !w.id.Omit {
+		chunk := w.chunk
+		if w.id.Body != "" {
+			chunk = identifierChunk(w.id.Body)
+		}
+		if _, err := w.w.Write(chunk); err != nil {
+			return 0, err
+		}
+	}
+	if n == len(p) {
+		return n, nil
+	}
+	written, err := w.w.Write(p[n:])
+	return n + written, err
+}
+
+// identifierChunk returns the stream identifier chunk with body
+func identifierChunk(body string) []byte {
+	l := len(body)
+	return append([]byte{0xff, byte(l), byte(l >> 8), byte(l >> 16)}, body...)
+}
+
+// identifierReader rewrites the start of a stream to the identifier the
+// decoder expects
+type identifierReader struct {
+	r        io.Reader
+	id       *StreamIdentifier
+	expected string
+
+	head    []byte
+	started bool
+}
+
+func (m *Middleware) withStreamIdentifierReader(r io.Reader, expected string) io.Reader {
+	if m.streamID == nil {
+		return r
+	}
+	return &identifierReader{r: r, id: m.streamID, expected: expected}
+}
+
+func (r *identifierReader) Read(p []byte) (int, error) {
+	if !r.started {
+		r.started = true
+		if err := r.rewrite(); err != nil {
+			return 0, err
+		}
+	}
+	if len(r.head) > 0 {
+		n := copy(p, r.head)
+		r.head = r.head[n:]
+		return n, nil
+	}
+	return r.r.Read(p)
+}
+
+// rewrite reads the first chunk header and replaces a custom identifier
+// with the expected one, or inserts the expected one if it is missing and
+// optional. Anything else is passed on for the decoder to judge.
+func (r *identifierReader) rewrite() error {
+	hdr := make([]byte, 4)
+	n, err := io.ReadFull(r.r, hdr)
+	r.head = hdr[:n]
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		return err
+	}
+	if hdr[0] != 0xff {
+		if r.id.Optional {
+			r.head = append([]byte(r.expected), hdr...)
+		}
+		return nil
+	}
+	l := int(hdr[1]) | int(hdr[2])<<8 | int(hdr[3])<<16
+	if r.id.Body == "" || l != len(r.id.Body) {
+		return nil
+	}
+	body := make([]byte, l)
+	n, err = io.ReadFull(r.r, body)
+	if string(body[:n]) == r.id.Body {
+		r.head = []byte(r.expected)
+	} else {
+		r.head = append(hdr, body[:n]...)
+	}
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestWithStreamIdentifier(t *testing.T) {
+	data := []byte(strings.Repeat("stream identifier interop ", 1000))
+	for _, a := range []Algorithm{Snappy, S2} {
+		t.Run(a.String(), func(t *testing.T) {
+			custom := New(a, WithStreamIdentifier(StreamIdentifier{Body: "HaDoOpSnAp"}))
+			// The second stream uses the pooled encoder
+			for range 2 {
+				stream := writeContainer(t, custom, data)
+				if !bytes.HasPrefix(stream, identifierChunk("HaDoOpSnAp")) {
+					t.Fatalf("Expected the custom identifier, got %q", stream[:16])
+				}
+				if _, err := io.ReadAll(New(a).Reader(bytes.NewReader(stream))); err == nil {
+					t.Fatal("Expected the default reader to reject the custom identifier")
+				}
+				got, err := io.ReadAll(custom.Reader(iotest.OneByteReader(bytes.NewReader(stream))))
+				if err != nil || !bytes.Equal(got, data) {
+					t.Fatalf("Failed to read the custom identifier: %v", err)
+				}
+			}
+
+			omitted := writeContainer(t, New(a, WithStreamIdentifier(StreamIdentifier{Omit: true})), data)
+			if omitted[0] == 0xff {
+				t.Fatal("Expected no identifier chunk")
+			}
+			if _, err := io.ReadAll(New(a).Reader(bytes.NewReader(omitted))); err == nil {
+				t.Fatal("Expected the default reader to require the identifier")
+			}
+			optional := New(a, WithStreamIdentifier(StreamIdentifier{Optional: true}))
+			for _, stream := range [][]byte{omitted, writeContainer(t, New(a), data)} {
+				got, err := io.ReadAll(optional.Reader(bytes.NewReader(stream)))
+				if err != nil || !bytes.Equal(got, data) {
+					t.Fatalf("Failed to read with an optional identifier: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestWithStreamIdentifier_Empty(t *testing.T) {
+	m := New(Snappy, WithStreamIdentifier(StreamIdentifier{Optional: true}))
+	got, err := io.ReadAll(m.Reader(bytes.NewReader(writeContainer(t, m, nil))))
+	if err != nil || len(got) != 0 {
+		t.Fatalf("Expected an empty stream, got %d bytes, %v", len(got), err)
+	}
+}
+
+func TestWithStreamIdentifier_DefaultBody(t *testing.T) {
+	data := []byte(strings.Repeat("default identifier ", 1000))
+	for a, id := range map[Algorithm]string{Snappy: snappyIdentifier, S2: s2Identifier} {
+		t.Run(a.String(), func(t *testing.T) {
+			m := New(a, WithStreamIdentifier(StreamIdentifier{Optional: true}))
+			stream := writeContainer(t, m, data)
+			if !bytes.HasPrefix(stream, []byte(id)) {
+				t.Fatalf("Expected the codec's identifier, got %q", stream[:10])
+			}
+			for _, r := range []*Middleware{m, New(a)} {
+				got, err := io.ReadAll(r.Reader(bytes.NewReader(stream)))
+				if err != nil || !bytes.Equal(got, data) {
+					t.Fatalf("Round trip failed: %v", err)
+				}
+			}
+		})
+	}
+}
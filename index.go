@@ -0,0 +1,113 @@
+package compression
+
+import (
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/s2"
+)
+
+var (
+	// ErrNoIndex is returned by ExportIndex when the stream has no index,
+	// either because it is not closed yet or because WithS2Index is not set
+	ErrNoIndex = errors.New("compression: no index available")
+	// ErrNotSeekable is returned when random access is requested for an
+	// algorithm that does not support it
+	ErrNotSeekable = errors.New("compression: algorithm does not support seeking")
+)
+
+// WithS2Index makes S2 writers build a seek index of the stream. The index
+// is not appended to the compressed output; it can be retrieved after Close
+// through IndexExporter and stored separately, e.g. in a database while the
+// payload lives in object storage.
+func WithS2Index() Option {
+	return func(m *Middleware) {
+		m.s2Index = true
+	}
+}
+
+// IndexExporter is implemented by writers returned from Middleware.Writer
+type IndexExporter interface {
+	// ExportIndex writes the index of the closed stream to w
+	ExportIndex(w io.Writer) error
+}
+
+func (w *writer) ExportIndex(dst io.Writer) error {
+	if !w.closed || len(w.index) == 0 {
+		return ErrNoIndex
+	}
+	_, err := dst.Write(w.index)
+	return err
+}
+
+// Index is a seek index of a compressed stream
+type Index struct {
+	raw []byte
+	idx s2.Index
+}
+
+// LoadIndex reads an index previously written by ExportIndex
+func LoadIndex(r io.Reader) (*Index, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	i := &Index{raw: raw}
+	if _, err := i.idx.Load(raw); err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// TotalUncompressed returns the uncompressed size of the indexed stream
+func (i *Index) TotalUncompressed() int64 {
+	return i.idx.TotalUncompressed
+}
+
+// Find returns the compressed and uncompressed offsets of the block
+// containing the uncompressed offset
+func (i *Index) Find(offset int64) (compressedOff, uncompressedOff int64, err error) {
+	return i.idx.Find(offset)
+}
+
+// SeekableReader returns a reader over the compressed stream rs that can
+// seek to arbitrary uncompressed offsets using idx. Only S2 streams are
+// supported. With WithReadahead, the blocks following the read position are
+// decoded ahead; the reader must then be closed.
+func (m *Middleware) SeekableReader(rs io.ReadSeeker, idx *Index) (io.ReadSeeker, error) {
+	if m.algorithm != S2 {
+		return nil, ErrNotSeekable
+	}
+	var raw []byte
+	if idx != nil {
+		raw = idx.raw
+	}
+	m.stats.readers.Add(1)
+	s, err := s2.NewReader(rs).ReadSeeker(true, raw)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	if m.readahead <= 0 {
+		return seekableReader{s}, nil
+	}
+	return newReadaheadReader(m, seekableReader{s}, m.readahead, readaheadChunk), nil
+}
+
+// seekableReader classifies the errors of an S2 ReadSeeker
+type seekableReader struct {
+	s *s2.ReadSeeker
+}
+
+func (r seekableReader) Read(p []byte) (int, error) {
+	n, err := r.s.Read(p)
+	return n, classifyError(err)
+}
+
+func (r seekableReader) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.s.ReadAt(p, off)
+	return n, classifyError(err)
+}
+
+func (r seekableReader) Seek(offset int64, whence int) (int64, error) {
+	return r.s.Seek(offset, whence)
+}
@@ -0,0 +1,103 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestS2Index_ExportLoadSeek(t *testing.T) {
+	m := New(S2, WithS2Index())
+
+	testData := make([]byte, 4<<20)
+	for i := range testData {
+		testData[i] = byte(i / 1024)
+	}
+
+	var payload bytes.Buffer
+	w := m.Writer(&payload)
+	w.Write(testData)
+
+	if err := w.(IndexExporter).ExportIndex(io.Discard); err != ErrNoIndex {
+		t.Fatalf("Expected ErrNoIndex before Close, got %v", err)
+	}
+	w.(io.Closer).Close()
+
+	// The index is stored separately from the payload
+	var sidecar bytes.Buffer
+	if err := w.(IndexExporter).ExportIndex(&sidecar); err != nil {
+		t.Fatalf("Failed to export index: %v", err)
+	}
+
+	idx, err := LoadIndex(&sidecar)
+	if err != nil {
+		t.Fatalf("Failed to load index: %v", err)
+	}
+	if idx.TotalUncompressed() != int64(len(testData)) {
+		t.Fatalf("Expected %d uncompressed bytes, got %d", len(testData), idx.TotalUncompressed())
+	}
+
+	rs, err := m.SeekableReader(bytes.NewReader(payload.Bytes()), idx)
+	if err != nil {
+		t.Fatalf("Failed to create seekable reader: %v", err)
+	}
+	offset := int64(3<<20 + 12345)
+	if _, err := rs.Seek(offset, io.SeekStart); err != nil {
+		t.Fatalf("Failed to seek: %v", err)
+	}
+	got := make([]byte, 100)
+	if _, err := io.ReadFull(rs, got); err != nil {
+		t.Fatalf("Failed to read after seek: %v", err)
+	}
+	if !bytes.Equal(got, testData[offset:offset+100]) {
+		t.Fatal("Data mismatch after seek")
+	}
+
+	// The payload is still a plain S2 stream
+	data, err := io.ReadAll(m.Reader(bytes.NewReader(payload.Bytes())))
+	if err != nil || !bytes.Equal(data, testData) {
+		t.Fatalf("Failed to read payload sequentially: %v", err)
+	}
+}
+
+func TestSeekableReader_Unsupported(t *testing.T) {
+	if _, err := New(Gzip).SeekableReader(bytes.NewReader(nil), nil); err != ErrNotSeekable {
+		t.Fatalf("Expected ErrNotSeekable, got %v", err)
+	}
+}
+
+func TestSeekableReader_Corrupt(t *testing.T) {
+	m := New(S2, WithS2Index())
+	testData := make([]byte, 2<<20)
+	for i := range testData {
+		testData[i] = byte(i * 7 / 1024)
+	}
+	var payload bytes.Buffer
+	w := m.Writer(&payload)
+	w.Write(testData)
+	w.(io.Closer).Close()
+	var sidecar bytes.Buffer
+	w.(IndexExporter).ExportIndex(&sidecar)
+	idx, err := LoadIndex(&sidecar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt := payload.Bytes()
+	for i := len(corrupt) / 2; i < len(corrupt)/2+64; i++ {
+		corrupt[i] ^= 0xff
+	}
+	for _, m := range []*Middleware{m, New(S2, WithReadahead(2))} {
+		rs, err := m.SeekableReader(bytes.NewReader(corrupt), idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(rs); !errors.Is(err, ErrCorruptStream) {
+			t.Errorf("Expected ErrCorruptStream, got %v", err)
+		}
+		if c, ok := rs.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
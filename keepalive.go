@@ -0,0 +1,108 @@
+package compression
+
+import (
+	"sync"
+	"time"
+)
+
+// WithKeepalive makes writers emit a keepalive when nothing has been written
+// for interval, so intermediaries such as load balancers or multipart upload
+// sessions do not time out idle streams. A keepalive flushes pending data and
+// adds a few bytes that readers skip: an empty stored frame in framed streams,
+// the empty sync block of a flush for Gzip, Zlib and Flate, a repeated stream
+// identifier for S2 and Snappy, and a skippable frame or empty raw block for
+// Zstd. Bzip2 streams cannot carry keepalives and are left idle. Keepalives
+// shift the compressed offsets recorded by WithS2Index, so the two options
+// should not be combined.
+func WithKeepalive(interval time.Duration) Option {
+	return func(m *Middleware) {
+		m.keepalive = interval
+	}
+}
+
+// Keepalive payloads
+var (
+	s2StreamIdentifier     = []byte("\xff\x06\x00\x00S2sTwO")
+	snappyStreamIdentifier = []byte("\xff\x06\x00\x00sNaPpY")
+	// zstdSkippableFrame is a skippable frame without content
+	zstdSkippableFrame = []byte{0x50, 0x2a, 0x4d, 0x18, 0, 0, 0, 0}
+	// zstdEmptyBlock is a raw block without content that is not the last
+	zstdEmptyBlock = []byte{0, 0, 0}
+)
+
+// keepalive serializes a writer with its idle timer
+type keepalive struct {
+	mu    sync.Mutex
+	timer *time.Timer
+	// written counts the keepalive bytes written outside of frames
+	written int64
+}
+
+// startKeepalive arms the idle timer of w
+func (w *writer) startKeepalive() {
+	k := &keepalive{}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	w.keepalive = k
+	k.timer = time.AfterFunc(w.m.keepalive, w.idle)
+}
+
+// lock locks w against keepalives and postpones the next one
+func (w *writer) lock() {
+	if w.keepalive != nil {
+		w.keepalive.mu.Lock()
+		w.keepalive.timer.Reset(w.m.keepalive)
+	}
+}
+
+func (w *writer) unlock() {
+	if w.keepalive != nil {
+		w.keepalive.mu.Unlock()
+	}
+}
+
+// idle is called by the timer when w has been idle for the interval
+func (w *writer) idle() {
+	w.keepalive.mu.Lock()
+	defer w.keepalive.mu.Unlock()
+	if w.closed {
+		return
+	}
+	if err := w.writeKeepalive(); err != nil {
+		// The next write reports the failure of the underlying writer
+		return
+	}
+	w.keepalive.timer.Reset(w.m.keepalive)
+}
+
+func (w *writer) writeKeepalive() error {
+	if f, ok := w.enc.(*framedWriter); ok {
+		return f.cw.keepalive()
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	var p []byte
+	switch w.m.algorithm {
+	case S2:
+		p = s2StreamIdentifier
+	case Snappy:
+		p = snappyStreamIdentifier
+	case Zstd:
+		p = zstdEmptyBlock
+		if w.out.total == w.keepalive.written {
+			// No frame started yet
+			p = zstdSkippableFrame
+		}
+		if d, ok := w.enc.(interface{ betweenFrames() bool }); ok && d.betweenFrames() {
+			// The flush ended the frame, see WithDeclaredSizes
+			p = zstdSkippableFrame
+		}
+	default:
+		// The flush wrote an empty sync block
+		return nil
+	}
+	n, err := w.out.Write(p)
+	w.keepalive.written += int64(n)
+	return err
+}
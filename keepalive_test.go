@@ -0,0 +1,93 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is a bytes.Buffer safe for the keepalive goroutine
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Len()
+}
+
+func TestWithKeepalive(t *testing.T) {
+	requireZstd(t)
+	for _, tc := range []struct {
+		name string
+		m    *Middleware
+	}{
+		{"gzip", New(Gzip, WithKeepalive(5*time.Millisecond))},
+		{"zlib", New(Zlib, WithKeepalive(5*time.Millisecond))},
+		{"zstd", New(Zstd, WithKeepalive(5*time.Millisecond))},
+		{"s2", New(S2, WithKeepalive(5*time.Millisecond))},
+		{"snappy", New(Snappy, WithKeepalive(5*time.Millisecond))},
+		{"framed", New(Zstd, WithFrameSize(1024), WithKeepalive(5*time.Millisecond))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out lockedBuffer
+			w := tc.m.Writer(&out)
+
+			// Keepalives before any data
+			time.Sleep(30 * time.Millisecond)
+			if out.Len() == 0 {
+				t.Fatal("Expected keepalive output while idle")
+			}
+			w.Write([]byte("hello "))
+			n := out.Len()
+			time.Sleep(30 * time.Millisecond)
+			if out.Len() == n {
+				t.Fatal("Expected keepalive output after data")
+			}
+			w.Write(bytes.Repeat([]byte("world "), 500))
+			w.(io.Closer).Close()
+
+			n = out.Len()
+			time.Sleep(20 * time.Millisecond)
+			if out.Len() != n {
+				t.Fatal("Expected no keepalives after Close")
+			}
+
+			got, err := io.ReadAll(tc.m.Reader(bytes.NewReader(out.buf.Bytes())))
+			if err != nil {
+				t.Fatalf("Failed to read stream with keepalives: %v", err)
+			}
+			if want := "hello " + string(bytes.Repeat([]byte("world "), 500)); string(got) != want {
+				t.Fatalf("Expected %d bytes, got %d", len(want), len(got))
+			}
+		})
+	}
+}
+
+func TestWithKeepalive_NotIndexed(t *testing.T) {
+	m := New(S2, WithFrameSize(100), WithKeepalive(time.Hour))
+	var out bytes.Buffer
+	w := m.Writer(&out)
+	w.Write(bytes.Repeat([]byte("x"), 150))
+	w.(*writer).writeKeepalive()
+	w.Write(bytes.Repeat([]byte("y"), 50))
+	w.(io.Closer).Close()
+
+	index, err := ReadContainerIndex(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index) != 2 || index[1].UncompressedOffset != 100 || index[1].Size != 100 {
+		t.Fatalf("Unexpected index %+v", index)
+	}
+}
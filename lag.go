@@ -0,0 +1,60 @@
+package compression
+
+import "time"
+
+// WithStoredUnderLag makes framed writers store frames uncompressed while
+// they lag more than maxLag behind the producer, keeping the pipeline live
+// during traffic spikes at the cost of ratio. The lag of a frame is the time
+// from when its data was handed to the middleware until its compression
+// starts: the start of the Write call, or the Write of an AsyncWriter, whose
+// queue makes the lag grow while the encoder falls behind. Stored frames are
+// counted in Stats.LaggingFrames. With WithFramePayloadHooks they are
+// written as frames of algorithm None, which the hooks can tell apart. The
+// option implies framing with DefaultFrameSize unless WithFrameSize is set.
+func WithStoredUnderLag(maxLag time.Duration) Option {
+	return func(m *Middleware) {
+		m.maxLag = maxLag
+		if m.frameSize == 0 {
+			m.frameSize = DefaultFrameSize
+		}
+	}
+}
+
+// arrived records the start of a Write as the arrival of its data unless
+// the caller set it, returning the function that clears it again
+func (f *framedWriter) arrived() func() {
+	if f.maxLag <= 0 || !f.since.IsZero() {
+		return func() {}
+	}
+	f.since = time.Now()
+	return func() { f.since = time.Time{} }
+}
+
+// lagging reports whether the buffered frame is later than the lag allows
+func (f *framedWriter) lagging() bool {
+	return f.maxLag > 0 && !f.since.IsZero() && time.Since(f.since) > f.maxLag
+}
+
+// uncompressed returns the buffered frame as a stored frame
+func (f *framedWriter) uncompressed() frameRecord {
+	m := f.codecs[0].m
+	m.stats.laggingFrames.Add(1)
+	if m.wrapPayload != nil {
+		return frameRecord{typ: frameCoded, algorithm: None, size: len(f.buf), payload: f.buf}
+	}
+	return frameRecord{typ: frameStored, size: len(f.buf), payload: f.buf}
+}
+
+// writeSince writes p like Write for data handed to the middleware at since,
+// see WithStoredUnderLag
+func (w *writer) writeSince(p []byte, since time.Time) (int, error) {
+	f, ok := w.enc.(*framedWriter)
+	if !ok || f.maxLag <= 0 {
+		return w.Write(p)
+	}
+	return w.write(p, func(p []byte) (int, error) {
+		f.since = since
+		defer func() { f.since = time.Time{} }()
+		return f.Write(p)
+	})
+}
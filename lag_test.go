@@ -0,0 +1,92 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+// slowFrames returns a WithFrameLevel option delaying the compression of
+// every frame by d
+func slowFrames(d time.Duration) Option {
+	return WithFrameLevel(func(int, []byte) Level {
+		time.Sleep(d)
+		return Default
+	})
+}
+
+func TestWithStoredUnderLag(t *testing.T) {
+	xorPayload := func(_ uint64, _ byte, p []byte) ([]byte, error) {
+		out := make([]byte, len(p))
+		for i, b := range p {
+			out[i] = b ^ 0x5a
+		}
+		return out, nil
+	}
+	data := bytes.Repeat([]byte("lagging "), 4*1024/8)
+	for name, opts := range map[string][]Option{
+		"stored": nil,
+		"hooks":  {WithFramePayloadHooks(xorPayload, xorPayload)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := New(S2, append(opts, WithFrameSize(1024), WithStoredUnderLag(20*time.Millisecond), slowFrames(30*time.Millisecond))...)
+			// The frames of one Write wait for the compression of the first
+			compressed := writeContainer(t, m, data)
+			if got := m.Stats().LaggingFrames; got != 3 {
+				t.Fatalf("Expected 3 frames stored under lag, got %d", got)
+			}
+			if len(compressed) < 3*1024 {
+				t.Fatalf("Expected the lagging frames uncompressed, got %d bytes", len(compressed))
+			}
+			got, err := io.ReadAll(New(S2, append(opts, WithFrameSize(1024))...).Reader(bytes.NewReader(compressed)))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("Failed to read: %v", err)
+			}
+		})
+	}
+}
+
+func TestWithStoredUnderLag_SlowProducer(t *testing.T) {
+	m := New(S2, WithFrameSize(1024), WithStoredUnderLag(time.Millisecond), slowFrames(5*time.Millisecond))
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	// Every Write completes a frame that is compressed right away
+	for i := 0; i < 4; i++ {
+		if _, err := w.Write(bytes.Repeat([]byte{'a' + byte(i)}, 1024)); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+	if err := w.(io.Closer).Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if got := m.Stats().LaggingFrames; got != 0 {
+		t.Fatalf("Expected no frames stored under lag, got %d", got)
+	}
+}
+
+func TestWithStoredUnderLag_AsyncWriter(t *testing.T) {
+	m := New(S2, WithFrameSize(1024), WithStoredUnderLag(10*time.Millisecond))
+	out := &gatedWriter{gate: make(chan struct{})}
+	a := m.AsyncWriter(out)
+	a.Queue = 8
+	data := bytes.Repeat([]byte("queued "), 4*1024/7+1)[:4*1024]
+	for p := data; len(p) > 0; p = p[1024:] {
+		if _, err := a.Write(p[:1024]); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+	// The first frame blocks on the sink while the others age in the queue
+	time.Sleep(50 * time.Millisecond)
+	close(out.gate)
+	if err := a.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if got := m.Stats().LaggingFrames; got != 3 {
+		t.Fatalf("Expected the 3 queued frames stored under lag, got %d", got)
+	}
+	got, err := io.ReadAll(m.Reader(&out.buf))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to read: %v", err)
+	}
+}
@@ -0,0 +1,110 @@
+package compression
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// Histograms are log-linear like HDR histograms: every power of two range
+// of nanoseconds is split into latencySubBuckets linear buckets, so bounds
+// are accurate to 1/latencySubBuckets. The buckets cover calls of up to
+// 2^40 nanoseconds, the last bucket everything slower.
+const (
+	latencySubBits    = 3
+	latencySubBuckets = 1 << latencySubBits
+	latencyBuckets    = (40 - latencySubBits + 1) * latencySubBuckets
+)
+
+// WithLatencyTracking records the latency of every Write and Read call in
+// histograms exposed through Stats and WritePrometheus
+func WithLatencyTracking() Option {
+	return func(m *Middleware) {
+		m.trackLatency = true
+	}
+}
+
+// Histogram is a snapshot of a latency histogram with log-linear buckets
+type Histogram struct {
+	Count int64
+	Sum   time.Duration
+	// Buckets[i] counts observations below BucketBound(i) and not below
+	// the bound of the previous bucket
+	Buckets [latencyBuckets]int64
+}
+
+// BucketBound returns the exclusive upper bound of bucket i
+func BucketBound(i int) time.Duration {
+	if i < latencySubBuckets {
+		return time.Duration(i + 1)
+	}
+	shift := i/latencySubBuckets - 1
+	return time.Duration(i%latencySubBuckets+latencySubBuckets+1) << shift
+}
+
+// latencyBucket returns the bucket of an observation of d
+func latencyBucket(d time.Duration) int {
+	v := uint64(max(d, 0))
+	shift := max(bits.Len64(v)-latencySubBits-1, 0)
+	return min(shift*latencySubBuckets+int(v>>shift), latencyBuckets-1)
+}
+
+// Quantile returns an upper bound of the latency below which the fraction q
+// of all observations fall, with a precision of 12.5%
+func (h Histogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := int64(q * float64(h.Count))
+	var seen int64
+	for i, n := range h.Buckets {
+		seen += n
+		if seen > rank {
+			return BucketBound(i)
+		}
+	}
+	return BucketBound(latencyBuckets - 1)
+}
+
+// Mean returns the average latency
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// plus returns h with the observations of o added
+func (h Histogram) plus(o Histogram) Histogram {
+	h.Count += o.Count
+	h.Sum += o.Sum
+	for i, n := range o.Buckets {
+		h.Buckets[i] += n
+	}
+	return h
+}
+
+type latencyHistogram struct {
+	buckets [latencyBuckets]atomic.Int64
+	count   atomic.Int64
+	sum     atomic.Int64
+	// tag is the histogram of the middleware tag, see WithTag
+	tag *latencyHistogram
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	if h.tag != nil {
+		h.tag.observe(d)
+	}
+	h.buckets[latencyBucket(d)].Add(1)
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *latencyHistogram) snapshot() Histogram {
+	s := Histogram{Count: h.count.Load(), Sum: time.Duration(h.sum.Load())}
+	for i := range h.buckets {
+		s.Buckets[i] = h.buckets[i].Load()
+	}
+	return s
+}
@@ -0,0 +1,82 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyTracking(t *testing.T) {
+	requireZstd(t)
+	m := New(Zstd, WithLatencyTracking())
+
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	for i := 0; i < 10; i++ {
+		w.Write(bytes.Repeat([]byte("latency "), 1000))
+	}
+	w.(io.Closer).Close()
+	io.ReadAll(m.Reader(&buf))
+
+	s := m.Stats()
+	if s.WriteLatency.Count != 10 {
+		t.Fatalf("Expected 10 write observations, got %d", s.WriteLatency.Count)
+	}
+	if s.ReadLatency.Count == 0 {
+		t.Fatal("Expected read observations")
+	}
+	if p99 := s.WriteLatency.Quantile(0.99); p99 <= 0 || p99 < s.WriteLatency.Quantile(0.5) {
+		t.Fatalf("Unexpected quantiles p50=%v p99=%v", s.WriteLatency.Quantile(0.5), p99)
+	}
+
+	var prom bytes.Buffer
+	if err := m.WritePrometheus(&prom, "hybridbuffer_compression"); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	for _, want := range []string{
+		"hybridbuffer_compression_bytes_written_total 80000\n",
+		"# TYPE hybridbuffer_compression_write_duration_seconds histogram\n",
+		"hybridbuffer_compression_write_duration_seconds_count 10\n",
+		`hybridbuffer_compression_write_duration_seconds_bucket{le="+Inf"} 10`,
+	} {
+		if !strings.Contains(prom.String(), want) {
+			t.Fatalf("Expected metrics to contain %q:\n%s", want, prom.String())
+		}
+	}
+}
+
+func TestHistogram_Quantile(t *testing.T) {
+	var h latencyHistogram
+	for i := 0; i < 99; i++ {
+		h.observe(time.Microsecond)
+	}
+	h.observe(time.Second)
+
+	s := h.snapshot()
+	if q := s.Quantile(0.5); q < time.Microsecond || q > time.Microsecond*9/8 {
+		t.Fatalf("Unexpected median %v", q)
+	}
+	if q := s.Quantile(0.999); q < time.Second || q > time.Second*9/8 {
+		t.Fatalf("Unexpected tail %v", q)
+	}
+}
+
+func TestLatencyBucket(t *testing.T) {
+	for i := 0; i < latencyBuckets-1; i++ {
+		lower := time.Duration(0)
+		if i > 0 {
+			lower = BucketBound(i - 1)
+		}
+		if b := latencyBucket(lower); b != i {
+			t.Fatalf("Expected %v in bucket %d, got %d", lower, i, b)
+		}
+		if b := latencyBucket(BucketBound(i) - 1); b != i {
+			t.Fatalf("Expected %v in bucket %d, got %d", BucketBound(i)-1, i, b)
+		}
+	}
+	if b := latencyBucket(time.Hour); b != latencyBuckets-1 {
+		t.Fatalf("Expected an hour in the last bucket, got %d", b)
+	}
+}
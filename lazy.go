@@ -0,0 +1,84 @@
+package compression
+
+import "io"
+
+// lazyReader opens a decoder that parses a header on the first Read rather
+// than when the reader is created, so that creating a reader never touches
+// the source and a missing or corrupt header is returned by Read. An empty
+// source reads as an empty stream.
+type lazyReader struct {
+	src  *touchReader
+	in   io.Reader
+	open func(io.Reader) (io.Reader, error)
+	dec  io.Reader
+	err  error
+}
+
+func newLazyReader(r io.Reader, open func(io.Reader) (io.Reader, error)) *lazyReader {
+	l := &lazyReader{src: &touchReader{r: r}, open: open}
+	l.in = l.src
+	if br, ok := r.(io.ByteReader); ok {
+		// codecs then read exactly up to the end of the stream
+		l.in = &touchByteReader{l.src, br}
+	}
+	return l
+}
+
+// init opens the decoder once
+func (l *lazyReader) init() error {
+	if l.dec == nil && l.err == nil {
+		l.dec, l.err = l.open(l.in)
+		if l.err == io.ErrUnexpectedEOF && !l.src.touched {
+			// Codecs differ in reporting an empty source
+			l.err = io.EOF
+		}
+	}
+	return l.err
+}
+
+func (l *lazyReader) Read(p []byte) (int, error) {
+	if err := l.init(); err != nil {
+		return 0, err
+	}
+	return l.dec.Read(p)
+}
+
+func (l *lazyReader) WriteTo(w io.Writer) (int64, error) {
+	if err := l.init(); err == io.EOF {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return io.Copy(w, l.dec)
+}
+
+func (l *lazyReader) Close() error {
+	if c, ok := l.dec.(io.Closer); ok && l.err == nil {
+		return c.Close()
+	}
+	return nil
+}
+
+// touchReader records whether any data was read from r
+type touchReader struct {
+	r       io.Reader
+	touched bool
+}
+
+func (t *touchReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	t.touched = t.touched || n > 0
+	return n, err
+}
+
+// touchByteReader is a touchReader over an io.ByteReader
+type touchByteReader struct {
+	*touchReader
+	br io.ByteReader
+}
+
+func (t *touchByteReader) ReadByte() (byte, error) {
+	c, err := t.br.ReadByte()
+	t.touched = t.touched || err == nil
+	return c, err
+}
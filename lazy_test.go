@@ -0,0 +1,45 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+func TestLazyHeader(t *testing.T) {
+	for _, a := range []Algorithm{Gzip, Zlib} {
+		t.Run(a.String(), func(t *testing.T) {
+			m := New(a)
+			src := &countingSource{r: bytes.NewReader(nil)}
+			r := m.Reader(src)
+			if src.n.Load() != 0 {
+				t.Fatal("Expected the header to be read on the first Read")
+			}
+			if got, err := io.ReadAll(r); err != nil || len(got) != 0 {
+				t.Fatalf("Expected an empty source to read as an empty stream, got %d bytes, %v", len(got), err)
+			}
+			var buf bytes.Buffer
+			if n, err := m.Reader(bytes.NewReader(nil)).(io.WriterTo).WriteTo(&buf); n != 0 || err != nil {
+				t.Fatalf("Expected WriteTo to copy nothing, got %d, %v", n, err)
+			}
+
+			stream := writeContainer(t, m, []byte("truncated header"))
+			if _, err := io.ReadAll(m.Reader(bytes.NewReader(stream[:3]))); !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Fatalf("Expected io.ErrUnexpectedEOF for a truncated header, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLazyHeader_Corrupt(t *testing.T) {
+	r := New(Gzip).Reader(bytes.NewReader([]byte("this is not a gzip stream")))
+	if _, err := io.ReadAll(r); !errors.Is(err, gzip.ErrHeader) {
+		t.Fatalf("Expected gzip.ErrHeader, got %v", err)
+	}
+	if err := r.(io.Closer).Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+package compression
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrGlobalLimit is returned by writers and readers that would exceed the
+// process-wide limits set with SetGlobalLimits
+var ErrGlobalLimit = fmt.Errorf("%w: global limit", ErrLimitExceeded)
+
+// Limits are process-wide limits shared by all middleware, see
+// SetGlobalLimits. Zero values are unlimited.
+type Limits struct {
+	// MaxConcurrentStreams limits the writers and readers open at once
+	MaxConcurrentStreams int64
+	// MaxTotalEncoderMemory limits the estimated encoder memory of the open
+	// writers, see CostEstimate
+	MaxTotalEncoderMemory int64
+	// MaxDecompressedBytesPerSecond limits the rate at which all readers
+	// together return decompressed data. Readers exceeding it are delayed,
+	// and fail with ErrDecodeTimeout instead of waiting beyond the deadline
+	// of WithDecodeTimeout.
+	MaxDecompressedBytesPerSecond int64
+}
+
+var global struct {
+	limits  atomic.Pointer[Limits]
+	streams atomic.Int64
+	memory  atomic.Int64
+
+	// next is when the decompressed bytes returned so far are due at the
+	// configured rate
+	mu   sync.Mutex
+	next time.Time
+}
+
+// SetGlobalLimits sets limits enforced across all middleware of the process,
+// so a misconfigured caller cannot exhaust the host. Writers and readers
+// created beyond MaxConcurrentStreams or MaxTotalEncoderMemory fail with
+// ErrGlobalLimit. A writer holds its share until Close or Abort, a reader
+// until Close or until it returned its first error such as io.EOF. Streams
+// abandoned before that keep their share for the lifetime of the process,
+// so writers must always be closed, and readers unless read to the end.
+// Streams already open keep running when the limits are lowered.
+func SetGlobalLimits(l Limits) {
+	global.limits.Store(&l)
+}
+
+// acquireGlobal reserves a stream with an encoder of memory bytes
+func acquireGlobal(memory int64) error {
+	var l Limits
+	if p := global.limits.Load(); p != nil {
+		l = *p
+	}
+	streams := global.streams.Add(1)
+	total := global.memory.Add(memory)
+	switch {
+	case l.MaxConcurrentStreams > 0 && streams > l.MaxConcurrentStreams:
+		releaseGlobal(memory)
+		return fmt.Errorf("%w: more than %d concurrent streams", ErrGlobalLimit, l.MaxConcurrentStreams)
+	case l.MaxTotalEncoderMemory > 0 && memory > 0 && total > l.MaxTotalEncoderMemory:
+		releaseGlobal(memory)
+		return fmt.Errorf("%w: encoders need more than %d bytes", ErrGlobalLimit, l.MaxTotalEncoderMemory)
+	}
+	return nil
+}
+
+func releaseGlobal(memory int64) {
+	global.streams.Add(-1)
+	global.memory.Add(-memory)
+}
+
+// throttleGlobal delays the caller until n more decompressed bytes fit the
+// global rate, counting waits for the lock into waits. It fails with
+// ErrDecodeTimeout without waiting if the delay would pass deadline, unless
+// it is zero.
+func throttleGlobal(n int64, waits *counter, deadline time.Time) error {
+	p := global.limits.Load()
+	if p == nil || p.MaxDecompressedBytesPerSecond <= 0 || n <= 0 {
+		return nil
+	}
+	rate := p.MaxDecompressedBytesPerSecond
+	lockCounted(&global.mu, waits)
+	now := time.Now()
+	if global.next.Before(now) {
+		global.next = now
+	}
+	next := global.next.Add(time.Duration(float64(n) / float64(rate) * float64(time.Second)))
+	if !deadline.IsZero() && next.After(deadline) {
+		global.mu.Unlock()
+		return fmt.Errorf("%w: throttled beyond the decode deadline", ErrDecodeTimeout)
+	}
+	global.next = next
+	global.mu.Unlock()
+	time.Sleep(next.Sub(now))
+	return nil
+}
+
+// throttleWriter applies the global rate to the data written through it
+type throttleWriter struct {
+	w        io.Writer
+	waits    *counter
+	deadline time.Time
+}
+
+func (t *throttleWriter) Write(p []byte) (int, error) {
+	if err := throttleGlobal(int64(len(p)), t.waits, t.deadline); err != nil {
+		return 0, err
+	}
+	return t.w.Write(p)
+}
+
+// throttled reports whether a global rate is set
+func throttled() bool {
+	p := global.limits.Load()
+	return p != nil && p.MaxDecompressedBytesPerSecond > 0
+}
+
+// encoderMemory estimates the memory of a writer at level l
+func (m *Middleware) encoderMemory(l Level) int64 {
+	p := m.memory
+	if p == nil {
+		p = &memoryPlan{zstdWindow: maxZstdWindow, s2Block: maxS2Block}
+	}
+	enc, _ := codecMemory(m.algorithm, l, p)
+	enc += m.parallelGzipMemory(p)
+	if m.frameSize > 0 {
+		enc += 2 * int64(m.frameSize)
+	}
+	return enc
+}
+
+// deadline returns the deadline of WithDecodeTimeout, zero if r has none or
+// has not been read yet
+func (r *reader) deadline() time.Time {
+	if l, ok := r.dec.(*limitedReader); ok {
+		return l.deadline
+	}
+	return time.Time{}
+}
+
+// releaseGlobal returns the global share of r once
+func (r *reader) releaseGlobal() {
+	if r.global {
+		r.global = false
+		releaseGlobal(0)
+	}
+}
@@ -0,0 +1,97 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSetGlobalLimits_Streams(t *testing.T) {
+	requireZstd(t)
+	t.Cleanup(func() { SetGlobalLimits(Limits{}) })
+	// Streams left open by other tests count as well
+	SetGlobalLimits(Limits{MaxConcurrentStreams: global.streams.Load() + 1})
+
+	var buf bytes.Buffer
+	w := New(Zstd).Writer(&buf)
+	if _, err := New(S2).Writer(io.Discard).Write([]byte("x")); !errors.Is(err, ErrGlobalLimit) {
+		t.Fatalf("Expected ErrGlobalLimit for a second writer, got %v", err)
+	}
+	if _, err := New(S2).Reader(&buf).Read(make([]byte, 1)); !errors.Is(err, ErrGlobalLimit) {
+		t.Fatalf("Expected ErrGlobalLimit for a reader, got %v", err)
+	}
+	w.Write([]byte("global limits"))
+	if err := w.(io.Closer).Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	r := New(Zstd).Reader(&buf)
+	if got, err := io.ReadAll(r); err != nil || string(got) != "global limits" {
+		t.Fatalf("Expected the data after Close freed the stream, got %q, %v", got, err)
+	}
+	// The reader released its stream at EOF
+	if _, err := New(S2).Writer(io.Discard).Write([]byte("x")); err != nil {
+		t.Fatalf("Expected a writer after the reader finished, got %v", err)
+	}
+}
+
+func TestSetGlobalLimits_EncoderMemory(t *testing.T) {
+	requireZstd(t)
+	t.Cleanup(func() { SetGlobalLimits(Limits{}) })
+	m := New(Zstd, WithLevel(Best))
+	SetGlobalLimits(Limits{MaxTotalEncoderMemory: global.memory.Load() + m.encoderMemory(Best)})
+
+	w := m.Writer(io.Discard)
+	if _, err := m.Writer(io.Discard).Write([]byte("x")); !errors.Is(err, ErrGlobalLimit) {
+		t.Fatalf("Expected ErrGlobalLimit, got %v", err)
+	}
+	w.(io.Closer).Close()
+	w = m.Writer(io.Discard)
+	if _, err := w.Write([]byte("x")); err != nil {
+		t.Fatalf("Expected a writer after Close freed the memory, got %v", err)
+	}
+	w.(io.Closer).Close()
+}
+
+func TestSetGlobalLimits_Rate(t *testing.T) {
+	t.Cleanup(func() { SetGlobalLimits(Limits{}) })
+	m := New(S2)
+	data := bytes.Repeat([]byte("rate"), 64<<10)
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	w.Write(data)
+	w.(io.Closer).Close()
+
+	SetGlobalLimits(Limits{MaxDecompressedBytesPerSecond: 1 << 20})
+	start := time.Now()
+	got, err := io.ReadAll(m.Reader(&buf))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	// 256 KiB at 1 MiB/s
+	if d := time.Since(start); d < 200*time.Millisecond {
+		t.Fatalf("Expected the rate to delay the reader, took %v", d)
+	}
+}
+
+func TestSetGlobalLimits_RateDeadline(t *testing.T) {
+	t.Cleanup(func() { SetGlobalLimits(Limits{}) })
+	m := New(S2, WithDecodeTimeout(100*time.Millisecond))
+	data := bytes.Repeat([]byte("rate"), 64<<10)
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	w.Write(data)
+	w.(io.Closer).Close()
+
+	// 256 KiB at 256 KiB/s would take a second
+	SetGlobalLimits(Limits{MaxDecompressedBytesPerSecond: 256 << 10})
+	start := time.Now()
+	if _, err := io.ReadAll(m.Reader(&buf)); !errors.Is(err, ErrDecodeTimeout) {
+		t.Fatalf("Expected ErrDecodeTimeout, got %v", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("Expected the reader to fail at the deadline, took %v", d)
+	}
+}
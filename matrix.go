@@ -0,0 +1,87 @@
+package compression
+
+import (
+	"fmt"
+	"slices"
+)
+
+// CodecSettings are the tuned settings of one algorithm, see LevelMatrix
+type CodecSettings struct {
+	// Level is the level the algorithm is used with
+	Level Level
+	// Native, if positive, is the native setting used for Level: 1 to 9 for
+	// Gzip, Zlib, Flate and Bzip2, the encoder levels 1 to 4 for Zstd and
+	// the modes 1 to 3 for S2
+	Native int
+}
+
+// LevelMatrix holds the settings of every algorithm a middleware may use,
+// see WithLevelMatrix
+type LevelMatrix map[Algorithm]CodecSettings
+
+// WithLevelMatrix configures the settings per algorithm, so features
+// choosing the algorithm, such as WithFallbackAlgorithm and
+// WithPerFrameBestOf, use tuned settings for each codec instead of the
+// single level of the middleware. The settings of the algorithm in use
+// replace the level set with WithLevel; algorithms without settings use it.
+// Levels chosen per stream or frame, e.g. by WithAutoTune or WithFrameLevel,
+// are not replaced, but use the native settings of the matrix.
+func WithLevelMatrix(matrix LevelMatrix) Option {
+	return func(m *Middleware) {
+		m.matrix = matrix
+	}
+}
+
+// applyMatrix validates the level matrix, adds its native settings to the
+// level map and sets the level of the algorithm in use
+func (m *Middleware) applyMatrix() error {
+	var lm *LevelMap
+	for a, s := range m.matrix {
+		if _, ok := levelNames[s.Level]; !ok {
+			return fmt.Errorf("%w: unknown level %d for %s in the level matrix", ErrInvalidLevel, int(s.Level), a)
+		}
+		if s.Native <= 0 {
+			continue
+		}
+		if !slices.Contains(nativeLevels[a], s.Native) {
+			return fmt.Errorf("%w: native setting %d for %s in the level matrix", ErrInvalidLevel, s.Native, a)
+		}
+		if lm == nil {
+			lm = m.levels.clone()
+		}
+		if lm.native[a] == nil {
+			lm.native[a] = make(map[Level]int)
+		}
+		lm.native[a][s.Level] = s.Native
+	}
+	if lm != nil {
+		m.levels = lm
+	}
+	if s, ok := m.matrix[m.algorithm]; ok {
+		m.level = s.Level
+	}
+	return nil
+}
+
+// codecLevel returns the level algorithm a is used with in streams of level
+// l, see WithLevelMatrix
+func (m *Middleware) codecLevel(a Algorithm, l Level) Level {
+	if s, ok := m.matrix[a]; ok && l == m.level {
+		return s.Level
+	}
+	return l
+}
+
+// clone returns a copy of lm that can be modified, or an empty map for nil
+func (lm *LevelMap) clone() *LevelMap {
+	c := &LevelMap{native: make(map[Algorithm]map[Level]int)}
+	if lm != nil {
+		for a, levels := range lm.native {
+			c.native[a] = make(map[Level]int, len(levels))
+			for l, n := range levels {
+				c.native[a][l] = n
+			}
+		}
+	}
+	return c
+}
@@ -0,0 +1,69 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestWithLevelMatrix_Fallback(t *testing.T) {
+	matrix := LevelMatrix{Gzip: {Level: Best, Native: 1}, Zstd: {Level: Fastest}}
+	m := New(Algorithm(99), WithFallbackAlgorithm(Gzip), WithLevel(Default), WithLevelMatrix(matrix))
+	if m.algorithm != Gzip || m.level != Best {
+		t.Fatalf("Expected gzip at Best from the matrix, got %s at %s", m.algorithm, m.level)
+	}
+	if n, ok := m.levels.Native(Gzip, Best); !ok || n != 1 {
+		t.Fatalf("Expected native setting 1 for gzip at Best, got %d", n)
+	}
+
+	data := bytes.Repeat([]byte("tuned per codec "), 4096)
+	got := writeContainer(t, m, data)
+	if want := writeContainer(t, New(Gzip, WithLevel(Fastest)), data); !bytes.Equal(got, want) {
+		t.Fatalf("Expected the output of gzip level 1, got %d bytes instead of %d", len(got), len(want))
+	}
+	if out, err := io.ReadAll(m.Reader(bytes.NewReader(got))); err != nil || !bytes.Equal(out, data) {
+		t.Fatalf("Failed to read: %v", err)
+	}
+}
+
+func TestWithLevelMatrix_BestOf(t *testing.T) {
+	requireZstd(t)
+	m := New(S2, WithLevel(Fastest), WithPerFrameBestOf(S2, Zstd, Gzip), WithLevelMatrix(LevelMatrix{Zstd: {Level: Best}, Gzip: {Level: Better}}))
+	f := newFramedWriter(m, m.level, io.Discard)
+	want := map[Algorithm]Level{S2: Fastest, Zstd: Best, Gzip: Better}
+	for _, c := range f.codecs {
+		if c.level != want[c.algorithm] {
+			t.Fatalf("Expected %s at %s, got %s", c.algorithm, want[c.algorithm], c.level)
+		}
+	}
+
+	// Levels chosen per stream are kept
+	f = newFramedWriter(m, Default, io.Discard)
+	for _, c := range f.codecs {
+		if c.level != Default {
+			t.Fatalf("Expected %s at the stream level, got %s", c.algorithm, c.level)
+		}
+	}
+}
+
+func TestWithLevelMatrix_Invalid(t *testing.T) {
+	for name, matrix := range map[string]LevelMatrix{
+		"level":  {Zstd: {Level: Level(42)}},
+		"native": {Zstd: {Level: Best, Native: 9}},
+	} {
+		if _, err := NewE(Gzip, WithLevelMatrix(matrix)); !errors.Is(err, ErrInvalidLevel) {
+			t.Fatalf("%s: expected ErrInvalidLevel, got %v", name, err)
+		}
+	}
+}
+
+func TestConfig_Matrix(t *testing.T) {
+	m := NewFactory(Config{Algorithm: Zstd, Matrix: LevelMatrix{Zstd: {Level: Better, Native: 3}}}).Tenant("a")
+	if m.level != Better {
+		t.Fatalf("Expected the matrix level, got %s", m.level)
+	}
+	if _, ok := m.DebugDump()["config"].(map[string]any)["level_matrix"]; !ok {
+		t.Fatal("Expected the matrix in the debug dump")
+	}
+}
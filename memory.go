@@ -0,0 +1,133 @@
+package compression
+
+import (
+	"fmt"
+)
+
+// ErrMemoryBudget is returned by writers and readers of middleware whose
+// configuration cannot fit the budget set with WithMemoryBudget
+var ErrMemoryBudget = fmt.Errorf("%w: configuration exceeds memory budget", ErrLimitExceeded)
+
+// Memory estimates used by WithMemoryBudget
+const (
+	maxZstdWindow = 8 << 20
+	minZstdWindow = 1 << 10
+	maxS2Block    = 1 << 20
+	minS2Block    = 4 << 10
+	codecBlock    = 128 << 10
+)
+
+// zstdTables estimates the match tables of the zstd encoder per level
+var zstdTables = map[Level]int64{
+	Fastest: 256 << 10,
+	Default: 1 << 20,
+	Better:  4 << 20,
+	Best:    16 << 20,
+}
+
+// memoryPlan holds the codec settings derived from a memory budget
+type memoryPlan struct {
+	zstdWindow int
+	s2Block    int
+	// gzipBlocks are the parallel gzip blocks kept in memory if fewer than
+	// configured, see WithSpillDir
+	gzipBlocks int
+}
+
+// WithMemoryBudget limits the memory used by the codecs of the middleware to
+// about bytes in total. The budget is shared by MaxActiveWriters streams if
+// a quota is set, otherwise it applies to a single stream. It is translated
+// into codec settings: the zstd window, the S2 block size and single
+// threaded encoding and decoding. Readers can then only decode zstd streams
+// written with a window that fits the budget. If the configuration cannot
+// fit, writers and readers fail with ErrMemoryBudget. The estimates are
+// approximate and cover codec buffers, not the caller's data.
+func WithMemoryBudget(bytes int64) Option {
+	return func(m *Middleware) {
+		m.memoryBudget = bytes
+	}
+}
+
+// streamBudget returns the memory budget of a single stream, or 0 without
+// WithMemoryBudget
+func (m *Middleware) streamBudget() int64 {
+	budget := m.memoryBudget
+	if m.quota.MaxActiveWriters > 0 {
+		budget /= m.quota.MaxActiveWriters
+	}
+	return budget
+}
+
+// planMemory derives the codec settings from the memory budget
+func (m *Middleware) planMemory() error {
+	budget := m.streamBudget()
+	p := &memoryPlan{zstdWindow: maxZstdWindow, s2Block: maxS2Block}
+	for p.zstdWindow > minZstdWindow && m.streamMemory(p) > budget {
+		p.zstdWindow /= 2
+	}
+	for p.s2Block > minS2Block && m.streamMemory(p) > budget {
+		p.s2Block /= 2
+	}
+	if m.spill && m.parallelGzipMemory(p) > 0 {
+		p.gzipBlocks = m.gzipWorkers()
+		for p.gzipBlocks > 1 && m.streamMemory(p) > budget {
+			p.gzipBlocks--
+		}
+		if p.gzipBlocks == m.gzipWorkers() {
+			p.gzipBlocks = 0
+		}
+	}
+	if need := m.streamMemory(p); need > budget {
+		return fmt.Errorf("%w: a %s stream needs about %d bytes, %d available", ErrMemoryBudget, m.algorithm, need, budget)
+	}
+	m.memory = p
+	return nil
+}
+
+// streamMemory estimates the memory of the larger of a writer and a reader
+// with plan p
+func (m *Middleware) streamMemory(p *memoryPlan) int64 {
+	algorithms := m.bestOf
+	if len(algorithms) == 0 {
+		algorithms = []Algorithm{m.algorithm}
+	}
+	var enc, dec int64
+	for _, a := range algorithms {
+		e, d := codecMemory(a, m.codecLevel(a, m.level), p)
+		enc += e
+		dec = max(dec, d)
+	}
+	enc += m.parallelGzipMemory(p)
+	if m.frameSize > 0 {
+		// The frame buffer, plus one output buffer per codec
+		enc += int64(m.frameSize) * int64(1+len(algorithms))
+		dec += int64(m.frameSize)
+	}
+	return max(enc, dec)
+}
+
+// codecMemory estimates the memory of an encoder and a decoder
+func codecMemory(a Algorithm, l Level, p *memoryPlan) (enc, dec int64) {
+	switch a {
+	case Zstd:
+		return 2*int64(p.zstdWindow) + zstdTables[l] + codecBlock, int64(p.zstdWindow) + 2*codecBlock
+	case S2:
+		return 2*int64(p.s2Block) + 64<<10, 2 * int64(p.s2Block)
+	case Snappy:
+		return 3 * 64 << 10, 2 * 64 << 10
+	case None:
+		return 0, 0
+	case SnappyBlock:
+		// Plus the buffered stream, one frame with WithFrameSize
+		return 64 << 10, 64 << 10
+	case Bzip2:
+		// The block with its suffix array, and the inverse transform
+		block := bzip2BlockSize(l)
+		return 6 * block, 5 * block
+	default:
+		if l == Fastest {
+			return 256 << 10, 64 << 10
+		}
+		return 1 << 20, 64 << 10
+	}
+}
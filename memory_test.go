@@ -0,0 +1,48 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestWithMemoryBudget(t *testing.T) {
+	requireZstd(t)
+	data := bytes.Repeat([]byte("budgeted stream "), 20000)
+
+	m := New(Zstd, WithMemoryBudget(4<<20), WithQuota(Quota{MaxActiveWriters: 2}))
+	if m.memory == nil || m.memory.zstdWindow >= maxZstdWindow {
+		t.Fatalf("Expected a reduced zstd window, got %+v", m.memory)
+	}
+	if need := m.streamMemory(m.memory); need > 2<<20 {
+		t.Fatalf("Expected the plan to fit 2 MiB per stream, needs %d", need)
+	}
+
+	for _, m := range []*Middleware{m, New(S2, WithMemoryBudget(256<<10))} {
+		var buf bytes.Buffer
+		w := m.Writer(&buf)
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		w.(io.Closer).Close()
+		got, err := io.ReadAll(m.Reader(&buf))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("Round trip failed: %v", err)
+		}
+	}
+}
+
+func TestWithMemoryBudget_Rejected(t *testing.T) {
+	var warning string
+	m := New(Zstd, WithLevel(Best), WithMemoryBudget(1<<20), WithWarningHook(func(msg string) { warning = msg }))
+	if warning == "" {
+		t.Fatal("Expected a warning for the rejected configuration")
+	}
+	if _, err := m.Writer(io.Discard).Write([]byte("x")); !errors.Is(err, ErrMemoryBudget) {
+		t.Fatalf("Expected ErrMemoryBudget from the writer, got %v", err)
+	}
+	if _, err := m.Reader(bytes.NewReader(nil)).Read(make([]byte, 1)); !errors.Is(err, ErrMemoryBudget) {
+		t.Fatalf("Expected ErrMemoryBudget from the reader, got %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package compression
+
+// RPCFrameSize is the frame size of WithRPCStreaming
+const RPCFrameSize = 4 << 10
+
+// WithRPCStreaming is a preset for RPC streaming: frames are capped at
+// RPCFrameSize and compressed at the Fastest level, and writers end a frame
+// on every message boundary marked with MessageWriter.EndMessage, so each
+// message can be decoded as soon as it arrives, independently of the rest
+// of the stream.
+func WithRPCStreaming() Option {
+	return func(m *Middleware) {
+		m.frameSize = RPCFrameSize
+		m.level = Fastest
+	}
+}
+
+// MessageWriter is implemented by writers returned from Middleware.Writer
+type MessageWriter interface {
+	// EndMessage marks the end of a message: the data written since the
+	// previous message is compressed and written to the underlying writer
+	// without waiting for more data. In framed streams the next message
+	// starts a new frame.
+	EndMessage() error
+}
+
+func (w *writer) EndMessage() error {
+	return w.Flush()
+}
@@ -0,0 +1,50 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestWithRPCStreaming(t *testing.T) {
+	requireZstd(t)
+	m := New(Zstd, WithRPCStreaming())
+	var wire bytes.Buffer
+	w := m.Writer(&wire)
+	cr := containerReader{r: bufio.NewReader(&wire)}
+
+	for i := 0; i < 5; i++ {
+		msg := []byte(fmt.Sprintf(`{"seq":%d,"payload":"%s"}`, i, bytes.Repeat([]byte("x"), i*100)))
+		w.Write(msg)
+		if err := w.(MessageWriter).EndMessage(); err != nil {
+			t.Fatalf("Failed to end message: %v", err)
+		}
+
+		// The message is decodable from the frames on the wire so far
+		rec, err := cr.next()
+		if err != nil {
+			t.Fatalf("Expected a frame for message %d: %v", i, err)
+		}
+		got := make([]byte, rec.size)
+		if err := (&framedReader{m: m}).decodeFrame(got, rec); err != nil || !bytes.Equal(got, msg) {
+			t.Fatalf("Message %d not decodable on its own: %v", i, err)
+		}
+	}
+	w.(io.Closer).Close()
+
+	big := bytes.Repeat([]byte("large message "), 1000)
+	wire.Reset()
+	w = m.Writer(&wire)
+	w.Write(big)
+	w.(MessageWriter).EndMessage()
+	w.(io.Closer).Close()
+	index, err := ReadContainerIndex(bytes.NewReader(wire.Bytes()), int64(wire.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (len(big) + RPCFrameSize - 1) / RPCFrameSize; len(index) != want {
+		t.Fatalf("Expected %d frames of at most %d bytes, got %d", want, RPCFrameSize, len(index))
+	}
+}
@@ -0,0 +1,295 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ErrStreamClosed is returned when writing to a closed mux stream, opening
+// a stream twice or opening a stream of a closed mux
+var ErrStreamClosed = errors.New("compression: mux stream closed")
+
+// Mux layout
+//
+//	header: magic "HBMX" | version (1 byte)
+//	record: type (1 byte) | uvarint stream id | [uvarint length | data]
+//
+// Data records carry compressed bytes of one stream, end-of-stream records
+// have no length and data, and a record of type 0 ends the mux.
+const (
+	muxMagic   = "HBMX"
+	muxVersion = 1
+
+	muxEnd       = 0
+	muxData      = 1
+	muxStreamEnd = 2
+)
+
+// Mux interleaves several independently compressed logical streams into a
+// single output, e.g. data, index and metadata of one spill file. Streams
+// may be written concurrently.
+type Mux struct {
+	m *Middleware
+
+	mu      sync.Mutex
+	w       io.Writer
+	err     error
+	started bool
+	closed  bool
+	streams map[uint64]bool
+}
+
+// NewMux creates a mux writing to w. Every stream is compressed with m.
+func NewMux(w io.Writer, m *Middleware) *Mux {
+	return &Mux{m: m, w: w, streams: make(map[uint64]bool)}
+}
+
+// Stream opens the logical stream with the given id. It must be closed
+// before the mux.
+func (x *Mux) Stream(id uint64) (io.WriteCloser, error) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if _, ok := x.streams[id]; ok || x.closed {
+		return nil, ErrStreamClosed
+	}
+	x.streams[id] = true
+	s := &muxStream{x: x, id: id}
+	s.w = x.m.Writer(writerFunc(s.writeCompressed))
+	return s, nil
+}
+
+// writeRecord writes one record, holding the lock so that records of
+// concurrent streams never interleave
+func (x *Mux) writeRecord(typ byte, id uint64, data []byte) error {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if x.err != nil {
+		return x.err
+	}
+	if !x.started {
+		x.started = true
+		if _, x.err = x.w.Write(append([]byte(muxMagic), muxVersion)); x.err != nil {
+			return x.err
+		}
+	}
+
+	header := make([]byte, 1, 1+2*binary.MaxVarintLen64)
+	header[0] = typ
+	if typ != muxEnd {
+		header = binary.AppendUvarint(header, id)
+	}
+	if typ == muxData {
+		header = binary.AppendUvarint(header, uint64(len(data)))
+	}
+	if _, x.err = x.w.Write(header); x.err != nil {
+		return x.err
+	}
+	_, x.err = x.w.Write(data)
+	return x.err
+}
+
+// Close ends the mux. It fails if streams are still open and does nothing
+// once the mux was ended.
+func (x *Mux) Close() error {
+	x.mu.Lock()
+	if x.closed {
+		x.mu.Unlock()
+		return nil
+	}
+	for id, open := range x.streams {
+		if open {
+			x.mu.Unlock()
+			return fmt.Errorf("compression: mux stream %d still open", id)
+		}
+	}
+	x.closed = true
+	x.mu.Unlock()
+	return x.writeRecord(muxEnd, 0, nil)
+}
+
+type muxStream struct {
+	x      *Mux
+	id     uint64
+	w      io.Writer
+	closed bool
+}
+
+func (s *muxStream) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, ErrStreamClosed
+	}
+	return s.w.Write(p)
+}
+
+// writeCompressed receives the compressed output of the stream
+func (s *muxStream) writeCompressed(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := s.x.writeRecord(muxData, s.id, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *muxStream) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	err := s.w.(io.Closer).Close()
+	s.x.mu.Lock()
+	s.x.streams[s.id] = false
+	if err != nil && s.x.err == nil {
+		// The stream cannot be ended, so the mux fails
+		s.x.err = err
+	}
+	s.x.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.x.writeRecord(muxStreamEnd, s.id, nil)
+}
+
+// writerFunc adapts a function to io.Writer
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+// Demux reads the logical streams of a mux. Records of streams that are not
+// being read are buffered in memory until their stream is read.
+type Demux struct {
+	m *Middleware
+
+	mu         sync.Mutex
+	r          *bufio.Reader
+	err        error
+	headerRead bool
+	streams    map[uint64]*demuxStream
+}
+
+// NewDemux creates a demux reading from r. Every stream is decompressed
+// with m.
+func NewDemux(r io.Reader, m *Middleware) *Demux {
+	return &Demux{m: m, r: bufio.NewReader(r), streams: make(map[uint64]*demuxStream)}
+}
+
+// Stream returns a reader for the logical stream with the given id
+func (d *Demux) Stream(id uint64) io.Reader {
+	return d.m.Reader(&demuxReader{d: d, s: d.stream(id)})
+}
+
+// Streams reads the remaining mux and returns the ids of all streams in it
+func (d *Demux) Streams() ([]uint64, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for d.err == nil {
+		d.err = d.readRecord()
+	}
+	if d.err != io.EOF {
+		return nil, d.err
+	}
+	ids := make([]uint64, 0, len(d.streams))
+	for id := range d.streams {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+type demuxStream struct {
+	buf   bytes.Buffer
+	ended bool
+}
+
+// stream returns the state of stream id, creating it if needed
+func (d *Demux) stream(id uint64) *demuxStream {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.streamLocked(id)
+}
+
+func (d *Demux) streamLocked(id uint64) *demuxStream {
+	s, ok := d.streams[id]
+	if !ok {
+		s = &demuxStream{}
+		d.streams[id] = s
+	}
+	return s
+}
+
+// readRecord reads the next record into the buffer of its stream
+func (d *Demux) readRecord() error {
+	if !d.headerRead {
+		header := make([]byte, len(muxMagic)+1)
+		if _, err := io.ReadFull(d.r, header); err != nil || string(header[:len(muxMagic)]) != muxMagic {
+			return fmt.Errorf("%w: bad mux header", ErrCorruptStream)
+		}
+		if header[len(muxMagic)] != muxVersion {
+			return fmt.Errorf("%w: mux version %d", ErrUnsupportedVersion, header[len(muxMagic)])
+		}
+		d.headerRead = true
+	}
+
+	typ, err := d.r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("%w: missing end of mux", io.ErrUnexpectedEOF)
+	}
+	if typ == muxEnd {
+		return io.EOF
+	}
+	id, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return fmt.Errorf("%w: bad mux record", ErrCorruptStream)
+	}
+	s := d.streamLocked(id)
+	switch typ {
+	case muxStreamEnd:
+		s.ended = true
+		return nil
+	case muxData:
+	default:
+		return fmt.Errorf("%w: unknown mux record type %d", ErrCorruptStream, typ)
+	}
+
+	n, err := binary.ReadUvarint(d.r)
+	if err != nil || n > 2*MaxFrameSize {
+		return fmt.Errorf("%w: bad mux record length", ErrCorruptStream)
+	}
+	if _, err := io.CopyN(&s.buf, d.r, int64(n)); err != nil {
+		return fmt.Errorf("%w: truncated mux record", io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+// demuxReader yields the compressed bytes of one stream
+type demuxReader struct {
+	d *Demux
+	s *demuxStream
+}
+
+func (r *demuxReader) Read(p []byte) (int, error) {
+	r.d.mu.Lock()
+	defer r.d.mu.Unlock()
+
+	for r.s.buf.Len() == 0 {
+		if r.s.ended {
+			return 0, io.EOF
+		}
+		if r.d.err != nil {
+			if r.d.err == io.EOF {
+				return 0, fmt.Errorf("%w: mux stream not ended", io.ErrUnexpectedEOF)
+			}
+			return 0, r.d.err
+		}
+		r.d.err = r.d.readRecord()
+	}
+	return r.s.buf.Read(p)
+}
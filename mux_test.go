@@ -0,0 +1,99 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestMux_RoundTrip(t *testing.T) {
+	requireZstd(t)
+	m := New(Zstd)
+	streams := map[uint64][]byte{
+		0: bytes.Repeat([]byte("data "), 10000),
+		1: bytes.Repeat([]byte("index "), 500),
+		2: []byte(`{"meta":true}`),
+	}
+
+	var buf bytes.Buffer
+	mux := NewMux(&buf, m)
+	var wg sync.WaitGroup
+	for id, data := range streams {
+		s, err := mux.Stream(id)
+		if err != nil {
+			t.Fatalf("Failed to open stream %d: %v", id, err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < len(data); i += 1000 {
+				s.Write(data[i:min(i+1000, len(data))])
+			}
+			s.Close()
+		}()
+	}
+	wg.Wait()
+	if err := mux.Close(); err != nil {
+		t.Fatalf("Failed to close mux: %v", err)
+	}
+
+	// Read the streams in a different order than they were written
+	demux := NewDemux(bytes.NewReader(buf.Bytes()), m)
+	for _, id := range []uint64{2, 0, 1} {
+		data, err := io.ReadAll(demux.Stream(id))
+		if err != nil {
+			t.Fatalf("Failed to read stream %d: %v", id, err)
+		}
+		if !bytes.Equal(data, streams[id]) {
+			t.Fatalf("Stream %d: data mismatch", id)
+		}
+	}
+
+	ids, err := NewDemux(bytes.NewReader(buf.Bytes()), m).Streams()
+	if err != nil {
+		t.Fatalf("Failed to list streams: %v", err)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	if len(ids) != 3 || ids[0] != 0 || ids[2] != 2 {
+		t.Fatalf("Unexpected stream ids %v", ids)
+	}
+}
+
+func TestMux_OpenStreams(t *testing.T) {
+	var buf bytes.Buffer
+	mux := NewMux(&buf, New(S2))
+	s, _ := mux.Stream(7)
+	if _, err := mux.Stream(7); err != ErrStreamClosed {
+		t.Fatalf("Expected ErrStreamClosed for duplicate stream, got %v", err)
+	}
+	if err := mux.Close(); err == nil {
+		t.Fatal("Expected error closing mux with open streams")
+	}
+	s.Close()
+	if err := mux.Close(); err != nil {
+		t.Fatalf("Failed to close mux: %v", err)
+	}
+	n := buf.Len()
+	if err := mux.Close(); err != nil || buf.Len() != n {
+		t.Fatalf("Expected closing again to write nothing, got %d more bytes, %v", buf.Len()-n, err)
+	}
+	if _, err := mux.Stream(8); err != ErrStreamClosed {
+		t.Fatalf("Expected ErrStreamClosed opening a stream of a closed mux, got %v", err)
+	}
+}
+
+func TestMux_StreamCloseError(t *testing.T) {
+	mux := NewMux(failingWriter{}, New(S2))
+	s, _ := mux.Stream(1)
+	s.Write([]byte("lost"))
+	if err := s.Close(); !errors.Is(err, errSinkFull) {
+		t.Fatalf("Expected the sink error closing the stream, got %v", err)
+	}
+	// The mux fails instead of waiting for the stream forever
+	if err := mux.Close(); !errors.Is(err, errSinkFull) {
+		t.Fatalf("Expected the sink error closing the mux, got %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package compression
+
+import "github.com/klauspost/compress/s2"
+
+// NoCopyWriter is implemented by writers returned from Middleware.Writer
+type NoCopyWriter interface {
+	// WriteNoCopy writes p like Write, but lets the encoder reference p
+	// instead of copying it where the codec supports it: S2 streams encode
+	// p in place, framed streams compress full frames directly from p. The
+	// caller must not modify p until the writer has been flushed or closed.
+	// Other codecs copy p as Write does.
+	WriteNoCopy(p []byte) (int, error)
+}
+
+func (w *writer) WriteNoCopy(p []byte) (int, error) {
+	return w.write(p, w.encodeNoCopy)
+}
+
+func (w *writer) encodeNoCopy(p []byte) (int, error) {
+	switch enc := w.enc.(type) {
+	case *s2.Writer:
+		if err := enc.EncodeBuffer(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	case *framedWriter:
+		return enc.WriteNoCopy(p)
+	}
+	return w.enc.Write(p)
+}
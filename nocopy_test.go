@@ -0,0 +1,51 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestWriteNoCopy(t *testing.T) {
+	requireZstd(t)
+	data := bytes.Repeat([]byte("zero copy ingestion "), 10000)
+	for _, tc := range []struct {
+		name string
+		m    *Middleware
+	}{
+		{"s2", New(S2)},
+		{"framed", New(Zstd, WithFrameSize(16<<10))},
+		{"gzip", New(Gzip)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := tc.m.Writer(&buf)
+			// Unaligned writes mix buffered and direct frames
+			for _, chunk := range [][]byte{data[:1000], data[1000:150000], data[150000:]} {
+				if n, err := w.(NoCopyWriter).WriteNoCopy(chunk); err != nil || n != len(chunk) {
+					t.Fatalf("WriteNoCopy wrote %d of %d: %v", n, len(chunk), err)
+				}
+			}
+			w.(io.Closer).Close()
+			if got := tc.m.Stats().BytesWritten; got != int64(len(data)) {
+				t.Fatalf("Expected %d bytes accounted, got %d", len(data), got)
+			}
+			got, err := io.ReadAll(tc.m.Reader(&buf))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("Round trip failed: %v", err)
+			}
+		})
+	}
+}
+
+func TestWriteNoCopy_Allocations(t *testing.T) {
+	requireZstd(t)
+	m := New(Zstd, WithFrameSize(16<<10), WithLevel(Fastest))
+	data := bytes.Repeat([]byte("steady state "), 1<<12)
+	w := m.Writer(io.Discard).(NoCopyWriter)
+	w.WriteNoCopy(data)
+	allocs := testing.AllocsPerRun(10, func() { w.WriteNoCopy(data) })
+	if allocs > 5 {
+		t.Fatalf("Expected few allocations per direct write, got %v", allocs)
+	}
+}
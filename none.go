@@ -0,0 +1,36 @@
+package compression
+
+import (
+	"io"
+	"os"
+)
+
+// passthrough is the encoder of None, writing data through unchanged
+type passthrough struct {
+	w io.Writer
+}
+
+func (p *passthrough) Write(b []byte) (int, error) {
+	return p.w.Write(b)
+}
+
+func (p *passthrough) Close() error {
+	return nil
+}
+
+func (p *passthrough) Reset(w io.Writer) {
+	p.w = w
+}
+
+// passthroughFile returns the source file of a None reader whose data can
+// be copied in the kernel, see WithKernelCopy
+func (r *reader) passthroughFile() *os.File {
+	if r.m.algorithm != None || !r.m.kernelCopy || !kernelCopySupported {
+		return nil
+	}
+	if c, ok := r.dec.(*countingReader); ok {
+		f, _ := c.r.(*os.File)
+		return f
+	}
+	return nil
+}
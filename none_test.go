@@ -0,0 +1,63 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNone(t *testing.T) {
+	m := New(None, WithLevel(Best))
+	data := []byte("passed through unchanged")
+	if got := writeContainer(t, m, data); !bytes.Equal(got, data) {
+		t.Fatalf("Expected the data unchanged, got %q", got)
+	}
+
+	r := m.Reader(bytes.NewReader(data))
+	s, ok := r.(io.ReadSeeker)
+	if !ok {
+		t.Fatal("Expected the reader to pass Seek through")
+	}
+	if _, err := s.Seek(7, io.SeekStart); err != nil {
+		t.Fatalf("Failed to seek: %v", err)
+	}
+	if got, err := io.ReadAll(s); err != nil || string(got) != "through unchanged" {
+		t.Fatalf("Expected the data after the offset, got %q, %v", got, err)
+	}
+	if a, err := ParseAlgorithm("passthrough"); err != nil || a != None {
+		t.Fatalf("Expected passthrough to parse as None, got %v, %v", a, err)
+	}
+}
+
+func TestNone_KernelCopy(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("kernel copy "), 10000)
+	src := filepath.Join(dir, "src")
+	if err := os.WriteFile(src, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out, err := os.Create(filepath.Join(dir, "dst"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	m := New(None, WithKernelCopy())
+	n, err := io.Copy(out, m.Reader(in))
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("Failed to copy: %d, %v", n, err)
+	}
+	if got, _ := os.ReadFile(out.Name()); !bytes.Equal(got, data) {
+		t.Fatal("Copied data mismatch")
+	}
+	if s := m.Stats(); s.BytesDecompressed != int64(len(data)) {
+		t.Fatalf("Expected the copy to be accounted, got %d", s.BytesDecompressed)
+	}
+}
@@ -0,0 +1,149 @@
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// ErrPanic wraps panics recovered by the panic handler, see SetPanicHandler
+var ErrPanic = errors.New("compression: recovered panic")
+
+var panicHandler atomic.Pointer[func(recovered any, stack []byte)]
+
+// SetPanicHandler makes the package recover panics when creating encoders
+// and decoders and inside codecs, e.g. on codec bugs triggered by corrupt
+// input. fn receives the recovered value and the stack of the panicking
+// goroutine, for reporting, and the affected stream fails with an error
+// wrapping ErrPanic.
+// A nil fn restores the default of letting panics propagate.
+func SetPanicHandler(fn func(recovered any, stack []byte)) {
+	if fn == nil {
+		panicHandler.Store(nil)
+		return
+	}
+	panicHandler.Store(&fn)
+}
+
+// WithPanicFree makes the streams of the middleware return panics as errors
+// wrapping ErrPanic instead of letting them propagate, whether or not a panic
+// handler is set, for servers where a panic is unacceptable. Writer and
+// Reader return a stream failing with the error if creating the codec
+// panics, like WriterE and ReaderE, and Write, Flush, Close, Read, WriteTo
+// and DecodeInto return it if the codec panics. Panics are still reported to
+// the handler of SetPanicHandler. Panics of the writer passed to WriteTo are
+// not codec panics and propagate. Panics inside goroutines of the codecs
+// themselves, such as those of parallel gzip, cannot be recovered.
+func WithPanicFree() Option {
+	return func(m *Middleware) {
+		m.panicFree = true
+	}
+}
+
+// recoverPanic must be deferred. It recovers a panic if a handler is set or
+// the middleware is panic free, reports it and passes the resulting error to
+// fail.
+func (m *Middleware) recoverPanic(fail func(error)) {
+	if panicHandler.Load() == nil && !m.panicFree {
+		return
+	}
+	if r := recover(); r != nil {
+		fail(panicError(r))
+	}
+}
+
+// panicError reports the recovered value r to the handler, if set, and
+// returns it as an error wrapping ErrPanic
+func panicError(r any) error {
+	if fn := panicHandler.Load(); fn != nil {
+		(*fn)(r, debug.Stack())
+	}
+	if perr, ok := r.(error); ok {
+		return fmt.Errorf("%w: %w", ErrPanic, perr)
+	}
+	return fmt.Errorf("%w: %v", ErrPanic, r)
+}
+
+// guard runs fn, turning panics into errors if a panic handler is set or the
+// middleware is panic free
+func (m *Middleware) guard(fn func() error) (err error) {
+	defer m.recoverPanic(func(perr error) { err = perr })
+	return fn()
+}
+
+// guard runs fn like Middleware.guard and classifies its error like the
+// errors of readers. If fn panics, the encoder may be left broken, so w
+// fails all further calls and does not return it to the pool.
+func (w *writer) guard(fn func() error) error {
+	panicked := true
+	err := w.m.guard(func() error {
+		err := fn()
+		panicked = false
+		return err
+	})
+	if panicked {
+		w.err = err
+	}
+	return classifyError(err)
+}
+
+// guardNew returns the guard for codec constructors
+func (m *Middleware) guardNew() func(func() error) error {
+	if m.panicFree {
+		return guardAll
+	}
+	return m.guard
+}
+
+// guardAll runs fn, turning every panic into an error, also without a
+// handler. Errors panicked by codec constructors, such as a corrupt gzip
+// header, are returned as they are.
+func guardAll(fn func() error) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		var rerr runtime.Error
+		if perr, ok := r.(error); ok && !errors.As(perr, &rerr) {
+			err = perr
+			return
+		}
+		if fn := panicHandler.Load(); fn != nil {
+			(*fn)(r, debug.Stack())
+		}
+		err = fmt.Errorf("%w: %v", ErrPanic, r)
+	}()
+	return fn()
+}
+
+// errDestinationPanic stops a guarded copy whose destination panicked
+var errDestinationPanic = errors.New("compression: destination panicked")
+
+// destinationWriter is the destination w of a copy from a codec run by a
+// guard. A panic of w is not a codec panic: it stops the copy with
+// errDestinationPanic and is raised again by repanic after the guard.
+type destinationWriter struct {
+	w        io.Writer
+	panicked any
+}
+
+func (d *destinationWriter) Write(p []byte) (n int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			d.panicked = r
+			err = errDestinationPanic
+		}
+	}()
+	return d.w.Write(p)
+}
+
+// repanic raises the panic of the destination again
+func (d *destinationWriter) repanic() {
+	if d.panicked != nil {
+		panic(d.panicked)
+	}
+}
@@ -0,0 +1,130 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// panicSource panics on Read like a misbehaving codec or source
+type panicSource struct{}
+
+func (panicSource) Read(p []byte) (int, error) {
+	panic("source exploded")
+}
+
+func TestSetPanicHandler(t *testing.T) {
+	var recovered any
+	var stack []byte
+	SetPanicHandler(func(r any, s []byte) {
+		recovered, stack = r, s
+	})
+	defer SetPanicHandler(nil)
+
+	m := New(Gzip)
+	_, err := io.ReadAll(m.Reader(panicSource{}))
+	if !errors.Is(err, ErrPanic) {
+		t.Fatalf("expected ErrPanic, got %v", err)
+	}
+	if recovered == nil || len(stack) == 0 {
+		t.Error("handler was not called")
+	}
+}
+
+func TestSetPanicHandler_Unset(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected the panic to propagate without a handler")
+		}
+	}()
+	io.ReadAll(New(Gzip).Reader(panicSource{}))
+}
+
+// panicSink panics on Write like a misbehaving destination
+type panicSink struct{}
+
+func (panicSink) Write(p []byte) (int, error) {
+	panic("sink exploded")
+}
+
+func TestWithPanicFree(t *testing.T) {
+	requireZstd(t)
+	m := New(Gzip, WithPanicFree())
+	if _, err := io.ReadAll(m.Reader(panicSource{})); !errors.Is(err, ErrPanic) {
+		t.Fatalf("Expected ErrPanic from Read, got %v", err)
+	}
+	if _, err := io.Copy(io.Discard, m.Reader(panicSource{})); !errors.Is(err, ErrPanic) {
+		t.Fatalf("Expected ErrPanic from WriteTo, got %v", err)
+	}
+	if _, err := m.Reader(panicSource{}).(DirectDecoder).DecodeInto(make([]byte, 10)); !errors.Is(err, ErrPanic) {
+		t.Fatalf("Expected ErrPanic from DecodeInto, got %v", err)
+	}
+
+	for _, a := range []Algorithm{Gzip, S2} {
+		w := New(a, WithPanicFree()).Writer(panicSink{})
+		w.Write([]byte("hello"))
+		if err := w.(interface{ Flush() error }).Flush(); !errors.Is(err, ErrPanic) {
+			t.Fatalf("%s: expected ErrPanic from Flush, got %v", a, err)
+		}
+	}
+	w := New(Zstd, WithPanicFree(), WithFrameSize(4)).Writer(panicSink{})
+	if _, err := w.Write([]byte("hello")); !errors.Is(err, ErrPanic) {
+		t.Fatalf("Expected ErrPanic from Write, got %v", err)
+	}
+	w = New(Snappy, WithPanicFree()).Writer(panicSink{})
+	w.Write([]byte("hello"))
+	if err := w.(io.Closer).Close(); !errors.Is(err, ErrPanic) {
+		t.Fatalf("Expected ErrPanic from Close, got %v", err)
+	}
+}
+
+func TestWithPanicFree_PoisonsWriter(t *testing.T) {
+	m := New(Gzip, WithPanicFree())
+	w := m.Writer(panicSink{})
+	w.Write([]byte("hello"))
+	if err := w.(interface{ Flush() error }).Flush(); !errors.Is(err, ErrPanic) {
+		t.Fatalf("Expected ErrPanic from Flush, got %v", err)
+	}
+	// The encoder may be broken, so the writer keeps failing
+	if _, err := w.Write([]byte("more")); !errors.Is(err, ErrPanic) {
+		t.Fatalf("Expected ErrPanic from Write after the panic, got %v", err)
+	}
+	if err := w.(io.Closer).Close(); !errors.Is(err, ErrPanic) {
+		t.Fatalf("Expected ErrPanic from Close after the panic, got %v", err)
+	}
+	if idle := m.stats.idleEncoders.Load(); idle != 0 {
+		t.Fatalf("Expected the encoder not to be pooled, %d idle", idle)
+	}
+}
+
+func TestWithPanicFree_Constructors(t *testing.T) {
+	// Without the option the constructors panic with the error
+	m := New(Algorithm(999), WithPanicFree())
+	if _, err := m.Writer(io.Discard).Write([]byte("x")); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Fatalf("Expected a failing writer, got %v", err)
+	}
+	if _, err := m.Reader(panicSource{}).Read(make([]byte, 1)); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Fatalf("Expected a failing reader, got %v", err)
+	}
+}
+
+func TestWithPanicFree_Handler(t *testing.T) {
+	var recovered any
+	SetPanicHandler(func(r any, s []byte) { recovered = r })
+	defer SetPanicHandler(nil)
+
+	if _, err := io.ReadAll(New(Gzip, WithPanicFree()).Reader(panicSource{})); !errors.Is(err, ErrPanic) || recovered == nil {
+		t.Fatalf("Expected the panic to be reported, got %v", err)
+	}
+}
+
+func TestWithPanicFree_WriteToDestination(t *testing.T) {
+	compressed := writeContainer(t, New(Gzip), []byte("hello"))
+	defer func() {
+		if r := recover(); r != "sink exploded" {
+			t.Errorf("Expected the panic of the destination to propagate, got %v", r)
+		}
+	}()
+	New(Gzip, WithPanicFree()).Reader(bytes.NewReader(compressed)).(io.WriterTo).WriteTo(panicSink{})
+}
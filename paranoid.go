@@ -0,0 +1,50 @@
+package compression
+
+import "time"
+
+// Limits of PresetParanoid
+const (
+	ParanoidMaxDecompressedSize = 1 << 30
+	ParanoidMaxExpansionRatio   = 1000
+	ParanoidMemoryBudget        = 64 << 20
+	ParanoidDecodeTimeout       = time.Minute
+)
+
+// WithStrictFraming makes readers of unframed streams fail with
+// ErrCorruptStream if data follows the end of the compressed stream, instead
+// of ignoring it as Zlib, Flate and SnappyBlock readers do by default. Framed
+// streams are length-prefixed and end with an end marker, so they are always
+// read strictly.
+func WithStrictFraming() Option {
+	return func(m *Middleware) {
+		m.strictFrames = true
+	}
+}
+
+// PresetParanoid bundles the defensive read-side options for decoding
+// untrusted input into a single switch: readers return at most
+// ParanoidMaxDecompressedSize bytes, expand at most ParanoidMaxExpansionRatio
+// times, decode within ParanoidDecodeTimeout, use codec memory within
+// ParanoidMemoryBudget, decode single threaded with the pure Go backend,
+// without readahead and with strict framing. The reader enforces the limits
+// around the codec, and SnappyBlock, which decodes a stream into one
+// allocation, checks the size the stream declares against the decompressed
+// size limit and the memory budget before decoding. Options following the
+// preset override single limits. The memory budget also applies to writers
+// of the middleware.
+func PresetParanoid() Option {
+	return func(m *Middleware) {
+		for _, opt := range []Option{
+			WithMaxDecompressedSize(ParanoidMaxDecompressedSize),
+			WithMaxExpansionRatio(ParanoidMaxExpansionRatio),
+			WithDecodeTimeout(ParanoidDecodeTimeout),
+			WithMemoryBudget(ParanoidMemoryBudget),
+			WithWorkerCount(func() int { return 1 }),
+			WithBackend(PureGo),
+			WithStrictFraming(),
+		} {
+			opt(m)
+		}
+		m.readahead = 0
+	}
+}
@@ -0,0 +1,51 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestPresetParanoid(t *testing.T) {
+	requireZstd(t)
+	data := bytes.Repeat([]byte("untrusted input decoded paranoidly "), 1000)
+	for _, a := range []Algorithm{Gzip, Zstd, S2, Snappy, Zlib, Flate, Bzip2, None, SnappyBlock} {
+		t.Run(a.String(), func(t *testing.T) {
+			m := New(a, PresetParanoid())
+			if err := m.err; err != nil {
+				t.Fatalf("Expected the preset to fit every algorithm, got %v", err)
+			}
+			stream := writeContainer(t, m, data)
+			got, err := io.ReadAll(m.Reader(bytes.NewReader(stream)))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("Round trip failed: %d bytes, %v", len(got), err)
+			}
+
+			// a lower size cap keeps the bomb small
+			capped := New(a, PresetParanoid(), WithMaxDecompressedSize(1<<20))
+			bomb := writeContainer(t, New(a), make([]byte, 4<<20))
+			if _, err := io.ReadAll(capped.Reader(bytes.NewReader(bomb))); !errors.Is(err, ErrLimitExceeded) {
+				t.Fatalf("Expected ErrLimitExceeded for a bomb, got %v", err)
+			}
+
+			if a == None {
+				return
+			}
+			trailing := append(bytes.Clone(stream), "trailing garbage"...)
+			if _, err := io.ReadAll(m.Reader(bytes.NewReader(trailing))); !errors.Is(err, ErrCorruptStream) {
+				t.Fatalf("Expected ErrCorruptStream for trailing data, got %v", err)
+			}
+		})
+	}
+}
+
+func TestPresetParanoid_Override(t *testing.T) {
+	m := New(Zstd, PresetParanoid(), WithMaxExpansionRatio(1e6), WithReadahead(4))
+	if m.maxExpansion != 1e6 || m.maxDecoded != ParanoidMaxDecompressedSize || m.readahead != 4 {
+		t.Fatalf("Expected later options to override the preset, got ratio %g, size %d, readahead %d", m.maxExpansion, m.maxDecoded, m.readahead)
+	}
+	if m.workers() != 1 || m.memory == nil {
+		t.Fatalf("Expected single threaded decoding within a memory plan")
+	}
+}
@@ -0,0 +1,84 @@
+package compression
+
+import (
+	"io"
+
+	"github.com/klauspost/pgzip"
+)
+
+// defaultGzipBlockSize is the block size of WithParallelGzip if none is given
+const defaultGzipBlockSize = 1 << 20
+
+// WithParallelGzip makes Gzip writers compress blocks of blockSize bytes on
+// up to workers cores using klauspost/pgzip, for large streams such as spill
+// files. The output is a regular gzip stream. Each writer buffers about
+// 2*blockSize*workers bytes. Zero values select 1 MiB blocks and the
+// current worker count, see WithWorkerCount. It has no effect with
+// WithStdlibCompat or WithDictzip.
+func WithParallelGzip(blockSize, workers int) Option {
+	return func(m *Middleware) {
+		if blockSize <= 0 {
+			blockSize = defaultGzipBlockSize
+		}
+		m.pgzipBlock, m.pgzipBlocks = blockSize, max(workers, 0)
+	}
+}
+
+// gzipWorkers returns the worker count of parallel gzip writers
+func (m *Middleware) gzipWorkers() int {
+	if m.pgzipBlocks > 0 {
+		return m.pgzipBlocks
+	}
+	return m.workers()
+}
+
+func (m *Middleware) createParallelGzipWriter(w io.Writer, level int) encoder {
+	workers := m.gzipWorkers()
+	blocks := workers
+	if m.memory != nil && m.memory.gzipBlocks > 0 {
+		blocks = min(blocks, m.memory.gzipBlocks)
+	}
+	gzipWriter, err := pgzip.NewWriterLevel(w, level)
+	if err == nil {
+		err = gzipWriter.SetConcurrency(m.pgzipBlock, blocks)
+	}
+	if err != nil {
+		panic("failed to create parallel gzip writer: " + err.Error())
+	}
+	enc := &pgzipWriteCloser{gzipWriter, m.pgzipBlock, blocks}
+	if blocks < workers {
+		return m.newSpillStage(enc)
+	}
+	return enc
+}
+
+// parallelGzipMemory estimates the buffers of a parallel gzip writer with
+// memory plan p, which may be nil
+func (m *Middleware) parallelGzipMemory(p *memoryPlan) int64 {
+	if m.algorithm != Gzip || m.pgzipBlock == 0 || m.stdlibCompat || m.dictzipChunk > 0 {
+		return 0
+	}
+	workers := m.gzipWorkers()
+	blocks := workers
+	if p != nil && p.gzipBlocks > 0 {
+		blocks = min(blocks, p.gzipBlocks)
+	}
+	// Plus the drain buffer of the spill stage
+	if blocks < workers {
+		return 2*int64(m.pgzipBlock)*int64(blocks) + int64(m.pgzipBlock)
+	}
+	return 2 * int64(m.pgzipBlock) * int64(blocks)
+}
+
+// pgzipWriteCloser restores the concurrency settings of the writer on
+// Reset, which pgzip resets to its defaults
+type pgzipWriteCloser struct {
+	*pgzip.Writer
+	blockSize int
+	blocks    int
+}
+
+func (w *pgzipWriteCloser) Reset(dst io.Writer) {
+	w.Writer.Reset(dst)
+	w.Writer.SetConcurrency(w.blockSize, w.blocks)
+}
@@ -0,0 +1,46 @@
+package compression
+
+import (
+	"bytes"
+	stdgzip "compress/gzip"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestWithParallelGzip(t *testing.T) {
+	var data []byte
+	for i := 0; len(data) < 1<<20; i++ {
+		data = fmt.Appendf(data, "line %d of a large spill file\n", i)
+	}
+	m := New(Gzip, WithParallelGzip(64<<10, 4))
+	// The second stream uses the pooled encoder
+	for range 2 {
+		var buf bytes.Buffer
+		w := m.Writer(&buf)
+		if _, ok := w.(*writer).enc.(*pgzipWriteCloser); !ok {
+			t.Fatalf("Expected a parallel gzip encoder, got %T", w.(*writer).enc)
+		}
+		for p := data; len(p) > 0; p = p[min(len(p), 10000):] {
+			w.Write(p[:min(len(p), 10000)])
+		}
+		if err := w.(io.Closer).Close(); err != nil {
+			t.Fatalf("Failed to close: %v", err)
+		}
+
+		zr, err := stdgzip.NewReader(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Failed to open the gzip stream: %v", err)
+		}
+		if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("Failed to read with the stdlib: %v", err)
+		}
+	}
+}
+
+func TestWithParallelGzip_StdlibCompatWins(t *testing.T) {
+	w := New(Gzip, WithParallelGzip(0, 0), WithStdlibCompat()).Writer(io.Discard)
+	if _, ok := w.(*writer).enc.(*pgzipWriteCloser); ok {
+		t.Fatal("Expected WithStdlibCompat to take precedence")
+	}
+}
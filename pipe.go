@@ -0,0 +1,98 @@
+package compression
+
+import (
+	"io"
+	"sync"
+)
+
+// Pipe returns a connected pair for a producer and a consumer goroutine:
+// data written to the writer is compressed by m, passed through an
+// io.Pipe and decompressed by the reader. Closing the writer ends the
+// stream and closing the reader early fails further writes with
+// io.ErrClosedPipe. A stream the reader fails to decode fails further
+// writes with the decode error. Both halves also implement
+// CloseWithError(error) error, which fails the other half with the given
+// error.
+func Pipe(m *Middleware) (io.WriteCloser, io.ReadCloser) {
+	pr, pw := io.Pipe()
+	return &pipeWriter{w: m.Writer(pw), pw: pw}, &pipeReader{m: m, pr: pr}
+}
+
+// pipeWriter is the compressing half of a Pipe
+type pipeWriter struct {
+	w  io.Writer
+	pw *io.PipeWriter
+}
+
+func (p *pipeWriter) Write(b []byte) (int, error) {
+	return p.w.Write(b)
+}
+
+// Close completes the compressed stream, the reader then returns io.EOF
+// after the remaining data
+func (p *pipeWriter) Close() error {
+	err := p.w.(io.Closer).Close()
+	if err != nil {
+		p.pw.CloseWithError(err)
+		return err
+	}
+	return p.pw.Close()
+}
+
+// CloseWithError abandons the stream, the reader returns err. The pipe is
+// closed first, so that releasing the encoder does not block on a reader
+// that is gone.
+func (p *pipeWriter) CloseWithError(err error) error {
+	p.pw.CloseWithError(err)
+	if a, ok := p.w.(Aborter); ok {
+		a.Abort()
+	}
+	return nil
+}
+
+// pipeReader is the decompressing half of a Pipe. mu serializes Read and
+// closing the decoder.
+type pipeReader struct {
+	m      *Middleware
+	pr     *io.PipeReader
+	mu     sync.Mutex
+	r      io.Reader
+	closed bool
+}
+
+func (p *pipeReader) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if p.r == nil {
+		// Create the decoder on first use, as decoders may read a header
+		// and must not block Pipe
+		p.r = p.m.Reader(p.pr)
+	}
+	n, err := p.r.Read(b)
+	if err != nil && err != io.EOF {
+		// Fail the producer instead of leaving it blocked
+		p.pr.CloseWithError(err)
+	}
+	return n, err
+}
+
+// Close closes the pipe, further writes fail with io.ErrClosedPipe
+func (p *pipeReader) Close() error {
+	return p.CloseWithError(nil)
+}
+
+// CloseWithError closes the pipe, further writes fail with err
+func (p *pipeReader) CloseWithError(err error) error {
+	// Close the pipe first, it unblocks a Read in progress
+	p.pr.CloseWithError(err)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.r.(io.Closer); ok && !p.closed {
+		c.Close()
+	}
+	p.closed = true
+	return nil
+}
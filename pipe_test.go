@@ -0,0 +1,80 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestPipe(t *testing.T) {
+	requireZstd(t)
+	for _, algorithm := range []Algorithm{Gzip, Zstd, S2} {
+		data := bytes.Repeat([]byte("piped "), 10000)
+		w, r := Pipe(New(algorithm))
+		go func() {
+			w.Write(data)
+			w.Close()
+		}()
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: content mismatch", algorithm)
+		}
+		r.Close()
+	}
+}
+
+func TestPipe_CloseWithError(t *testing.T) {
+	requireZstd(t)
+	errProducer := errors.New("producer failed")
+	w, r := Pipe(New(Zstd))
+	go func() {
+		w.Write([]byte("partial"))
+		w.(interface{ CloseWithError(error) error }).CloseWithError(errProducer)
+	}()
+	if _, err := io.ReadAll(r); !errors.Is(err, errProducer) {
+		t.Errorf("expected producer error, got %v", err)
+	}
+
+	w, r = Pipe(New(Gzip))
+	r.Close()
+	if _, err := w.Write(bytes.Repeat([]byte("x"), 1<<20)); err == nil {
+		if err = w.Close(); !errors.Is(err, io.ErrClosedPipe) {
+			t.Errorf("expected io.ErrClosedPipe, got %v", err)
+		}
+	}
+	if _, err := r.Read(make([]byte, 1)); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("read after close: %v", err)
+	}
+
+	// Closing the reader during a blocked Read unblocks it
+	w, r = Pipe(New(Gzip))
+	done := make(chan error)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		done <- err
+	}()
+	r.Close()
+	if err := <-done; err == nil {
+		t.Error("Expected the blocked Read to fail")
+	}
+	w.(interface{ CloseWithError(error) error }).CloseWithError(errProducer)
+}
+
+func TestPipe_DecodeError(t *testing.T) {
+	// The producer writes a stream the consumer cannot decode
+	w, r := Pipe(New(Gzip))
+	raw := w.(*pipeWriter).pw
+	go io.Copy(io.Discard, r)
+	var err error
+	for i := 0; i < 100 && err == nil; i++ {
+		_, err = raw.Write(bytes.Repeat([]byte("not gzip "), 1000))
+	}
+	if !errors.Is(err, ErrCorruptStream) {
+		t.Errorf("Expected the classified decode error on the write side, got %v", err)
+	}
+	w.(interface{ CloseWithError(error) error }).CloseWithError(err)
+}
@@ -0,0 +1,102 @@
+package compression
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Profile is a named, reusable list of options, e.g. an organization wide
+// base configuration. A profile inherits the options of its parent, which
+// are applied first.
+type Profile struct {
+	Name    string
+	Parent  *Profile
+	Options []Option
+}
+
+// Conflict reports a setting of a profile that is changed by a later
+// profile in the inheritance chain or by an override
+type Conflict struct {
+	// Setting is the name of the changed setting
+	Setting string
+	// SetBy is the name of the profile that set it first
+	SetBy string
+	// ChangedBy is the name of the profile changing it, or "override"
+	ChangedBy string
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s set by %s is changed by %s", c.Setting, c.SetBy, c.ChangedBy)
+}
+
+// Options layers overrides on top of the options of base and its parents and
+// returns the combined options for New. Settings of a profile that are
+// changed by a child profile or an override are reported as conflicts; the
+// later option wins. The settings are found by applying the options in order
+// to a middleware with the defaults of New and comparing its settings before
+// and after each option, so options reading files, such as
+// WithDictionaryFile, do so here as well.
+func Options(base Profile, overrides ...Option) ([]Option, []Conflict) {
+	var chain []*Profile
+	for p := &base; p != nil; p = p.Parent {
+		chain = append([]*Profile{p}, chain...)
+	}
+
+	var (
+		opts      []Option
+		conflicts []Conflict
+		probe     = newMiddleware(Gzip)
+		setBy     = make(map[string]string)
+	)
+	apply := func(source string, opt Option) {
+		before := probe.settings()
+		opt(probe)
+		after := probe.settings()
+		names := make([]string, 0, len(after))
+		for name := range after {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if after[name] == before[name] {
+				continue
+			}
+			if prev, ok := setBy[name]; ok && prev != source {
+				conflicts = append(conflicts, Conflict{Setting: name, SetBy: prev, ChangedBy: source})
+			}
+			setBy[name] = source
+		}
+		opts = append(opts, opt)
+	}
+	for _, p := range chain {
+		for _, opt := range p.Options {
+			apply(p.Name, opt)
+		}
+	}
+	for _, opt := range overrides {
+		apply("override", opt)
+	}
+	return opts, conflicts
+}
+
+// settings returns the configuration fields of m in comparable form
+func (m *Middleware) settings() map[string]string {
+	v := reflect.ValueOf(m).Elem()
+	settings := make(map[string]string, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		if name == "stats" || name == "encoders" || name == "baseline" {
+			continue
+		}
+		f := v.Field(i)
+		switch {
+		case f.Kind() == reflect.Func, f.Kind() == reflect.Pointer && f.Type().Elem().Kind() == reflect.Struct:
+			// Compare hooks and shared objects such as tuners by identity
+			settings[name] = fmt.Sprint(f.Pointer())
+		default:
+			settings[name] = fmt.Sprint(f)
+		}
+	}
+	return settings
+}
@@ -0,0 +1,69 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestOptions(t *testing.T) {
+	requireZstd(t)
+	org := Profile{
+		Name:    "org",
+		Options: []Option{WithLevel(Better), WithQuota(Quota{MaxActiveWriters: 10})},
+	}
+	team := Profile{
+		Name:    "team",
+		Parent:  &org,
+		Options: []Option{WithFrameSize(64 << 10), WithLevel(Best)},
+	}
+
+	opts, conflicts := Options(team, WithFrameSize(128<<10), WithTag("test-profile"))
+	if len(opts) != 6 {
+		t.Fatalf("Expected 6 options, got %d", len(opts))
+	}
+	want := []Conflict{
+		{Setting: "level", SetBy: "org", ChangedBy: "team"},
+		{Setting: "frameSize", SetBy: "team", ChangedBy: "override"},
+	}
+	if len(conflicts) != len(want) {
+		t.Fatalf("Expected conflicts %v, got %v", want, conflicts)
+	}
+	for i := range want {
+		if conflicts[i] != want[i] {
+			t.Fatalf("Expected conflict %v, got %v", want[i], conflicts[i])
+		}
+	}
+
+	m := New(Zstd, opts...)
+	if m.level != Best || m.frameSize != 128<<10 || m.quota.MaxActiveWriters != 10 || m.tag != "test-profile" {
+		t.Fatalf("Options not applied in order: level=%v frameSize=%d", m.level, m.frameSize)
+	}
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	w.Write([]byte("profiled"))
+	w.(io.Closer).Close()
+	if got, _ := io.ReadAll(m.Reader(&buf)); string(got) != "profiled" {
+		t.Fatalf("Round trip failed: %q", got)
+	}
+}
+
+func TestOptions_NoConflictForSameValue(t *testing.T) {
+	base := Profile{Name: "base", Options: []Option{WithLevel(Fastest)}}
+	if _, conflicts := Options(base, WithLevel(Fastest)); len(conflicts) != 0 {
+		t.Fatalf("Expected no conflicts, got %v", conflicts)
+	}
+}
+
+func TestOptions_ZeroValue(t *testing.T) {
+	// Fastest is the zero Level, Default the level of New
+	base := Profile{Name: "base", Options: []Option{WithLevel(Fastest)}}
+	want := []Conflict{{Setting: "level", SetBy: "base", ChangedBy: "override"}}
+	if _, conflicts := Options(base, WithLevel(Better)); len(conflicts) != 1 || conflicts[0] != want[0] {
+		t.Fatalf("Expected conflicts %v, got %v", want, conflicts)
+	}
+	base = Profile{Name: "base", Options: []Option{WithLevel(Better)}}
+	if _, conflicts := Options(base, WithLevel(Fastest)); len(conflicts) != 1 || conflicts[0] != want[0] {
+		t.Fatalf("Expected conflicts %v, got %v", want, conflicts)
+	}
+}
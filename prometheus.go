@@ -0,0 +1,130 @@
+package compression
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// promSeries is the stats of one label set
+type promSeries struct {
+	labels string
+	stats  Stats
+}
+
+// WritePrometheus writes the middleware stats in the Prometheus text
+// exposition format, with metric names prefixed by namespace. Middleware
+// created WithTag carry a tag label.
+func (m *Middleware) WritePrometheus(w io.Writer, namespace string) error {
+	var labels string
+	if m.tag != "" {
+		labels = "tag=" + strconv.Quote(m.tag)
+	}
+	return writePrometheus(w, namespace, []promSeries{{labels, m.Stats()}}, m.trackLatency)
+}
+
+// WriteTagPrometheus writes the stats of all tags, see StatsByTag, in the
+// Prometheus text exposition format with a tag label
+func WriteTagPrometheus(w io.Writer, namespace string) error {
+	byTag := StatsByTag()
+	tags := make([]string, 0, len(byTag))
+	for tag := range byTag {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	series := make([]promSeries, 0, len(tags))
+	latency := false
+	for _, tag := range tags {
+		s := byTag[tag]
+		latency = latency || s.WriteLatency.Count > 0 || s.ReadLatency.Count > 0
+		series = append(series, promSeries{"tag=" + strconv.Quote(tag), s})
+	}
+	return writePrometheus(w, namespace, series, latency)
+}
+
+func writePrometheus(w io.Writer, namespace string, series []promSeries, latency bool) error {
+	metrics := []struct {
+		name, typ, help string
+		value           func(Stats) int64
+	}{
+		{"writers_total", "counter", "Compressing writers created.", func(s Stats) int64 { return s.Writers }},
+		{"readers_total", "counter", "Decompressing readers created.", func(s Stats) int64 { return s.Readers }},
+		{"bytes_written_total", "counter", "Uncompressed bytes accepted by writers.", func(s Stats) int64 { return s.BytesWritten }},
+		{"bytes_compressed_total", "counter", "Compressed bytes produced by writers.", func(s Stats) int64 { return s.BytesCompressed }},
+		{"bytes_read_total", "counter", "Compressed bytes consumed by readers.", func(s Stats) int64 { return s.BytesRead }},
+		{"bytes_decompressed_total", "counter", "Uncompressed bytes returned by readers.", func(s Stats) int64 { return s.BytesDecompressed }},
+		{"corruptions_total", "counter", "Readers that detected corrupt data.", func(s Stats) int64 { return s.Corruptions }},
+		{"active_writers", "gauge", "Writers not closed yet.", func(s Stats) int64 { return s.ActiveWriters }},
+		{"encoder_pool_hits_total", "counter", "Writers that reused a pooled encoder.", func(s Stats) int64 { return s.EncoderPoolHits }},
+		{"encoder_pool_misses_total", "counter", "Writers that created an encoder.", func(s Stats) int64 { return s.EncoderPoolMisses }},
+		{"lock_waits_total", "counter", "Acquisitions of locks shared between streams that had to wait.", func(s Stats) int64 { return s.LockWaits }},
+		{"lagging_frames_total", "counter", "Frames stored uncompressed because the writer lagged.", func(s Stats) int64 { return s.LaggingFrames }},
+	}
+	for _, metric := range metrics {
+		name := namespace + "_" + metric.name
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, metric.help, name, metric.typ); err != nil {
+			return err
+		}
+		for _, s := range series {
+			if _, err := fmt.Fprintf(w, "%s%s %d\n", name, braces(s.labels), metric.value(s.stats)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !latency {
+		return nil
+	}
+	histograms := []struct {
+		name, help string
+		value      func(Stats) Histogram
+	}{
+		{"write_duration_seconds", "Latency of Write calls.", func(s Stats) Histogram { return s.WriteLatency }},
+		{"read_duration_seconds", "Latency of Read calls.", func(s Stats) Histogram { return s.ReadLatency }},
+	}
+	for _, hist := range histograms {
+		name := namespace + "_" + hist.name
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, hist.help, name); err != nil {
+			return err
+		}
+		for _, s := range series {
+			if err := writePrometheusHistogram(w, name, s.labels, hist.value(s.stats)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writePrometheusHistogram(w io.Writer, name, labels string, h Histogram) error {
+	prefix := labels
+	if prefix != "" {
+		prefix += ","
+	}
+	var cumulative int64
+	for i, n := range h.Buckets[:latencyBuckets-1] {
+		cumulative += n
+		bound := BucketBound(i)
+		if bound&(bound-1) != 0 {
+			// Only powers of two are exported, keeping the series few
+			continue
+		}
+		le := strconv.FormatFloat(bound.Seconds(), 'g', -1, 64)
+		if _, err := fmt.Fprintf(w, "%s_bucket{%sle=%q} %d\n", name, prefix, le, cumulative); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n%s_sum%s %g\n%s_count%s %d\n",
+		name, prefix, h.Count, name, braces(labels), h.Sum.Seconds(), name, braces(labels), h.Count)
+	return err
+}
+
+// braces wraps a non-empty label set in braces
+func braces(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
@@ -0,0 +1,241 @@
+package compression
+
+import "io"
+
+// readaheadChunk is the size of the chunks prefetched from indexed streams,
+// the default S2 block size
+const readaheadChunk = 1 << 20
+
+// WithReadahead makes readers of framed streams and readers returned by
+// SeekableReader fetch and decode up to frames frames (blocks of indexed
+// streams) ahead in a background goroutine while the caller consumes the
+// current one, hiding the latency of slow sources such as object stores
+// during sequential restores. Seeking discards the frames fetched ahead.
+// Framed streams need WithFrameSize. It costs up to frames decoded frames of
+// memory per reader. Readers that are not read to the end must be closed to
+// stop the goroutine.
+func WithReadahead(frames int) Option {
+	return func(m *Middleware) {
+		m.readahead = frames
+	}
+}
+
+// prefetched is a frame decoded ahead
+type prefetched struct {
+	data []byte
+	err  error
+}
+
+// readaheadReader reads chunks of size bytes from src in a goroutine started
+// by the first read. The goroutine is stopped before src is used directly,
+// by Seek, Close and the fast paths of WriteTo and DecodeInto.
+type readaheadReader struct {
+	m      *Middleware
+	src    io.Reader
+	size   int
+	frames int
+
+	// ahead, done and exited belong to the running goroutine
+	running bool
+	ahead   chan prefetched
+	done    chan struct{}
+	exited  chan struct{}
+	closed  bool
+
+	cur []byte
+	err error
+	// pos is the uncompressed offset of the caller
+	pos int64
+}
+
+func newReadaheadReader(m *Middleware, src io.Reader, frames, size int) *readaheadReader {
+	return &readaheadReader{m: m, src: src, size: size, frames: frames}
+}
+
+// start starts the goroutine unless it is running or src is done
+func (r *readaheadReader) start() {
+	if r.running || r.err != nil {
+		return
+	}
+	r.running = true
+	r.ahead = make(chan prefetched, r.frames)
+	r.done = make(chan struct{})
+	r.exited = make(chan struct{})
+	go r.fetch(r.ahead, r.done, r.exited)
+}
+
+// stop stops the goroutine and returns the frames it fetched ahead
+func (r *readaheadReader) stop() []prefetched {
+	if !r.running {
+		return nil
+	}
+	r.running = false
+	close(r.done)
+	<-r.exited
+	var queued []prefetched
+	for {
+		select {
+		case p := <-r.ahead:
+			queued = append(queued, p)
+		default:
+			return queued
+		}
+	}
+}
+
+// next returns the next frame fetched ahead
+func (r *readaheadReader) next() {
+	r.start()
+	next := <-r.ahead
+	r.cur, r.err = next.data, next.err
+	if r.err != nil {
+		r.running = false
+		<-r.exited
+	}
+}
+
+func (r *readaheadReader) Read(p []byte) (int, error) {
+	for len(r.cur) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.next()
+	}
+	n := copy(p, r.cur)
+	r.cur = r.cur[n:]
+	r.pos += int64(n)
+	return n, nil
+}
+
+// WriteTo writes the frames fetched ahead to w without copying them. With
+// WithKernelCopy and a file sink, the goroutine is stopped and the rest is
+// written by the framed reader, so that stored frames are copied by the
+// kernel.
+func (r *readaheadReader) WriteTo(w io.Writer) (int64, error) {
+	if f, ok := r.src.(*framedReader); ok && f.file != nil && fileOf(w) != nil {
+		return r.forward(w)
+	}
+	var total int64
+	for {
+		if len(r.cur) > 0 {
+			n, err := w.Write(r.cur)
+			r.cur = r.cur[n:]
+			r.pos += int64(n)
+			total += int64(n)
+			if err != nil {
+				return total, err
+			}
+		}
+		if r.err == io.EOF {
+			return total, nil
+		} else if r.err != nil {
+			return total, r.err
+		}
+		r.next()
+	}
+}
+
+// forward writes the data fetched ahead to w and the rest with the WriteTo
+// of src
+func (r *readaheadReader) forward(w io.Writer) (int64, error) {
+	var total int64
+	for _, p := range append([]prefetched{{data: r.cur, err: r.err}}, r.stop()...) {
+		n, err := w.Write(p.data)
+		total += int64(n)
+		r.pos += int64(n)
+		if err != nil {
+			return total, err
+		}
+		if p.err == io.EOF {
+			return total, nil
+		} else if p.err != nil {
+			return total, p.err
+		}
+	}
+	r.cur = nil
+	n, err := r.src.(io.WriterTo).WriteTo(w)
+	r.pos += n
+	return total + n, err
+}
+
+// DecodeInto decodes in place with src until the first read starts the
+// goroutine, then copies the frames fetched ahead into dst
+func (r *readaheadReader) DecodeInto(dst []byte) (int, error) {
+	if d, ok := r.src.(DirectDecoder); ok && !r.running && len(r.cur) == 0 && r.err == nil {
+		n, err := d.DecodeInto(dst)
+		r.pos += int64(n)
+		return n, err
+	}
+	n := 0
+	for {
+		m := copy(dst[n:], r.cur)
+		r.cur = r.cur[m:]
+		n += m
+		r.pos += int64(m)
+		if len(r.cur) > 0 {
+			return n, io.ErrShortBuffer
+		}
+		if r.err == io.EOF {
+			return n, nil
+		} else if r.err != nil {
+			return n, r.err
+		}
+		r.next()
+	}
+}
+
+// Seek discards the frames fetched ahead and seeks src, which must
+// implement io.Seeker
+func (r *readaheadReader) Seek(offset int64, whence int) (int64, error) {
+	s, ok := r.src.(io.Seeker)
+	if !ok {
+		return r.pos, ErrNotSeekable
+	}
+	r.stop()
+	r.cur, r.err = nil, nil
+	if whence == io.SeekCurrent {
+		offset, whence = r.pos+offset, io.SeekStart
+	}
+	pos, err := s.Seek(offset, whence)
+	r.pos = pos
+	return pos, err
+}
+
+// fetch decodes the chunks of src until it fails or done is closed. A frame
+// fitting the buffer is decoded in place by framed sources.
+func (r *readaheadReader) fetch(ahead chan<- prefetched, done <-chan struct{}, exited chan<- struct{}) {
+	defer close(exited)
+	send := func(p prefetched) bool {
+		select {
+		case ahead <- p:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	defer r.m.recoverPanic(func(err error) { send(prefetched{err: err}) })
+	for {
+		buf := make([]byte, r.size)
+		n, err := r.src.Read(buf)
+		if !send(prefetched{data: buf[:n], err: err}) || err != nil {
+			return
+		}
+	}
+}
+
+// Close stops the goroutine and closes src
+func (r *readaheadReader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	r.stop()
+	r.cur = nil
+	if r.err == nil {
+		r.err = io.ErrClosedPipe
+	}
+	if c, ok := r.src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
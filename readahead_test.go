@@ -0,0 +1,162 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingSource counts the bytes read from it
+type countingSource struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (s *countingSource) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.n.Add(int64(n))
+	return n, err
+}
+
+func TestWithReadahead(t *testing.T) {
+	requireZstd(t)
+	data := make([]byte, 64<<10)
+	for i := range data {
+		data[i] = byte(i * 7 / 5)
+	}
+	m := New(Zstd, WithFrameSize(4096), WithReadahead(4))
+	compressed := writeContainer(t, m, data)
+
+	src := &countingSource{r: bytes.NewReader(compressed)}
+	r := m.Reader(src)
+	head := make([]byte, 100)
+	if _, err := io.ReadFull(r, head); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	// The goroutine keeps fetching while the caller is idle
+	deadline := time.Now().Add(5 * time.Second)
+	for src.n.Load() < int64(len(compressed)/4) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected frames to be prefetched, read %d of %d bytes", src.n.Load(), len(compressed))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if got := append(head, rest...); !bytes.Equal(got, data) {
+		t.Fatalf("Data mismatch: got %d bytes, want %d", len(got), len(data))
+	}
+}
+
+func TestWithReadahead_Close(t *testing.T) {
+	data := bytes.Repeat([]byte("readahead "), 16<<10)
+	m := New(S2, WithFrameSize(1024), WithReadahead(2))
+	r := m.Reader(bytes.NewReader(writeContainer(t, m, data))).(io.ReadCloser)
+	if _, err := r.Read(make([]byte, 10)); err != nil {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	ra := r.(*reader).dec.(*readaheadReader)
+	select {
+	case <-ra.done:
+	default:
+		t.Fatal("Expected Close to stop the readahead")
+	}
+}
+
+func TestWithReadahead_Seekable(t *testing.T) {
+	m := New(S2, WithS2Index(), WithReadahead(2))
+	data := make([]byte, 4<<20)
+	for i := range data {
+		data[i] = byte(i / 1024)
+	}
+	var payload, sidecar bytes.Buffer
+	w := m.Writer(&payload)
+	w.Write(data)
+	w.(io.Closer).Close()
+	w.(IndexExporter).ExportIndex(&sidecar)
+	idx, err := LoadIndex(&sidecar)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := m.SeekableReader(bytes.NewReader(payload.Bytes()), idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.(io.Closer).Close()
+	got := make([]byte, 100)
+	if _, err := io.ReadFull(rs, got); err != nil || !bytes.Equal(got, data[:100]) {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	// Relative seeks are relative to the caller, not to the prefetched data
+	if pos, err := rs.Seek(1<<20, io.SeekCurrent); err != nil || pos != 1<<20+100 {
+		t.Fatalf("Seek = %d, %v", pos, err)
+	}
+	if _, err := io.ReadFull(rs, got); err != nil || !bytes.Equal(got, data[1<<20+100:1<<20+200]) {
+		t.Fatalf("Failed to read after seek: %v", err)
+	}
+	if _, err := rs.Seek(3<<20, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(rs)
+	if err != nil || !bytes.Equal(rest, data[3<<20:]) {
+		t.Fatalf("Failed to read the rest: %v", err)
+	}
+}
+
+func TestWithReadahead_DecodeInto(t *testing.T) {
+	data := bytes.Repeat([]byte("decoded in place "), 4096)
+	m := New(S2, WithFrameSize(4096), WithReadahead(2))
+	compressed := writeContainer(t, m, data)
+
+	// Before the first read, frames are decoded in place
+	dst := make([]byte, len(data))
+	r := m.Reader(bytes.NewReader(compressed))
+	if n, err := r.(DirectDecoder).DecodeInto(dst); err != nil || !bytes.Equal(dst[:n], data) {
+		t.Fatalf("DecodeInto = %d, %v", n, err)
+	}
+
+	// After it, from the frames fetched ahead
+	r = m.Reader(bytes.NewReader(compressed))
+	if _, err := io.ReadFull(r, dst[:10]); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.(DirectDecoder).DecodeInto(dst[10:100]); err != io.ErrShortBuffer || n != 90 {
+		t.Fatalf("Expected io.ErrShortBuffer after 90 bytes, got %d, %v", n, err)
+	}
+	if n, err := r.(DirectDecoder).DecodeInto(dst[100:]); err != nil || n != len(data)-100 || !bytes.Equal(dst, data) {
+		t.Fatalf("DecodeInto = %d, %v", n, err)
+	}
+}
+
+// closingSource records whether it was closed
+type closingSource struct {
+	io.Reader
+	closed bool
+}
+
+func (s *closingSource) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestReadaheadReader_ClosesSource(t *testing.T) {
+	src := &closingSource{Reader: bytes.NewReader(make([]byte, 1<<16))}
+	r := newReadaheadReader(New(None), src, 2, 1024)
+	if _, err := r.Read(make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil || !src.closed {
+		t.Fatalf("Expected the source to be closed: %v", err)
+	}
+	if _, err := r.Read(make([]byte, 10)); err == nil {
+		t.Fatal("Expected reads after Close to fail")
+	}
+}
@@ -0,0 +1,102 @@
+package compression
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+)
+
+var (
+	// ErrExpansionRatio is returned by readers whose stream expands more than
+	// allowed by WithMaxExpansionRatio
+	ErrExpansionRatio = fmt.Errorf("%w: expansion ratio", ErrLimitExceeded)
+	// ErrDecompressedSize is returned by readers whose stream is larger than
+	// allowed by WithMaxDecompressedSize
+	ErrDecompressedSize = fmt.Errorf("%w: decompressed size", ErrLimitExceeded)
+	// ErrDecodeTimeout is returned by readers decoding for longer than
+	// allowed by WithDecodeTimeout
+	ErrDecodeTimeout = fmt.Errorf("%w: decode timeout", ErrLimitExceeded)
+)
+
+// WithMaxExpansionRatio makes readers fail with ErrExpansionRatio as soon as
+// the decompressed output exceeds ratio times the compressed input read so
+// far, guarding against decompression bombs also where the input is small
+// enough to pass an absolute size limit. Codecs read their input in blocks,
+// so the check is lenient by up to a block of input. Passthrough readers of
+// None are not checked.
+func WithMaxExpansionRatio(ratio float64) Option {
+	return func(m *Middleware) {
+		m.maxExpansion = ratio
+	}
+}
+
+// WithMaxDecompressedSize makes readers return at most n bytes and fail with
+// ErrDecompressedSize if the stream is larger. Zstd decoders additionally
+// refuse frames declaring a larger size before decoding them.
+func WithMaxDecompressedSize(n int64) Option {
+	return func(m *Middleware) {
+		m.maxDecoded = n
+	}
+}
+
+// WithDecodeTimeout makes readers fail with ErrDecodeTimeout once decoding a
+// stream has taken longer than d since its first Read, bounding the CPU time
+// a hostile stream can consume. The limit is checked after every Read and
+// does not interrupt a Read blocked on the source. Streams served from
+// WithReadCache are not decoded and not checked.
+func WithDecodeTimeout(d time.Duration) Option {
+	return func(m *Middleware) {
+		m.readTimeout = d
+	}
+}
+
+// readLimited reports whether readers of m need a limitedReader
+func (m *Middleware) readLimited() bool {
+	return m.maxExpansion > 0 || m.maxDecoded > 0 || m.readTimeout > 0 || m.strictFrames
+}
+
+// limitedReader enforces the read limits of m on dec, which decodes from in
+type limitedReader struct {
+	m   *Middleware
+	dec io.Reader
+	in  *countingReader
+	out int64
+	// deadline is set by the first Read with WithDecodeTimeout
+	deadline time.Time
+	// src is the buffered source checked for trailing data with
+	// WithStrictFraming
+	src *bufio.Reader
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.m.readTimeout > 0 && l.deadline.IsZero() {
+		l.deadline = time.Now().Add(l.m.readTimeout)
+	}
+	n, err := l.dec.Read(p)
+	l.out += int64(n)
+	if max := l.m.maxDecoded; max > 0 && l.out > max {
+		n -= int(l.out - max)
+		l.out = max
+		return n, fmt.Errorf("%w: more than %d bytes", ErrDecompressedSize, max)
+	}
+	if max, in := l.m.maxExpansion, l.in.total.Load(); max > 0 && float64(l.out) > max*float64(in) {
+		return n, fmt.Errorf("%w: %d bytes decompressed from %d, more than %g times", ErrExpansionRatio, l.out, in, max)
+	}
+	if !l.deadline.IsZero() && time.Now().After(l.deadline) {
+		return n, fmt.Errorf("%w: decoding took longer than %v", ErrDecodeTimeout, l.m.readTimeout)
+	}
+	if err == io.EOF && l.src != nil {
+		if _, perr := l.src.Peek(1); perr == nil {
+			return n, fmt.Errorf("%w: data after the end of the stream", ErrCorruptStream)
+		}
+	}
+	return n, err
+}
+
+func (l *limitedReader) Close() error {
+	if c, ok := l.dec.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
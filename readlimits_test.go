@@ -0,0 +1,85 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestWithMaxExpansionRatio(t *testing.T) {
+	requireZstd(t)
+	bomb := make([]byte, 4<<20)
+	// random letters compress a few times only
+	rng := rand.New(rand.NewSource(1))
+	text := make([]byte, 64<<10)
+	for i := range text {
+		text[i] = 'a' + byte(rng.Intn(16))
+	}
+	for _, a := range []Algorithm{Gzip, Zstd, S2, Bzip2} {
+		t.Run(a.String(), func(t *testing.T) {
+			m := New(a, WithMaxExpansionRatio(50))
+			stream := writeContainer(t, New(a), bomb)
+			_, err := io.ReadAll(m.Reader(bytes.NewReader(stream)))
+			if !errors.Is(err, ErrExpansionRatio) || !errors.Is(err, ErrLimitExceeded) {
+				t.Fatalf("Expected ErrExpansionRatio, got %v", err)
+			}
+			if _, err := io.Copy(io.Discard, m.Reader(bytes.NewReader(stream))); !errors.Is(err, ErrExpansionRatio) {
+				t.Fatalf("Expected ErrExpansionRatio from WriteTo, got %v", err)
+			}
+
+			got, err := io.ReadAll(m.Reader(bytes.NewReader(writeContainer(t, m, text))))
+			if err != nil || !bytes.Equal(got, text) {
+				t.Fatalf("Expected text within the ratio to decode, got %d bytes, %v", len(got), err)
+			}
+		})
+	}
+}
+
+func TestWithMaxExpansionRatio_Framed(t *testing.T) {
+	requireZstd(t)
+	m := New(Zstd, WithFrameSize(64<<10), WithReadahead(2), WithMaxExpansionRatio(20))
+	stream := writeContainer(t, m, make([]byte, 1<<20))
+	r := m.Reader(bytes.NewReader(stream))
+	defer r.(io.Closer).Close()
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrExpansionRatio) {
+		t.Fatalf("Expected ErrExpansionRatio, got %v", err)
+	}
+}
+
+func TestWithMaxDecompressedSize(t *testing.T) {
+	requireZstd(t)
+	data := bytes.Repeat([]byte("size capped "), 10000)
+	for _, a := range []Algorithm{Gzip, Zstd, None} {
+		t.Run(a.String(), func(t *testing.T) {
+			m := New(a, WithMaxDecompressedSize(50000))
+			got, err := io.ReadAll(m.Reader(bytes.NewReader(writeContainer(t, m, data))))
+			if !errors.Is(err, ErrDecompressedSize) || !errors.Is(err, ErrLimitExceeded) {
+				t.Fatalf("Expected ErrDecompressedSize, got %v", err)
+			}
+			// zstd refuses the frame as it declares its size
+			if len(got) != 50000 && a != Zstd {
+				t.Fatalf("Expected the first 50000 bytes, got %d", len(got))
+			}
+
+			m = New(a, WithMaxDecompressedSize(int64(len(data))))
+			if got, err := io.ReadAll(m.Reader(bytes.NewReader(writeContainer(t, m, data)))); err != nil || len(got) != len(data) {
+				t.Fatalf("Expected a stream of the maximum size to decode, got %d bytes, %v", len(got), err)
+			}
+		})
+	}
+}
+
+func TestWithDecodeTimeout(t *testing.T) {
+	m := New(S2, WithDecodeTimeout(time.Nanosecond))
+	stream := writeContainer(t, New(S2), bytes.Repeat([]byte("slow "), 1000))
+	if _, err := io.ReadAll(m.Reader(bytes.NewReader(stream))); !errors.Is(err, ErrDecodeTimeout) {
+		t.Fatalf("Expected ErrDecodeTimeout, got %v", err)
+	}
+	m = New(S2, WithDecodeTimeout(time.Minute))
+	if _, err := io.ReadAll(m.Reader(bytes.NewReader(stream))); err != nil {
+		t.Fatalf("Expected no error within the timeout, got %v", err)
+	}
+}
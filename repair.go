@@ -0,0 +1,115 @@
+package compression
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// gzip header flags
+const (
+	gzipFlagHCRC    = 1 << 1
+	gzipFlagExtra   = 1 << 2
+	gzipFlagName    = 1 << 3
+	gzipFlagComment = 1 << 4
+)
+
+// RepairCRC copies the gzip stream read from r to w, replacing the trailer of
+// every member with the CRC-32 and size recomputed from its data. It recovers
+// streams whose trailer is damaged or missing, e.g. after interrupted writes;
+// the headers and compressed data are copied unchanged and must be intact.
+func RepairCRC(r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+	for member := 0; ; member++ {
+		if _, err := br.Peek(1); err == io.EOF && member > 0 {
+			return bw.Flush()
+		}
+		if err := repairMember(br, bw); err != nil {
+			return fmt.Errorf("compression: gzip member %d: %w", member, err)
+		}
+	}
+}
+
+// repairMember copies one gzip member with a recomputed trailer
+func repairMember(r *bufio.Reader, w *bufio.Writer) error {
+	tee := &teeByteReader{r: r, w: w}
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(tee, header); err != nil || header[0] != 0x1f || header[1] != 0x8b || header[2] != 8 {
+		return fmt.Errorf("%w: bad gzip header", ErrCorruptStream)
+	}
+	flags := header[3]
+	if flags&gzipFlagExtra != 0 {
+		var n [2]byte
+		if _, err := io.ReadFull(tee, n[:]); err != nil {
+			return fmt.Errorf("%w: bad gzip header", ErrCorruptStream)
+		}
+		if _, err := io.CopyN(io.Discard, tee, int64(binary.LittleEndian.Uint16(n[:]))); err != nil {
+			return fmt.Errorf("%w: bad gzip header", ErrCorruptStream)
+		}
+	}
+	for _, flag := range []byte{gzipFlagName, gzipFlagComment} {
+		if flags&flag == 0 {
+			continue
+		}
+		for {
+			b, err := tee.ReadByte()
+			if err != nil {
+				return fmt.Errorf("%w: bad gzip header", ErrCorruptStream)
+			}
+			if b == 0 {
+				break
+			}
+		}
+	}
+	if flags&gzipFlagHCRC != 0 {
+		if _, err := io.CopyN(io.Discard, tee, 2); err != nil {
+			return fmt.Errorf("%w: bad gzip header", ErrCorruptStream)
+		}
+	}
+
+	// The deflate reader consumes exactly the compressed data from an
+	// io.ByteReader, so the trailer is left in r
+	crc := crc32.NewIEEE()
+	size, err := io.Copy(crc, flate.NewReader(tee))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorruptStream, err)
+	}
+
+	// Drop the old trailer, as far as it exists
+	if _, err := r.Discard(8); err != nil && err != io.EOF {
+		return err
+	}
+	trailer := binary.LittleEndian.AppendUint32(nil, crc.Sum32())
+	trailer = binary.LittleEndian.AppendUint32(trailer, uint32(size))
+	_, err = w.Write(trailer)
+	return err
+}
+
+// teeByteReader copies everything read from r to w
+type teeByteReader struct {
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func (t *teeByteReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if _, werr := t.w.Write(p[:n]); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (t *teeByteReader) ReadByte() (byte, error) {
+	b, err := t.r.ReadByte()
+	if err == nil {
+		err = t.w.WriteByte(b)
+	}
+	return b, err
+}
@@ -0,0 +1,79 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestRepairCRC(t *testing.T) {
+	m := New(Gzip)
+	var original bytes.Buffer
+	for _, part := range []string{"first member ", "second member"} {
+		w := m.Writer(&original)
+		w.Write(bytes.Repeat([]byte(part), 100))
+		w.(io.Closer).Close()
+	}
+	want := append(bytes.Repeat([]byte("first member "), 100), bytes.Repeat([]byte("second member"), 100)...)
+
+	// Damage the trailer of both members
+	var probe bytes.Buffer
+	w := m.Writer(&probe)
+	w.Write(bytes.Repeat([]byte("first member "), 100))
+	w.(io.Closer).Close()
+	damaged := bytes.Clone(original.Bytes())
+	damaged[probe.Len()-1] ^= 0xff
+	damaged[len(damaged)-8] ^= 0xff
+
+	if _, err := io.ReadAll(mustGzipReader(t, damaged)); err == nil {
+		t.Fatal("Expected the damaged stream to fail")
+	}
+
+	var repaired bytes.Buffer
+	if err := RepairCRC(bytes.NewReader(damaged), &repaired); err != nil {
+		t.Fatalf("Failed to repair: %v", err)
+	}
+	if !bytes.Equal(repaired.Bytes(), original.Bytes()) {
+		t.Fatal("Expected the original stream back")
+	}
+	got, err := io.ReadAll(mustGzipReader(t, repaired.Bytes()))
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("Repaired stream does not read back: %v", err)
+	}
+}
+
+func TestRepairCRC_MissingTrailer(t *testing.T) {
+	var buf bytes.Buffer
+	gw, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	gw.Name = "spill.bin"
+	gw.Comment = "header fields are copied"
+	gw.Write([]byte("interrupted write"))
+	gw.Close()
+	truncated := buf.Bytes()[:buf.Len()-8]
+
+	var repaired bytes.Buffer
+	if err := RepairCRC(bytes.NewReader(truncated), &repaired); err != nil {
+		t.Fatalf("Failed to repair: %v", err)
+	}
+	if !bytes.Equal(repaired.Bytes(), buf.Bytes()) {
+		t.Fatal("Expected the trailer to be restored")
+	}
+}
+
+func TestRepairCRC_CorruptData(t *testing.T) {
+	err := RepairCRC(bytes.NewReader([]byte("not gzip")), io.Discard)
+	if !errors.Is(err, ErrCorruptStream) {
+		t.Fatalf("Expected ErrCorruptStream, got %v", err)
+	}
+}
+
+func mustGzipReader(t *testing.T, p []byte) io.Reader {
+	t.Helper()
+	r, err := gzip.NewReader(bytes.NewReader(p))
+	if err != nil {
+		t.Fatalf("Failed to open gzip stream: %v", err)
+	}
+	return r
+}
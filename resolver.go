@@ -0,0 +1,120 @@
+package compression
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// WithDictionaryResolver sets a function fetching zstd dictionaries by ID,
+// e.g. from S3 or a database, so dictionaries can be distributed lazily.
+// Readers of streams whose first frame names a dictionary the middleware
+// does not have call fn with its ID before decoding, instead of failing with
+// ErrDictionaryMissing. fn may return a dictionary in zstd format, whose ID
+// must match, or raw dictionary content. The last 16 resolved dictionaries
+// are kept, and each reader only registers the dictionary of its stream;
+// calls of fn are serialized and not repeated for a kept ID.
+func WithDictionaryResolver(fn func(id uint32) ([]byte, error)) Option {
+	return withResolver(func(_ context.Context, id uint32) ([]byte, error) {
+		return fn(id)
+	})
+}
+
+// withResolver sets the resolver, which is called with the context of
+// WithContext
+func withResolver(fn func(ctx context.Context, id uint32) ([]byte, error)) Option {
+	return func(m *Middleware) {
+		m.resolver = fn
+		m.resolved = &resolvedDictionaries{}
+	}
+}
+
+// resolvedDictionaryLimit is the number of resolved dictionaries kept
+var resolvedDictionaryLimit = 16
+
+// resolvedDictionaries holds the dictionaries fetched by the resolver. mu
+// serializes the resolver calls, cacheMu guards dicts.
+type resolvedDictionaries struct {
+	mu      sync.Mutex
+	cacheMu sync.Mutex
+	// dicts is ordered from the least to the most recently used
+	dicts []resolvedDictionary
+}
+
+// resolvedDictionary is a dictionary fetched by the resolver, raw unless it
+// is in zstd format
+type resolvedDictionary struct {
+	id   uint32
+	dict []byte
+	raw  bool
+}
+
+// get returns the kept dictionary with id and marks it as used
+func (d *resolvedDictionaries) get(id uint32) (resolvedDictionary, bool) {
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+	for i, r := range d.dicts {
+		if r.id == id {
+			d.dicts = append(append(d.dicts[:i], d.dicts[i+1:]...), r)
+			return r, true
+		}
+	}
+	return resolvedDictionary{}, false
+}
+
+// add keeps r, dropping the least recently used dictionary beyond the limit
+func (d *resolvedDictionaries) add(r resolvedDictionary) {
+	d.cacheMu.Lock()
+	defer d.cacheMu.Unlock()
+	d.dicts = append(d.dicts, r)
+	if n := len(d.dicts) - resolvedDictionaryLimit; n > 0 {
+		d.dicts = append(d.dicts[:0:0], d.dicts[n:]...)
+	}
+}
+
+// knowsDictionary reports whether readers have the dictionary with the
+// given ID without resolving it
+func (m *Middleware) knowsDictionary(id uint32) bool {
+	if m.layered != nil && m.layered.id == id {
+		return true
+	}
+	for _, dict := range m.decoderDictionaries() {
+		if zstdDictionaryID(dict) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveDictionary returns the dictionary with the given ID, fetching it
+// unless it is kept, see WithDictionaryResolver. It returns no dictionary
+// if readers have it without resolving.
+func (m *Middleware) resolveDictionary(id uint32) (resolvedDictionary, error) {
+	if m.knowsDictionary(id) {
+		return resolvedDictionary{}, nil
+	}
+	if r, ok := m.resolved.get(id); ok {
+		return r, nil
+	}
+	m.resolved.mu.Lock()
+	defer m.resolved.mu.Unlock()
+	if r, ok := m.resolved.get(id); ok {
+		return r, nil
+	}
+	dict, err := m.resolver(m.context(), id)
+	if err != nil {
+		return resolvedDictionary{}, fmt.Errorf("%w: resolving dictionary %d: %v", ErrDictionaryMissing, id, err)
+	}
+	if len(dict) == 0 {
+		return resolvedDictionary{}, fmt.Errorf("%w: resolved dictionary %d is empty", ErrInvalidDictionary, id)
+	}
+	resolved := resolvedDictionary{id: id, dict: dict, raw: true}
+	if _, got, err := zstdDictionaryContent(dict); err == nil {
+		if got != id {
+			return resolvedDictionary{}, fmt.Errorf("%w: resolved dictionary %d has ID %d", ErrInvalidDictionary, id, got)
+		}
+		resolved.raw = false
+	}
+	m.resolved.add(resolved)
+	return resolved, nil
+}
@@ -0,0 +1,122 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestWithDictionaryResolver(t *testing.T) {
+	requireZstd(t)
+	dict := testZstdDictionary(t, 7)
+	payload := []byte(`{"level":"info","service":"svc-3","message":"request 93 handled in 3ms"}`)
+	for name, opts := range map[string][]Option{
+		"stream": nil,
+		"framed": {WithFrameSize(1024)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			compressed := writeContainer(t, New(Zstd, append(opts, WithZstdDictionary(dict))...), payload)
+			if _, err := io.ReadAll(New(Zstd, opts...).Reader(bytes.NewReader(compressed))); err == nil {
+				t.Fatal("Expected an error without a resolver")
+			}
+
+			var calls []uint32
+			m := New(Zstd, append(opts, WithDictionaryResolver(func(id uint32) ([]byte, error) {
+				calls = append(calls, id)
+				return dict, nil
+			}))...)
+			for i := 0; i < 2; i++ {
+				got, err := io.ReadAll(m.Reader(bytes.NewReader(compressed)))
+				if err != nil || !bytes.Equal(got, payload) {
+					t.Fatalf("Failed to read: %v", err)
+				}
+			}
+			// The resolved dictionary is kept
+			if len(calls) != 1 || calls[0] != 7 {
+				t.Fatalf("Expected one call for dictionary 7, got %v", calls)
+			}
+		})
+	}
+}
+
+func TestWithDictionaryResolver_Raw(t *testing.T) {
+	requireZstd(t)
+	content := bytes.Repeat([]byte(`"service":"svc-3","message":"request handled"`), 4)
+	payload := []byte(`{"level":"info","service":"svc-3","message":"request handled in 3ms"}`)
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf, zstd.WithEncoderDictRaw(42, content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(payload)
+	zw.Close()
+
+	m := New(Zstd, WithDictionaryResolver(func(id uint32) ([]byte, error) { return content, nil }))
+	got, err := io.ReadAll(m.Reader(&buf))
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("Failed to read with the raw dictionary: %v", err)
+	}
+}
+
+func TestWithDictionaryResolver_Errors(t *testing.T) {
+	requireZstd(t)
+	payload := []byte(`{"level":"info","service":"svc-3","message":"request 93 handled in 3ms"}`)
+	compressed := writeContainer(t, New(Zstd, WithZstdDictionary(testZstdDictionary(t, 7))), payload)
+
+	fail := true
+	m := New(Zstd, WithDictionaryResolver(func(id uint32) ([]byte, error) {
+		if fail {
+			return nil, fmt.Errorf("dictionary %d not found", id)
+		}
+		return testZstdDictionary(t, id), nil
+	}))
+	if _, err := io.ReadAll(m.Reader(bytes.NewReader(compressed))); !errors.Is(err, ErrDictionaryMissing) {
+		t.Fatalf("Expected ErrDictionaryMissing, got %v", err)
+	}
+	// Failed resolutions are retried
+	fail = false
+	if got, err := io.ReadAll(m.Reader(bytes.NewReader(compressed))); err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("Failed to read after the resolver recovered: %v", err)
+	}
+
+	for name, dict := range map[string][]byte{"other id": testZstdDictionary(t, 8), "empty": nil} {
+		m := New(Zstd, WithDictionaryResolver(func(uint32) ([]byte, error) { return dict, nil }))
+		if _, err := io.ReadAll(m.Reader(bytes.NewReader(compressed))); !errors.Is(err, ErrInvalidDictionary) {
+			t.Fatalf("%s: expected ErrInvalidDictionary, got %v", name, err)
+		}
+	}
+}
+
+func TestWithDictionaryResolver_Limit(t *testing.T) {
+	requireZstd(t)
+	defer func(n int) { resolvedDictionaryLimit = n }(resolvedDictionaryLimit)
+	resolvedDictionaryLimit = 2
+
+	payload := []byte(`{"level":"info","service":"svc-3","message":"request 93 handled in 3ms"}`)
+	streams := make(map[uint32][]byte)
+	for id := uint32(1); id <= 3; id++ {
+		streams[id] = writeContainer(t, New(Zstd, WithZstdDictionary(testZstdDictionary(t, id))), payload)
+	}
+	var calls []uint32
+	m := New(Zstd, WithDictionaryResolver(func(id uint32) ([]byte, error) {
+		calls = append(calls, id)
+		return testZstdDictionary(t, id), nil
+	}))
+	// Dictionary 1 is used again before 3 is resolved, so 2 is dropped
+	for _, id := range []uint32{1, 2, 1, 3, 1, 2} {
+		got, err := io.ReadAll(m.Reader(bytes.NewReader(streams[id])))
+		if err != nil || !bytes.Equal(got, payload) {
+			t.Fatalf("Dictionary %d: failed to read: %v", id, err)
+		}
+	}
+	if fmt.Sprint(calls) != "[1 2 3 2]" {
+		t.Errorf("Expected resolver calls [1 2 3 2], got %v", calls)
+	}
+	if n := len(m.resolved.dicts); n != 2 {
+		t.Errorf("Expected 2 kept dictionaries, got %d", n)
+	}
+}
@@ -0,0 +1,85 @@
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrWritePending wraps the sink error of writers with WithResumableWrites.
+// The compressed data the sink did not take is kept until Retry delivers it.
+var ErrWritePending = errors.New("compression: write pending after sink error")
+
+// WithResumableWrites makes writers survive transient errors of the
+// underlying writer. When it fails, the compressed data it did not take is
+// kept and the writer returns an error wrapping ErrWritePending and the sink
+// error. Writes, flushes and Close fail with that error until Retry delivered
+// the kept data, after which the stream continues as if nothing happened.
+// Retry also works after Close, so a stream can be completed once the sink
+// recovered.
+func WithResumableWrites() Option {
+	return func(m *Middleware) {
+		m.resumable = true
+	}
+}
+
+// Retrier is implemented by writers returned from Middleware.Writer
+type Retrier interface {
+	// Retry writes the compressed data kept after a sink error. It returns
+	// nil when nothing is pending, and an error wrapping ErrWritePending when
+	// the sink fails again.
+	Retry() error
+}
+
+func (w *writer) Retry() error {
+	w.lock()
+	defer w.unlock()
+	if w.resume == nil {
+		return nil
+	}
+	return w.resume.retry()
+}
+
+// resumeWriter sits between a writer and its sink. It keeps what the sink did
+// not take after an error, and everything written after it, so the encoder
+// state stays consistent with the kept data.
+type resumeWriter struct {
+	w       io.Writer
+	pending []byte
+	err     error
+}
+
+func (r *resumeWriter) Write(p []byte) (int, error) {
+	if r.err != nil {
+		r.pending = append(r.pending, p...)
+		return len(p), nil
+	}
+	n, err := r.w.Write(p)
+	if err == nil && n < len(p) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		r.pending = append(r.pending, p[n:]...)
+		r.err = fmt.Errorf("%w: %w", ErrWritePending, err)
+	}
+	return len(p), nil
+}
+
+// retry writes the pending data to the sink
+func (r *resumeWriter) retry() error {
+	if r.err == nil {
+		return nil
+	}
+	n, err := r.w.Write(r.pending)
+	if err == nil && n < len(r.pending) {
+		err = io.ErrShortWrite
+	}
+	r.pending = r.pending[:copy(r.pending, r.pending[n:])]
+	if err != nil {
+		r.err = fmt.Errorf("%w: %w", ErrWritePending, err)
+		return r.err
+	}
+	r.pending = nil
+	r.err = nil
+	return nil
+}
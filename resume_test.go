@@ -0,0 +1,94 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// flakySink fails the next failures writes after taking half of the data
+type flakySink struct {
+	buf      bytes.Buffer
+	failures int
+}
+
+var errOutage = errors.New("sink outage")
+
+func (s *flakySink) Write(p []byte) (int, error) {
+	if s.failures > 0 {
+		s.failures--
+		n, _ := s.buf.Write(p[:len(p)/2])
+		return n, errOutage
+	}
+	return s.buf.Write(p)
+}
+
+func TestWithResumableWrites(t *testing.T) {
+	requireZstd(t)
+	data := bytes.Repeat([]byte("resumable writes survive sink outages "), 4096)
+	for name, opts := range map[string][]Option{
+		"zstd":   {WithResumableWrites()},
+		"gzip":   {WithResumableWrites()},
+		"framed": {WithResumableWrites(), WithFrameSize(4096)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			a := Zstd
+			if name == "gzip" {
+				a = Gzip
+			}
+			m := New(a, opts...)
+			sink := &flakySink{}
+			w := m.Writer(sink)
+
+			// half leaves a partial frame for Flush
+			half := len(data)/2 - 100
+			if _, err := w.Write(data[:half]); err != nil {
+				t.Fatalf("Failed to write: %v", err)
+			}
+			sink.failures = 2
+			if err := w.(interface{ Flush() error }).Flush(); !errors.Is(err, ErrWritePending) || !errors.Is(err, errOutage) {
+				t.Fatalf("Expected ErrWritePending wrapping the sink error, got %v", err)
+			}
+			if n, err := w.Write(data[half:]); n != 0 || !errors.Is(err, ErrWritePending) {
+				t.Fatalf("Expected writes to fail while pending, got %d, %v", n, err)
+			}
+			if err := w.(Retrier).Retry(); !errors.Is(err, errOutage) {
+				t.Fatalf("Expected the second outage, got %v", err)
+			}
+			if err := w.(Retrier).Retry(); err != nil {
+				t.Fatalf("Failed to retry: %v", err)
+			}
+			if _, err := w.Write(data[half:]); err != nil {
+				t.Fatalf("Failed to write after retry: %v", err)
+			}
+
+			sink.failures = 1
+			if err := w.(io.Closer).Close(); !errors.Is(err, ErrWritePending) {
+				t.Fatalf("Expected Close to report the pending data, got %v", err)
+			}
+			if err := w.(Retrier).Retry(); err != nil {
+				t.Fatalf("Failed to retry after Close: %v", err)
+			}
+
+			got, err := io.ReadAll(m.Reader(&sink.buf))
+			if err != nil {
+				t.Fatalf("Failed to read: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("Data mismatch: got %d bytes, want %d", len(got), len(data))
+			}
+		})
+	}
+}
+
+func TestWithResumableWrites_NothingPending(t *testing.T) {
+	var buf bytes.Buffer
+	w := New(S2, WithResumableWrites()).Writer(&buf)
+	if err := w.(Retrier).Retry(); err != nil {
+		t.Fatalf("Expected no error without pending data, got %v", err)
+	}
+	if err := New(S2).Writer(&buf).(Retrier).Retry(); err != nil {
+		t.Fatalf("Expected no error without WithResumableWrites, got %v", err)
+	}
+}
@@ -0,0 +1,280 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// WithRecoverCorruptFrames makes readers skip damaged frames instead of
+// failing, resynchronizing on the next frame boundary, so most of a damaged
+// buffer can still be read. Every skipped range is reported to
+// WithCorruptionCallback as a CorruptionSkipped event and counted in
+// Stats.Corruptions; the reader itself only fails on errors of the source and
+// on read limits.
+//
+// It applies to framed streams (WithFrameSize) of every algorithm and to
+// unframed Zstd, S2 and Snappy streams. Framed streams lose only the damaged
+// frames, but a damaged frame header ends the stream, and WithChecksum is not
+// verified once a frame was skipped. S2 and Snappy return only chunks whose
+// checksum matches. Zstd resynchronizes on the next frame magic number after
+// the damage, so the rest of a damaged frame is lost and data decoded from it
+// before the damage was detected is returned. Other unframed streams have no
+// frame boundaries and fail as without the option.
+func WithRecoverCorruptFrames() Option {
+	return func(m *Middleware) {
+		m.salvage = true
+	}
+}
+
+// salvageable reports whether unframed streams of a can be resynchronized
+func salvageable(a Algorithm) bool {
+	return a == S2 || a == Snappy || a == Zstd && zstdAvailable
+}
+
+// skipped reports a range skipped by WithRecoverCorruptFrames
+func (m *Middleware) skipped(off, compressedOff, compressedSize int64, err error) {
+	m.stats.corruptions.Add(1)
+	if m.onCorruption != nil {
+		m.onCorruption(CorruptionEvent{
+			Kind:             CorruptionSkipped,
+			Algorithm:        m.algorithm,
+			Tag:              m.tag,
+			Offset:           off,
+			CompressedOffset: compressedOff,
+			CompressedSize:   compressedSize,
+			Err:              err,
+		})
+	}
+}
+
+// newSalvageReader creates the decoder of an unframed stream read from r
+// that skips damaged frames
+func (m *Middleware) newSalvageReader(r io.Reader) io.Reader {
+	if m.algorithm == Zstd {
+		return m.createZstdSalvager(r)
+	}
+	return m.newChunkSalvager(r)
+}
+
+// skip skips rec, which failed to decode with err, if frames are recovered.
+// It returns err otherwise.
+func (f *framedReader) skip(rec frameRecord, err error) error {
+	if !f.m.salvage || !errors.Is(err, ErrCorruptStream) {
+		return err
+	}
+	// The checksum of the stream cannot match without the frame
+	f.sum = nil
+	if dedupable(rec.typ) {
+		f.dedup.skipped()
+	}
+	f.m.skipped(f.decoded, f.start, f.position()-f.start, err)
+	return nil
+}
+
+// position returns the compressed offset of the next frame
+func (f *framedReader) position() int64 {
+	if f.in == nil {
+		return 0
+	}
+	return f.in.total.Load() - int64(f.cr.r.Buffered())
+}
+
+// salvageSource is the source of a salvaging reader, tracking the compressed
+// offset and the errors of the source
+type salvageSource struct {
+	r   *bufio.Reader
+	pos int64
+	err error
+}
+
+func newSalvageSource(r io.Reader, size int) *salvageSource {
+	return &salvageSource{r: bufio.NewReaderSize(r, size)}
+}
+
+func (s *salvageSource) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.pos += int64(n)
+	s.failed(err)
+	return n, err
+}
+
+func (s *salvageSource) peek(n int) ([]byte, error) {
+	p, err := s.r.Peek(n)
+	s.failed(err)
+	return p, err
+}
+
+func (s *salvageSource) discard(n int) {
+	n, _ = s.r.Discard(n)
+	s.pos += int64(n)
+}
+
+// failed records err if it is an error of the source
+func (s *salvageSource) failed(err error) {
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull && s.err == nil {
+		s.err = err
+	}
+}
+
+// skipTo discards the data up to the next occurrence of magic. It returns
+// io.EOF if there is none.
+func (s *salvageSource) skipTo(magic []byte) error {
+	for {
+		p, err := s.peek(s.r.Size())
+		if i := bytes.Index(p, magic); i >= 0 {
+			s.discard(i)
+			return nil
+		}
+		if err != nil {
+			s.discard(len(p))
+			return err
+		}
+		s.discard(len(p) - len(magic) + 1)
+	}
+}
+
+// S2 and Snappy chunk types
+const (
+	chunkCompressed   = 0x00
+	chunkUncompressed = 0x01
+	chunkIdentifier   = 0xff
+)
+
+// maxChunkBlock is the largest block of a chunk accepted by S2 readers
+const maxChunkBlock = 4 << 20
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// chunkCRC returns the masked CRC-32C of the data of a chunk
+func chunkCRC(p []byte) uint32 {
+	c := crc32.Update(0, castagnoliTable, p)
+	return (c>>15 | c<<17) + 0xa282ead8
+}
+
+// chunkSalvager decodes the chunks of an S2 or Snappy stream, skipping damaged
+// chunks up to the next chunk that decodes and verifies
+type chunkSalvager struct {
+	m     *Middleware
+	src   *salvageSource
+	block int
+	off   int64
+	buf   []byte
+	cur   []byte
+	err   error
+}
+
+func (m *Middleware) newChunkSalvager(r io.Reader) *chunkSalvager {
+	block := maxChunkBlock
+	if m.memory != nil {
+		block = m.memory.s2Block
+	}
+	expected := s2Identifier
+	if m.algorithm == Snappy {
+		expected = snappyIdentifier
+	}
+	r = m.withStreamIdentifierReader(r, expected)
+	return &chunkSalvager{m: m, src: newSalvageSource(r, 8+s2.MaxEncodedLen(block)), block: block}
+}
+
+func (c *chunkSalvager) Read(p []byte) (int, error) {
+	for len(c.cur) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		c.cur, c.err = c.next()
+	}
+	n := copy(p, c.cur)
+	c.cur = c.cur[n:]
+	c.off += int64(n)
+	return n, nil
+}
+
+// next returns the data of the next intact chunk. After a damaged chunk it
+// searches byte by byte for a chunk that decodes and verifies.
+func (c *chunkSalvager) next() ([]byte, error) {
+	start := c.src.pos
+	var damage error
+	for {
+		data, size, err := c.chunk(damage != nil)
+		if c.src.err != nil {
+			return nil, c.src.err
+		}
+		if err == nil || err == io.EOF {
+			if damage != nil {
+				c.m.skipped(c.off, start, c.src.pos-start, damage)
+			}
+			c.src.discard(size)
+			return data, err
+		}
+		if damage == nil {
+			damage = err
+		}
+		c.src.discard(1)
+	}
+}
+
+// chunk decodes the chunk at the start of the source, returning its data and
+// its size. While resynchronizing only data chunks and stream identifiers
+// are accepted, other chunk types are too likely to appear in garbage.
+func (c *chunkSalvager) chunk(resync bool) ([]byte, int, error) {
+	header, err := c.src.peek(4)
+	if len(header) == 0 && err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: chunk header cut short", io.ErrUnexpectedEOF)
+	}
+	typ, n := header[0], int(header[1])|int(header[2])<<8|int(header[3])<<16
+	switch {
+	case typ == chunkIdentifier:
+		if n != 6 {
+			return nil, 0, fmt.Errorf("%w: bad stream identifier", ErrCorruptStream)
+		}
+	case typ == chunkCompressed || typ == chunkUncompressed:
+		if n < 4 || n > 4+s2.MaxEncodedLen(c.block) {
+			return nil, 0, fmt.Errorf("%w: bad chunk length %d", ErrCorruptStream, n)
+		}
+	case typ < 0x80:
+		return nil, 0, fmt.Errorf("%w: reserved chunk type %#x", ErrCorruptStream, typ)
+	case resync:
+		return nil, 0, fmt.Errorf("%w: skippable chunk while resynchronizing", ErrCorruptStream)
+	}
+	p, err := c.src.peek(4 + n)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: chunk cut short", io.ErrUnexpectedEOF)
+	}
+	body := p[4:]
+	switch typ {
+	case chunkIdentifier:
+		if resync && string(p) != s2Identifier && string(p) != snappyIdentifier {
+			return nil, 0, fmt.Errorf("%w: bad stream identifier", ErrCorruptStream)
+		}
+		return nil, len(p), nil
+	case chunkCompressed:
+		size, err := s2.DecodedLen(body[4:])
+		if err != nil || size > c.block {
+			return nil, 0, fmt.Errorf("%w: bad chunk", ErrCorruptStream)
+		}
+		if c.buf, err = s2.Decode(c.buf[:cap(c.buf)], body[4:]); err != nil {
+			return nil, 0, fmt.Errorf("%w: %v", ErrCorruptStream, err)
+		}
+	case chunkUncompressed:
+		if len(body)-4 > c.block {
+			return nil, 0, fmt.Errorf("%w: bad chunk", ErrCorruptStream)
+		}
+		c.buf = append(c.buf[:0], body[4:]...)
+	default:
+		return nil, len(p), nil
+	}
+	if chunkCRC(c.buf) != binary.LittleEndian.Uint32(body) {
+		return nil, 0, fmt.Errorf("%w: chunk checksum mismatch", ErrChecksumMismatch)
+	}
+	return c.buf, len(p), nil
+}
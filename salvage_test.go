@@ -0,0 +1,147 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+// salvageParts returns distinct parts of a log buffer
+func salvageParts(n int) [][]byte {
+	parts := make([][]byte, n)
+	for i := range parts {
+		var b bytes.Buffer
+		for j := 0; j < 200; j++ {
+			fmt.Fprintf(&b, "part %02d line %03d: salvage the rest of the log\n", i, j)
+		}
+		parts[i] = b.Bytes()
+	}
+	return parts
+}
+
+// writeParts compresses each part into a chunk or frame of its own. Parts
+// are flushed, or compressed as separate zstd streams.
+func writeParts(t *testing.T, m *Middleware, parts [][]byte) (stream []byte, offsets []int) {
+	t.Helper()
+	var buf bytes.Buffer
+	if m.algorithm == Zstd && m.frameSize == 0 {
+		for _, p := range parts {
+			offsets = append(offsets, buf.Len())
+			buf.Write(writeContainer(t, m, p))
+		}
+		return buf.Bytes(), offsets
+	}
+	w := m.Writer(&buf)
+	for _, p := range parts {
+		offsets = append(offsets, buf.Len())
+		if _, err := w.Write(p); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		if err := w.(interface{ Flush() error }).Flush(); err != nil {
+			t.Fatalf("Failed to flush: %v", err)
+		}
+	}
+	if err := w.(io.Closer).Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	return buf.Bytes(), offsets
+}
+
+func TestWithRecoverCorruptFrames(t *testing.T) {
+	requireZstd(t)
+	parts := salvageParts(10)
+	for name, tt := range map[string]struct {
+		a    Algorithm
+		opts []Option
+	}{
+		"zstd":   {Zstd, nil},
+		"s2":     {S2, nil},
+		"snappy": {Snappy, nil},
+		"framed": {Gzip, []Option{WithFrameSize(len(parts[0]))}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			stream, offsets := writeParts(t, New(tt.a, tt.opts...), parts)
+			// Damage the middle of part 4
+			mid := (offsets[4] + offsets[5]) / 2
+			copy(stream[mid:], bytes.Repeat([]byte{0xa5}, 16))
+
+			var events []CorruptionEvent
+			m := New(tt.a, append(tt.opts, WithRecoverCorruptFrames(), WithCorruptionCallback(func(e CorruptionEvent) {
+				events = append(events, e)
+			}))...)
+			got, err := io.ReadAll(m.Reader(bytes.NewReader(stream)))
+			if err != nil {
+				t.Fatalf("Failed to read: %v", err)
+			}
+			for i, p := range parts {
+				if i != 4 && !bytes.Contains(got, p) {
+					t.Fatalf("Part %d was not recovered", i)
+				}
+			}
+			if len(got) >= len(bytes.Join(parts, nil)) && tt.a != Zstd {
+				t.Fatal("Expected the damaged part to be skipped")
+			}
+			if len(events) != 1 {
+				t.Fatalf("Expected one skipped range, got %d", len(events))
+			}
+			e := events[0]
+			if e.Kind != CorruptionSkipped || e.Err == nil || e.CompressedSize <= 0 ||
+				e.CompressedOffset < int64(offsets[4]) || e.CompressedOffset > int64(mid) || e.CompressedOffset+e.CompressedSize > int64(offsets[5]) {
+				t.Fatalf("Unexpected event %+v for part 4 at %d-%d", e, offsets[4], offsets[5])
+			}
+			if m.Stats().Corruptions != 1 {
+				t.Fatalf("Expected one corruption in the stats, got %d", m.Stats().Corruptions)
+			}
+
+			// Without recovery the damage fails the reader
+			if _, err := io.ReadAll(New(tt.a, tt.opts...).Reader(bytes.NewReader(stream))); err == nil {
+				t.Fatal("Expected an error without WithRecoverCorruptFrames")
+			}
+		})
+	}
+}
+
+func TestWithRecoverCorruptFrames_Garbage(t *testing.T) {
+	requireZstd(t)
+	parts := salvageParts(3)
+	for _, a := range []Algorithm{Zstd, S2} {
+		t.Run(a.String(), func(t *testing.T) {
+			stream, offsets := writeParts(t, New(a), parts)
+			garbage := bytes.Repeat([]byte("garbage!"), 100)
+			damaged := append(append(append([]byte{}, garbage...), stream[:offsets[2]]...), garbage...)
+			damaged = append(damaged, stream[offsets[2]:offsets[2]+10]...)
+
+			var skipped int64
+			m := New(a, WithRecoverCorruptFrames(), WithCorruptionCallback(func(e CorruptionEvent) {
+				skipped += e.CompressedSize
+			}))
+			got, err := io.ReadAll(m.Reader(bytes.NewReader(damaged)))
+			if err != nil {
+				t.Fatalf("Failed to read: %v", err)
+			}
+			if want := bytes.Join(parts[:2], nil); !bytes.Equal(got, want) {
+				t.Fatalf("Expected the intact parts, got %d bytes, want %d", len(got), len(want))
+			}
+			// The leading garbage, and the trailing garbage with the cut part
+			if want := int64(2*len(garbage) + 10); skipped != want {
+				t.Fatalf("Expected %d bytes skipped, got %d", want, skipped)
+			}
+		})
+	}
+}
+
+func TestWithRecoverCorruptFrames_SourceErrors(t *testing.T) {
+	stream, _ := writeParts(t, New(S2), salvageParts(2))
+	errSource := errors.New("source failed")
+	called := false
+	m := New(S2, WithRecoverCorruptFrames(), WithCorruptionCallback(func(CorruptionEvent) { called = true }))
+	r := m.Reader(io.MultiReader(bytes.NewReader(stream[:len(stream)/2]), &errReader{errSource}))
+	if _, err := io.ReadAll(r); !errors.Is(err, errSource) {
+		t.Fatalf("Expected the source error, got %v", err)
+	}
+	if called {
+		t.Fatal("Expected source errors not to be reported as skipped")
+	}
+}
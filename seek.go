@@ -0,0 +1,66 @@
+package compression
+
+import "io"
+
+// seekable exposes the random access of src on r. It applies when the
+// decoder of r passes the source through unchanged, so that disabling
+// compression does not cost hybridbuffer consumers Seek and ReadAt.
+func (r *reader) seekable(src io.Reader) io.Reader {
+	s, seeker := src.(io.Seeker)
+	ra, readerAt := src.(io.ReaderAt)
+	switch {
+	case seeker && readerAt:
+		return &seekReaderAt{seekReader: &seekReader{r, s}, at: ra}
+	case seeker:
+		return &seekReader{r, s}
+	case readerAt:
+		return &readerAtReader{r, ra}
+	}
+	return r
+}
+
+// seekReader is a passthrough reader whose source implements io.Seeker
+type seekReader struct {
+	*reader
+	seeker io.Seeker
+}
+
+// Seek seeks the source. The content hash cannot be verified after
+// seeking and is dropped.
+func (r *seekReader) Seek(offset int64, whence int) (int64, error) {
+	r.unread = nil
+	r.ahead, r.aheadErr = nil, nil
+	r.hash = nil
+	return r.seeker.Seek(offset, whence)
+}
+
+// readerAtReader is a passthrough reader whose source implements
+// io.ReaderAt
+type readerAtReader struct {
+	*reader
+	at io.ReaderAt
+}
+
+func (r *readerAtReader) ReadAt(p []byte, off int64) (int, error) {
+	return r.reader.readAt(r.at, p, off)
+}
+
+// seekReaderAt is a passthrough reader whose source implements both
+type seekReaderAt struct {
+	*seekReader
+	at io.ReaderAt
+}
+
+func (r *seekReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return r.reader.readAt(r.at, p, off)
+}
+
+func (r *reader) readAt(at io.ReaderAt, p []byte, off int64) (int, error) {
+	n, err := at.ReadAt(p, off)
+	r.m.stats.bytesRead.Add(int64(n))
+	r.m.stats.bytesDecompressed.Add(int64(n))
+	if terr := throttleGlobal(int64(n), &r.m.stats.lockWaits, r.deadline()); err == nil {
+		err = terr
+	}
+	return n, err
+}
@@ -0,0 +1,36 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSeekable(t *testing.T) {
+	m := New(Gzip)
+	src := bytes.NewReader([]byte("0123456789"))
+	r := (&reader{m: m, dec: src}).seekable(src)
+
+	s, ok := r.(io.ReadSeeker)
+	if !ok {
+		t.Fatal("reader does not implement io.Seeker")
+	}
+	if _, err := s.Seek(4, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(s)
+	if string(got) != "456789" {
+		t.Errorf("read after seek = %q", got)
+	}
+
+	p := make([]byte, 3)
+	if _, err := r.(io.ReaderAt).ReadAt(p, 1); err != nil || string(p) != "123" {
+		t.Errorf("ReadAt = %q, %v", p, err)
+	}
+
+	plain := strings.NewReader("x")
+	if _, ok := (&reader{m: m, dec: plain}).seekable(io.MultiReader(plain)).(io.Seeker); ok {
+		t.Error("reader over a non-seekable source implements io.Seeker")
+	}
+}
@@ -0,0 +1,495 @@
+package compression
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Sink is a destination of compressed data. Unlike io.Writer, every call
+// carries a context, so retries, metrics and cancellation can be layered on
+// any destination uniformly, see RetrySink and ObserveSink.
+type Sink interface {
+	Write(ctx context.Context, p []byte) (int, error)
+	// Flush makes the data written so far durable or visible, where the
+	// destination supports it
+	Flush(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+// Source is random access compressed input, the counterpart of Sink
+type Source interface {
+	ReadAt(ctx context.Context, p []byte, off int64) (int, error)
+	// Size returns the size of the input in bytes
+	Size(ctx context.Context) (int64, error)
+	Close(ctx context.Context) error
+}
+
+// WriterSink adapts w to a Sink. Flush and Close are passed on if w
+// implements Flush() error or io.Closer; os.File is synced on Flush.
+func WriterSink(w io.Writer) Sink {
+	return writerSink{w}
+}
+
+type writerSink struct {
+	w io.Writer
+}
+
+func (s writerSink) Write(ctx context.Context, p []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.w.Write(p)
+}
+
+func (s writerSink) Flush(ctx context.Context) error {
+	switch w := s.w.(type) {
+	case *os.File:
+		return w.Sync()
+	case interface{ Flush() error }:
+		return w.Flush()
+	}
+	return nil
+}
+
+func (s writerSink) Close(ctx context.Context) error {
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// ReaderAtSource adapts r with the given size to a Source. Close is passed
+// on if r implements io.Closer.
+func ReaderAtSource(r io.ReaderAt, size int64) Source {
+	return readerAtSource{r, size}
+}
+
+// FileSource adapts f to a Source
+func FileSource(f *os.File) (Source, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return readerAtSource{f, fi.Size()}, nil
+}
+
+type readerAtSource struct {
+	r    io.ReaderAt
+	size int64
+}
+
+func (s readerAtSource) ReadAt(ctx context.Context, p []byte, off int64) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return s.r.ReadAt(p, off)
+}
+
+func (s readerAtSource) Size(ctx context.Context) (int64, error) {
+	return s.size, nil
+}
+
+func (s readerAtSource) Close(ctx context.Context) error {
+	if c, ok := s.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// ReaderSource adapts the sequential reader r of size bytes to a Source.
+// Reads must be sequential; reading at any other offset fails with
+// ErrNotSeekable.
+func ReaderSource(r io.Reader, size int64) Source {
+	return &readerSource{r: r, size: size}
+}
+
+type readerSource struct {
+	r    io.Reader
+	size int64
+	off  int64
+}
+
+func (s *readerSource) ReadAt(ctx context.Context, p []byte, off int64) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if off != s.off {
+		return 0, fmt.Errorf("%w: read at %d, source is at %d", ErrNotSeekable, off, s.off)
+	}
+	n, err := io.ReadFull(s.r, p)
+	s.off += int64(n)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (s *readerSource) Size(ctx context.Context) (int64, error) {
+	return s.size, nil
+}
+
+func (s *readerSource) Close(ctx context.Context) error {
+	if c, ok := s.r.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// ObjectReader is the part of an object storage client needed to read one
+// object, e.g. through ranged GET requests
+type ObjectReader interface {
+	ReadRange(ctx context.Context, off, length int64) (io.ReadCloser, error)
+	Size(ctx context.Context) (int64, error)
+}
+
+// ObjectSource adapts an object to a Source. Every ReadAt is one ranged
+// read, so callers should read in large chunks, e.g. whole frames. The size
+// is fetched once, so the object must not change while it is read.
+func ObjectSource(o ObjectReader) Source {
+	return &objectSource{o: o}
+}
+
+type objectSource struct {
+	o ObjectReader
+
+	// size is valid once sized is set
+	mu    sync.Mutex
+	size  int64
+	sized bool
+}
+
+func (s *objectSource) ReadAt(ctx context.Context, p []byte, off int64) (int, error) {
+	size, err := s.Size(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if off >= size {
+		return 0, io.EOF
+	}
+	length := min(int64(len(p)), size-off)
+	rc, err := s.o.ReadRange(ctx, off, length)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	n, err := io.ReadFull(rc, p[:length])
+	if err == nil && int64(n) < int64(len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Size returns the size of the object, fetching it on the first call
+func (s *objectSource) Size(ctx context.Context) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.sized {
+		size, err := s.o.Size(ctx)
+		if err != nil {
+			return 0, err
+		}
+		s.size, s.sized = size, true
+	}
+	return s.size, nil
+}
+
+func (s *objectSource) Close(ctx context.Context) error {
+	return nil
+}
+
+// ObjectUploader is the part of an object storage client needed to write one
+// object as a multipart upload. Parts are numbered from 1.
+type ObjectUploader interface {
+	UploadPart(ctx context.Context, part int, p []byte) error
+	Complete(ctx context.Context, parts int) error
+}
+
+// DefaultPartSize is the part size of ObjectSink for part sizes of 0 or less
+const DefaultPartSize = 8 << 20
+
+// ObjectSink adapts a multipart upload to a Sink that uploads parts of
+// partSize bytes, or DefaultPartSize if partSize is 0 or less; the last part
+// may be smaller. Flush does not upload partial parts, as object stores
+// require a minimum part size.
+func ObjectSink(u ObjectUploader, partSize int) Sink {
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+	return &objectSink{u: u, size: partSize}
+}
+
+type objectSink struct {
+	u     ObjectUploader
+	size  int
+	buf   []byte
+	parts int
+}
+
+func (s *objectSink) Write(ctx context.Context, p []byte) (int, error) {
+	var n int
+	for len(p) > 0 {
+		k := min(len(p), s.size-len(s.buf))
+		s.buf = append(s.buf, p[:k]...)
+		p = p[k:]
+		n += k
+		if len(s.buf) == s.size {
+			if err := s.upload(ctx); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (s *objectSink) upload(ctx context.Context) error {
+	if err := s.u.UploadPart(ctx, s.parts+1, s.buf); err != nil {
+		return err
+	}
+	s.parts++
+	s.buf = s.buf[:0]
+	return nil
+}
+
+func (s *objectSink) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (s *objectSink) Close(ctx context.Context) error {
+	if len(s.buf) > 0 || s.parts == 0 {
+		if err := s.upload(ctx); err != nil {
+			return err
+		}
+	}
+	return s.u.Complete(ctx, s.parts)
+}
+
+// RetrySink retries failed calls of s up to attempts times in total, waiting
+// backoff before the first retry and doubling it after each one. Partial
+// writes resume with the remaining data. Retries stop when ctx is done.
+func RetrySink(s Sink, attempts int, backoff time.Duration) Sink {
+	return retrySink{s, retryPolicy{attempts, backoff}}
+}
+
+// RetrySource retries failed reads of s like RetrySink. io.EOF is not
+// retried.
+func RetrySource(s Source, attempts int, backoff time.Duration) Source {
+	return retrySource{s, retryPolicy{attempts, backoff}}
+}
+
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+}
+
+// do calls fn until it succeeds, attempts are used up or ctx is done
+func (r retryPolicy) do(ctx context.Context, fn func() error) error {
+	backoff := r.backoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || err == io.EOF || attempt >= r.attempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+type retrySink struct {
+	s Sink
+	r retryPolicy
+}
+
+func (s retrySink) Write(ctx context.Context, p []byte) (int, error) {
+	var written int
+	err := s.r.do(ctx, func() error {
+		n, err := s.s.Write(ctx, p[written:])
+		written += n
+		return err
+	})
+	return written, err
+}
+
+func (s retrySink) Flush(ctx context.Context) error {
+	return s.r.do(ctx, func() error { return s.s.Flush(ctx) })
+}
+
+func (s retrySink) Close(ctx context.Context) error {
+	return s.r.do(ctx, func() error { return s.s.Close(ctx) })
+}
+
+type retrySource struct {
+	s Source
+	r retryPolicy
+}
+
+func (s retrySource) ReadAt(ctx context.Context, p []byte, off int64) (int, error) {
+	var n int
+	err := s.r.do(ctx, func() (err error) {
+		n, err = s.s.ReadAt(ctx, p, off)
+		return err
+	})
+	return n, err
+}
+
+func (s retrySource) Size(ctx context.Context) (int64, error) {
+	var size int64
+	err := s.r.do(ctx, func() (err error) {
+		size, err = s.s.Size(ctx)
+		return err
+	})
+	return size, err
+}
+
+func (s retrySource) Close(ctx context.Context) error {
+	return s.s.Close(ctx)
+}
+
+// SinkObserver is called after every call of an observed Sink or Source
+// with the operation ("write", "flush", "close", "read_at" or "size"), the
+// number of bytes transferred, the duration and the error
+type SinkObserver func(op string, n int, d time.Duration, err error)
+
+// ObserveSink reports every call of s to fn, e.g. to export metrics
+func ObserveSink(s Sink, fn SinkObserver) Sink {
+	return observedSink{s, fn}
+}
+
+// ObserveSource reports every call of s to fn like ObserveSink
+func ObserveSource(s Source, fn SinkObserver) Source {
+	return observedSource{s, fn}
+}
+
+type observedSink struct {
+	s  Sink
+	fn SinkObserver
+}
+
+func (s observedSink) Write(ctx context.Context, p []byte) (int, error) {
+	start := time.Now()
+	n, err := s.s.Write(ctx, p)
+	s.fn("write", n, time.Since(start), err)
+	return n, err
+}
+
+func (s observedSink) Flush(ctx context.Context) error {
+	start := time.Now()
+	err := s.s.Flush(ctx)
+	s.fn("flush", 0, time.Since(start), err)
+	return err
+}
+
+func (s observedSink) Close(ctx context.Context) error {
+	start := time.Now()
+	err := s.s.Close(ctx)
+	s.fn("close", 0, time.Since(start), err)
+	return err
+}
+
+type observedSource struct {
+	s  Source
+	fn SinkObserver
+}
+
+func (s observedSource) ReadAt(ctx context.Context, p []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := s.s.ReadAt(ctx, p, off)
+	s.fn("read_at", n, time.Since(start), err)
+	return n, err
+}
+
+func (s observedSource) Size(ctx context.Context) (int64, error) {
+	start := time.Now()
+	size, err := s.s.Size(ctx)
+	s.fn("size", 0, time.Since(start), err)
+	return size, err
+}
+
+func (s observedSource) Close(ctx context.Context) error {
+	start := time.Now()
+	err := s.s.Close(ctx)
+	s.fn("close", 0, time.Since(start), err)
+	return err
+}
+
+// SinkWriter returns a writer compressing into s. Close closes the
+// compressed stream and then s, once; Flush flushes both. ctx applies to all
+// calls of s.
+func (m *Middleware) SinkWriter(ctx context.Context, s Sink) io.WriteCloser {
+	return &sinkWriter{ctx: ctx, s: s, w: m.Writer(sinkIOWriter{ctx, s})}
+}
+
+type sinkWriter struct {
+	ctx    context.Context
+	s      Sink
+	w      io.Writer
+	closed bool
+}
+
+// sinkIOWriter adapts a Sink to io.Writer for the compressing writer
+type sinkIOWriter struct {
+	ctx context.Context
+	s   Sink
+}
+
+func (s sinkIOWriter) Write(p []byte) (int, error) {
+	return s.s.Write(s.ctx, p)
+}
+
+func (w *sinkWriter) Write(p []byte) (int, error) {
+	return w.w.Write(p)
+}
+
+// Flush flushes the compressed stream and the sink
+func (w *sinkWriter) Flush() error {
+	if f, ok := w.w.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+	return w.s.Flush(w.ctx)
+}
+
+func (w *sinkWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	var err error
+	if c, ok := w.w.(io.Closer); ok {
+		err = c.Close()
+	}
+	if cerr := w.s.Close(w.ctx); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// SourceSection returns a section reader over all of src, with ctx applied
+// to every read. It plugs sources into the random access APIs: Reader with
+// Seek and ReadAt passthrough, SeekableReader, ReadContainerIndex and
+// OpenDictzip.
+func SourceSection(ctx context.Context, src Source) (*io.SectionReader, error) {
+	size, err := src.Size(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(sourceReaderAt{ctx, src}, 0, size), nil
+}
+
+// sourceReaderAt adapts a Source to io.ReaderAt
+type sourceReaderAt struct {
+	ctx context.Context
+	src Source
+}
+
+func (s sourceReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return s.src.ReadAt(s.ctx, p, off)
+}
@@ -0,0 +1,153 @@
+package compression
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakyObject is an in-memory object store client failing every other call
+type flakyObject struct {
+	data  []byte
+	parts [][]byte
+	calls int
+	done  int
+	sizes int
+}
+
+var errFlaky = errors.New("flaky object store")
+
+func (o *flakyObject) fail() bool {
+	o.calls++
+	return o.calls%2 == 1
+}
+
+func (o *flakyObject) ReadRange(ctx context.Context, off, length int64) (io.ReadCloser, error) {
+	if o.fail() {
+		return nil, errFlaky
+	}
+	return io.NopCloser(bytes.NewReader(o.data[off : off+length])), nil
+}
+
+func (o *flakyObject) Size(ctx context.Context) (int64, error) {
+	o.sizes++
+	return int64(len(o.data)), nil
+}
+
+func (o *flakyObject) UploadPart(ctx context.Context, part int, p []byte) error {
+	if o.fail() {
+		return errFlaky
+	}
+	if part != len(o.parts)+1 {
+		return errors.New("parts out of order")
+	}
+	o.parts = append(o.parts, bytes.Clone(p))
+	return nil
+}
+
+func (o *flakyObject) Complete(ctx context.Context, parts int) error {
+	o.done = parts
+	o.data = bytes.Join(o.parts, nil)
+	return nil
+}
+
+func TestSinkWriter_ObjectStore(t *testing.T) {
+	requireZstd(t)
+	ctx := context.Background()
+	m := New(Zstd, WithFrameSize(4096))
+	data := []byte(strings.Repeat("sinks and sources ", 5000))
+
+	obj := &flakyObject{}
+	var ops int
+	sink := ObserveSink(RetrySink(ObjectSink(obj, 8<<10), 3, time.Millisecond), func(op string, n int, d time.Duration, err error) {
+		if err != nil {
+			t.Errorf("Expected retries to hide the failures, %s failed: %v", op, err)
+		}
+		ops++
+	})
+	w := m.SinkWriter(ctx, sink)
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if obj.done == 0 || obj.done != len(obj.parts) || ops == 0 {
+		t.Fatalf("Expected a completed upload, got %d of %d parts and %d observed calls", obj.done, len(obj.parts), ops)
+	}
+	parts := len(obj.parts)
+	if err := w.Close(); err != nil || len(obj.parts) != parts {
+		t.Fatalf("Expected closing again to leave the upload alone, got %d parts, %v", len(obj.parts), err)
+	}
+
+	src := RetrySource(ObjectSource(obj), 3, time.Millisecond)
+	section, err := SourceSection(ctx, src)
+	if err != nil {
+		t.Fatalf("Failed to open the source: %v", err)
+	}
+	index, err := ReadContainerIndex(section, section.Size())
+	if err != nil || len(index) == 0 {
+		t.Fatalf("Failed to read the index: %v", err)
+	}
+	got, err := io.ReadAll(m.Reader(section))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if obj.sizes != 1 {
+		t.Errorf("Expected the size to be fetched once, got %d calls", obj.sizes)
+	}
+}
+
+func TestSinkWriter_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var buf bytes.Buffer
+	w := New(S2).SinkWriter(ctx, WriterSink(&buf))
+	cancel()
+	w.Write([]byte("cancelled"))
+	if err := w.Close(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRetrySink_GivesUp(t *testing.T) {
+	// The first upload fails and a single attempt does not retry it
+	sink := RetrySink(ObjectSink(&flakyObject{}, 1), 1, time.Millisecond)
+	if _, err := sink.Write(context.Background(), []byte("x")); !errors.Is(err, errFlaky) {
+		t.Fatalf("Expected the upload error, got %v", err)
+	}
+}
+
+func TestObjectSink_DefaultPartSize(t *testing.T) {
+	ctx := context.Background()
+	// Every call succeeds after the first one
+	obj := &flakyObject{calls: 1}
+	sink := ObjectSink(obj, 0)
+	if n, err := sink.Write(ctx, []byte("zero part size")); n != 14 || err != nil {
+		t.Fatalf("Failed to write: %d, %v", n, err)
+	}
+	if err := sink.Close(ctx); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if len(obj.parts) != 1 || string(obj.data) != "zero part size" {
+		t.Fatalf("Expected a single part, got %q", obj.parts)
+	}
+}
+
+func TestReaderSource(t *testing.T) {
+	ctx := context.Background()
+	src := ReaderSource(strings.NewReader("sequential"), 10)
+	p := make([]byte, 4)
+	if n, err := src.ReadAt(ctx, p, 0); n != 4 || err != nil {
+		t.Fatalf("Failed to read: %d, %v", n, err)
+	}
+	if _, err := src.ReadAt(ctx, p, 0); !errors.Is(err, ErrNotSeekable) {
+		t.Fatalf("Expected ErrNotSeekable, got %v", err)
+	}
+	if n, err := src.ReadAt(ctx, make([]byte, 10), 4); n != 6 || err != io.EOF {
+		t.Fatalf("Expected the rest and io.EOF, got %d, %v", n, err)
+	}
+}
@@ -0,0 +1,112 @@
+package compression
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/klauspost/compress/snappy"
+)
+
+// errSnappyBlockTooLarge is returned by SnappyBlock writers exceeding the
+// size a block can hold
+var errSnappyBlockTooLarge = fmt.Errorf("%w: snappy block larger than 4 GiB", ErrLimitExceeded)
+
+// snappyBlockWriter buffers the stream and writes it as a single snappy
+// block on Close, as the block format has no framing
+type snappyBlockWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func (m *Middleware) createSnappyBlockWriter(w io.Writer) encoder {
+	return &snappyBlockWriter{w: w}
+}
+
+func (b *snappyBlockWriter) Write(p []byte) (int, error) {
+	if int64(len(b.buf))+int64(len(p)) > math.MaxUint32 {
+		return 0, errSnappyBlockTooLarge
+	}
+	b.buf = append(b.buf, p...)
+	return len(p), nil
+}
+
+func (b *snappyBlockWriter) Close() error {
+	_, err := b.w.Write(snappy.Encode(nil, b.buf))
+	b.buf = b.buf[:0]
+	return err
+}
+
+func (b *snappyBlockWriter) Reset(w io.Writer) {
+	b.w = w
+	b.buf = b.buf[:0]
+}
+
+// snappyBlockReader reads the whole block and decodes it on the first Read
+type snappyBlockReader struct {
+	r io.Reader
+	// limit is the largest decoded block accepted, 0 for unlimited
+	limit   int64
+	data    []byte
+	err     error
+	decoded bool
+}
+
+func (m *Middleware) createSnappyBlockReader(r io.Reader) io.Reader {
+	return &snappyBlockReader{r: r, limit: m.snappyBlockLimit()}
+}
+
+// snappyBlockLimit returns the largest block readers decode, as the whole
+// block is held in memory: the decompressed size limit and the memory budget
+// of a stream, or 0 if neither is set
+func (m *Middleware) snappyBlockLimit() int64 {
+	limit := m.maxDecoded
+	if budget := m.streamBudget(); budget > 0 && (limit <= 0 || budget < limit) {
+		limit = budget
+	}
+	return limit
+}
+
+func (b *snappyBlockReader) Read(p []byte) (int, error) {
+	if !b.decoded {
+		b.decoded = true
+		b.data, b.err = b.decode()
+	}
+	if len(b.data) == 0 {
+		if b.err != nil {
+			return 0, b.err
+		}
+		return 0, io.EOF
+	}
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+	return n, nil
+}
+
+// decode reads and decodes the block, checking the size it declares against
+// the limit before allocating it
+func (b *snappyBlockReader) decode() ([]byte, error) {
+	r := b.r
+	if b.limit > 0 {
+		// Valid blocks within the limit are no longer than this, limits
+		// beyond the encodable size do not bound the input
+		if n := snappy.MaxEncodedLen(int(min(b.limit, math.MaxUint32))); n >= 0 {
+			r = io.LimitReader(r, int64(n)+1)
+		}
+	}
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(src) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	n, err := snappy.DecodedLen(src)
+	if err != nil {
+		return nil, err
+	}
+	if b.limit > 0 && int64(n) > b.limit {
+		return nil, fmt.Errorf("%w: snappy block declares %d bytes, more than %d", ErrDecompressedSize, n, b.limit)
+	}
+	return snappy.Decode(nil, src)
+}
@@ -0,0 +1,75 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/snappy"
+)
+
+func TestSnappyBlock_Interop(t *testing.T) {
+	data := []byte(strings.Repeat("cassandra sstable row ", 1000))
+	m := New(SnappyBlock)
+
+	// The second stream uses the pooled encoder
+	for range 2 {
+		block := writeContainer(t, m, data)
+		got, err := snappy.Decode(nil, block)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("Expected a raw snappy block: %v", err)
+		}
+	}
+
+	got, err := io.ReadAll(m.Reader(bytes.NewReader(snappy.Encode(nil, data))))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to read a raw snappy block: %v", err)
+	}
+}
+
+func TestSnappyBlock_Framed(t *testing.T) {
+	data := []byte(strings.Repeat("framed snappy blocks ", 5000))
+	m := New(SnappyBlock, WithFrameSize(16<<10))
+	got, err := io.ReadAll(m.Reader(bytes.NewReader(writeContainer(t, m, data))))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to round trip: %v", err)
+	}
+}
+
+func TestSnappyBlock_Corrupt(t *testing.T) {
+	m := New(SnappyBlock)
+	if _, err := io.ReadAll(m.Reader(bytes.NewReader(nil))); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Expected io.ErrUnexpectedEOF for an empty stream, got %v", err)
+	}
+	block := writeContainer(t, m, []byte(strings.Repeat("corrupt ", 100)))
+	if _, err := io.ReadAll(m.Reader(bytes.NewReader(block[:len(block)-3]))); !errors.Is(err, s2.ErrCorrupt) {
+		t.Fatalf("Expected s2.ErrCorrupt for a cut block, got %v", err)
+	}
+}
+
+func TestSnappyBlock_Limits(t *testing.T) {
+	// A few bytes declaring a block of almost 4 GiB
+	bomb := append(binary.AppendUvarint(nil, 0xF0000000), 0x00, 'x')
+	for name, m := range map[string]*Middleware{
+		"max size": New(SnappyBlock, WithMaxDecompressedSize(1<<20)),
+		"paranoid": New(SnappyBlock, PresetParanoid()),
+	} {
+		if _, err := io.ReadAll(m.Reader(bytes.NewReader(bomb))); !errors.Is(err, ErrDecompressedSize) {
+			t.Errorf("%s: expected ErrDecompressedSize, got %v", name, err)
+		}
+	}
+
+	data := []byte(strings.Repeat("within the limit ", 1000))
+	// Limits beyond the largest encodable block do not bound the input
+	for _, limit := range []int64{1 << 20, 8 << 30} {
+		m := New(SnappyBlock, WithMaxDecompressedSize(limit))
+		got, err := io.ReadAll(m.Reader(bytes.NewReader(snappy.Encode(nil, data))))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("Failed to read a block within the limit of %d: %v", limit, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package compression
+
+import (
+	"io"
+	"os"
+)
+
+// zeroPage is compared against to detect zero runs
+var zeroPage [4096]byte
+
+// WithZeroFrames makes zstd writers emit a complete frame for streams without
+// data instead of no output at all, as the reference zstd implementation
+// does. Some decoders reject empty input.
+func WithZeroFrames() Option {
+	return func(m *Middleware) {
+		m.zeroFrames = true
+	}
+}
+
+// WithSparseDetection stores frames consisting only of zero bytes as zero
+// frames without payload, skipping the codec entirely. This makes large
+// zero-filled regions, such as preallocated pages, nearly free to compress
+// and decompress. The granularity is the frame size; the option implies
+// framing with DefaultFrameSize unless WithFrameSize is set.
+func WithSparseDetection() Option {
+	return func(m *Middleware) {
+		m.sparse = true
+		if m.frameSize == 0 {
+			m.frameSize = DefaultFrameSize
+		}
+	}
+}
+
+// isZero reports whether p consists only of zero bytes
+func isZero(p []byte) bool {
+	for len(p) > 0 {
+		n := min(len(p), len(zeroPage))
+		if string(p[:n]) != string(zeroPage[:n]) {
+			return false
+		}
+		p = p[n:]
+	}
+	return true
+}
+
+// WithSparseFiles makes readers create sparse files when they write to an
+// *os.File through WriteTo, e.g. with io.Copy or DecompressToFile: runs of
+// zero bytes covering whole 4 KiB blocks are skipped with Seek instead of
+// written, leaving holes that read back as zeros. This saves time and disk
+// space for payloads like VM images. The file must be empty past its
+// current offset, e.g. newly created or truncated.
+func WithSparseFiles() Option {
+	return func(m *Middleware) {
+		m.sparseFiles = true
+	}
+}
+
+// sparseWriter writes to f, seeking over zero blocks instead of writing them
+type sparseWriter struct {
+	f *os.File
+	// hole is the length of the zero run not yet seeked over
+	hole int64
+}
+
+func (w *sparseWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// Collect data up to the next zero block
+		data := 0
+		for data < len(p) {
+			n := min(len(p)-data, len(zeroPage))
+			if n == len(zeroPage) && isZero(p[data:data+n]) {
+				break
+			}
+			data += n
+		}
+		if data > 0 {
+			if err := w.seekHole(); err != nil {
+				return written, err
+			}
+			n, err := w.f.Write(p[:data])
+			written += n
+			if err != nil {
+				return written, err
+			}
+			p = p[data:]
+		}
+		for len(p) >= len(zeroPage) && isZero(p[:len(zeroPage)]) {
+			w.hole += int64(len(zeroPage))
+			written += len(zeroPage)
+			p = p[len(zeroPage):]
+		}
+	}
+	return written, nil
+}
+
+// skip adds n zero bytes to the pending hole
+func (w *sparseWriter) skip(n int64) {
+	w.hole += n
+}
+
+// seekHole moves the file offset past the pending hole
+func (w *sparseWriter) seekHole() error {
+	if w.hole == 0 {
+		return nil
+	}
+	_, err := w.f.Seek(w.hole, io.SeekCurrent)
+	w.hole = 0
+	return err
+}
+
+// finish extends the file over a trailing hole
+func (w *sparseWriter) finish() error {
+	if w.hole == 0 {
+		return nil
+	}
+	if err := w.seekHole(); err != nil {
+		return err
+	}
+	end, err := w.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	return w.f.Truncate(end)
+}
+
+// fileOf returns the file w writes to, if it is a file
+func fileOf(w io.Writer) *os.File {
+	switch w := w.(type) {
+	case *os.File:
+		return w
+	case *sparseWriter:
+		if w.seekHole() == nil {
+			return w.f
+		}
+	}
+	return nil
+}
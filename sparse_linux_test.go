@@ -0,0 +1,19 @@
+package compression
+
+import (
+	"os"
+	"syscall"
+	"testing"
+)
+
+// checkSparse fails if the file at path occupies as many blocks as size
+func checkSparse(t *testing.T, path string, size int) {
+	t.Helper()
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated := fi.Sys().(*syscall.Stat_t).Blocks * 512; allocated >= int64(size) {
+		t.Errorf("Expected a sparse file, %d bytes allocated for %d", allocated, size)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package compression
+
+import "testing"
+
+func checkSparse(t *testing.T, path string, size int) {}
@@ -0,0 +1,116 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWithSparseDetection(t *testing.T) {
+	requireZstd(t)
+	data := make([]byte, 8<<16)
+	copy(data[3<<16:], bytes.Repeat([]byte("not sparse "), 1000))
+
+	m := New(Zstd, WithSparseDetection(), WithFrameSize(1<<16))
+	compressed := writeContainer(t, m, data)
+
+	cr := containerReader{r: bufio.NewReader(bytes.NewReader(compressed))}
+	zero := 0
+	for {
+		rec, err := cr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.typ == frameZero {
+			zero++
+		}
+	}
+	if zero != 7 {
+		t.Fatalf("Expected 7 zero frames, got %d", zero)
+	}
+
+	got, err := io.ReadAll(m.Reader(bytes.NewReader(compressed)))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Round trip failed: %v", err)
+	}
+	dst := make([]byte, len(data))
+	for i := range dst {
+		dst[i] = 0xff
+	}
+	if n, err := m.Reader(bytes.NewReader(compressed)).(DirectDecoder).DecodeInto(dst); err != nil || !bytes.Equal(dst[:n], data) {
+		t.Fatalf("DecodeInto failed: %v", err)
+	}
+}
+
+func TestWithZeroFrames(t *testing.T) {
+	requireZstd(t)
+	for _, tc := range []struct {
+		opts  []Option
+		empty bool
+	}{
+		{nil, true},
+		{[]Option{WithZeroFrames()}, false},
+	} {
+		var buf bytes.Buffer
+		w := New(Zstd, tc.opts...).Writer(&buf)
+		w.(io.Closer).Close()
+		if (buf.Len() == 0) != tc.empty {
+			t.Fatalf("Expected empty output %v, got %d bytes", tc.empty, buf.Len())
+		}
+	}
+}
+
+// sparseImage returns 1 MiB of zeros with data at the start and the middle
+func sparseImage() []byte {
+	data := make([]byte, 1<<20)
+	copy(data, "boot sector")
+	copy(data[512<<10+100:], bytes.Repeat([]byte("payload "), 100))
+	return data
+}
+
+func TestWithSparseFiles(t *testing.T) {
+	requireZstd(t)
+	data := sparseImage()
+	for _, m := range []*Middleware{
+		New(Zstd, WithSparseFiles()),
+		New(Zstd, WithSparseFiles(), WithSparseDetection(), WithFrameSize(64<<10)),
+	} {
+		var compressed bytes.Buffer
+		w := m.Writer(&compressed)
+		w.Write(data)
+		w.(io.Closer).Close()
+
+		path := filepath.Join(t.TempDir(), "image")
+		if _, err := m.DecompressToFile(bytes.NewReader(compressed.Bytes()), path); err != nil {
+			t.Fatalf("Failed to decompress: %v", err)
+		}
+		got, _ := os.ReadFile(path)
+		if !bytes.Equal(got, data) {
+			t.Fatalf("Sparse file content mismatch, got %d bytes", len(got))
+		}
+		checkSparse(t, path, len(data))
+	}
+}
+
+func TestSparseWriter_TrailingHole(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "trailing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	w := &sparseWriter{f: f}
+	w.Write([]byte("head"))
+	w.Write(make([]byte, 3*len(zeroPage)+10))
+	if err := w.finish(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := f.Stat(); fi.Size() != int64(4+3*len(zeroPage)+10) {
+		t.Fatalf("Unexpected file size %d", fi.Size())
+	}
+}
@@ -0,0 +1,157 @@
+package compression
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// WithSpillDir lets writers in parallel block mode (see WithParallelGzip)
+// that need more staging memory than WithMemoryBudget allows keep only the
+// blocks that fit in memory and stage the rest of their input in a
+// temporary file in dir, instead of failing with ErrMemoryBudget. An empty
+// dir selects a directory on disk for the OS: $TMPDIR or /var/tmp on Linux,
+// where /tmp is often in memory, and os.TempDir elsewhere.
+func WithSpillDir(dir string) Option {
+	return func(m *Middleware) {
+		m.spill = true
+		m.spillDir = dir
+	}
+}
+
+// spillStage stages the input of an encoder in a temporary file, which a
+// goroutine drains into the encoder in blocks
+type spillStage struct {
+	m     *Middleware
+	enc   encoder
+	dir   string
+	block int
+
+	mu       sync.Mutex
+	progress *sync.Cond
+	file     *os.File
+	// off and end are the drained and the written offsets of file
+	off, end int64
+	closing  bool
+	err      error
+	done     chan struct{}
+}
+
+func (m *Middleware) newSpillStage(enc encoder) *spillStage {
+	s := &spillStage{m: m, enc: enc, dir: m.spillDir, block: m.pgzipBlock}
+	s.progress = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *spillStage) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return 0, s.err
+	}
+	if s.file == nil {
+		f, err := createSpillFile(s.dir)
+		if err != nil {
+			return 0, err
+		}
+		s.file = f
+		s.done = make(chan struct{})
+		go s.drain()
+	}
+	n, err := s.file.WriteAt(p, s.end)
+	s.end += int64(n)
+	s.progress.Broadcast()
+	return n, err
+}
+
+// drain feeds the staged input to the encoder until the stage is closed
+// and empty or the encoder fails
+func (s *spillStage) drain() {
+	defer close(s.done)
+	defer s.m.recoverPanic(func(err error) { s.fail(err) })
+	buf := make([]byte, s.block)
+	for {
+		s.mu.Lock()
+		for s.off == s.end && !s.closing && s.err == nil {
+			s.progress.Wait()
+		}
+		if s.off == s.end || s.err != nil {
+			s.mu.Unlock()
+			return
+		}
+		off, n := s.off, int(min(int64(len(buf)), s.end-s.off))
+		s.mu.Unlock()
+
+		// Only drain truncates the file, so the range stays valid
+		_, err := s.file.ReadAt(buf[:n], off)
+		if err == nil {
+			_, err = s.enc.Write(buf[:n])
+		}
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		s.mu.Lock()
+		s.off += int64(n)
+		if s.off == s.end {
+			s.file.Truncate(0)
+			s.off, s.end = 0, 0
+		}
+		s.progress.Broadcast()
+		s.mu.Unlock()
+	}
+}
+
+func (s *spillStage) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.progress.Broadcast()
+}
+
+// Flush waits until the staged input is encoded and flushes the encoder
+func (s *spillStage) Flush() error {
+	s.mu.Lock()
+	for s.off != s.end && s.err == nil {
+		s.progress.Wait()
+	}
+	err := s.err
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if f, ok := s.enc.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Close encodes the staged input, removes the file and closes the encoder
+func (s *spillStage) Close() error {
+	s.mu.Lock()
+	s.closing = true
+	s.progress.Broadcast()
+	s.mu.Unlock()
+	if s.done != nil {
+		<-s.done
+	}
+	if s.file != nil {
+		releaseSpillFile(s.file)
+		s.file = nil
+	}
+	err := s.err
+	if cerr := s.enc.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *spillStage) Reset(w io.Writer) {
+	s.enc.Reset(w)
+	s.off, s.end = 0, 0
+	s.closing = false
+	s.err = nil
+	s.done = nil
+}
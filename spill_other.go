@@ -0,0 +1,16 @@
+//go:build !unix
+
+package compression
+
+import "os"
+
+// createSpillFile creates a temporary file in dir. Open files cannot be
+// removed on these systems, see releaseSpillFile.
+func createSpillFile(dir string) (*os.File, error) {
+	return os.CreateTemp(dir, "hybridbuffer-spill-*")
+}
+
+func releaseSpillFile(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
@@ -0,0 +1,83 @@
+package compression
+
+import (
+	"bytes"
+	stdgzip "compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestWithSpillDir(t *testing.T) {
+	var data []byte
+	for i := 0; len(data) < 2<<20; i++ {
+		data = fmt.Appendf(data, "line %d staged on disk\n", i)
+	}
+	// Fits the gzip codec and two of the eight blocks
+	budget := int64(1<<20 + 320<<10)
+	if _, err := New(Gzip, WithParallelGzip(64<<10, 8), WithMemoryBudget(budget)).Writer(io.Discard).Write(data); !errors.Is(err, ErrMemoryBudget) {
+		t.Fatalf("Expected ErrMemoryBudget without spilling, got %v", err)
+	}
+
+	dir := t.TempDir()
+	m := New(Gzip, WithParallelGzip(64<<10, 8), WithMemoryBudget(budget), WithSpillDir(dir))
+	if m.memory.gzipBlocks != 2 {
+		t.Fatalf("Expected 2 blocks in memory, got %d", m.memory.gzipBlocks)
+	}
+	// The second stream uses the pooled encoder
+	for range 2 {
+		var buf bytes.Buffer
+		w := m.Writer(&buf)
+		if _, ok := w.(*writer).enc.(*spillStage); !ok {
+			t.Fatalf("Expected a spill stage, got %T", w.(*writer).enc)
+		}
+		half := len(data) / 2
+		if _, err := w.Write(data[:half]); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		if err := w.(interface{ Flush() error }).Flush(); err != nil {
+			t.Fatalf("Failed to flush: %v", err)
+		}
+		if _, err := w.Write(data[half:]); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+		if err := w.(io.Closer).Close(); err != nil {
+			t.Fatalf("Failed to close: %v", err)
+		}
+
+		zr, err := stdgzip.NewReader(&buf)
+		if err != nil {
+			t.Fatalf("Failed to open the gzip stream: %v", err)
+		}
+		if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("Failed to read with the stdlib: %v", err)
+		}
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("Expected the spill files to be removed, found %d", len(entries))
+	}
+}
+
+func TestWithSpillDir_FitsBudget(t *testing.T) {
+	m := New(Gzip, WithParallelGzip(64<<10, 2), WithMemoryBudget(8<<20), WithSpillDir(""))
+	if _, ok := m.Writer(io.Discard).(*writer).enc.(*pgzipWriteCloser); !ok {
+		t.Fatal("Expected no spill stage when the blocks fit")
+	}
+}
+
+type failingWriter struct{}
+
+var errSinkFull = errors.New("sink full")
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errSinkFull }
+
+func TestWithSpillDir_EncoderError(t *testing.T) {
+	m := New(Gzip, WithParallelGzip(64<<10, 8), WithMemoryBudget(1<<20+320<<10), WithSpillDir(t.TempDir()))
+	w := m.Writer(failingWriter{})
+	w.Write(bytes.Repeat([]byte("x"), 1<<20))
+	if err := w.(io.Closer).Close(); !errors.Is(err, errSinkFull) {
+		t.Fatalf("Expected the sink error, got %v", err)
+	}
+}
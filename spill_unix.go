@@ -0,0 +1,38 @@
+//go:build unix
+
+package compression
+
+import (
+	"os"
+	"runtime"
+)
+
+// createSpillFile creates an anonymous temporary file in dir, which is
+// unlinked right away, so the space is freed even if the process dies
+func createSpillFile(dir string) (*os.File, error) {
+	if dir == "" {
+		dir = defaultSpillDir()
+	}
+	f, err := os.CreateTemp(dir, "hybridbuffer-spill-*")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Remove(f.Name()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+func releaseSpillFile(f *os.File) {
+	f.Close()
+}
+
+// defaultSpillDir avoids /tmp on Linux, which is often a tmpfs counting
+// against memory
+func defaultSpillDir() string {
+	if runtime.GOOS != "linux" || os.Getenv("TMPDIR") != "" {
+		return os.TempDir()
+	}
+	return "/var/tmp"
+}
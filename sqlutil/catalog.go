@@ -0,0 +1,128 @@
+package sqlutil
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"schneider.vip/hybridbuffer/middleware/compression"
+)
+
+// catalogColumns are the columns of a catalog table in CatalogEntry order
+const catalogColumns = "id, algorithm, level, tag, uncompressed, compressed, checksum, dictionary_id, location, created"
+
+// Catalog is a compression.CatalogStore keeping entries in a database table.
+// The statements use ? placeholders as SQLite and MySQL do. Creation times
+// are stored as Unix nanoseconds.
+type Catalog struct {
+	db    *sql.DB
+	table string
+}
+
+// NewCatalog returns a catalog stored in table of db, see CreateTable
+func NewCatalog(db *sql.DB, table string) *Catalog {
+	return &Catalog{db: db, table: table}
+}
+
+// CreateTable creates the catalog table unless it exists
+func (c *Catalog) CreateTable(ctx context.Context) error {
+	_, err := c.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+c.table+` (
+	id TEXT PRIMARY KEY,
+	algorithm TEXT NOT NULL,
+	level TEXT NOT NULL,
+	tag TEXT NOT NULL,
+	uncompressed INTEGER NOT NULL,
+	compressed INTEGER NOT NULL,
+	checksum INTEGER NOT NULL,
+	dictionary_id INTEGER NOT NULL,
+	location TEXT NOT NULL,
+	created INTEGER NOT NULL
+)`)
+	return err
+}
+
+func (c *Catalog) Record(ctx context.Context, e compression.CatalogEntry) error {
+	_, err := c.db.ExecContext(ctx,
+		"INSERT INTO "+c.table+" ("+catalogColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		e.ID, e.Algorithm.String(), e.Level.String(), e.Tag, e.Uncompressed, e.Compressed,
+		int64(e.Checksum), int64(e.DictionaryID), e.Location, e.Created.UnixNano())
+	return err
+}
+
+func (c *Catalog) Lookup(ctx context.Context, id string) (compression.CatalogEntry, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT "+catalogColumns+" FROM "+c.table+" WHERE id = ?", id)
+	if err != nil {
+		return compression.CatalogEntry{}, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return compression.CatalogEntry{}, err
+		}
+		return compression.CatalogEntry{}, fmt.Errorf("%w: %s", compression.ErrNotCataloged, id)
+	}
+	return scanEntry(rows)
+}
+
+// Query selects by tag and creation time in the database and applies the
+// location prefix and the limit to the result
+func (c *Catalog) Query(ctx context.Context, q compression.CatalogQuery) ([]compression.CatalogEntry, error) {
+	var where []string
+	var args []any
+	if q.Tag != "" {
+		where = append(where, "tag = ?")
+		args = append(args, q.Tag)
+	}
+	if !q.Since.IsZero() {
+		where = append(where, "created >= ?")
+		args = append(args, q.Since.UnixNano())
+	}
+	if !q.Until.IsZero() {
+		where = append(where, "created < ?")
+		args = append(args, q.Until.UnixNano())
+	}
+	query := "SELECT " + catalogColumns + " FROM " + c.table
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	rows, err := c.db.QueryContext(ctx, query+" ORDER BY created", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []compression.CatalogEntry
+	for rows.Next() && (q.Limit <= 0 || len(entries) < q.Limit) {
+		e, err := scanEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		if q.Match(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, rows.Err()
+}
+
+func scanEntry(rows *sql.Rows) (compression.CatalogEntry, error) {
+	var e compression.CatalogEntry
+	var algorithm, level string
+	var checksum, dictionaryID, created int64
+	err := rows.Scan(&e.ID, &algorithm, &level, &e.Tag, &e.Uncompressed, &e.Compressed,
+		&checksum, &dictionaryID, &e.Location, &created)
+	if err != nil {
+		return e, err
+	}
+	e.Checksum, e.DictionaryID = uint32(checksum), uint32(dictionaryID)
+	e.Created = time.Unix(0, created)
+	e.Algorithm, err = compression.ParseAlgorithm(algorithm)
+	if err == nil {
+		e.Level, err = compression.ParseLevel(level)
+	}
+	if err != nil {
+		return e, fmt.Errorf("sqlutil: catalog entry %s: %w", e.ID, err)
+	}
+	return e, nil
+}
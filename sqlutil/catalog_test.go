@@ -0,0 +1,126 @@
+package sqlutil
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"schneider.vip/hybridbuffer/middleware/compression"
+)
+
+// memDriver keeps inserted rows in memory. Queries return all rows, or the
+// rows matching the first argument for "WHERE id = ?", which is enough for
+// Catalog since it filters query results again.
+type memDriver struct {
+	mu   sync.Mutex
+	rows [][]driver.Value
+}
+
+func (d *memDriver) Open(string) (driver.Conn, error) { return memConn{d}, nil }
+
+type memConn struct{ d *memDriver }
+
+func (c memConn) Prepare(query string) (driver.Stmt, error) { return memStmt{c.d, query}, nil }
+func (memConn) Close() error                                { return nil }
+func (memConn) Begin() (driver.Tx, error)                   { return nil, errors.New("no transactions") }
+
+type memStmt struct {
+	d     *memDriver
+	query string
+}
+
+func (memStmt) Close() error  { return nil }
+func (memStmt) NumInput() int { return -1 }
+
+func (s memStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if strings.HasPrefix(s.query, "INSERT") {
+		s.d.mu.Lock()
+		s.d.rows = append(s.d.rows, args)
+		s.d.mu.Unlock()
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s memStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	var rows [][]driver.Value
+	for _, row := range s.d.rows {
+		if !strings.Contains(s.query, "WHERE id = ?") || row[0] == args[0] {
+			rows = append(rows, row)
+		}
+	}
+	return &memRows{rows: rows}, nil
+}
+
+type memRows struct{ rows [][]driver.Value }
+
+func (*memRows) Columns() []string {
+	return strings.Split(catalogColumns, ", ")
+}
+func (*memRows) Close() error { return nil }
+
+func (r *memRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestCatalog(t *testing.T) {
+	sql.Register("sqlutil-mem", &memDriver{})
+	db, err := sql.Open("sqlutil-mem", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	c := NewCatalog(db, "streams")
+	if err := c.CreateTable(ctx); err != nil {
+		t.Fatal(err)
+	}
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	want := compression.CatalogEntry{
+		ID: "abc", Algorithm: compression.Zstd, Level: compression.Best, Tag: "db",
+		Uncompressed: 1000, Compressed: 100, Checksum: 0xdeadbeef, DictionaryID: 7,
+		Location: "s3://bucket/abc", Created: created,
+	}
+	if err := c.Record(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Record(ctx, compression.CatalogEntry{ID: "def", Algorithm: compression.S2, Tag: "logs", Created: created}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := c.Lookup(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Created.Equal(want.Created) {
+		t.Errorf("created = %v, want %v", got.Created, want.Created)
+	}
+	got.Created = want.Created
+	if got != want {
+		t.Errorf("entry = %+v, want %+v", got, want)
+	}
+	if _, err := c.Lookup(ctx, "missing"); !errors.Is(err, compression.ErrNotCataloged) {
+		t.Errorf("expected ErrNotCataloged, got %v", err)
+	}
+
+	entries, err := c.Query(ctx, compression.CatalogQuery{Location: "s3://"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].ID != "abc" {
+		t.Errorf("query returned %+v", entries)
+	}
+}
@@ -0,0 +1,119 @@
+// Package sqlutil stores compressed values in database BLOB columns.
+//
+// Values are written in the framed container of the compression package,
+// whose header records the algorithm, so a column may hold values of
+// different codecs and the configured Middleware can be switched to another
+// algorithm without migrating existing rows. The Middleware must therefore
+// be created with compression.WithFrameSize.
+//
+// Stored values start with the byte 0xFF, which never starts UTF-8 text,
+// followed by the container. Values without this prefix, such as rows
+// written before compression was enabled, are scanned unchanged; binary
+// legacy rows must not start with 0xFF and the container magic.
+package sqlutil
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+
+	"schneider.vip/hybridbuffer/middleware/compression"
+)
+
+// valueHeader precedes the container of every stored value
+const valueHeader = 0xFF
+
+// ErrNotFramed is returned by New for a Middleware writing plain streams
+var ErrNotFramed = errors.New("sqlutil: middleware must use framing, see compression.WithFrameSize")
+
+// Codec converts column values with a Middleware
+type Codec struct {
+	m *compression.Middleware
+}
+
+// New creates a Codec compressing with m, which must use framing
+func New(m *compression.Middleware) (*Codec, error) {
+	if m.FrameSize() <= 0 {
+		return nil, ErrNotFramed
+	}
+	return &Codec{m: m}, nil
+}
+
+// Value returns a driver.Valuer inserting p compressed. A nil p is stored
+// as NULL.
+func (c *Codec) Value(p []byte) driver.Valuer {
+	return value{c: c, p: p}
+}
+
+// Scanner returns a sql.Scanner decompressing the column into dst. NULL
+// scans to nil.
+func (c *Codec) Scanner(dst *[]byte) sql.Scanner {
+	return scanner{c: c, dst: dst}
+}
+
+// Compress returns p compressed as stored by Value
+func (c *Codec) Compress(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(valueHeader)
+	w := c.m.Writer(&buf)
+	if _, err := w.Write(p); err != nil {
+		return nil, err
+	}
+	if err := w.(io.Closer).Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress returns the content of a stored value
+func (c *Codec) Decompress(p []byte) ([]byte, error) {
+	if len(p) == 0 || p[0] != valueHeader || !bytes.HasPrefix(p[1:], []byte(compression.HeaderMagic)) {
+		return bytes.Clone(p), nil
+	}
+	r := c.m.Reader(bytes.NewReader(p[1:]))
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
+	}
+	return io.ReadAll(r)
+}
+
+type value struct {
+	c *Codec
+	p []byte
+}
+
+func (v value) Value() (driver.Value, error) {
+	if v.p == nil {
+		return nil, nil
+	}
+	return v.c.Compress(v.p)
+}
+
+type scanner struct {
+	c   *Codec
+	dst *[]byte
+}
+
+func (s scanner) Scan(src any) error {
+	var p []byte
+	switch src := src.(type) {
+	case nil:
+		*s.dst = nil
+		return nil
+	case []byte:
+		p = src
+	case string:
+		p = []byte(src)
+	default:
+		return fmt.Errorf("sqlutil: cannot scan %T into a compressed value", src)
+	}
+	out, err := s.c.Decompress(p)
+	if err != nil {
+		return err
+	}
+	*s.dst = out
+	return nil
+}
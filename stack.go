@@ -0,0 +1,249 @@
+package compression
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"schneider.vip/hybridbuffer/middleware"
+)
+
+// ErrStackMismatch is returned by readers of a Stack whose stream was written
+// with other algorithms than the stack is configured with
+var ErrStackMismatch = errors.New("compression: codec stack mismatch")
+
+// Stack header, preceding the stream of the outermost middleware:
+//
+//	offset  size  field
+//	0       4     magic "HBCS" (48 42 43 53)
+//	4       1     stack version, 2
+//	5       1     number of layers n
+//	6       n     algorithms of the layers, innermost first
+//
+// Version 1 headers hold the algorithms of two layers without their number.
+const (
+	stackMagic     = "HBCS"
+	stackVersion   = 2
+	stackVersionV1 = 1
+)
+
+// Stacked is a middleware compressing with stacked codecs, see Stack
+type Stacked struct {
+	layers []*Middleware
+}
+
+// Ensure Stacked implements middleware.Middleware interface
+var _ middleware.Middleware = (*Stacked)(nil)
+
+// Stack returns a middleware compressing with the first layer and
+// compressing the output again with each following layer, e.g. a fast S2
+// pass removing long repeats of scientific formats followed by zstd. The
+// algorithms of all layers are recorded in a small header before the
+// stream, so readers unwind the codecs in the right order and fail with
+// ErrStackMismatch for streams of another stack instead of returning
+// garbage; ReadStackHeader reports them. Options such as dictionaries or
+// framing apply to the codec of the middleware they are set on, and each
+// middleware keeps its own stats. Stacks need at least one layer and at
+// most 255.
+func Stack(layers ...*Middleware) *Stacked {
+	return &Stacked{layers: layers}
+}
+
+// algorithms returns the algorithms of the layers, innermost first
+func (s *Stacked) algorithms() []Algorithm {
+	algorithms := make([]Algorithm, len(s.layers))
+	for i, m := range s.layers {
+		algorithms[i] = m.algorithm
+	}
+	return algorithms
+}
+
+// check fails for stacks without layers or with more than the header holds
+func (s *Stacked) check() error {
+	if len(s.layers) == 0 || len(s.layers) > 255 {
+		return fmt.Errorf("compression: stack of %d layers", len(s.layers))
+	}
+	return nil
+}
+
+// Writer wraps w, compressing with the layers from the innermost to the
+// outermost
+func (s *Stacked) Writer(w io.Writer) io.Writer {
+	if err := s.check(); err != nil {
+		return &errWriter{err}
+	}
+	header := append([]byte(stackMagic), stackVersion, byte(len(s.layers)))
+	for _, a := range s.algorithms() {
+		header = append(header, byte(a))
+	}
+	sw := &stackWriter{writers: make([]io.Writer, len(s.layers)), header: &stackHeaderWriter{w: w, header: header}}
+	var next io.Writer = sw.header
+	for i := len(s.layers) - 1; i >= 0; i-- {
+		next = s.layers[i].Writer(next)
+		sw.writers[i] = next
+	}
+	return sw
+}
+
+// Reader wraps r, decompressing with the layers from the outermost to the
+// innermost
+func (s *Stacked) Reader(r io.Reader) io.Reader {
+	if err := s.check(); err != nil {
+		return &errReader{err}
+	}
+	return &stackReader{s: s, r: r}
+}
+
+// ReadStackHeader reads the header of a stream written by a Stack from r and
+// returns the algorithms of its layers, innermost first
+func ReadStackHeader(r io.Reader) ([]Algorithm, error) {
+	var p [6]byte
+	if _, err := io.ReadFull(r, p[:]); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("%w: stack header cut short", io.ErrUnexpectedEOF)
+	} else if err != nil {
+		return nil, err
+	}
+	if string(p[:len(stackMagic)]) != stackMagic {
+		return nil, fmt.Errorf("%w: bad stack magic", ErrCorruptStream)
+	}
+	var layers []byte
+	switch p[4] {
+	case stackVersionV1:
+		layers = []byte{p[5], 0}
+		if _, err := io.ReadFull(r, layers[1:]); err != nil {
+			return nil, fmt.Errorf("%w: stack header cut short", io.ErrUnexpectedEOF)
+		}
+	case stackVersion:
+		if p[5] == 0 {
+			return nil, fmt.Errorf("%w: stack without layers", ErrCorruptStream)
+		}
+		layers = make([]byte, p[5])
+		if _, err := io.ReadFull(r, layers); err != nil {
+			return nil, fmt.Errorf("%w: stack header cut short", io.ErrUnexpectedEOF)
+		}
+	default:
+		return nil, fmt.Errorf("%w: stack version %d", ErrUnsupportedVersion, p[4])
+	}
+	algorithms := make([]Algorithm, len(layers))
+	for i, a := range layers {
+		algorithms[i] = Algorithm(a)
+	}
+	return algorithms, nil
+}
+
+// stackHeaderWriter writes the stack header before the first bytes of the
+// outer stream
+type stackHeaderWriter struct {
+	w      io.Writer
+	header []byte
+}
+
+func (h *stackHeaderWriter) Write(p []byte) (int, error) {
+	if err := h.writeHeader(); err != nil {
+		return 0, err
+	}
+	return h.w.Write(p)
+}
+
+// writeHeader writes the header unless it was written
+func (h *stackHeaderWriter) writeHeader() error {
+	if h.header == nil {
+		return nil
+	}
+	if _, err := h.w.Write(h.header); err != nil {
+		return err
+	}
+	h.header = nil
+	return nil
+}
+
+// stackWriter is the writer of a Stack. writers holds the writers of the
+// layers, innermost first.
+type stackWriter struct {
+	writers []io.Writer
+	header  *stackHeaderWriter
+}
+
+func (w *stackWriter) Write(p []byte) (int, error) {
+	return w.writers[0].Write(p)
+}
+
+// Flush flushes the layers from the innermost to the outermost
+func (w *stackWriter) Flush() error {
+	for _, c := range w.writers {
+		if f, ok := c.(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close finishes the streams from the innermost to the outermost. The
+// header is written even if the outermost codec wrote nothing.
+func (w *stackWriter) Close() error {
+	var err error
+	for _, c := range w.writers {
+		if cerr := c.(io.Closer).Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err == nil {
+		err = w.header.writeHeader()
+	}
+	return err
+}
+
+// stackReader is the reader of a Stack, reading the header on first use.
+// readers holds the readers of the layers, innermost first.
+type stackReader struct {
+	s       *Stacked
+	r       io.Reader
+	readers []io.Reader
+	err     error
+}
+
+// init reads the header and opens the codecs once
+func (r *stackReader) init() error {
+	if r.readers != nil || r.err != nil {
+		return r.err
+	}
+	algorithms, err := ReadStackHeader(r.r)
+	if err != nil {
+		r.err = err
+		return err
+	}
+	if want := r.s.algorithms(); !slices.Equal(algorithms, want) {
+		r.err = fmt.Errorf("%w: stream stacks %v, reader %v", ErrStackMismatch, algorithms, want)
+		return r.err
+	}
+	r.readers = make([]io.Reader, len(r.s.layers))
+	next := r.r
+	for i := len(r.s.layers) - 1; i >= 0; i-- {
+		next = r.s.layers[i].Reader(next)
+		r.readers[i] = next
+	}
+	return nil
+}
+
+func (r *stackReader) Read(p []byte) (int, error) {
+	if err := r.init(); err != nil {
+		return 0, err
+	}
+	return r.readers[0].Read(p)
+}
+
+// Close closes the readers from the innermost to the outermost
+func (r *stackReader) Close() error {
+	var err error
+	for _, c := range r.readers {
+		if c, ok := c.(io.Closer); ok {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestStack(t *testing.T) {
+	data := bytes.Repeat([]byte("temperature=21.5 humidity=40 pressure=1013 "), 2000)
+	s := Stack(New(S2), New(Gzip, WithLevel(Best)))
+	var buf bytes.Buffer
+	w := s.Writer(&buf)
+	if _, err := w.Write(data[:len(data)/2]); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if err := w.(interface{ Flush() error }).Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if _, err := w.Write(data[len(data)/2:]); err != nil {
+		t.Fatalf("Failed to write: %v", err)
+	}
+	if err := w.(io.Closer).Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	stream := buf.Bytes()
+
+	layers, err := ReadStackHeader(bytes.NewReader(stream))
+	if err != nil || len(layers) != 2 || layers[0] != S2 || layers[1] != Gzip {
+		t.Fatalf("Expected an s2 in gzip stack, got %v: %v", layers, err)
+	}
+	r := s.Reader(bytes.NewReader(stream))
+	got, err := io.ReadAll(r)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if err := r.(io.Closer).Close(); err != nil {
+		t.Fatalf("Failed to close the reader: %v", err)
+	}
+
+	// Readers of another stack fail instead of returning garbage
+	if _, err := io.ReadAll(Stack(New(Snappy), New(Gzip)).Reader(bytes.NewReader(stream))); !errors.Is(err, ErrStackMismatch) {
+		t.Fatalf("Expected ErrStackMismatch, got %v", err)
+	}
+}
+
+func TestStack_Layers(t *testing.T) {
+	data := bytes.Repeat([]byte("temperature=21.5 humidity=40 pressure=1013 "), 2000)
+	s := Stack(New(S2), New(Snappy), New(Gzip))
+	var buf bytes.Buffer
+	w := s.Writer(&buf)
+	w.Write(data)
+	if err := w.(io.Closer).Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	stream := buf.Bytes()
+	if layers, err := ReadStackHeader(bytes.NewReader(stream)); err != nil || len(layers) != 3 || layers[1] != Snappy {
+		t.Fatalf("Expected all three layers in the header, got %v: %v", layers, err)
+	}
+	if got, err := io.ReadAll(s.Reader(bytes.NewReader(stream))); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to read: %v", err)
+	}
+	if _, err := io.ReadAll(Stack(New(S2), New(Gzip)).Reader(bytes.NewReader(stream))); !errors.Is(err, ErrStackMismatch) {
+		t.Fatalf("Expected ErrStackMismatch for a missing layer, got %v", err)
+	}
+
+	// Streams of version 1 headers remain readable
+	var v1 bytes.Buffer
+	v1.WriteString("HBCS\x01")
+	v1.Write([]byte{byte(S2), byte(Gzip)})
+	v1.Write(writeContainer(t, New(Gzip), writeContainer(t, New(S2), data)))
+	if got, err := io.ReadAll(Stack(New(S2), New(Gzip)).Reader(&v1)); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to read a version 1 stream: %v", err)
+	}
+
+	if _, err := Stack().Writer(io.Discard).Write(data); err == nil {
+		t.Fatal("Expected an error for a stack without layers")
+	}
+}
+
+func TestStack_Empty(t *testing.T) {
+	s := Stack(New(S2), New(Gzip))
+	var buf bytes.Buffer
+	if err := s.Writer(&buf).(io.Closer).Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte(stackMagic)) {
+		t.Fatalf("Expected the stack header, got %q", buf.Bytes())
+	}
+	if got, err := io.ReadAll(s.Reader(&buf)); err != nil || len(got) != 0 {
+		t.Fatalf("Expected an empty stream, got %d bytes: %v", len(got), err)
+	}
+}
+
+func TestReadStackHeader_Errors(t *testing.T) {
+	for name, tc := range map[string]struct {
+		header []byte
+		want   error
+	}{
+		"short":     {[]byte("HBC"), io.ErrUnexpectedEOF},
+		"magic":     {[]byte("HBCF\x02\x02\x02\x00"), ErrCorruptStream},
+		"version":   {[]byte("HBCS\x03\x02\x02\x00"), ErrUnsupportedVersion},
+		"no layers": {[]byte("HBCS\x02\x00"), ErrCorruptStream},
+		"layers":    {[]byte("HBCS\x02\x03\x02\x00"), io.ErrUnexpectedEOF},
+	} {
+		if _, err := ReadStackHeader(bytes.NewReader(tc.header)); !errors.Is(err, tc.want) {
+			t.Fatalf("%s: expected %v, got %v", name, tc.want, err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package compression
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// State is the runtime state of a middleware as exported by ExportState
+type State struct {
+	Algorithm Algorithm `json:"algorithm"`
+	// Tuner is the state of the tuner set with WithAutoTune, if any
+	Tuner *TunerState `json:"tuner,omitempty"`
+	// Dictionary is the zstd dictionary in use, if any
+	Dictionary []byte `json:"dictionary,omitempty"`
+	// Dictionaries are the replaced dictionaries readers still accept, most
+	// recent first
+	Dictionaries [][]byte `json:"dictionaries,omitempty"`
+	// Stats are the stats at the time of the export
+	Stats Stats `json:"stats"`
+}
+
+// ExportState returns the runtime state of the middleware as a JSON blob:
+// the tuning decisions, the dictionaries and the stats. A new middleware, e.g.
+// after a blue/green deploy, continues from it with ImportState instead of
+// starting cold.
+func (m *Middleware) ExportState() ([]byte, error) {
+	state := State{
+		Algorithm:  m.algorithm,
+		Dictionary: m.encoderDictionary(),
+		Stats:      m.Stats(),
+	}
+	if dicts := m.decoderDictionaries(); len(dicts) > 1 {
+		state.Dictionaries = dicts[1:]
+	}
+	if m.tuner != nil {
+		tuner := m.tuner.snapshot()
+		state.Tuner = &tuner
+	}
+	return json.Marshal(state)
+}
+
+// ImportState restores a state returned by ExportState. It must be called
+// before the middleware is used. The tuner state is loaded into the tuner
+// set with WithAutoTune, the dictionaries are used if none is configured, and
+// the exported stats are added to Stats as a baseline; active writers are
+// not carried over, and the baseline does not count against the quota.
+func (m *Middleware) ImportState(blob []byte) error {
+	var state State
+	if err := json.Unmarshal(blob, &state); err != nil {
+		return err
+	}
+	if state.Algorithm != m.algorithm {
+		return fmt.Errorf("compression: state of a %s middleware imported into %s", state.Algorithm, m.algorithm)
+	}
+	if state.Dictionary != nil && m.dictionary == nil {
+		dicts := append([][]byte{state.Dictionary}, state.Dictionaries...)
+		for _, dict := range dicts {
+			if err := validateZstdDictionary(dict); err != nil {
+				return fmt.Errorf("%w: %v", ErrInvalidDictionary, err)
+			}
+		}
+		m.dictionary = state.Dictionary
+		if len(dicts) > 1 {
+			m.swapped.Store(&dicts)
+		}
+	}
+	if state.Tuner != nil && m.tuner != nil {
+		m.tuner.setState(*state.Tuner)
+	}
+
+	baseline := Stats{}.plus(state.Stats)
+	if b := m.baseline.Load(); b != nil {
+		baseline = baseline.plus(*b)
+	}
+	m.baseline.Store(&baseline)
+	return nil
+}
@@ -0,0 +1,127 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestExportImportState(t *testing.T) {
+	requireZstd(t)
+	dict := testZstdDictionary(t, 11)
+	tuner := NewTuner()
+	m := New(Zstd, WithZstdDictionary(dict), WithAutoTune(tuner), WithLatencyTracking())
+	for i := 0; i < 20; i++ {
+		var buf bytes.Buffer
+		w := m.Writer(&buf)
+		w.Write(bytes.Repeat([]byte(`{"state":"exported"}`), 500))
+		w.(io.Closer).Close()
+	}
+	blob, err := m.ExportState()
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	restoredTuner := NewTuner()
+	restored := New(Zstd, WithAutoTune(restoredTuner), WithLatencyTracking())
+	if err := restored.ImportState(blob); err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if !bytes.Equal(restored.dictionary, dict) {
+		t.Fatal("Expected the dictionary to be restored")
+	}
+	if restoredTuner.Level() != tuner.Level() {
+		t.Fatalf("Expected tuner level %v, got %v", tuner.Level(), restoredTuner.Level())
+	}
+	before, after := m.Stats(), restored.Stats()
+	if after.Writers != before.Writers || after.BytesCompressed != before.BytesCompressed || after.WriteLatency.Count != before.WriteLatency.Count {
+		t.Fatalf("Expected stats baseline %+v, got %+v", before, after)
+	}
+
+	// The restored dictionary decodes streams of the original middleware
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	w.Write([]byte(`{"state":"shared"}`))
+	w.(io.Closer).Close()
+	if got, err := io.ReadAll(restored.Reader(&buf)); err != nil || string(got) != `{"state":"shared"}` {
+		t.Fatalf("Round trip with restored dictionary failed: %v", err)
+	}
+
+	if err := New(S2).ImportState(blob); err == nil {
+		t.Fatal("Expected error importing the state of another algorithm")
+	}
+}
+
+func TestImportState_Quota(t *testing.T) {
+	m := New(S2)
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	w.Write(make([]byte, 1000))
+	w.(io.Closer).Close()
+	blob, err := m.ExportState()
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	// The restored history does not consume the quota of the new middleware
+	restored := New(S2, WithQuota(Quota{MaxBytes: 1000}))
+	if err := restored.ImportState(blob); err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	w = restored.Writer(&buf)
+	if _, err := w.Write(make([]byte, 1000)); err != nil {
+		t.Fatalf("Expected the write to fit the quota: %v", err)
+	}
+	w.(io.Closer).Close()
+	if got := restored.Stats().BytesWritten; got != 2000 {
+		t.Fatalf("Expected 2000 bytes written including the baseline, got %d", got)
+	}
+}
+
+func TestExportState_Dictionaries(t *testing.T) {
+	requireZstd(t)
+	old, dict := testZstdDictionary(t, 12), testZstdDictionary(t, 13)
+	m := New(Zstd, WithZstdDictionary(old))
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	w.Write([]byte(`{"state":"old"}`))
+	w.(io.Closer).Close()
+	if err := m.SetDictionary(dict); err != nil {
+		t.Fatalf("Failed to set dictionary: %v", err)
+	}
+	blob, err := m.ExportState()
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	restored := New(Zstd)
+	if err := restored.ImportState(blob); err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if !bytes.Equal(restored.encoderDictionary(), dict) {
+		t.Fatal("Expected the current dictionary to be restored")
+	}
+	// Streams written with the replaced dictionary still decode
+	if got, err := io.ReadAll(restored.Reader(&buf)); err != nil || string(got) != `{"state":"old"}` {
+		t.Fatalf("Round trip with replaced dictionary failed: %v", err)
+	}
+}
+
+func TestExportState_ConcurrentTuning(t *testing.T) {
+	m := New(S2, WithAutoTune(NewTuner()))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			w := m.Writer(io.Discard)
+			w.Write(bytes.Repeat([]byte("tuned"), 100))
+			w.(io.Closer).Close()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := m.ExportState(); err != nil {
+			t.Fatalf("Failed to export: %v", err)
+		}
+	}
+	<-done
+}
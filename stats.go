@@ -0,0 +1,255 @@
+package compression
+
+import (
+	"io"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats is a snapshot of the counters of a Middleware
+type Stats struct {
+	// Writers and Readers count the streams created
+	Writers int64
+	Readers int64
+	// ActiveWriters counts writers that have not been closed yet
+	ActiveWriters int64
+	// BytesWritten is the uncompressed input accepted by writers
+	BytesWritten int64
+	// BytesCompressed is the compressed output produced by writers
+	BytesCompressed int64
+	// BytesRead is the compressed input consumed by readers
+	BytesRead int64
+	// BytesDecompressed is the uncompressed output returned by readers
+	BytesDecompressed int64
+	// WriteLatency and ReadLatency are only recorded with WithLatencyTracking
+	WriteLatency Histogram
+	ReadLatency  Histogram
+	// Corruptions counts readers that detected corrupt data, see
+	// WithCorruptionCallback
+	Corruptions int64
+	// WriteAllocs and ReadAllocs are only recorded with WithAllocTracking
+	WriteAllocs AllocStats
+	ReadAllocs  AllocStats
+	// EncoderPoolHits counts writers that reused a pooled encoder,
+	// EncoderPoolMisses writers at the configured level that created one
+	EncoderPoolHits   int64
+	EncoderPoolMisses int64
+	// LockWaits counts acquisitions of locks shared between streams, those
+	// of a Tuner and of the global rate limit, that had to wait for another
+	// stream
+	LockWaits int64
+	// LaggingFrames counts frames stored uncompressed by WithStoredUnderLag
+	LaggingFrames int64
+}
+
+// Ratio returns the compressed size relative to the uncompressed size of
+// everything written so far, or 0 if nothing was written
+func (s Stats) Ratio() float64 {
+	if s.BytesWritten == 0 {
+		return 0
+	}
+	return float64(s.BytesCompressed) / float64(s.BytesWritten)
+}
+
+type stats struct {
+	writers           counter
+	readers           counter
+	activeWriters     gauge
+	bytesWritten      counter
+	bytesCompressed   counter
+	bytesRead         counter
+	bytesDecompressed counter
+	corruptions       counter
+	writeLatency      latencyHistogram
+	readLatency       latencyHistogram
+	writeAllocs       allocCounter
+	readAllocs        allocCounter
+	poolHits          counter
+	poolMisses        counter
+	lockWaits         counter
+	laggingFrames     counter
+	// idleEncoders approximates the encoders in the pool, the pool may
+	// drop them on GC
+	idleEncoders counter
+}
+
+// counterStripes is the number of cache lines a counter is spread over, so
+// streams on different cores do not contend for one
+const counterStripes = 8
+
+// counter is an atomic counter that also counts into the counter of the
+// middleware tag, if any. Add picks a random stripe and never contends with
+// other cores; Load sums the stripes.
+type counter struct {
+	stripes [counterStripes]stripe
+	tag     *counter
+}
+
+// stripe is an atomic counter padded to a cache line
+type stripe struct {
+	atomic.Int64
+	_ [56]byte
+}
+
+func (c *counter) Add(n int64) {
+	if c.tag != nil {
+		c.tag.Add(n)
+	}
+	c.stripes[rand.Uint32()%counterStripes].Add(n)
+}
+
+func (c *counter) Load() int64 {
+	var n int64
+	for i := range c.stripes {
+		n += c.stripes[i].Load()
+	}
+	return n
+}
+
+// gauge is an atomic value that also counts into the gauge of the middleware
+// tag, if any. Unlike a counter it returns the new value, for limits.
+type gauge struct {
+	atomic.Int64
+	tag *gauge
+}
+
+func (g *gauge) Add(n int64) int64 {
+	if g.tag != nil {
+		g.tag.Add(n)
+	}
+	return g.Int64.Add(n)
+}
+
+// lockCounted locks mu, counting into waits, which may be nil, if another
+// goroutine holds it
+func lockCounted(mu *sync.Mutex, waits *counter) {
+	if mu.TryLock() {
+		return
+	}
+	if waits != nil {
+		waits.Add(1)
+	}
+	mu.Lock()
+}
+
+// linkTag makes all counters of s count into tag as well
+func (s *stats) linkTag(tag *stats) {
+	s.writers.tag = &tag.writers
+	s.readers.tag = &tag.readers
+	s.activeWriters.tag = &tag.activeWriters
+	s.bytesWritten.tag = &tag.bytesWritten
+	s.bytesCompressed.tag = &tag.bytesCompressed
+	s.bytesRead.tag = &tag.bytesRead
+	s.bytesDecompressed.tag = &tag.bytesDecompressed
+	s.corruptions.tag = &tag.corruptions
+	s.poolHits.tag = &tag.poolHits
+	s.poolMisses.tag = &tag.poolMisses
+	s.lockWaits.tag = &tag.lockWaits
+	s.laggingFrames.tag = &tag.laggingFrames
+	s.writeLatency.tag = &tag.writeLatency
+	s.readLatency.tag = &tag.readLatency
+	s.writeAllocs.linkTag(&tag.writeAllocs)
+	s.readAllocs.linkTag(&tag.readAllocs)
+}
+
+func (s *stats) snapshot() Stats {
+	return Stats{
+		Writers:           s.writers.Load(),
+		Readers:           s.readers.Load(),
+		ActiveWriters:     s.activeWriters.Load(),
+		BytesWritten:      s.bytesWritten.Load(),
+		BytesCompressed:   s.bytesCompressed.Load(),
+		BytesRead:         s.bytesRead.Load(),
+		BytesDecompressed: s.bytesDecompressed.Load(),
+		Corruptions:       s.corruptions.Load(),
+		WriteLatency:      s.writeLatency.snapshot(),
+		ReadLatency:       s.readLatency.snapshot(),
+		WriteAllocs:       s.writeAllocs.snapshot(),
+		ReadAllocs:        s.readAllocs.snapshot(),
+		EncoderPoolHits:   s.poolHits.Load(),
+		EncoderPoolMisses: s.poolMisses.Load(),
+		LockWaits:         s.lockWaits.Load(),
+		LaggingFrames:     s.laggingFrames.Load(),
+	}
+}
+
+// tagStats holds the stats of every tag, see WithTag
+var tagStats sync.Map
+
+// WithTag assigns the middleware to a content class such as "logs" or
+// "images". The stats of all middleware with the same tag are aggregated in
+// StatsByTag, and metrics carry the tag as a label, to see which data
+// classes benefit from compression.
+func WithTag(tag string) Option {
+	return func(m *Middleware) {
+		m.tag = tag
+	}
+}
+
+// StatsByTag returns the aggregated stats of every tag in use
+func StatsByTag() map[string]Stats {
+	byTag := make(map[string]Stats)
+	tagStats.Range(func(tag, s any) bool {
+		byTag[tag.(string)] = s.(*stats).snapshot()
+		return true
+	})
+	return byTag
+}
+
+// Stats returns a snapshot of the middleware counters, including those
+// restored with ImportState
+func (m *Middleware) Stats() Stats {
+	s := m.stats.snapshot()
+	if b := m.baseline.Load(); b != nil {
+		s = s.plus(*b)
+	}
+	return s
+}
+
+// plus returns s with the cumulative counters of b added, as restored by
+// ImportState
+func (s Stats) plus(b Stats) Stats {
+	s.Writers += b.Writers
+	s.Readers += b.Readers
+	s.BytesWritten += b.BytesWritten
+	s.BytesCompressed += b.BytesCompressed
+	s.BytesRead += b.BytesRead
+	s.BytesDecompressed += b.BytesDecompressed
+	s.Corruptions += b.Corruptions
+	s.WriteLatency = s.WriteLatency.plus(b.WriteLatency)
+	s.ReadLatency = s.ReadLatency.plus(b.ReadLatency)
+	return s
+}
+
+type countingWriter struct {
+	w     io.Writer
+	n     *counter
+	total int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	c.total += int64(n)
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n *counter
+	// total and eof, set once r returned io.EOF, are read by other
+	// goroutines with WithReadahead
+	total atomic.Int64
+	eof   atomic.Bool
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	c.total.Add(int64(n))
+	if err == io.EOF {
+		c.eof.Store(true)
+	}
+	return n, err
+}
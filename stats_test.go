@@ -0,0 +1,77 @@
+package compression
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCounter_Concurrent(t *testing.T) {
+	var tag, c counter
+	c.tag = &tag
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Add(2)
+			}
+		}()
+	}
+	wg.Wait()
+	if c.Load() != 32000 || tag.Load() != 32000 {
+		t.Fatalf("Expected 32000, got %d and %d for the tag", c.Load(), tag.Load())
+	}
+}
+
+func TestStats_EncoderPool(t *testing.T) {
+	m := New(S2)
+	for i := 0; i < 3; i++ {
+		w := m.Writer(io.Discard)
+		w.Write([]byte("pooled"))
+		w.(io.Closer).Close()
+	}
+	s := m.Stats()
+	// The pool may drop encoders on GC, but the first writer creates one
+	if s.EncoderPoolMisses < 1 || s.EncoderPoolHits+s.EncoderPoolMisses != 3 {
+		t.Fatalf("Expected 3 writers to hit or miss the pool, got %d hits and %d misses", s.EncoderPoolHits, s.EncoderPoolMisses)
+	}
+}
+
+func TestLockCounted(t *testing.T) {
+	var mu sync.Mutex
+	var waits counter
+	lockCounted(&mu, &waits)
+	mu.Unlock()
+	if waits.Load() != 0 {
+		t.Fatalf("Expected no wait for a free lock, got %d", waits.Load())
+	}
+
+	mu.Lock()
+	done := make(chan struct{})
+	go func() {
+		lockCounted(&mu, &waits)
+		mu.Unlock()
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	mu.Unlock()
+	<-done
+	if waits.Load() != 1 {
+		t.Fatalf("Expected one wait for a held lock, got %d", waits.Load())
+	}
+}
+
+func BenchmarkStats_ParallelWrites(b *testing.B) {
+	m := New(None)
+	p := make([]byte, 64)
+	b.RunParallel(func(pb *testing.PB) {
+		w := m.Writer(io.Discard)
+		defer w.(io.Closer).Close()
+		for pb.Next() {
+			w.Write(p)
+		}
+	})
+}
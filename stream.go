@@ -0,0 +1,477 @@
+package compression
+
+import (
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// ErrClosed is returned when writing to a writer that has been closed
+var ErrClosed = errors.New("compression: writer closed")
+
+// encoder is a compressing writer that can be reused for another stream
+type encoder interface {
+	io.WriteCloser
+	Reset(io.Writer)
+}
+
+// writer is the io.Writer returned by Middleware.Writer. It accounts the
+// stream against the middleware stats and quota and returns the encoder to
+// the pool on Close.
+type writer struct {
+	m      *Middleware
+	enc    encoder
+	level  Level
+	out    *countingWriter
+	resume *resumeWriter
+	closed bool
+	index  []byte
+	// memory is the encoder memory reserved with SetGlobalLimits
+	memory int64
+	// dictGen is the dictionary generation of enc, see SetDictionary
+	dictGen uint64
+
+	// in and busy feed the tuner
+	in   int64
+	busy time.Duration
+
+	keepalive *keepalive
+
+	// probe collects the input sample of WithEntropyCheck until probed
+	probe  []byte
+	probed bool
+
+	arena *Arena
+
+	// catalog collects the entry of WithCatalog
+	catalog *catalogRecord
+
+	// bytes collects the bytes of WriteByte
+	bytes []byte
+	// err fails all calls once the writer lost data it accepted, see
+	// drainBytes, or its encoder panicked
+	err error
+
+	// hash digests the content, see WithContentHash
+	hash hash.Hash
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	return w.write(p, w.enc.Write)
+}
+
+// write passes p to the encoder with encode after the bytes of WriteByte
+func (w *writer) write(p []byte, encode func([]byte) (int, error)) (int, error) {
+	w.lock()
+	defer w.unlock()
+	if w.closed {
+		return 0, ErrClosed
+	}
+	if err := w.drainBytes(); err != nil {
+		return 0, err
+	}
+	return w.writeLocked(p, encode)
+}
+
+// writeLocked passes p to the encoder with encode, accounting it against the
+// stats and quota. The caller holds the lock.
+func (w *writer) writeLocked(p []byte, encode func([]byte) (int, error)) (int, error) {
+	if err := w.pending(); err != nil {
+		return 0, err
+	}
+	if err := w.m.reserve(len(p)); err != nil {
+		return 0, err
+	}
+	if w.m.trackLatency || w.m.tuner != nil {
+		start := time.Now()
+		defer func() {
+			d := time.Since(start)
+			w.busy += d
+			if w.m.trackLatency {
+				w.m.stats.writeLatency.observe(d)
+			}
+		}()
+	}
+	if w.m.entropyCheck {
+		w.probeEntropy(p)
+	}
+	if w.m.allocSample > 0 {
+		defer w.m.stats.writeAllocs.start(w.m.allocSample).stop()
+	}
+	var n int
+	err := w.guard(func() (err error) {
+		n, err = encode(p)
+		return err
+	})
+	w.m.unreserve(len(p) - n)
+	if w.hash != nil {
+		w.hash.Write(p[:n])
+	}
+	w.in += int64(n)
+	w.m.stats.bytesWritten.Add(int64(n))
+	if err == nil {
+		err = w.pending()
+	}
+	return n, err
+}
+
+// pending returns the sink error of WithResumableWrites until Retry
+// succeeded
+func (w *writer) pending() error {
+	if w.resume == nil {
+		return nil
+	}
+	return w.resume.err
+}
+
+// WithFlushCallback calls fn at every safe decode point of written streams:
+// after each frame of framed streams and after each Flush of other streams.
+// The offsets count the compressed and uncompressed bytes up to that point,
+// e.g. to build indexes or replication checkpoints. fn runs synchronously in
+// the writing goroutine.
+func WithFlushCallback(fn func(compressedOffset, uncompressedOffset int64)) Option {
+	return func(m *Middleware) {
+		m.flushCallback = fn
+	}
+}
+
+// Flush flushes pending compressed data to the underlying writer
+func (w *writer) Flush() error {
+	w.lock()
+	defer w.unlock()
+	return w.flush()
+}
+
+func (w *writer) flush() error {
+	if w.closed {
+		return ErrClosed
+	}
+	if err := w.drainBytes(); err != nil {
+		return err
+	}
+	f, ok := w.enc.(interface{ Flush() error })
+	if !ok {
+		return nil
+	}
+	if err := w.guard(f.Flush); err != nil {
+		return err
+	}
+	if err := w.pending(); err != nil {
+		return err
+	}
+	if _, framed := w.enc.(*framedWriter); !framed && w.m.flushCallback != nil {
+		// Framed writers report every frame themselves
+		w.m.flushCallback(w.out.total, w.in)
+	}
+	return nil
+}
+
+// Close completes the stream and returns the encoder to the pool. Calls
+// after the first do nothing and return nil, later writes fail with
+// ErrClosed.
+func (w *writer) Close() error {
+	return w.finish(w.closeEncoder, true)
+}
+
+// Aborter is implemented by writers returned from Middleware.Writer
+type Aborter interface {
+	// Abort ends the stream early, e.g. when a spill is cancelled. Framed
+	// streams end after the last complete frame and are flagged as aborted,
+	// so readers return all complete frames followed by ErrAborted. Other
+	// streams are closed with the data written so far.
+	Abort() error
+}
+
+func (w *writer) Abort() error {
+	return w.finish(func() error {
+		if f, ok := w.enc.(*framedWriter); ok {
+			return f.abort()
+		}
+		return w.closeEncoder()
+	}, false)
+}
+
+// finish ends the stream with end and releases the writer. Complete
+// streams are recorded in the catalog.
+func (w *writer) finish(end func() error, complete bool) error {
+	w.lock()
+	defer w.unlock()
+	if w.closed {
+		return nil
+	}
+	err := w.drainBytes()
+	w.closed = true
+	if w.keepalive != nil {
+		w.keepalive.timer.Stop()
+	}
+	start := time.Now()
+	if err == nil {
+		// A writer that lost data does not end the stream
+		err = w.guard(end)
+	}
+	if w.m.tuner != nil && err == nil {
+		w.m.tuner.observe(w.level, w.in, w.out.total, w.busy+time.Since(start), &w.m.stats.lockWaits)
+	}
+	w.m.release()
+	releaseGlobal(w.memory)
+	if w.arena != nil {
+		w.enc.(*framedWriter).useArena(nil)
+		w.m.arenas.Put(w.arena)
+		w.arena = nil
+	}
+	if err == nil {
+		w.m.putEncoder(w.level, w.enc, w.dictGen)
+		err = w.pending()
+	}
+	if err == nil && complete && w.catalog != nil {
+		err = w.record()
+	}
+	return err
+}
+
+func (w *writer) closeEncoder() error {
+	if sw, ok := w.enc.(*s2.Writer); ok && w.m.s2Index {
+		index, err := sw.CloseIndex()
+		w.index = index
+		return err
+	}
+	return w.enc.Close()
+}
+
+// reader is the io.Reader returned by Middleware.Reader
+type reader struct {
+	m      *Middleware
+	dec    io.Reader
+	unread []byte
+	// hash digests the content, see WithExpectedContentHash
+	hash  hash.Hash
+	arena *Arena
+	// global is set while r holds a stream of SetGlobalLimits
+	global bool
+	// off is the uncompressed offset, corrupt is set once corruption was
+	// reported
+	off     int64
+	corrupt bool
+	// in counts the compressed bytes read from the source
+	in *countingReader
+
+	// ahead holds the bytes decoded by ReadByte and ReadRune in aheadBuf,
+	// aheadErr the error that ended decoding ahead
+	ahead    []byte
+	aheadBuf []byte
+	aheadErr error
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(r.ahead) > 0 {
+		n := copy(p, r.ahead)
+		r.ahead = r.ahead[n:]
+		return n, nil
+	}
+	if r.aheadErr != nil {
+		return 0, r.aheadErr
+	}
+	return r.read(p)
+}
+
+func (r *reader) read(p []byte) (int, error) {
+	if len(r.unread) > 0 {
+		n := copy(p, r.unread)
+		r.unread = r.unread[n:]
+		r.off += int64(n)
+		if r.hash != nil {
+			r.hash.Write(p[:n])
+		}
+		return n, nil
+	}
+	if r.m.trackLatency {
+		start := time.Now()
+		defer func() { r.m.stats.readLatency.observe(time.Since(start)) }()
+	}
+	if r.m.allocSample > 0 {
+		defer r.m.stats.readAllocs.start(r.m.allocSample).stop()
+	}
+	var n int
+	err := r.m.guard(func() (err error) {
+		n, err = r.dec.Read(p)
+		return err
+	})
+	r.m.stats.bytesDecompressed.Add(int64(n))
+	if terr := throttleGlobal(int64(n), &r.m.stats.lockWaits, r.deadline()); err == nil {
+		err = terr
+	}
+	r.off += int64(n)
+	if r.hash != nil {
+		if herr := r.sum(p[:n], err == io.EOF); herr != nil {
+			err = herr
+		}
+	}
+	if err != nil {
+		r.releaseGlobal()
+		err = r.classify(err)
+		r.corrupted(err)
+		err = r.tolerate(err)
+	}
+	return n, err
+}
+
+func (r *reader) WriteTo(w io.Writer) (int64, error) {
+	// WriteTo ends with the end of the stream or an error
+	defer r.releaseGlobal()
+	total, done, err := r.writeAhead(w)
+	if done {
+		return total, err
+	}
+	if len(r.unread) > 0 {
+		n, err := w.Write(r.unread)
+		if r.hash != nil {
+			r.hash.Write(r.unread[:n])
+		}
+		r.unread = r.unread[n:]
+		r.off += int64(n)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+	}
+	if dst, src := fileOf(w), r.passthroughFile(); dst != nil && src != nil && r.hash == nil && !throttled() {
+		n, err := dst.ReadFrom(src)
+		r.m.stats.bytesRead.Add(n)
+		r.m.stats.bytesDecompressed.Add(n)
+		return total + n, err
+	}
+	var sparse *sparseWriter
+	if f, ok := w.(*os.File); ok && r.m.sparseFiles {
+		sparse = &sparseWriter{f: f}
+		w = sparse
+	}
+	if r.hash != nil {
+		w = io.MultiWriter(w, r.hash)
+	}
+	if throttled() {
+		w = &throttleWriter{w: w, waits: &r.m.stats.lockWaits, deadline: r.deadline()}
+	}
+	var n int64
+	dst := &destinationWriter{w: w}
+	err = r.m.guard(func() (err error) {
+		n, err = io.Copy(dst, r.dec)
+		return err
+	})
+	dst.repanic()
+	r.m.stats.bytesDecompressed.Add(n)
+	r.off += n
+	if sparse != nil && err == nil {
+		err = sparse.finish()
+	}
+	if r.hash != nil && err == nil {
+		err = r.sum(nil, true)
+	}
+	err = r.classify(err)
+	r.corrupted(err)
+	if err = r.tolerate(err); err == io.EOF {
+		err = nil
+	}
+	return total + n, err
+}
+
+// DirectDecoder is implemented by readers returned from Middleware.Reader
+type DirectDecoder interface {
+	// DecodeInto decodes the remaining stream into dst and returns the number
+	// of bytes decoded. It is meant for callers that know the uncompressed
+	// size and saves copying through intermediate buffers where the format
+	// allows it. It returns io.ErrShortBuffer if the stream is larger than
+	// dst.
+	DecodeInto(dst []byte) (int, error)
+}
+
+func (r *reader) DecodeInto(dst []byte) (int, error) {
+	ahead := copy(dst, r.ahead)
+	r.ahead = r.ahead[ahead:]
+	if len(r.ahead) > 0 {
+		return ahead, io.ErrShortBuffer
+	}
+	if r.aheadErr == io.EOF {
+		return ahead, nil
+	} else if r.aheadErr != nil {
+		return ahead, r.aheadErr
+	}
+	n, err := r.decodeInto(dst[ahead:])
+	return ahead + n, err
+}
+
+func (r *reader) decodeInto(dst []byte) (int, error) {
+	var n int
+	var err error
+	if d, ok := r.dec.(DirectDecoder); ok {
+		err = r.m.guard(func() (err error) {
+			n, err = d.DecodeInto(dst)
+			return err
+		})
+	} else {
+		err = r.m.guard(func() (err error) {
+			n, err = io.ReadFull(r.dec, dst)
+			return err
+		})
+		switch err {
+		case io.EOF, io.ErrUnexpectedEOF:
+			err = nil
+		case nil:
+			// dst is full, make sure the stream ends here
+			probe := make([]byte, 1)
+			if m, _ := io.ReadFull(r.dec, probe); m > 0 {
+				r.unread = probe
+				err = io.ErrShortBuffer
+			}
+		}
+	}
+	r.m.stats.bytesDecompressed.Add(int64(n))
+	if terr := throttleGlobal(int64(n), &r.m.stats.lockWaits, r.deadline()); terr != nil && (err == nil || err == io.ErrShortBuffer) {
+		err = terr
+	}
+	r.off += int64(n)
+	if err != io.ErrShortBuffer {
+		r.releaseGlobal()
+	}
+	if r.hash != nil && (err == nil || err == io.ErrShortBuffer) {
+		if herr := r.sum(dst[:n], err == nil); herr != nil {
+			err = herr
+		}
+	}
+	err = r.classify(err)
+	r.corrupted(err)
+	if err = r.tolerate(err); err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *reader) Close() error {
+	r.releaseGlobal()
+	if r.arena != nil {
+		r.m.arenas.Put(r.arena)
+		r.arena = nil
+	}
+	if c, ok := r.dec.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// errWriter fails every write with err
+type errWriter struct {
+	err error
+}
+
+func (w *errWriter) Write(p []byte) (int, error) { return 0, w.err }
+func (w *errWriter) Close() error                { return w.err }
+
+// errReader fails every read with err
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) { return 0, r.err }
@@ -0,0 +1,88 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// closeOptions are option sets changing how writers end their stream
+func closeOptions(t *testing.T) map[string][]Option {
+	return map[string][]Option{
+		"default":   nil,
+		"framed":    {WithFrameSize(1024)},
+		"checksum":  {WithChecksum(CRC32C)},
+		"keepalive": {WithKeepalive(time.Hour)},
+		"resumable": {WithResumableWrites()},
+		"dictzip":   {WithDictzip(1024)},
+		"declared":  {WithDeclaredSizes()},
+		"pgzip":     {WithParallelGzip(1<<16, 2)},
+		"memory":    {WithMemoryBudget(8 << 20)},
+		"dedup":     {WithFrameDedup(4)},
+		"best-of":   {WithPerFrameBestOf(Zstd, S2)},
+		"refresh":   {WithDictionaryRefresh(2, 512)},
+		"entropy":   {WithEntropyCheck()},
+		"arena":     {WithArena(NewArenaPool(1024)), WithFrameSize(1024)},
+		"id":        {WithStreamIdentifier(StreamIdentifier{Body: "custom"})},
+		"spill":     {WithSpillDir(t.TempDir())},
+		"catalog":   {WithCatalog(NewFileCatalog(t.TempDir() + "/catalog"))},
+	}
+}
+
+func TestWriter_Close(t *testing.T) {
+	requireZstd(t)
+	for _, a := range []Algorithm{Gzip, Zstd, S2, Snappy, Zlib, Flate, Bzip2, None, SnappyBlock} {
+		for name, opts := range closeOptions(t) {
+			t.Run(a.String()+"/"+name, func(t *testing.T) {
+				m := New(a, opts...)
+				var buf bytes.Buffer
+				w := m.Writer(&buf)
+				if err := w.(io.Closer).Close(); err != nil {
+					t.Fatalf("Failed to close without writing: %v", err)
+				}
+				n := buf.Len()
+				if err := w.(io.Closer).Close(); err != nil || buf.Len() != n {
+					t.Fatalf("Expected closing again to do nothing, got %d more bytes, %v", buf.Len()-n, err)
+				}
+				if _, err := w.Write([]byte("late")); !errors.Is(err, ErrClosed) {
+					t.Fatalf("Expected ErrClosed, got %v", err)
+				}
+				if err := w.(Aborter).Abort(); err != nil {
+					t.Fatalf("Expected Abort after Close to do nothing, got %v", err)
+				}
+				got, err := io.ReadAll(m.Reader(bytes.NewReader(buf.Bytes())))
+				if err != nil || len(got) != 0 {
+					t.Fatalf("Expected a valid empty stream, got %d bytes, %v", len(got), err)
+				}
+			})
+		}
+	}
+}
+
+func TestWriter_CloseConcurrently(t *testing.T) {
+	requireZstd(t)
+	w := New(Zstd, WithKeepalive(time.Millisecond)).Writer(io.Discard)
+	w.Write([]byte("closed from several goroutines"))
+	errs := make(chan error)
+	for i := 0; i < 4; i++ {
+		go func() { errs <- w.(io.Closer).Close() }()
+	}
+	for i := 0; i < 4; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("Failed to close: %v", err)
+		}
+	}
+}
+
+func TestWriter_CloseFailed(t *testing.T) {
+	w := New(Gzip).Writer(&flakySink{failures: 1})
+	w.Write([]byte("the sink fails on close"))
+	if err := w.(io.Closer).Close(); !errors.Is(err, errOutage) {
+		t.Fatalf("Expected the sink error, got %v", err)
+	}
+	if err := w.(io.Closer).Close(); err != nil {
+		t.Fatalf("Expected closing again to return nil, got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package compression
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWithTag(t *testing.T) {
+	requireZstd(t)
+	// Tag stats are global, count relative to earlier runs
+	before := StatsByTag()
+	logs1 := New(Zstd, WithTag("test-logs"))
+	logs2 := New(Gzip, WithTag("test-logs"))
+	images := New(S2, WithTag("test-images"))
+
+	for _, m := range []*Middleware{logs1, logs2, images} {
+		var buf bytes.Buffer
+		w := m.Writer(&buf)
+		w.Write(bytes.Repeat([]byte("tagged "), 1000))
+		w.(io.Closer).Close()
+		io.ReadAll(m.Reader(&buf))
+	}
+
+	byTag := StatsByTag()
+	logs, logsBefore := byTag["test-logs"], before["test-logs"]
+	if logs.Writers-logsBefore.Writers != 2 || logs.Readers-logsBefore.Readers != 2 || logs.BytesWritten-logsBefore.BytesWritten != 14000 {
+		t.Fatalf("Unexpected logs stats: %+v", logs)
+	}
+	if want := logs1.Stats().BytesCompressed + logs2.Stats().BytesCompressed; logs.BytesCompressed-logsBefore.BytesCompressed != want {
+		t.Fatalf("Expected %d compressed bytes for logs, got %d", want, logs.BytesCompressed-logsBefore.BytesCompressed)
+	}
+	if logs1.Stats().Writers != 1 {
+		t.Fatalf("Expected middleware stats to stay separate, got %+v", logs1.Stats())
+	}
+	imagesBefore := before["test-images"]
+	if images := byTag["test-images"]; images.Writers-imagesBefore.Writers != 1 || images.BytesDecompressed-imagesBefore.BytesDecompressed != 7000 {
+		t.Fatalf("Unexpected images stats: %+v", images)
+	}
+
+	var prom bytes.Buffer
+	if err := WriteTagPrometheus(&prom, "hb"); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	for _, want := range []string{
+		fmt.Sprintf("hb_writers_total{tag=\"test-logs\"} %d\n", logs.Writers),
+		fmt.Sprintf("hb_writers_total{tag=\"test-images\"} %d\n", byTag["test-images"].Writers),
+		fmt.Sprintf("hb_bytes_written_total{tag=\"test-logs\"} %d\n", logs.BytesWritten),
+	} {
+		if !strings.Contains(prom.String(), want) {
+			t.Fatalf("Expected metrics to contain %q:\n%s", want, prom.String())
+		}
+	}
+
+	prom.Reset()
+	logs1.WritePrometheus(&prom, "hb")
+	if !strings.Contains(prom.String(), "hb_writers_total{tag=\"test-logs\"} 1\n") {
+		t.Fatalf("Expected tag label in middleware metrics:\n%s", prom.String())
+	}
+}
@@ -0,0 +1,40 @@
+package compression
+
+import "io"
+
+// TeeWriter returns a writer that compresses everything written to it into
+// compressedSink and passes the original bytes to rawSink in the same pass,
+// e.g. for keeping an archive copy next to a live uncompressed feed. Close
+// finishes the compressed stream; rawSink is not closed.
+func (m *Middleware) TeeWriter(compressedSink, rawSink io.Writer) io.WriteCloser {
+	return &teeWriter{w: m.Writer(compressedSink), raw: rawSink}
+}
+
+type teeWriter struct {
+	w   io.Writer
+	raw io.Writer
+}
+
+func (t *teeWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	if n > 0 {
+		if m, rawErr := t.raw.Write(p[:n]); rawErr != nil {
+			return m, rawErr
+		} else if m < n {
+			return m, io.ErrShortWrite
+		}
+	}
+	return n, err
+}
+
+// Flush flushes the compressed stream
+func (t *teeWriter) Flush() error {
+	if f, ok := t.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (t *teeWriter) Close() error {
+	return t.w.(io.Closer).Close()
+}
@@ -0,0 +1,41 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestTeeWriter(t *testing.T) {
+	requireZstd(t)
+	m := New(Zstd)
+	testData := bytes.Repeat([]byte("archive and live feed "), 100)
+
+	var compressed, raw bytes.Buffer
+	w := m.TeeWriter(&compressed, &raw)
+	for i := 0; i < len(testData); i += 100 {
+		if _, err := w.Write(testData[i:min(i+100, len(testData))]); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+
+	if !bytes.Equal(raw.Bytes(), testData) {
+		t.Fatal("Raw sink data mismatch")
+	}
+	data, err := io.ReadAll(m.Reader(&compressed))
+	if err != nil || !bytes.Equal(data, testData) {
+		t.Fatalf("Compressed sink data mismatch: %v", err)
+	}
+}
+
+func TestTeeWriter_RawError(t *testing.T) {
+	failing := writerFunc(func(p []byte) (int, error) { return 0, errors.New("raw sink down") })
+	w := New(S2).TeeWriter(io.Discard, failing)
+	if _, err := w.Write([]byte("x")); err == nil {
+		t.Fatal("Expected raw sink error")
+	}
+}
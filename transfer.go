@@ -0,0 +1,28 @@
+package compression
+
+import (
+	"io"
+	"os"
+)
+
+// WithKernelCopy lets readers of framed streams copy stored (uncompressed)
+// frames from a source *os.File to a destination *os.File in the kernel,
+// using copy_file_range or sendfile, instead of through user space buffers.
+// Streams of the None algorithm are copied in the kernel as a whole. It
+// applies when the reader is drained with WriteTo, e.g. by io.Copy, and is
+// only effective on Linux.
+func WithKernelCopy() Option {
+	return func(m *Middleware) {
+		m.kernelCopy = true
+	}
+}
+
+// newFramedReader creates the framed reader for src, read through in
+func (m *Middleware) newFramedReader(src, in io.Reader) *framedReader {
+	f := newFramedReader(m, in)
+	f.in, _ = in.(*countingReader)
+	if file, ok := src.(*os.File); ok && m.kernelCopy && kernelCopySupported {
+		f.file = file
+	}
+	return f
+}
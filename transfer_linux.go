@@ -0,0 +1,44 @@
+//go:build linux
+
+package compression
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+const kernelCopySupported = true
+
+// transferFile copies n bytes from the current offset of src to dst. The
+// runtime implements (*os.File).ReadFrom from a limited *os.File with
+// copy_file_range, splice or sendfile on Linux.
+func transferFile(dst, src *os.File, n int64) (int64, error) {
+	return dst.ReadFrom(&io.LimitedReader{R: src, N: n})
+}
+
+// preallocate reserves size bytes for f, so the file is laid out in as few
+// extents as possible. File systems without fallocate are left alone, other
+// errors such as ENOSPC are returned.
+func preallocate(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if err == syscall.EOPNOTSUPP {
+		return nil
+	}
+	return err
+}
+
+// mmapFile maps the first size bytes of f read-only
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	data, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	// The file is read sequentially
+	syscall.Madvise(data, syscall.MADV_SEQUENTIAL)
+	return data, nil
+}
+
+func munmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package compression
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+const kernelCopySupported = false
+
+func transferFile(dst, src *os.File, n int64) (int64, error) {
+	return io.CopyN(dst, src, n)
+}
+
+func preallocate(f *os.File, size int64) error {
+	return f.Truncate(size)
+}
+
+func mmapFile(f *os.File, size int) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
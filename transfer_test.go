@@ -0,0 +1,75 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKernelCopy_StoredFrames(t *testing.T) {
+	m := New(S2, WithFrameSize(64<<10), WithKernelCopy())
+
+	// Mix incompressible frames, which are stored raw, with compressible ones
+	random := make([]byte, 200<<10)
+	rand.New(rand.NewSource(1)).Read(random)
+	testData := append(random, bytes.Repeat([]byte("compressible "), 20000)...)
+
+	dir := t.TempDir()
+	src, err := os.Create(filepath.Join(dir, "spill"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	w := m.Writer(src)
+	w.Write(testData)
+	w.(io.Closer).Close()
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	dst, err := os.Create(filepath.Join(dir, "restored"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	n, err := io.Copy(dst, m.Reader(src))
+	if err != nil {
+		t.Fatalf("Failed to copy: %v", err)
+	}
+	if n != int64(len(testData)) {
+		t.Fatalf("Expected %d bytes, copied %d", len(testData), n)
+	}
+
+	restored, err := os.ReadFile(dst.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored, testData) {
+		t.Fatal("Data mismatch")
+	}
+}
+
+func TestFrameSize_StoredFrames(t *testing.T) {
+	requireZstd(t)
+	m := New(Zstd, WithFrameSize(1024))
+
+	random := make([]byte, 1024)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	w.Write(random)
+	w.(io.Closer).Close()
+
+	if buf.Bytes()[HeaderSize] != frameStored {
+		t.Fatal("Expected incompressible frame to be stored raw")
+	}
+	data, err := io.ReadAll(m.Reader(&buf))
+	if err != nil || !bytes.Equal(data, random) {
+		t.Fatalf("Failed to read stored frame: %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package compression
+
+import (
+	"encoding/json"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tunerMinSamples is the number of streams observed per level before the
+// tuner trusts its stats, and tunerMaxSamples the number after which old
+// observations are decayed
+const (
+	tunerMinSamples = 3
+	tunerMaxSamples = 1024
+)
+
+var tunerLevels = [...]Level{Fastest, Default, Better, Best}
+
+// WithAutoTune lets t choose the level of every new writer based on the
+// ratio and throughput observed for previous streams. The configured level
+// is only used until t has made a decision.
+func WithAutoTune(t *Tuner) Option {
+	return func(m *Middleware) {
+		m.tuner = t
+	}
+}
+
+// Tuner is a feedback controller choosing the compression level. It
+// samples all levels and then settles on the fastest one whose output is
+// at most MinGain larger than the smallest output of any level, e.g. it
+// drops to Fastest if Best only saves 2%. It keeps sampling other levels
+// every Explore streams to follow changes in the data. A Tuner may be
+// shared by several middleware instances with similar data.
+type Tuner struct {
+	// MinGain is the relative size reduction that justifies a slower level.
+	// The default is 0.05.
+	MinGain float64
+	// Explore is the interval in streams at which other levels are sampled.
+	// The default is 32.
+	Explore int
+
+	mu      sync.Mutex
+	state   TunerState
+	streams atomic.Int64
+	// level is the level of state, warm is set once every level has enough
+	// samples, so Level only locks while the tuner warms up
+	level atomic.Int64
+	warm  atomic.Bool
+}
+
+// TunerState is the persistable state of a Tuner
+type TunerState struct {
+	Level  Level              `json:"level"`
+	Levels map[Level]LevelObs `json:"levels"`
+}
+
+// LevelObs accumulates observations of streams written with one level
+type LevelObs struct {
+	Streams int64         `json:"streams"`
+	In      int64         `json:"in"`
+	Out     int64         `json:"out"`
+	Busy    time.Duration `json:"busy_ns"`
+}
+
+func (o LevelObs) ratio() float64 {
+	return float64(o.Out) / float64(o.In)
+}
+
+func (o LevelObs) throughput() float64 {
+	return float64(o.In) / max(o.Busy.Seconds(), 1e-9)
+}
+
+// NewTuner creates a tuner starting at the Default level
+func NewTuner() *Tuner {
+	t := &Tuner{}
+	t.setState(TunerState{Level: Default, Levels: make(map[Level]LevelObs)})
+	return t
+}
+
+// Level returns the level for the next stream
+func (t *Tuner) Level() Level {
+	return t.next(nil)
+}
+
+// next returns the level for the next stream, counting waits for the lock
+// into waits
+func (t *Tuner) next(waits *counter) Level {
+	streams := t.streams.Add(1)
+	if !t.warm.Load() {
+		lockCounted(&t.mu, waits)
+		defer t.mu.Unlock()
+		for _, l := range tunerLevels {
+			if t.state.Levels[l].Streams < tunerMinSamples {
+				return l
+			}
+		}
+	}
+	explore := t.Explore
+	if explore <= 0 {
+		explore = 32
+	}
+	if streams%int64(explore) == 0 {
+		return tunerLevels[(streams/int64(explore))%int64(len(tunerLevels))]
+	}
+	return Level(t.level.Load())
+}
+
+// publish makes the decision visible to next. t.mu must be held.
+func (t *Tuner) publish() {
+	t.level.Store(int64(t.state.Level))
+	warm := true
+	for _, l := range tunerLevels {
+		warm = warm && t.state.Levels[l].Streams >= tunerMinSamples
+	}
+	t.warm.Store(warm)
+}
+
+// setState replaces the state of t
+func (t *Tuner) setState(state TunerState) {
+	if state.Levels == nil {
+		// e.g. "levels": null
+		state.Levels = make(map[Level]LevelObs)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.state = state
+	t.publish()
+}
+
+// snapshot returns a copy of the state of t
+func (t *Tuner) snapshot() TunerState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.state
+	state.Levels = maps.Clone(t.state.Levels)
+	return state
+}
+
+// observe records a finished stream and updates the decision, counting
+// waits for the lock into waits
+func (t *Tuner) observe(l Level, in, out int64, busy time.Duration, waits *counter) {
+	if in == 0 {
+		return
+	}
+	lockCounted(&t.mu, waits)
+	defer t.mu.Unlock()
+
+	o := t.state.Levels[l]
+	if o.Streams >= tunerMaxSamples {
+		o = LevelObs{Streams: o.Streams / 2, In: o.In / 2, Out: o.Out / 2, Busy: o.Busy / 2}
+	}
+	o.Streams++
+	o.In += in
+	o.Out += out
+	o.Busy += busy
+	t.state.Levels[l] = o
+	t.decide()
+	t.publish()
+}
+
+func (t *Tuner) decide() {
+	minGain := t.MinGain
+	if minGain <= 0 {
+		minGain = 0.05
+	}
+
+	bestRatio := -1.0
+	for _, l := range tunerLevels {
+		if o := t.state.Levels[l]; o.Streams >= tunerMinSamples && (bestRatio < 0 || o.ratio() < bestRatio) {
+			bestRatio = o.ratio()
+		}
+	}
+	if bestRatio < 0 {
+		return
+	}
+
+	var choice *LevelObs
+	for _, l := range tunerLevels {
+		o := t.state.Levels[l]
+		if o.Streams < tunerMinSamples || (o.ratio()-bestRatio)/o.ratio() > minGain {
+			continue
+		}
+		if choice == nil || o.throughput() > choice.throughput() {
+			choice = &o
+			t.state.Level = l
+		}
+	}
+}
+
+// State returns the tuner state as a JSON blob, so tuning survives restarts
+func (t *Tuner) State() ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return json.Marshal(t.state)
+}
+
+// LoadState restores a state returned by State
+func (t *Tuner) LoadState(blob []byte) error {
+	var state TunerState
+	if err := json.Unmarshal(blob, &state); err != nil {
+		return err
+	}
+	t.setState(state)
+	return nil
+}
@@ -0,0 +1,95 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestTuner_SamplesAndDecides(t *testing.T) {
+	requireZstd(t)
+	tuner := NewTuner()
+	m := New(Zstd, WithAutoTune(tuner))
+	testData := bytes.Repeat([]byte("tuning sample "), 5000)
+
+	seen := make(map[Level]int)
+	for i := 0; i < 4*tunerMinSamples; i++ {
+		w := m.Writer(io.Discard)
+		seen[w.(*writer).level]++
+		w.Write(testData)
+		w.(io.Closer).Close()
+	}
+	for _, l := range tunerLevels {
+		if seen[l] != tunerMinSamples {
+			t.Fatalf("Expected %d samples of %s, got %d", tunerMinSamples, l, seen[l])
+		}
+	}
+
+	state, err := tuner.State()
+	if err != nil {
+		t.Fatalf("Failed to export state: %v", err)
+	}
+	restored := NewTuner()
+	if err := restored.LoadState(state); err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	if restored.Level() != tuner.state.Level {
+		t.Fatal("Expected restored tuner to keep the decision")
+	}
+}
+
+func TestTuner_PrefersFastLevelForSmallGains(t *testing.T) {
+	tuner := NewTuner()
+	obs := map[Level]LevelObs{
+		Fastest: {Streams: 10, In: 1000, Out: 500, Busy: time.Millisecond},
+		Default: {Streams: 10, In: 1000, Out: 495, Busy: 2 * time.Millisecond},
+		Better:  {Streams: 10, In: 1000, Out: 492, Busy: 4 * time.Millisecond},
+		Best:    {Streams: 10, In: 1000, Out: 490, Busy: 8 * time.Millisecond},
+	}
+	tuner.state.Levels = obs
+	tuner.decide()
+	if tuner.state.Level != Fastest {
+		t.Fatalf("Expected Fastest when Best only gains 2%%, got %s", tuner.state.Level)
+	}
+
+	obs[Best] = LevelObs{Streams: 10, In: 1000, Out: 300, Busy: 8 * time.Millisecond}
+	tuner.decide()
+	if tuner.state.Level != Best {
+		t.Fatalf("Expected Best when it gains 40%%, got %s", tuner.state.Level)
+	}
+}
+
+func TestTuner_WarmLevelDoesNotLock(t *testing.T) {
+	tuner := NewTuner()
+	levels := make(map[Level]LevelObs)
+	for _, l := range tunerLevels {
+		levels[l] = LevelObs{Streams: tunerMinSamples, In: 1000, Out: 500, Busy: time.Millisecond}
+	}
+	tuner.setState(TunerState{Level: Better, Levels: levels})
+
+	tuner.mu.Lock()
+	defer tuner.mu.Unlock()
+	done := make(chan Level)
+	go func() { done <- tuner.Level() }()
+	select {
+	case l := <-done:
+		if l != Better {
+			t.Fatalf("Expected the decided level, got %s", l)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Level not to lock once the tuner is warm")
+	}
+}
+
+func TestTuner_LoadStateNullLevels(t *testing.T) {
+	requireZstd(t)
+	tuner := NewTuner()
+	if err := tuner.LoadState([]byte(`{"levels":null}`)); err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	// Observing a stream must not write to a nil map
+	w := New(Zstd, WithAutoTune(tuner)).Writer(io.Discard)
+	w.Write(bytes.Repeat([]byte("tuning sample "), 5000))
+	w.(io.Closer).Close()
+}
@@ -0,0 +1,97 @@
+package compression
+
+import "fmt"
+
+// NewE creates a middleware like New and returns it along with the error of
+// Validate, so misconfiguration is caught at startup instead of when the
+// first stream is created
+func NewE(algorithm Algorithm, opts ...Option) (*Middleware, error) {
+	m := New(algorithm, opts...)
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Validate reports misconfiguration of the middleware: an algorithm that is
+// unknown or not compiled into this binary and has no available fallback,
+// which makes Writer and Reader panic, a level other than the Level
+// constants, which codecs silently treat as their default, and errors of
+// options such as an invalid dictionary, which fail every stream. Levels an
+// algorithm has no use for, such as a level for Snappy, are accepted;
+// ValidateConfig reports them as warnings.
+func (m *Middleware) Validate() error {
+	if m.err != nil {
+		return m.err
+	}
+	if !m.algorithm.Available() {
+		if _, ok := algorithmNames[m.algorithm]; ok {
+			return fmt.Errorf("%w: %s is not available in this build", ErrUnsupportedAlgorithm, m.algorithm)
+		}
+		return fmt.Errorf("%w: unknown algorithm %s", ErrUnsupportedAlgorithm, m.algorithm)
+	}
+	if _, ok := levelNames[m.level]; !ok {
+		return fmt.Errorf("%w: unknown level %d for %s", ErrInvalidLevel, int(m.level), m.algorithm)
+	}
+	return nil
+}
+
+// smallFrameSize is the frame size below which the per-frame overhead of the
+// container and the codec outweighs higher levels
+const smallFrameSize = 64 << 10
+
+// Warning is a suspicious configuration reported by ValidateConfig
+type Warning struct {
+	// Code identifies the check, e.g. "level-ignored"
+	Code    string
+	Message string
+}
+
+func (w Warning) String() string {
+	return w.Code + ": " + w.Message
+}
+
+// ValidateConfig reports combinations in c that are accepted but waste
+// resources or do not do what they suggest, e.g. a level for Snappy, which
+// has none, or a dictionary for algorithms that ignore it. It does not create
+// a middleware and returns nil if nothing is suspicious.
+func ValidateConfig(c Config) []Warning {
+	var warnings []Warning
+	warn := func(code, format string, args ...any) {
+		warnings = append(warnings, Warning{Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if !c.Algorithm.Available() {
+		warn("unavailable", "algorithm %s is not available in this build", c.Algorithm)
+	}
+	if _, ok := levelNames[c.Level]; !ok {
+		warn("unknown-level", "level %d is unknown and compresses like the codec default", int(c.Level))
+	} else if (c.Algorithm == Snappy || c.Algorithm == SnappyBlock || c.Algorithm == None) && c.Level != Default {
+		warn("level-ignored", "%s has no levels, level %s is ignored", c.Algorithm, c.Level)
+	}
+
+	if len(c.Dictionary) > 0 {
+		if c.Algorithm != Zstd {
+			warn("dictionary-ignored", "the dictionary is only used by zstd and ignored by %s", c.Algorithm)
+		} else if err := validateZstdDictionary(c.Dictionary); err != nil {
+			warn("dictionary-invalid", "the dictionary is not a zstd dictionary: %v", err)
+		}
+	}
+
+	switch {
+	case c.FrameSize > MaxFrameSize:
+		warn("frame-size-capped", "frame size %d is capped at %d", c.FrameSize, MaxFrameSize)
+	case c.FrameSize > 0 && c.FrameSize < smallFrameSize:
+		if c.Level == Best && c.Algorithm != Snappy && c.Algorithm != SnappyBlock {
+			warn("small-frames", "frames of %d bytes are too small for %s at level best to pay off, use a larger frame size or a lower level", c.FrameSize, c.Algorithm)
+		}
+		if c.Algorithm == Zstd && len(c.Dictionary) == 0 && c.FrameSize < 4<<10 {
+			warn("tiny-frames", "frames of %d bytes compress poorly without a dictionary", c.FrameSize)
+		}
+	}
+
+	if c.Quota.MaxActiveWriters < 0 || c.Quota.MaxBytes < 0 {
+		warn("negative-quota", "negative quota limits are treated as unlimited")
+	}
+	return warnings
+}
@@ -0,0 +1,81 @@
+package compression
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	requireZstd(t)
+	dict := testZstdDictionary(t, 1)
+	for _, tc := range []struct {
+		name   string
+		config Config
+		codes  []string
+	}{
+		{"clean", Config{Algorithm: Zstd, Level: Better, Dictionary: dict, FrameSize: 1 << 20}, nil},
+		{"snappy level", Config{Algorithm: Snappy, Level: Best}, []string{"level-ignored"}},
+		{"unknown level", Config{Algorithm: Gzip, Level: 42}, []string{"unknown-level"}},
+		{"s2 dictionary", Config{Algorithm: S2, Dictionary: dict}, []string{"dictionary-ignored"}},
+		{"invalid dictionary", Config{Algorithm: Zstd, Dictionary: []byte("not a dictionary")}, []string{"dictionary-invalid"}},
+		{"zstd best tiny frames", Config{Algorithm: Zstd, Level: Best, FrameSize: 1024}, []string{"small-frames", "tiny-frames"}},
+		{"capped frames", Config{Algorithm: S2, FrameSize: MaxFrameSize + 1}, []string{"frame-size-capped"}},
+		{"negative quota", Config{Algorithm: S2, Quota: Quota{MaxBytes: -1}}, []string{"negative-quota"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var codes []string
+			for _, w := range ValidateConfig(tc.config) {
+				codes = append(codes, w.Code)
+			}
+			if !slices.Equal(codes, tc.codes) {
+				t.Fatalf("Expected warnings %v, got %v", tc.codes, codes)
+			}
+		})
+	}
+}
+
+func TestConfig_FrameSize(t *testing.T) {
+	m := New(Zstd, Config{Algorithm: Zstd, FrameSize: 4096}.Options()...)
+	if m.frameSize != 4096 {
+		t.Fatalf("Expected frame size 4096, got %d", m.frameSize)
+	}
+}
+
+func TestNewE(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		algorithm Algorithm
+		opts      []Option
+		want      error
+	}{
+		{"unknown algorithm", Algorithm(999), nil, ErrUnsupportedAlgorithm},
+		{"unknown level", Gzip, []Option{WithLevel(42)}, ErrInvalidLevel},
+		{"invalid dictionary", Zstd, []Option{WithBuiltinDictionary(BuiltinDictionary(99))}, ErrInvalidDictionary},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := NewE(tc.algorithm, tc.opts...)
+			if !errors.Is(err, tc.want) || m != nil {
+				t.Fatalf("Expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+
+	for _, a := range []Algorithm{Gzip, S2, Snappy, None} {
+		for _, l := range []Level{Fastest, Default, Better, Best} {
+			if _, err := NewE(a, WithLevel(l)); err != nil {
+				t.Fatalf("Expected %s at level %s to be valid, got %v", a, l, err)
+			}
+		}
+	}
+}
+
+func TestValidate_Fallback(t *testing.T) {
+	m := New(Algorithm(999), WithFallbackAlgorithm(S2))
+	if err := m.Validate(); err != nil {
+		t.Fatalf("Expected the fallback to be valid, got %v", err)
+	}
+	if err := New(Algorithm(999), WithFallbackAlgorithm(Algorithm(998))).Validate(); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Fatalf("Expected an unsupported algorithm, got %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+// ErrInvalidValue is returned by ValueCodec.Unmarshal for data not produced
+// by Marshal
+var ErrInvalidValue = errors.New("compression: invalid cache value")
+
+// Value header, the first byte of every marshaled value
+const (
+	valueRaw        = 0
+	valueCompressed = 1 // followed by the algorithm byte and the stream
+)
+
+// ValueCodec compresses values for caches such as Redis or memcached. Each
+// value starts with a small header recording whether and how it was
+// compressed, so values below the threshold or written with another
+// algorithm remain readable.
+type ValueCodec struct {
+	m       *Middleware
+	minSize int
+	opts    []Option
+
+	// readers holds the middleware reading the values of each algorithm,
+	// m for its own
+	mu      sync.Mutex
+	readers map[Algorithm]*Middleware
+}
+
+// NewValueCodec creates a codec compressing values of at least minSize
+// bytes with m. Smaller values and values that do not shrink are stored
+// raw. Values of other algorithms are read with one middleware per
+// algorithm created with opts, e.g. WithMaxDecompressedSize.
+func NewValueCodec(m *Middleware, minSize int, opts ...Option) *ValueCodec {
+	return &ValueCodec{m: m, minSize: minSize, opts: opts, readers: map[Algorithm]*Middleware{m.algorithm: m}}
+}
+
+// reader returns the middleware reading values of algorithm a
+func (c *ValueCodec) reader(a Algorithm) *Middleware {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, ok := c.readers[a]
+	if !ok {
+		m = New(a, c.opts...)
+		c.readers[a] = m
+	}
+	return m
+}
+
+// Marshal encodes p for storage
+func (c *ValueCodec) Marshal(p []byte) ([]byte, error) {
+	if len(p) >= c.minSize {
+		buf := bytes.NewBuffer(make([]byte, 0, len(p)/2))
+		buf.Write([]byte{valueCompressed, byte(c.m.algorithm)})
+		w := c.m.Writer(buf)
+		if _, err := w.Write(p); err != nil {
+			return nil, err
+		}
+		if err := w.(io.Closer).Close(); err != nil {
+			return nil, err
+		}
+		if buf.Len() < len(p)+1 {
+			return buf.Bytes(), nil
+		}
+	}
+	out := make([]byte, 1+len(p))
+	out[0] = valueRaw
+	copy(out[1:], p)
+	return out, nil
+}
+
+// Unmarshal decodes a value returned by Marshal
+func (c *ValueCodec) Unmarshal(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrInvalidValue
+	}
+	switch data[0] {
+	case valueRaw:
+		return bytes.Clone(data[1:]), nil
+	case valueCompressed:
+		if len(data) < 2 {
+			return nil, ErrInvalidValue
+		}
+		a := Algorithm(data[1])
+		if !a.Available() {
+			return nil, ErrInvalidValue
+		}
+		r := c.reader(a).Reader(bytes.NewReader(data[2:]))
+		if rc, ok := r.(io.Closer); ok {
+			defer rc.Close()
+		}
+		return io.ReadAll(r)
+	}
+	return nil, ErrInvalidValue
+}
@@ -0,0 +1,65 @@
+package compression
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestValueCodec(t *testing.T) {
+	requireZstd(t)
+	c := NewValueCodec(New(Zstd), 64)
+
+	large := bytes.Repeat([]byte(`{"key":"value"}`), 100)
+	for _, p := range [][]byte{large, []byte("small"), {}} {
+		data, err := c.Marshal(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := c.Unmarshal(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, p) {
+			t.Errorf("round trip of %d bytes failed", len(p))
+		}
+	}
+
+	data, _ := c.Marshal(large)
+	if data[0] != valueCompressed || len(data) >= len(large) {
+		t.Errorf("large value not compressed: %d bytes", len(data))
+	}
+	if data, _ := c.Marshal([]byte("small")); data[0] != valueRaw {
+		t.Error("value below the threshold was compressed")
+	}
+}
+
+func TestValueCodec_OtherAlgorithm(t *testing.T) {
+	data, err := NewValueCodec(New(S2), 0).Marshal(bytes.Repeat([]byte("s2 "), 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := NewValueCodec(New(Gzip), 0).Unmarshal(data)
+	if err != nil || !bytes.Equal(got, bytes.Repeat([]byte("s2 "), 100)) {
+		t.Errorf("reading a value of another algorithm failed: %v", err)
+	}
+	for _, bad := range [][]byte{nil, {valueCompressed}, {7, 1, 2}} {
+		if _, err := NewValueCodec(New(Gzip), 0).Unmarshal(bad); !errors.Is(err, ErrInvalidValue) {
+			t.Errorf("Unmarshal(%v) = %v", bad, err)
+		}
+	}
+}
+
+func TestValueCodec_ReaderOptions(t *testing.T) {
+	data, err := NewValueCodec(New(S2), 0).Marshal(bytes.Repeat([]byte("s2 "), 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewValueCodec(New(Gzip), 0, WithMaxDecompressedSize(100))
+	if _, err := c.Unmarshal(data); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("Expected the limit of the options, got %v", err)
+	}
+	if c.reader(S2) != c.reader(S2) || c.reader(Gzip) != c.m {
+		t.Error("Expected one middleware per algorithm")
+	}
+}
@@ -0,0 +1,112 @@
+package compression
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+)
+
+// ErrVectorMismatch is returned by CheckVector when a vector does not
+// decode to its input
+var ErrVectorMismatch = errors.New("compression: test vector mismatch")
+
+// TestVector is a compressed fixture with its expected content. Vectors
+// marshal to JSON, with Input and Compressed in base64, for decoders
+// written in other languages.
+type TestVector struct {
+	Name      string    `json:"name"`
+	Algorithm Algorithm `json:"algorithm"`
+	Level     Level     `json:"level"`
+	// FrameSize is set for vectors in the framed container format
+	FrameSize   int    `json:"frame_size,omitempty"`
+	Input       []byte `json:"input"`
+	InputSHA256 string `json:"input_sha256"`
+	Compressed  []byte `json:"compressed"`
+}
+
+// GenerateTestVectors returns fixtures of algorithm covering empty, text,
+// repetitive, incompressible and mixed inputs at every level, plain and in
+// the framed container. The same seed yields the same inputs; the
+// compressed bytes are stable for a given version of the codecs.
+func GenerateTestVectors(algorithm Algorithm, seed int64) ([]TestVector, error) {
+	if !algorithm.Available() {
+		return nil, fmt.Errorf("%w: %s not available", ErrUnsupportedAlgorithm, algorithm)
+	}
+	rng := rand.New(rand.NewSource(seed))
+	random := make([]byte, 4096)
+	rng.Read(random)
+	var text bytes.Buffer
+	for text.Len() < 8192 {
+		fmt.Fprintf(&text, "line %d: the quick brown fox jumps over %d lazy dogs\n", rng.Intn(1000), rng.Intn(100))
+	}
+	inputs := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"byte", []byte{byte(rng.Intn(256))}},
+		{"text", text.Bytes()},
+		{"repeat", bytes.Repeat(random[:16], 4096)},
+		{"random", random},
+		{"mixed", append(append(bytes.Clone(text.Bytes()), random...), text.Bytes()...)},
+	}
+
+	var vectors []TestVector
+	for _, in := range inputs {
+		for _, level := range []Level{Fastest, Default, Better, Best} {
+			for _, frameSize := range []int{0, 4096} {
+				var opts []Option
+				name := fmt.Sprintf("%s-%s-%s", algorithm, level, in.name)
+				if frameSize > 0 {
+					opts = append(opts, WithFrameSize(frameSize))
+					name += "-framed"
+				}
+				var buf bytes.Buffer
+				w := New(algorithm, append(opts, WithLevel(level))...).Writer(&buf)
+				if _, err := w.Write(in.data); err != nil {
+					return nil, err
+				}
+				if err := w.(io.Closer).Close(); err != nil {
+					return nil, err
+				}
+				sum := sha256.Sum256(in.data)
+				vectors = append(vectors, TestVector{
+					Name:        name,
+					Algorithm:   algorithm,
+					Level:       level,
+					FrameSize:   frameSize,
+					Input:       in.data,
+					InputSHA256: hex.EncodeToString(sum[:]),
+					Compressed:  buf.Bytes(),
+				})
+			}
+		}
+	}
+	return vectors, nil
+}
+
+// CheckVector decodes the compressed bytes of v and compares the result
+// with its input and digest
+func CheckVector(v TestVector) error {
+	var opts []Option
+	if v.FrameSize > 0 {
+		opts = append(opts, WithFrameSize(v.FrameSize))
+	}
+	r := New(v.Algorithm, opts...).Reader(bytes.NewReader(v.Compressed))
+	if rc, ok := r.(io.Closer); ok {
+		defer rc.Close()
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrVectorMismatch, v.Name, err)
+	}
+	sum := sha256.Sum256(got)
+	if !bytes.Equal(got, v.Input) || hex.EncodeToString(sum[:]) != v.InputSHA256 {
+		return fmt.Errorf("%w: %s", ErrVectorMismatch, v.Name)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package compression
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestGenerateTestVectors(t *testing.T) {
+	requireZstd(t)
+	for _, a := range []Algorithm{Gzip, Zstd, S2, Snappy, Zlib, Flate} {
+		t.Run(a.String(), func(t *testing.T) {
+			t.Parallel()
+			vectors, err := GenerateTestVectors(a, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, v := range vectors {
+				if err := CheckVector(v); err != nil {
+					t.Error(err)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateTestVectors_Stable(t *testing.T) {
+	vectors, _ := GenerateTestVectors(Zstd, 1)
+	again, _ := GenerateTestVectors(Zstd, 1)
+	for i, v := range vectors {
+		if !bytes.Equal(v.Compressed, again[i].Compressed) {
+			t.Errorf("%s: output not stable", v.Name)
+		}
+	}
+}
+
+func TestCheckVector_Mismatch(t *testing.T) {
+	requireZstd(t)
+	vectors, _ := GenerateTestVectors(Zstd, 7)
+	p, err := json.Marshal(vectors[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v TestVector
+	if err := json.Unmarshal(p, &v); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckVector(v); err != nil {
+		t.Fatalf("vector broken by JSON round trip: %v", err)
+	}
+	v.Input = append(v.Input, 'x')
+	if err := CheckVector(v); !errors.Is(err, ErrVectorMismatch) {
+		t.Errorf("expected ErrVectorMismatch, got %v", err)
+	}
+}
@@ -0,0 +1,34 @@
+package compression
+
+import "runtime"
+
+// WithWorkerCount sets the number of goroutines a single stream may use in
+// concurrent codecs: zstd and S2 encoders, zstd decoders (at most 4) and
+// WithParallelGzip without an explicit worker count. fn is called whenever
+// an encoder or decoder is created, and pooled encoders created for another
+// count are replaced, so limits that change at runtime are followed, e.g.
+// fractional container CPU limits. The default is runtime.GOMAXPROCS,
+// which tools such as automaxprocs adjust. WithMemoryBudget implies a
+// single worker.
+func WithWorkerCount(fn func() int) Option {
+	return func(m *Middleware) {
+		m.workerCount = fn
+	}
+}
+
+// workers returns the current worker count, at least 1
+func (m *Middleware) workers() int {
+	n := runtime.GOMAXPROCS(0)
+	if m.workerCount != nil {
+		n = m.workerCount()
+	}
+	return max(n, 1)
+}
+
+// pooledEncoder is an idle encoder with the worker count and dictionary
+// generation it was created with
+type pooledEncoder struct {
+	enc     encoder
+	workers int
+	dictGen uint64
+}
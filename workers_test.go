@@ -0,0 +1,53 @@
+package compression
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestWithWorkerCount(t *testing.T) {
+	workers := 3
+	m := New(Gzip, WithParallelGzip(64<<10, 0), WithWorkerCount(func() int { return workers }))
+	data := []byte(strings.Repeat("worker count follows cpu limits ", 10000))
+
+	w := m.Writer(io.Discard)
+	enc := w.(*writer).enc.(*pgzipWriteCloser)
+	if enc.blocks != 3 {
+		t.Fatalf("Expected 3 workers, got %d", enc.blocks)
+	}
+	w.Write(data)
+	w.(io.Closer).Close()
+
+	// The pooled encoder was created for 3 workers
+	workers = 1
+	var buf bytes.Buffer
+	w = m.Writer(&buf)
+	if got := w.(*writer).enc.(*pgzipWriteCloser); got == enc || got.blocks != 1 {
+		t.Fatalf("Expected a new encoder with 1 worker, got %d", got.blocks)
+	}
+	w.Write(data)
+	w.(io.Closer).Close()
+	if got, err := io.ReadAll(m.Reader(&buf)); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to round trip: %v", err)
+	}
+}
+
+func TestWithWorkerCount_Default(t *testing.T) {
+	requireZstd(t)
+	if n := New(Zstd).workers(); n != runtime.GOMAXPROCS(0) {
+		t.Fatalf("Expected GOMAXPROCS workers, got %d", n)
+	}
+	if n := New(Zstd, WithWorkerCount(func() int { return 0 })).workers(); n != 1 {
+		t.Fatalf("Expected at least 1 worker, got %d", n)
+	}
+	for _, a := range []Algorithm{Zstd, S2} {
+		m := New(a, WithWorkerCount(func() int { return 2 }))
+		data := []byte(strings.Repeat("concurrent codecs ", 100000))
+		if got, err := io.ReadAll(m.Reader(bytes.NewReader(writeContainer(t, m, data)))); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("Failed to round trip %s: %v", a, err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package compression
+
+import (
+	"bytes"
+	stdzlib "compress/zlib"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestWithZlibDictionary(t *testing.T) {
+	dict := []byte("%PDF-1.7 obj endobj stream endstream xref trailer")
+	data := bytes.Repeat([]byte("1 0 obj << /Type /Page >> endobj stream endstream "), 20)
+	for name, opts := range map[string][]Option{
+		"klauspost": {WithZlibDictionary(dict)},
+		"stdlib":    {WithZlibDictionary(dict), WithStdlibCompat()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := New(Zlib, opts...)
+			compressed := writeContainer(t, m, data)
+			got, err := io.ReadAll(m.Reader(bytes.NewReader(compressed)))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("Failed to read: %v", err)
+			}
+
+			// The stdlib reads the stream with the same preset dictionary
+			zr, err := stdzlib.NewReaderDict(bytes.NewReader(compressed), dict)
+			if err != nil {
+				t.Fatalf("Failed to create stdlib reader: %v", err)
+			}
+			if got, err := io.ReadAll(zr); err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("Failed to read with the stdlib: %v", err)
+			}
+
+			// Pooled writers keep the dictionary
+			if again := writeContainer(t, m, data); !bytes.Equal(again, compressed) {
+				t.Fatal("Expected the same output from a pooled writer")
+			}
+		})
+	}
+
+	// Streams written by the stdlib with the dictionary are read
+	var buf bytes.Buffer
+	zw, _ := stdzlib.NewWriterLevelDict(&buf, stdzlib.BestCompression, dict)
+	zw.Write(data)
+	zw.Close()
+	got, err := io.ReadAll(New(Zlib, WithZlibDictionary(dict)).Reader(bytes.NewReader(buf.Bytes())))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Failed to read the stdlib stream: %v", err)
+	}
+
+	// Without the dictionary, or with another one, reading fails
+	for _, m := range []*Middleware{New(Zlib), New(Zlib, WithZlibDictionary([]byte("other")))} {
+		if _, err := io.ReadAll(m.Reader(bytes.NewReader(buf.Bytes()))); !errors.Is(err, stdzlib.ErrDictionary) {
+			t.Fatalf("Expected a dictionary error, got %v", err)
+		}
+	}
+}
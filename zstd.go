@@ -0,0 +1,472 @@
+//go:build !nozstd
+
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/huff0"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdAvailable reports whether zstd support is compiled in. Build with the
+// nozstd tag to strip it from the binary.
+const zstdAvailable = true
+
+// Zstd compression methods
+func (m *Middleware) createZstdWriter(w io.Writer, l Level) encoder {
+	zstdWriter, err := zstd.NewWriter(w, m.zstdWriterOptions(l)...)
+	if err != nil {
+		panic("failed to create zstd writer: " + err.Error())
+	}
+	if m.declaredSizes {
+		return &declaredWriter{enc: zstdWriter, w: w, limit: m.declaredBuffer()}
+	}
+	return &zstdWriteCloser{zstdWriter}
+}
+
+func (m *Middleware) zstdWriterOptions(l Level) []zstd.EOption {
+	var level zstd.EncoderLevel
+	switch l {
+	case Fastest:
+		level = zstd.SpeedFastest
+	case Default:
+		level = zstd.SpeedDefault
+	case Better:
+		level = zstd.SpeedBetterCompression
+	case Best:
+		level = zstd.SpeedBestCompression
+	}
+	if n, ok := m.levels.Native(Zstd, l); ok {
+		level = zstd.EncoderLevel(n)
+	}
+
+	opts := []zstd.EOption{zstd.WithEncoderLevel(level)}
+	if m.layered != nil {
+		opts = append(opts, zstd.WithEncoderDictRaw(m.layered.id, m.layered.content))
+	} else if dict := m.encoderDictionary(); dict != nil {
+		opts = append(opts, zstd.WithEncoderDict(dict))
+	}
+	if m.zeroFrames {
+		opts = append(opts, zstd.WithZeroFrames(true))
+	}
+	if m.memory != nil {
+		opts = append(opts, zstd.WithWindowSize(m.memory.zstdWindow), zstd.WithEncoderConcurrency(1), zstd.WithLowerEncoderMem(true))
+	} else {
+		opts = append(opts, zstd.WithEncoderConcurrency(m.workers()))
+	}
+	return opts
+}
+
+func (m *Middleware) createZstdReader(r io.Reader) io.Reader {
+	if m.resolver != nil {
+		// The dictionary of the first frame is resolved before decoding
+		br, ok := r.(*bufio.Reader)
+		if !ok {
+			br = bufio.NewReader(r)
+		}
+		return newLazyReader(br, func(in io.Reader) (io.Reader, error) {
+			opts := m.zstdReaderOptions()
+			if id := zstdDictionaryIDOf(br); id != 0 {
+				d, err := m.resolveDictionary(id)
+				if err != nil {
+					return nil, err
+				}
+				if d.raw {
+					opts = append(opts, zstd.WithDecoderDictRaw(d.id, d.dict))
+				} else if d.dict != nil {
+					opts = append(opts, zstd.WithDecoderDicts(d.dict))
+				}
+			}
+			zstdReader, err := zstd.NewReader(in, opts...)
+			if err != nil {
+				return nil, err
+			}
+			return &zstdReadCloser{zstdReader}, nil
+		})
+	}
+	zstdReader, err := zstd.NewReader(r, m.zstdReaderOptions()...)
+	if err != nil {
+		panic("failed to create zstd reader: " + err.Error())
+	}
+	return &zstdReadCloser{zstdReader}
+}
+
+func (m *Middleware) zstdReaderOptions() []zstd.DOption {
+	var opts []zstd.DOption
+	if dicts := m.decoderDictionaries(); len(dicts) > 0 {
+		opts = append(opts, zstd.WithDecoderDicts(dicts...))
+	}
+	if m.layered != nil {
+		opts = append(opts, zstd.WithDecoderDictRaw(m.layered.id, m.layered.content))
+	}
+	if m.memory != nil {
+		opts = append(opts, zstd.WithDecoderMaxWindow(uint64(m.memory.zstdWindow)), zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+	} else {
+		opts = append(opts, zstd.WithDecoderConcurrency(min(4, m.workers())))
+	}
+	if m.maxDecoded > 0 {
+		opts = append(opts, zstd.WithDecoderMaxMemory(uint64(m.maxDecoded)))
+	}
+	return opts
+}
+
+// createZstdRefreshWriter creates a zstd writer using the raw dictionary
+// content dict with the given id instead of the configured dictionary, see
+// WithDictionaryRefresh
+func (m *Middleware) createZstdRefreshWriter(w io.Writer, l Level, id uint32, dict []byte) encoder {
+	opts := append(m.zstdWriterOptions(l), zstd.WithEncoderDictRaw(id, dict))
+	zstdWriter, err := zstd.NewWriter(w, opts...)
+	if err != nil {
+		panic("failed to create zstd writer: " + err.Error())
+	}
+	return &zstdWriteCloser{zstdWriter}
+}
+
+// createZstdRefreshReader creates a zstd reader for streams written by
+// createZstdRefreshWriter
+func (m *Middleware) createZstdRefreshReader(r io.Reader, id uint32, dict []byte) io.Reader {
+	opts := append(m.zstdReaderOptions(), zstd.WithDecoderDictRaw(id, dict))
+	zstdReader, err := zstd.NewReader(r, opts...)
+	if err != nil {
+		panic("failed to create zstd reader: " + err.Error())
+	}
+	return &zstdReadCloser{zstdReader}
+}
+
+type zstdWriteCloser struct {
+	*zstd.Encoder
+}
+
+func (w *zstdWriteCloser) Close() error {
+	return w.Encoder.Close()
+}
+
+// declaredWriter is the zstd writer of WithDeclaredSizes. It ends a frame at
+// every Flush and Close, declaring its size, and buffers the data of the
+// frame unless the size was declared up front. Beyond limit bytes, the frame
+// is streamed without a size.
+type declaredWriter struct {
+	enc   *zstd.Encoder
+	w     io.Writer
+	buf   []byte
+	limit int
+	// remaining counts the bytes still due in a frame announced with
+	// declareSize
+	remaining int64
+	frames    int
+	// streaming is set while a frame without a declared size is open
+	streaming bool
+}
+
+func (d *declaredWriter) Write(p []byte) (int, error) {
+	var n int
+	if d.remaining > 0 {
+		n = int(min(int64(len(p)), d.remaining))
+		if _, err := d.enc.Write(p[:n]); err != nil {
+			return 0, err
+		}
+		if d.remaining -= int64(n); d.remaining == 0 {
+			if err := d.enc.Close(); err != nil {
+				return n, err
+			}
+		}
+	}
+	if d.streaming {
+		if _, err := d.enc.Write(p[n:]); err != nil {
+			return n, err
+		}
+		return len(p), nil
+	}
+	d.buf = append(d.buf, p[n:]...)
+	if len(d.buf) > d.limit {
+		// Too large to buffer, stream the frame without its size
+		d.enc.Reset(d.w)
+		d.streaming = true
+		d.frames++
+		_, err := d.enc.Write(d.buf)
+		d.buf = d.buf[:0]
+		if err != nil {
+			return n, err
+		}
+	}
+	return len(p), nil
+}
+
+func (d *declaredWriter) declareSize(size int64) error {
+	if d.remaining > 0 {
+		return fmt.Errorf("compression: declared frame is missing %d bytes", d.remaining)
+	}
+	if err := d.Flush(); err != nil || size <= 0 {
+		return err
+	}
+	d.enc.ResetContentSize(d.w, size)
+	d.remaining = size
+	d.frames++
+	return nil
+}
+
+// Flush ends the current frame. A frame announced with declareSize cannot
+// end early and is flushed instead.
+func (d *declaredWriter) Flush() error {
+	if d.remaining > 0 {
+		return d.enc.Flush()
+	}
+	if d.streaming {
+		d.streaming = false
+		return d.enc.Close()
+	}
+	if len(d.buf) == 0 {
+		return nil
+	}
+	return d.frame()
+}
+
+// betweenFrames reports whether no frame is open
+func (d *declaredWriter) betweenFrames() bool {
+	return d.remaining == 0 && len(d.buf) == 0 && !d.streaming
+}
+
+// frame writes the buffered data as one frame
+func (d *declaredWriter) frame() error {
+	d.enc.ResetContentSize(d.w, int64(len(d.buf)))
+	_, err := d.enc.Write(d.buf)
+	if err == nil {
+		err = d.enc.Close()
+	}
+	d.buf = d.buf[:0]
+	d.frames++
+	return err
+}
+
+func (d *declaredWriter) Close() error {
+	if d.remaining > 0 {
+		return fmt.Errorf("compression: declared frame is missing %d bytes", d.remaining)
+	}
+	if d.streaming || len(d.buf) > 0 {
+		return d.Flush()
+	}
+	if d.frames == 0 {
+		// Write what the encoder writes for an empty stream
+		d.enc.Reset(d.w)
+		return d.enc.Close()
+	}
+	return nil
+}
+
+func (d *declaredWriter) Reset(w io.Writer) {
+	d.w = w
+	d.buf = d.buf[:0]
+	d.remaining = 0
+	d.frames = 0
+	d.streaming = false
+}
+
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (r *zstdReadCloser) Close() error {
+	r.Decoder.Close()
+	return nil
+}
+
+// zstdContentSize returns the uncompressed size recorded in the header of
+// the next zstd frame of r, or 0
+func zstdContentSize(r *bufio.Reader) int64 {
+	p, _ := r.Peek(zstd.HeaderMaxSize)
+	var h zstd.Header
+	if err := h.Decode(p); err != nil || !h.HasFCS {
+		return 0
+	}
+	return int64(h.FrameContentSize)
+}
+
+// zstdDictionaryIDOf returns the dictionary ID recorded in the header of the
+// next zstd frame of r, or 0
+func zstdDictionaryIDOf(r *bufio.Reader) uint32 {
+	p, _ := r.Peek(zstd.HeaderMaxSize)
+	var h zstd.Header
+	if err := h.Decode(p); err != nil {
+		return 0
+	}
+	return h.DictionaryID
+}
+
+// validateZstdDictionary checks that dict is a zstd dictionary
+func validateZstdDictionary(dict []byte) error {
+	_, err := zstd.InspectDictionary(dict)
+	return err
+}
+
+// compactEncoder and compactDecoder are created on first use, as most
+// programs never need them
+var (
+	compactEncoder = sync.OnceValue(func() *zstd.Encoder {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1))
+		return enc
+	})
+	compactDecoder = sync.OnceValue(func() *zstd.Decoder {
+		dec, _ := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		return dec
+	})
+)
+
+// zstdCompact compresses p for in-memory storage
+func zstdCompact(p []byte) []byte {
+	return compactEncoder().EncodeAll(p, nil)
+}
+
+// zstdExpand reverses zstdCompact
+func zstdExpand(p []byte) ([]byte, error) {
+	return compactDecoder().DecodeAll(p, nil)
+}
+
+// zstdDictionaryID returns the ID of a zstd dictionary, or 0
+func zstdDictionaryID(dict []byte) uint32 {
+	d, err := zstd.InspectDictionary(dict)
+	if err != nil {
+		return 0
+	}
+	return d.ID()
+}
+
+// zstdDictionaryContent returns the content and ID of the zstd dictionary
+// dict
+func zstdDictionaryContent(dict []byte) ([]byte, uint32, error) {
+	d, err := zstd.InspectDictionary(dict)
+	if err != nil {
+		return nil, 0, err
+	}
+	return d.Content(), d.ID(), nil
+}
+
+// zstdSentinel returns the sentinel of decoder errors that are not
+// corruption, or nil
+func zstdSentinel(err error) error {
+	switch {
+	case errors.Is(err, zstd.ErrUnknownDictionary):
+		return ErrDictionaryMissing
+	case errors.Is(err, zstd.ErrDecoderSizeExceeded):
+		return ErrDecompressedSize
+	case errors.Is(err, zstd.ErrWindowSizeExceeded):
+		return ErrLimitExceeded
+	}
+	return nil
+}
+
+// zstdCorruptionKind classifies the errors of the zstd decoder
+func zstdCorruptionKind(err error) (CorruptionKind, bool) {
+	switch {
+	case errors.Is(err, zstd.ErrCRCMismatch):
+		return CorruptionChecksum, true
+	case errors.Is(err, zstd.ErrMagicMismatch), errors.Is(err, zstd.ErrReservedBlockType),
+		errors.Is(err, zstd.ErrCompressedSizeTooBig), errors.Is(err, zstd.ErrBlockTooSmall),
+		errors.Is(err, zstd.ErrUnexpectedBlockSize), errors.Is(err, zstd.ErrWindowSizeTooSmall),
+		errors.Is(err, zstd.ErrFrameSizeMismatch), errors.Is(err, huff0.ErrMaxDecodedSizeExceeded):
+		return CorruptionDecode, true
+	}
+	return 0, false
+}
+
+// zstdMagic starts every zstd frame
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// zstdSalvager decodes a zstd stream, skipping from a frame that fails to
+// decode to the next frame magic, see WithRecoverCorruptFrames
+type zstdSalvager struct {
+	m   *Middleware
+	src *salvageSource
+	dec *zstd.Decoder
+	off int64
+	err error
+	// frame is the compressed offset of the last frame magic read
+	frame int64
+}
+
+// createZstdSalvager creates a zstd salvager for r. The synchronous decoder
+// reads exactly up to the damage, so the search for the next frame starts
+// right after it.
+func (m *Middleware) createZstdSalvager(r io.Reader) io.Reader {
+	opts := append(m.zstdReaderOptions(), zstd.WithDecoderConcurrency(1))
+	z := &zstdSalvager{m: m, src: newSalvageSource(r, 64<<10)}
+	dec, err := zstd.NewReader(zstdFrames{z}, opts...)
+	if err != nil {
+		panic("failed to create zstd reader: " + err.Error())
+	}
+	z.dec = dec
+	return z
+}
+
+// zstdFrames is the source of the decoder of a zstdSalvager. Its reads end
+// before every frame magic, so the salvager knows where the frame being
+// decoded started.
+type zstdFrames struct {
+	z *zstdSalvager
+}
+
+func (f zstdFrames) Read(p []byte) (int, error) {
+	src := f.z.src
+	p = p[:min(len(p), src.r.Size()-len(zstdMagic))]
+	ahead, _ := src.peek(len(p) + len(zstdMagic) - 1)
+	if bytes.HasPrefix(ahead, zstdMagic) {
+		f.z.frame = src.pos
+	}
+	if len(ahead) > 1 {
+		if i := bytes.Index(ahead[1:], zstdMagic); i >= 0 && i+1 < len(p) {
+			p = p[:i+1]
+		}
+	}
+	return src.Read(p)
+}
+
+func (z *zstdSalvager) Read(p []byte) (int, error) {
+	for {
+		if z.err != nil {
+			return 0, z.err
+		}
+		n, err := z.dec.Read(p)
+		z.off += int64(n)
+		if err != nil {
+			z.err = z.resync(err)
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// resync skips to the next frame after the decoder failed with err. It
+// returns nil if the decoder can continue there.
+func (z *zstdSalvager) resync(err error) error {
+	if err == io.EOF || z.src.err != nil {
+		return cmp.Or(z.src.err, err)
+	}
+	if err = classifyError(err); errors.Is(err, ErrLimitExceeded) || errors.Is(err, ErrDictionaryMissing) {
+		return err
+	}
+	if errors.Is(err, zstd.ErrMagicMismatch) {
+		// Data in place of the next frame
+		z.frame = z.src.pos - int64(len(zstdMagic))
+	}
+	end := z.src.skipTo(zstdMagic)
+	if z.src.err != nil {
+		return z.src.err
+	}
+	z.m.skipped(z.off, z.frame, z.src.pos-z.frame, err)
+	if end != nil {
+		return end
+	}
+	return z.dec.Reset(zstdFrames{z})
+}
+
+func (z *zstdSalvager) Close() error {
+	z.dec.Close()
+	return nil
+}
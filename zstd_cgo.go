@@ -0,0 +1,41 @@
+//go:build cgozstd && cgo && !nozstd
+
+package compression
+
+import (
+	"io"
+
+	"github.com/DataDog/zstd"
+)
+
+// cgoZstdAvailable reports whether the cgo zstd backend is compiled in
+const cgoZstdAvailable = true
+
+func (m *Middleware) createCgoZstdWriter(w io.Writer, l Level) encoder {
+	cw := &cgoZstdWriter{level: m.cgoZstdLevel(l), dict: m.dictionary}
+	cw.Reset(w)
+	return cw
+}
+
+func (m *Middleware) createCgoZstdReader(r io.Reader) io.Reader {
+	if m.dictionary != nil {
+		return zstd.NewReaderDict(r, m.dictionary)
+	}
+	return zstd.NewReader(r)
+}
+
+// cgoZstdWriter recreates the C writer on Reset, which DataDog/zstd does not
+// support
+type cgoZstdWriter struct {
+	*zstd.Writer
+	level int
+	dict  []byte
+}
+
+func (w *cgoZstdWriter) Reset(dst io.Writer) {
+	if w.dict != nil {
+		w.Writer = zstd.NewWriterLevelDict(dst, w.level, w.dict)
+	} else {
+		w.Writer = zstd.NewWriterLevel(dst, w.level)
+	}
+}
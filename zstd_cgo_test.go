@@ -0,0 +1,33 @@
+//go:build cgozstd && cgo && !nozstd
+
+package compression
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWithBackend_CgoZstd(t *testing.T) {
+	data := []byte(strings.Repeat(`{"level":"info","service":"svc-1","message":"request handled"}`, 500))
+	dict := testZstdDictionary(t, 7)
+	for _, opts := range [][]Option{nil, {WithZstdDictionary(dict)}, {WithLevel(Best)}} {
+		cgo := New(Zstd, append(opts, WithBackend(CgoZstd))...)
+		pure := New(Zstd, opts...)
+		if _, ok := cgo.Writer(io.Discard).(*writer).enc.(*cgoZstdWriter); !ok {
+			t.Fatal("Expected the cgo encoder")
+		}
+		for _, pair := range [][2]*Middleware{{cgo, pure}, {pure, cgo}, {cgo, cgo}} {
+			// The second stream uses the pooled encoder
+			for range 2 {
+				r := pair[1].Reader(bytes.NewReader(writeContainer(t, pair[0], data)))
+				got, err := io.ReadAll(r)
+				if err != nil || !bytes.Equal(got, data) {
+					t.Fatalf("Failed to round trip: %v", err)
+				}
+				r.(io.Closer).Close()
+			}
+		}
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !cgozstd || !cgo || nozstd
+
+package compression
+
+import "io"
+
+// cgoZstdAvailable reports whether the cgo zstd backend is compiled in.
+// Build with the cgozstd tag and cgo enabled to include it.
+const cgoZstdAvailable = false
+
+func (m *Middleware) createCgoZstdWriter(w io.Writer, l Level) encoder {
+	panic("cgo zstd backend not compiled in (build with cgozstd tag)")
+}
+
+func (m *Middleware) createCgoZstdReader(r io.Reader) io.Reader {
+	panic("cgo zstd backend not compiled in (build with cgozstd tag)")
+}
@@ -0,0 +1,68 @@
+//go:build nozstd
+
+package compression
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// zstdAvailable reports whether zstd support is compiled in
+const zstdAvailable = false
+
+func (m *Middleware) createZstdWriter(w io.Writer, l Level) encoder {
+	panic(fmt.Errorf("%w: zstd support not compiled in (built with nozstd tag)", ErrUnsupportedAlgorithm))
+}
+
+func (m *Middleware) createZstdReader(r io.Reader) io.Reader {
+	panic(fmt.Errorf("%w: zstd support not compiled in (built with nozstd tag)", ErrUnsupportedAlgorithm))
+}
+
+func (m *Middleware) createZstdRefreshWriter(w io.Writer, l Level, id uint32, dict []byte) encoder {
+	panic(fmt.Errorf("%w: zstd support not compiled in (built with nozstd tag)", ErrUnsupportedAlgorithm))
+}
+
+func (m *Middleware) createZstdRefreshReader(r io.Reader, id uint32, dict []byte) io.Reader {
+	panic(fmt.Errorf("%w: zstd support not compiled in (built with nozstd tag)", ErrUnsupportedAlgorithm))
+}
+
+func zstdContentSize(r *bufio.Reader) int64 {
+	return 0
+}
+
+func zstdDictionaryIDOf(r *bufio.Reader) uint32 {
+	return 0
+}
+
+func validateZstdDictionary(dict []byte) error {
+	return nil
+}
+
+func zstdCompact(p []byte) []byte {
+	return p
+}
+
+func zstdExpand(p []byte) ([]byte, error) {
+	return p, nil
+}
+
+func zstdDictionaryID(dict []byte) uint32 {
+	return 0
+}
+
+func zstdDictionaryContent(dict []byte) ([]byte, uint32, error) {
+	return dict, 0, nil
+}
+
+func zstdSentinel(err error) error {
+	return nil
+}
+
+func zstdCorruptionKind(err error) (CorruptionKind, bool) {
+	return 0, false
+}
+
+func (m *Middleware) createZstdSalvager(r io.Reader) io.Reader {
+	panic(fmt.Errorf("%w: zstd support not compiled in (built with nozstd tag)", ErrUnsupportedAlgorithm))
+}
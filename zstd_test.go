@@ -0,0 +1,38 @@
+//go:build !nozstd
+
+package compression
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestWithDeclaredSizes_Limit(t *testing.T) {
+	m := New(Zstd, WithDeclaredSizes())
+	data := bytes.Repeat([]byte("streamed without a size "), 1000)
+
+	var buf bytes.Buffer
+	w := m.Writer(&buf)
+	d := w.(*writer).enc.(*declaredWriter)
+	d.limit = 4096
+	for i := 0; i < len(data); i += 1000 {
+		if _, err := w.Write(data[i:min(i+1000, len(data))]); err != nil {
+			t.Fatalf("Failed to write: %v", err)
+		}
+	}
+	if !d.streaming || len(d.buf) != 0 {
+		t.Fatalf("Expected the frame to be streamed, %d bytes buffered", len(d.buf))
+	}
+	if err := w.(io.Closer).Close(); err != nil {
+		t.Fatalf("Failed to close: %v", err)
+	}
+	got, err := io.ReadAll(m.Reader(&buf))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Round trip failed: %v", err)
+	}
+
+	if got := New(Zstd, WithMemoryBudget(1<<20)).declaredBuffer(); got != 1<<20 {
+		t.Errorf("Expected the memory budget to bound the buffer, got %d", got)
+	}
+}